@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var diskCmd = &cobra.Command{
+	Use:   "disk",
+	Short: "Manage the cached disk images",
+	Long:  "Manage the cached disk images",
+}
+
+func init() {
+	RootCmd.AddCommand(diskCmd)
+}
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/api"
+	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	buildDebugShell bool
+	diskBuildCmd    = &cobra.Command{
+		Use:   "build <image>",
+		Short: "Build the disk image of a bootc container without running it",
+		Long:  "Build the disk image of a bootc container without running it",
+		Args:  cobra.ExactArgs(1),
+		RunE:  doDiskBuild,
+	}
+)
+
+func init() {
+	diskCmd.AddCommand(diskBuildCmd)
+	addDiskImageFlags(diskBuildCmd.Flags())
+	addVerbosityFlags(diskBuildCmd.Flags(), "Suppress the output from bootc disk creation, -qq also the progress updates")
+	diskBuildCmd.Flags().StringVar(&outputOpts.format, "format", "", "Output format of the result, either empty for the disk image path or 'json'")
+	diskBuildCmd.Flags().BoolVar(&buildDebugShell, "debug-shell", false, "Start a shell in the install environment instead of running the install")
+}
+
+// diskBuildResult is the JSON output of disk build
+type diskBuildResult struct {
+	Id          string                `json:"id"`
+	Path        string                `json:"path"`
+	Filesystems []api.FilesystemUsage `json:"filesystems,omitempty"`
+
+	ContentVerification *api.ContentVerification `json:"contentVerification,omitempty"`
+}
+
+// diskBuildError is the JSON output of a failed disk build, the pull fields
+// are set when the image could not be pulled, the verification when the
+// disk image was kept but its contents differ from the image
+type diskBuildError struct {
+	Error        string `json:"error"`
+	PullError    string `json:"pullError,omitempty"`
+	PullRegistry string `json:"pullRegistry,omitempty"`
+	PullLayer    string `json:"pullLayer,omitempty"`
+
+	ContentVerification *api.ContentVerification `json:"contentVerification,omitempty"`
+}
+
+func printDiskBuildError(err error) {
+	result := diskBuildError{Error: err.Error()}
+	var pullErr *api.PullError
+	if errors.As(err, &pullErr) {
+		result.PullError = pullErr.Kind
+		result.PullRegistry = pullErr.Registry
+		result.PullLayer = pullErr.Layer
+	}
+	var verificationErr *api.VerificationError
+	if errors.As(err, &verificationErr) {
+		result.ContentVerification = &verificationErr.Verification
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		logrus.Debugf("unable to print the error: %v", err)
+	}
+}
+
+func doDiskBuild(cmd *cobra.Command, args []string) error {
+	switch outputOpts.format {
+	case "", "json":
+	default:
+		return fmt.Errorf("unknown format %q", outputOpts.format)
+	}
+
+	user, err := user.NewUser()
+	if err != nil {
+		return fmt.Errorf("unable to get user: %w", err)
+	}
+
+	ctx, _, err := podmanConnection(user)
+	if err != nil {
+		return err
+	}
+
+	diskImageConfigInstance.RunDefaults, err = api.ParseRunDefaults(runDefaultSettings)
+	if err != nil {
+		return err
+	}
+	diskImageConfigInstance.FilesystemOptions, err = api.ParseFilesystemOptions(mkfsOptionSettings)
+	if err != nil {
+		return err
+	}
+
+	if explainFormat != "" {
+		return printConfigExplanation(ctx, user, args[0], cmd.Flags())
+	}
+	if dryRunFormat != "" {
+		return printDryRunInstall(ctx, user, args[0], cmd.Flags())
+	}
+	if buildDebugShell {
+		return bootc.NewBootcDisk(args[0], ctx, user).DebugShell(diskImageConfigInstance.DiskImageConfig())
+	}
+
+	builder := api.NewDiskBuilder(ctx, user, args[0])
+	activeBuilder = builder
+	// stdout only carries the result in the JSON output mode
+	if outputOpts.json() {
+		builder.SetProgress(os.Stderr)
+	}
+	ui := startFancy(cmd.Flags())
+	if ui != nil {
+		builder.SetProgress(ui)
+		builder.SetProgressHook(ui.Event)
+	}
+	builder.SetVerbosity(outputOpts.buildVerbosity(ui != nil))
+	saveStats := recordStats(user, builder, "disk build")
+	result, err := builder.Build(diskImageConfigInstance)
+	saveStats()
+	if stopFancy() && err != nil {
+		err = fmt.Errorf("build cancelled: %w", err)
+	}
+	if err != nil {
+		if outputOpts.json() {
+			printDiskBuildError(err)
+		}
+		return fmt.Errorf("unable to install bootc image: %w", err)
+	}
+	if err := chownDigestFile(); err != nil {
+		return err
+	}
+
+	// An install to the filesystem does not cache a disk image
+	if result.Target != "" {
+		if outputOpts.json() {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(diskBuildResult{Id: filepath.Base(result.Directory), Path: result.Target})
+		}
+		fmt.Println(result.Target)
+		return nil
+	}
+
+	disk, err := builder.Disk()
+	if err != nil {
+		return err
+	}
+	if outputOpts.json() {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diskBuildResult{Id: disk.Id, Path: disk.Path, Filesystems: disk.Filesystems, ContentVerification: disk.ContentVerification})
+	}
+	fmt.Println(disk.Path)
+	return nil
+}
+
+// chownDigestFile gives the file of --digest-file to artifactOwner
+func chownDigestFile() error {
+	if diskImageConfigInstance.DigestFile == "" {
+		return nil
+	}
+	return artifactOwner.Chown(diskImageConfigInstance.DigestFile)
+}
+
+// activeBuilder is the disk build of the command, removed by CleanupDiskBuild
+var activeBuilder *api.DiskBuilder
+
+// CleanupDiskBuild removes the install container of an interrupted disk build
+func CleanupDiskBuild() error {
+	if activeBuilder == nil {
+		return nil
+	}
+	return activeBuilder.Cleanup()
+}
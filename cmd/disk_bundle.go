@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/bundle"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+	"gitlab.com/bootc-org/podman-bootc/pkg/vm"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	bundleOutput  string
+	unbundleForce bool
+	diskBundleCmd = &cobra.Command{
+		Use:   "bundle <ID> -o <file>",
+		Short: "Write a cached disk image and its files to a single bundle",
+		Long:  "Write a cached disk image, its metadata and files to a single zstd compressed tar, preserving the holes of the disk image. Use 'disk unbundle' to recreate the cache entry on another machine.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  doDiskBundle,
+	}
+	diskUnbundleCmd = &cobra.Command{
+		Use:   "unbundle <file>",
+		Short: "Recreate a cache entry from a bundle",
+		Long:  "Recreate the cache entry written by 'disk bundle', verifying the digests of its files",
+		Args:  cobra.ExactArgs(1),
+		RunE:  doDiskUnbundle,
+	}
+)
+
+func init() {
+	diskCmd.AddCommand(diskBundleCmd)
+	diskCmd.AddCommand(diskUnbundleCmd)
+	diskBundleCmd.Flags().StringVarP(&bundleOutput, "output", "o", "", "Path of the bundle, e.g. bundle.tar.zst")
+	_ = diskBundleCmd.MarkFlagRequired("output")
+	diskUnbundleCmd.Flags().BoolVar(&unbundleForce, "force", false, "Replace an existing cache entry newer than the bundle")
+}
+
+func doDiskBundle(_ *cobra.Command, args []string) error {
+	user, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+
+	longID, cacheDir, err := vm.GetVMCachePath(args[0], user)
+	if err != nil {
+		return err
+	}
+
+	lock := utils.NewCacheLock(user.RunDir(), cacheDir)
+	locked, err := lock.TryLock(utils.Shared)
+	if err != nil {
+		return fmt.Errorf("unable to lock the VM cache path: %w", err)
+	}
+	if !locked {
+		return vm.ErrVMInUse
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			logrus.Warningf("unable to unlock VM %s: %v", longID, err)
+		}
+	}()
+
+	out, err := os.CreateTemp(filepath.Dir(bundleOutput), ".podman-bootc-bundle")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if err := bundle.Bundle(out, cacheDir); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(out.Name(), bundleOutput); err != nil {
+		return err
+	}
+	if err := artifactOwner.Chown(bundleOutput); err != nil {
+		return err
+	}
+
+	fmt.Printf("Bundled %s to %s\n", longID, bundleOutput)
+	return nil
+}
+
+func doDiskUnbundle(_ *cobra.Command, args []string) error {
+	user, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	id, err := bundle.ReadId(f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	cacheDir := user.ImageCacheDir(id)
+	lock := utils.NewCacheLock(user.RunDir(), cacheDir)
+	locked, err := lock.TryLock(utils.Exclusive)
+	if err != nil {
+		return fmt.Errorf("unable to lock the VM cache path: %w", err)
+	}
+	if !locked {
+		return vm.ErrVMInUse
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			logrus.Warningf("unable to unlock VM %s: %v", id, err)
+		}
+	}()
+
+	if _, err := bundle.Unbundle(f, user.CacheDir(), bundle.Options{Force: unbundleForce}); err != nil {
+		return err
+	}
+	fmt.Printf("Unbundled and verified %s\n", id)
+	return nil
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/vm"
+
+	"github.com/containers/podman/v5/pkg/bindings/containers"
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cleanOptions   bootc.CleanOptions
+	cleanAllImages bool
+	diskCleanCmd   = &cobra.Command{
+		Use:   "clean [<ID>] [--seeds] [--logs] [--failure-bundles]",
+		Short: "Remove the auxiliary files of a cached disk image, keeping the disk image",
+		Long:  "Remove the cloud-init seed, the install log or the evidence of failed builds of a cached disk image. The disk image and its metadata are kept.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  doDiskClean,
+	}
+)
+
+func init() {
+	diskCmd.AddCommand(diskCleanCmd)
+	diskCleanCmd.Flags().BoolVar(&cleanOptions.Seeds, "seeds", false, "Remove the cloud-init seed of the VM written by run --cloudinit")
+	diskCleanCmd.Flags().BoolVar(&cleanOptions.Logs, "logs", false, "Remove the log of the last install")
+	diskCleanCmd.Flags().BoolVar(&cleanOptions.FailureBundles, "failure-bundles", false, "Remove the record of the last failed build, and the install container and temporary disk kept by --keep-on-failure")
+	diskCleanCmd.Flags().BoolVar(&cleanAllImages, "all-images", false, "Clean the cache entries of all images, skipping the ones in use")
+}
+
+func doDiskClean(_ *cobra.Command, args []string) error {
+	if !cleanOptions.Seeds && !cleanOptions.Logs && !cleanOptions.FailureBundles {
+		return errors.New("select the files to remove with --seeds, --logs or --failure-bundles")
+	}
+	if cleanAllImages == (len(args) == 1) {
+		return errors.New("specify either an image id or --all-images")
+	}
+
+	user, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+	cleanOptions.RemoveContainer = func(id string) error {
+		ctx, _, err := podmanConnection(user)
+		if err != nil {
+			return err
+		}
+		force := true
+		_, err = containers.Remove(ctx, id, &containers.RemoveOptions{Force: &force})
+		return err
+	}
+
+	if cleanAllImages {
+		reports, err := bootc.CleanAllEntries(user, cleanOptions)
+		for _, report := range reports {
+			printCleanReport(report)
+		}
+		return err
+	}
+
+	_, cacheDir, err := vm.GetVMCachePath(args[0], user)
+	if err != nil {
+		return err
+	}
+	report, err := bootc.CleanEntry(user, cacheDir, cleanOptions)
+	if errors.Is(err, bootc.ErrEntryInUse) {
+		return vm.ErrVMInUse
+	}
+	printCleanReport(report)
+	return err
+}
+
+// printCleanReport prints the files removed from a cache entry
+func printCleanReport(report bootc.CleanReport) {
+	id := report.Id[:12]
+	switch {
+	case report.InUse:
+		fmt.Printf("%s: in use, skipped\n", id)
+	case len(report.Removed) == 0:
+		fmt.Printf("%s: nothing to remove\n", id)
+	}
+	for _, removed := range report.Removed {
+		if removed.Size > 0 {
+			fmt.Printf("%s: removed %s %s (%s)\n", id, removed.Kind, removed.Path, units.HumanSize(float64(removed.Size)))
+		} else {
+			fmt.Printf("%s: removed %s %s\n", id, removed.Kind, removed.Path)
+		}
+	}
+}
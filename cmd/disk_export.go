@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/api"
+	"gitlab.com/bootc-org/podman-bootc/pkg/chunked"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+
+	"github.com/docker/go-units"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportChunkSize string
+	exportResume    bool
+	exportBibLayout bool
+	diskExportCmd   = &cobra.Command{
+		Use:   "export <ID> <directory>",
+		Short: "Export a cached disk image as checksummed chunks",
+		Long:  "Export a cached disk image as chunk files and a manifest with the sha256 of every chunk. Use 'disk assemble' to reconstruct it. With --bib-layout, write the raw disk image and manifest of a bootc-image-builder output directory instead.",
+		Args:  cobra.ExactArgs(2),
+		RunE:  doDiskExport,
+	}
+	diskAssembleCmd = &cobra.Command{
+		Use:   "assemble <directory> <disk image>",
+		Short: "Reconstruct a disk image from an export",
+		Long:  "Reconstruct a disk image from the chunks written by 'disk export', verifying every chunk",
+		Args:  cobra.ExactArgs(2),
+		RunE:  doDiskAssemble,
+	}
+)
+
+func init() {
+	diskCmd.AddCommand(diskExportCmd)
+	diskCmd.AddCommand(diskAssembleCmd)
+	diskExportCmd.Flags().StringVar(&exportChunkSize, "chunk-size", "256MB", "Size of the chunks; optionally accepts K, M, G suffixes")
+	diskExportCmd.Flags().BoolVar(&exportResume, "resume", false, "Resume an interrupted export to the same directory")
+	diskExportCmd.Flags().BoolVar(&exportBibLayout, "bib-layout", false, "Write the disk image in the directory layout of bootc-image-builder, which 'disk import' reads")
+}
+
+func doDiskExport(_ *cobra.Command, args []string) error {
+	chunkSize, err := units.FromHumanSize(exportChunkSize)
+	if err != nil {
+		return fmt.Errorf("invalid chunk size: %w", err)
+	}
+
+	user, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+
+	var exportOwner *api.Owner
+	if artifactOwner != nil {
+		exportOwner = &api.Owner{Uid: artifactOwner.Uid, Gid: artifactOwner.Gid}
+	}
+	report, err := api.Export(user, args[0], args[1], api.ExportOptions{
+		BibLayout: exportBibLayout,
+		ChunkSize: chunkSize,
+		Resume:    exportResume,
+		Owner:     exportOwner,
+		Progress: func(done, total int64) {
+			logrus.Infof("exported %s of %s", units.HumanSize(float64(done)), units.HumanSize(float64(total)))
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if exportBibLayout {
+		fmt.Printf("Exported %s to %s\n", report.Id, args[1])
+		return nil
+	}
+	fmt.Printf("Exported %s in %d chunks to %s\n", units.HumanSize(float64(report.Size)), report.Chunks, args[1])
+	return nil
+}
+
+func doDiskAssemble(_ *cobra.Command, args []string) error {
+	if err := chunked.Assemble(args[0], args[1]); err != nil {
+		return err
+	}
+	if err := artifactOwner.Chown(args[1]); err != nil {
+		return err
+	}
+	fmt.Printf("Assembled and verified %s\n", args[1])
+	return nil
+}
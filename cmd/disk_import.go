@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/bib"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+	"gitlab.com/bootc-org/podman-bootc/pkg/vm"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	importForce   bool
+	diskImportCmd = &cobra.Command{
+		Use:   "import <directory>",
+		Short: "Import a disk image built by bootc-image-builder",
+		Long:  "Create the cache entry of the raw or qcow2 disk image in a bootc-image-builder output directory, reading the container image from its manifest. Use 'disk export --bib-layout' for the other direction.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  doDiskImport,
+	}
+)
+
+func init() {
+	diskCmd.AddCommand(diskImportCmd)
+	diskImportCmd.Flags().BoolVar(&importForce, "force", false, "Replace an existing cache entry of the container image")
+}
+
+func doDiskImport(_ *cobra.Command, args []string) error {
+	user, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+
+	source, err := bib.ReadOutput(args[0])
+	if err != nil {
+		return err
+	}
+
+	cacheDir := user.ImageCacheDir(source.ImageId)
+	lock := utils.NewCacheLock(user.RunDir(), cacheDir)
+	locked, err := lock.TryLock(utils.Exclusive)
+	if err != nil {
+		return fmt.Errorf("unable to lock the VM cache path: %w", err)
+	}
+	if !locked {
+		return vm.ErrVMInUse
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			logrus.Warningf("unable to unlock VM %s: %v", source.ImageId, err)
+		}
+	}()
+
+	id, err := bib.Import(args[0], user.CacheDir(), bib.Options{Force: importForce})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %s\n", id)
+	return nil
+}
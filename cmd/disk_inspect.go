@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+	"gitlab.com/bootc-org/podman-bootc/pkg/partitions"
+	"gitlab.com/bootc-org/podman-bootc/pkg/qcow2"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/vm"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diskInspectFormat string
+	diskInspectReplay bool
+	diskInspectCmd    = &cobra.Command{
+		Use:   "inspect <ID|FILE>",
+		Short: "Display the metadata of a cached disk image",
+		Long:  "Display the metadata of a cached disk image, or the partitions of a raw or qcow2 disk image file",
+		Args:  cobra.ExactArgs(1),
+		RunE:  doDiskInspect,
+	}
+)
+
+func init() {
+	diskCmd.AddCommand(diskInspectCmd)
+	diskInspectCmd.Flags().StringVar(&diskInspectFormat, "format", "", "Format the output using the given Go template, e.g. '{{.Partitions}}'")
+	diskInspectCmd.Flags().BoolVar(&diskInspectReplay, "replay-command", false, "Print a podman-bootc command rebuilding the disk image from the same inputs")
+}
+
+type diskInspectReport struct {
+	Id   string `json:"id"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	*bootc.DiskMeta
+}
+
+type diskFileReport struct {
+	Path       string                 `json:"path"`
+	Format     string                 `json:"format"`
+	Size       int64                  `json:"size"`
+	Partitions []partitions.Partition `json:"partitions"`
+}
+
+func doDiskInspect(_ *cobra.Command, args []string) error {
+	if st, err := os.Stat(args[0]); err == nil && !st.IsDir() {
+		return inspectDiskFile(args[0])
+	}
+
+	user, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+
+	longID, cacheDir, err := vm.GetVMCachePath(args[0], user)
+	if err != nil {
+		return err
+	}
+
+	diskPath := filepath.Join(cacheDir, config.DiskImage)
+	st, err := os.Stat(diskPath)
+	if err != nil {
+		return err
+	}
+	meta, err := bootc.ReadDiskMeta(diskPath)
+	if err != nil {
+		return err
+	}
+
+	if diskInspectReplay {
+		command, warnings := meta.ReplayCommand()
+		for _, warning := range warnings {
+			logrus.Warn(warning)
+		}
+		fmt.Println(command)
+		return nil
+	}
+
+	report := diskInspectReport{
+		Id:       longID,
+		Path:     diskPath,
+		Size:     st.Size(),
+		DiskMeta: meta,
+	}
+
+	return printInspectReport(report)
+}
+
+// inspectDiskFile reads the partitions of a disk image file without qemu-img
+func inspectDiskFile(path string) error {
+	disk, err := qcow2.OpenDisk(path)
+	if err != nil {
+		return err
+	}
+	defer disk.Close()
+
+	parts, err := partitions.Inspect(disk)
+	if err != nil && !errors.Is(err, partitions.ErrNoGPT) {
+		return fmt.Errorf("inspecting %s: %w", path, err)
+	}
+	return printInspectReport(diskFileReport{
+		Path:       path,
+		Format:     disk.Format,
+		Size:       disk.Size,
+		Partitions: parts,
+	})
+}
+
+func printInspectReport(report any) error {
+	if diskInspectFormat != "" {
+		tmpl, err := template.New("inspect").Parse(diskInspectFormat)
+		if err != nil {
+			return fmt.Errorf("invalid format: %w", err)
+		}
+		if err := tmpl.Execute(os.Stdout, report); err != nil {
+			return err
+		}
+		fmt.Println()
+		return nil
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
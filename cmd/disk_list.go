@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/api"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+
+	"github.com/containers/common/pkg/report"
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+)
+
+var diskListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the cached disk images",
+	Long:  "List the cached disk images",
+	Args:  cobra.NoArgs,
+	RunE:  doDiskList,
+}
+
+var diskListUnmanaged bool
+
+func init() {
+	diskCmd.AddCommand(diskListCmd)
+	diskListCmd.Flags().BoolVar(&diskListUnmanaged, "unmanaged", false, "List the entries of the cache which are not disk images, e.g. unreadable ones, instead of the disk images")
+}
+
+type diskListEntry struct {
+	Id           string
+	Repository   string
+	Generation   string
+	Size         string
+	Created      string
+	BootcVersion string
+	Builder      string
+	Pinned       string
+}
+
+// unmanagedListEntry is a line of disk list --unmanaged
+type unmanagedListEntry struct {
+	Path   string
+	Reason string
+}
+
+func doDiskList(_ *cobra.Command, _ []string) error {
+	if diskListUnmanaged {
+		return doDiskListUnmanaged()
+	}
+
+	hdrs := report.Headers(diskListEntry{}, map[string]string{
+		"BootcVersion": "Bootc",
+	})
+
+	rpt := report.New(os.Stdout, "disk list")
+	defer rpt.Flush()
+
+	rpt, err := rpt.Parse(
+		report.OriginPodman,
+		"{{range . }}{{.Id}}\t{{.Repository}}\t{{.Generation}}\t{{.Size}}\t{{.Created}}\t{{.BootcVersion}}\t{{.Builder}}\t{{.Pinned}}\n{{end -}}")
+	if err != nil {
+		return err
+	}
+
+	if err := rpt.Execute(hdrs); err != nil {
+		return err
+	}
+
+	user, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+
+	disks, err := collectDiskList(user)
+	if err != nil {
+		return err
+	}
+
+	return rpt.Execute(disks)
+}
+
+func collectDiskList(user api.User) (disks []diskListEntry, err error) {
+	cached, err := api.List(user)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, disk := range cached {
+		entry := diskListEntry{
+			Id:           disk.Id[:12],
+			Repository:   disk.Repository,
+			Generation:   "-",
+			Size:         units.HumanSize(float64(disk.Size)),
+			Created:      disk.Created.Format(time.RFC3339),
+			BootcVersion: disk.BootcVersion,
+			Builder:      "-",
+			Pinned:       "-",
+		}
+		if disk.BuilderVersion != "" {
+			entry.Builder = disk.BuilderVersion
+		}
+		if disk.Pinned {
+			entry.Pinned = "pinned"
+		}
+		if disk.Generation > 0 {
+			entry.Generation = strconv.Itoa(disk.Generation)
+		}
+		disks = append(disks, entry)
+	}
+	return disks, nil
+}
+
+func doDiskListUnmanaged() error {
+	user, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+	unmanaged, err := api.ListUnmanaged(user)
+	if err != nil {
+		return err
+	}
+
+	rpt := report.New(os.Stdout, "disk list")
+	defer rpt.Flush()
+	rpt, err = rpt.Parse(report.OriginPodman, "{{range . }}{{.Path}}\t{{.Reason}}\n{{end -}}")
+	if err != nil {
+		return err
+	}
+	if err := rpt.Execute(report.Headers(unmanagedListEntry{}, nil)); err != nil {
+		return err
+	}
+	entries := make([]unmanagedListEntry, 0, len(unmanaged))
+	for _, e := range unmanaged {
+		entries = append(entries, unmanagedListEntry{Path: e.Path, Reason: e.Reason})
+	}
+	return rpt.Execute(entries)
+}
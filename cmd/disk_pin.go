@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	diskPinCmd = &cobra.Command{
+		Use:   "pin <ID>",
+		Short: "Protect a cached disk image from the prune policies",
+		Long:  "Pin a cached disk image: it is never pruned by --image-ceiling, rebuilt for --max-cache-age or removed by rm --all, and rm and rm --all require --force until it is unpinned.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return setPinned(args[0], true)
+		},
+	}
+	diskUnpinCmd = &cobra.Command{
+		Use:   "unpin <ID>",
+		Short: "Let the prune policies remove a pinned disk image again",
+		Long:  "Let the prune policies remove a pinned disk image again",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return setPinned(args[0], false)
+		},
+	}
+)
+
+func init() {
+	diskCmd.AddCommand(diskPinCmd)
+	diskCmd.AddCommand(diskUnpinCmd)
+}
+
+func setPinned(id string, pinned bool) error {
+	user, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+	longID, cacheDir, err := vm.GetVMCachePath(id, user)
+	if err != nil {
+		return err
+	}
+	if err := bootc.SetPinned(user, cacheDir, pinned); err != nil {
+		if errors.Is(err, bootc.ErrEntryInUse) {
+			return vm.ErrVMInUse
+		}
+		return err
+	}
+	if pinned {
+		fmt.Printf("Pinned %s\n", longID[:12])
+	} else {
+		fmt.Printf("Unpinned %s\n", longID[:12])
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+	"gitlab.com/bootc-org/podman-bootc/pkg/nbd"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+	"gitlab.com/bootc-org/podman-bootc/pkg/vm"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveOpts             = nbd.ServeOptions{}
+	serveIKnowWhatImDoing bool
+	diskServeCmd          = &cobra.Command{
+		Use:   "serve <ID>",
+		Short: "Export a cached disk image over NBD",
+		Long:  "Export a cached disk image over NBD without copying it",
+		Args:  cobra.ExactArgs(1),
+		RunE:  doDiskServe,
+	}
+)
+
+func init() {
+	diskCmd.AddCommand(diskServeCmd)
+	diskServeCmd.Flags().StringVar(&serveOpts.Address, "nbd", "", "Address to listen on, either unix:<socket path> or tcp:<host>:<port>")
+	diskServeCmd.Flags().BoolVar(&serveOpts.ReadOnly, "read-only", true, "Export the disk image read-only")
+	diskServeCmd.Flags().BoolVar(&serveIKnowWhatImDoing, "i-know-what-im-doing", false, "Allow --read-only=false, the disk image will no longer match its container image")
+	_ = diskServeCmd.MarkFlagRequired("nbd")
+}
+
+func doDiskServe(_ *cobra.Command, args []string) error {
+	if !serveOpts.ReadOnly && !serveIKnowWhatImDoing {
+		return errors.New("writing to a cached disk image breaks its link to the container image, pass --i-know-what-im-doing to serve it writable")
+	}
+
+	user, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+
+	longID, cacheDir, err := vm.GetVMCachePath(args[0], user)
+	if err != nil {
+		return err
+	}
+
+	// Readers share the disk with running VMs, a writer must be alone
+	mode := utils.Shared
+	if !serveOpts.ReadOnly {
+		mode = utils.Exclusive
+	}
+	lock := utils.NewCacheLock(user.RunDir(), cacheDir)
+	locked, err := lock.TryLock(mode)
+	if err != nil {
+		return fmt.Errorf("unable to lock the VM cache path: %w", err)
+	}
+	if !locked {
+		return vm.ErrVMInUse
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			logrus.Warningf("unable to unlock VM %s: %v", longID, err)
+		}
+	}()
+
+	diskPath := filepath.Join(cacheDir, config.DiskImage)
+	if _, err := os.Stat(diskPath); err != nil {
+		return err
+	}
+	if meta, err := bootc.ReadDiskMeta(diskPath); err == nil {
+		serveOpts.Format = meta.DiskFormat()
+		// Record the writes before they happen, the cached disk must not be
+		// reused as built even if the export is killed
+		if !serveOpts.ReadOnly {
+			if err := bootc.MarkModified(diskPath, "written over NBD"); err != nil {
+				return fmt.Errorf("unable to mark the disk image as modified: %w", err)
+			}
+		}
+	}
+
+	// Tear down the export ourselves instead of the global handler exiting
+	signal.Reset(os.Interrupt, syscall.SIGTERM)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Serving %s on %s, press Ctrl-C to stop\n", diskPath, serveOpts.Address)
+	return nbd.Serve(ctx, diskPath, serveOpts)
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/doctor"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	doctorFormat string
+	doctorCmd    = &cobra.Command{
+		Use:   "doctor",
+		Short: "Check that the host environment can build and run bootc VMs",
+		Long:  "Check that the host environment can build and run bootc VMs",
+		Args:  cobra.NoArgs,
+		RunE:  doDoctor,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().StringVar(&doctorFormat, "format", "", "Output format, either empty for a table or 'json'")
+}
+
+func doDoctor(_ *cobra.Command, _ []string) error {
+	user, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+
+	results := doctor.RunChecks(user)
+
+	if err := printDoctorResults(results, doctorFormat); err != nil {
+		return err
+	}
+
+	if doctor.Failed(results) {
+		return errors.New("some host requirements are not met")
+	}
+	return nil
+}
+
+func printDoctorResults(results []doctor.Result, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	case "":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", strings.ToUpper(string(r.Status)), r.Name, r.Message)
+			if r.Hint != "" {
+				fmt.Fprintf(w, "\t\thint: %s\n", r.Hint)
+			}
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown format %s", format)
+	}
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+
+	"github.com/spf13/pflag"
+)
+
+// dryRunFormat is the format of --dry-run-install, empty to build
+var dryRunFormat string
+
+// printDryRunInstall prints what building the disk image of image would do,
+// with the options of flags and their sources, in the format of
+// --dry-run-install
+func printDryRunInstall(ctx context.Context, user user.User, image string, flags *pflag.FlagSet) error {
+	switch dryRunFormat {
+	case "text", "json":
+	default:
+		return fmt.Errorf("unknown --dry-run-install format %q, use text or json", dryRunFormat)
+	}
+	disk := bootc.NewBootcDisk(image, ctx, user)
+	plan, err := disk.DryRun(diskImageConfigInstance.DiskImageConfig())
+	if err != nil {
+		return err
+	}
+	if plan.Config, err = resolveConfig(disk, flags); err != nil {
+		return err
+	}
+	if dryRunFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	}
+	plan.Print(os.Stdout)
+	return nil
+}
+
+// addDryRunInstallFlag adds --dry-run-install to a command building disk images
+func addDryRunInstallFlag(flags *pflag.FlagSet) {
+	flags.StringVar(&dryRunFormat, "dry-run-install", "", "Pull the image and print the cache decision, the disk size, the bootc install command and the install container mounts, as text or json, instead of building")
+	flags.Lookup("dry-run-install").NoOptDefVal = "text"
+}
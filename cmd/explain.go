@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+
+	"github.com/spf13/pflag"
+)
+
+// diskImageFlagAnnotation marks the flags of the disk image options
+const diskImageFlagAnnotation = "podman-bootc/disk-image"
+
+// explainFormat is the format of --explain-config, empty to build
+var explainFormat string
+
+// markDiskImageFlags annotates the flags added by add, the disk image options
+func markDiskImageFlags(flags *pflag.FlagSet, add func()) {
+	existing := map[string]bool{}
+	flags.VisitAll(func(f *pflag.Flag) { existing[f.Name] = true })
+	add()
+	flags.VisitAll(func(f *pflag.Flag) {
+		if !existing[f.Name] {
+			_ = flags.SetAnnotation(f.Name, diskImageFlagAnnotation, []string{"true"})
+		}
+	})
+}
+
+// resolveConfig returns the effective disk image options of the build of
+// disk and their sources, for --explain-config and --dry-run-install. The
+// flags record whether they were set while parsing the command line, the
+// labels of the image are merged like the build does.
+func resolveConfig(disk *bootc.BootcDisk, flags *pflag.FlagSet) ([]bootc.ConfigOption, error) {
+	var options []bootc.ConfigOption
+	flags.VisitAll(func(f *pflag.Flag) {
+		if _, ok := f.Annotations[diskImageFlagAnnotation]; !ok {
+			return
+		}
+		source := bootc.ConfigFromDefault
+		if f.Changed {
+			source = bootc.ConfigFromFlag
+		}
+		options = append(options, bootc.ConfigOption{Name: f.Name, Value: f.Value.String(), Source: source})
+	})
+	if v, ok := os.LookupEnv("BOOTC_INSTALL_LOG"); ok {
+		options = append(options, bootc.ConfigOption{Name: "install-log", Value: v, Source: bootc.ConfigFromEnv})
+	}
+	return disk.ResolveConfig(diskImageConfigInstance.DiskImageConfig(), options)
+}
+
+// printConfigExplanation prints the effective disk image options of the
+// build of image in the format of --explain-config
+func printConfigExplanation(ctx context.Context, user user.User, image string, flags *pflag.FlagSet) error {
+	switch explainFormat {
+	case "table", "json":
+	default:
+		return fmt.Errorf("unknown --explain-config format %q, use table or json", explainFormat)
+	}
+	options, err := resolveConfig(bootc.NewBootcDisk(image, ctx, user), flags)
+	if err != nil {
+		return err
+	}
+	if explainFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(options)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OPTION\tVALUE\tSOURCE")
+	for _, o := range options {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", o.Name, o.Value, o.Origin())
+	}
+	return w.Flush()
+}
+
+// addExplainConfigFlag adds --explain-config to a command building disk images
+func addExplainConfigFlag(flags *pflag.FlagSet) {
+	flags.StringVar(&explainFormat, "explain-config", "", "Print the effective disk image options and their sources, as a table or json, instead of building")
+	flags.Lookup("explain-config").NoOptDefVal = "table"
+}
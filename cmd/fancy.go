@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"os"
+	"sync"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/progressui"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+// The progress UI of the running build, nil in the plain output mode
+var (
+	fancyMu        sync.Mutex
+	fancyUI        *progressui.UI
+	fancyPrompting bool
+	fancyCancelled bool
+)
+
+// startFancy starts the progress UI when it is enabled, the build writes its
+// output and sends its progress events to it. stopFancy ends it.
+func startFancy(flags *pflag.FlagSet) *progressui.UI {
+	if !outputOpts.fancyEnabled(flags) {
+		return nil
+	}
+	ui := progressui.New(os.Stdout)
+	// The log messages scroll above the status like the build output, the
+	// log file has its own writer
+	if logrus.StandardLogger().Out == os.Stderr {
+		logrus.SetOutput(ui)
+	}
+	fancyMu.Lock()
+	fancyUI, fancyPrompting, fancyCancelled = ui, false, false
+	fancyMu.Unlock()
+	ui.Start()
+	return ui
+}
+
+// stopFancy ends the progress UI, if any, and reports if the build was
+// cancelled from it
+func stopFancy() bool {
+	fancyMu.Lock()
+	ui, cancelled := fancyUI, fancyCancelled
+	fancyUI = nil
+	fancyMu.Unlock()
+	if ui == nil {
+		return false
+	}
+	ui.Stop()
+	if logrus.StandardLogger().Out == ui {
+		logrus.SetOutput(os.Stderr)
+	}
+	return cancelled
+}
+
+// InterruptBuild handles an interrupt during a build showing the progress
+// UI: the first one asks whether to cancel the build, which then removes its
+// temporary files. It returns false when the interrupt is not handled, e.g.
+// a second one while asking, and the caller cleans up and exits.
+func InterruptBuild() bool {
+	fancyMu.Lock()
+	defer fancyMu.Unlock()
+	if fancyUI == nil || fancyPrompting || fancyCancelled {
+		return false
+	}
+	fancyPrompting = true
+	ui := fancyUI
+
+	go func() {
+		ui.Pause()
+		cancel, err := utils.AskYesNo("\ncancel build? temp files will be cleaned")
+		if err != nil {
+			logrus.Debugf("unable to ask whether to cancel the build: %v", err)
+		}
+		fancyMu.Lock()
+		fancyPrompting = false
+		if cancel {
+			fancyCancelled = true
+			operationCancel()
+		}
+		fancyMu.Unlock()
+		ui.Resume()
+	}()
+	return true
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/systemd"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	generateRestart string
+	generateSystem  bool
+	generateCmd     = &cobra.Command{
+		Use:   "generate",
+		Short: "Generate files for running VMs",
+		Long:  "Generate files for running VMs",
+	}
+	generateSystemdCmd = &cobra.Command{
+		Use:   "systemd <ID>",
+		Short: "Print the systemd unit starting a VM with the host",
+		Long:  "Print the systemd unit starting a VM with the host, as installed by 'run --restart'",
+		Args:  cobra.ExactArgs(1),
+		RunE:  doGenerateSystemd,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(generateCmd)
+	generateCmd.AddCommand(generateSystemdCmd)
+	generateSystemdCmd.Flags().StringVar(&generateRestart, "restart", systemd.RestartAlways, "Restart policy of the VM: always or on-failure")
+	generateSystemdCmd.Flags().BoolVar(&generateSystem, "system", false, "Generate a system unit running as the current user instead of a user unit")
+}
+
+func doGenerateSystemd(_ *cobra.Command, args []string) error {
+	if err := systemd.ValidateRestart(generateRestart); err != nil {
+		return err
+	}
+	if generateRestart == systemd.RestartNo {
+		return fmt.Errorf("the %q restart policy needs no unit", systemd.RestartNo)
+	}
+
+	user, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+	longID, _, err := vm.GetVMCachePath(args[0], user)
+	if err != nil {
+		return err
+	}
+	unit, err := autostartUnit(longID, generateRestart, generateSystem)
+	if err != nil {
+		return err
+	}
+	fmt.Print(unit.String())
+	return nil
+}
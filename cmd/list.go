@@ -36,7 +36,7 @@ func doList(_ *cobra.Command, _ []string) error {
 
 	rpt, err := rpt.Parse(
 		report.OriginPodman,
-		"{{range . }}{{.Id}}\t{{.RepoTag}}\t{{.DiskSize}}\t{{.Created}}\t{{.Running}}\t{{.SshPort}}\n{{end -}}")
+		"{{range . }}{{.Id}}\t{{.RepoTag}}\t{{.DiskSize}}\t{{.Created}}\t{{.Running}}\t{{.SshPort}}\t{{.Accel}}\n{{end -}}")
 
 	if err != nil {
 		return err
@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+	"gitlab.com/bootc-org/podman-bootc/pkg/doctor"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+
+	"github.com/spf13/cobra"
+)
+
+type machineSetupConfig struct {
+	cpus      uint64
+	memoryMiB uint64
+	diskGiB   uint64
+	assumeYes bool
+}
+
+var (
+	machineSetup    machineSetupConfig
+	machineSetupCmd = &cobra.Command{
+		Use:   "machine-setup",
+		Short: "Create or configure a podman machine suitable for podman-bootc",
+		Long: "Check for a rootful podman machine with enough resources to build disk images, " +
+			"offer to create or reconfigure the " + config.MachineName + " machine otherwise, " +
+			"and verify it with the doctor checks",
+		Args: cobra.NoArgs,
+		RunE: doMachineSetup,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(machineSetupCmd)
+	machineSetupCmd.Flags().Uint64Var(&machineSetup.cpus, "cpus", 2, "Minimum number of CPUs of the machine")
+	machineSetupCmd.Flags().Uint64Var(&machineSetup.memoryMiB, "memory", 4096, "Minimum memory of the machine in MiB")
+	machineSetupCmd.Flags().Uint64Var(&machineSetup.diskGiB, "disk-size", 50, "Minimum disk size of the machine in GiB")
+	machineSetupCmd.Flags().BoolVarP(&machineSetup.assumeYes, "yes", "y", false, "Do not ask for confirmation before creating or reconfiguring the machine")
+}
+
+// problems lists why the machine is not suitable to build disk images
+func (c machineSetupConfig) problems(m utils.Machine) []string {
+	var problems []string
+	if !m.Rootful {
+		problems = append(problems, "it is rootless")
+	}
+	if m.CPUs < c.cpus {
+		problems = append(problems, fmt.Sprintf("it has %d CPUs, less than %d", m.CPUs, c.cpus))
+	}
+	if m.MemoryMiB < c.memoryMiB {
+		problems = append(problems, fmt.Sprintf("it has %d MiB of memory, less than %d", m.MemoryMiB, c.memoryMiB))
+	}
+	if m.DiskGiB < c.diskGiB {
+		problems = append(problems, fmt.Sprintf("its disk is %d GiB, less than %d", m.DiskGiB, c.diskGiB))
+	}
+	return problems
+}
+
+func doMachineSetup(_ *cobra.Command, _ []string) error {
+	user, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+
+	machines, err := utils.ListMachines()
+	if err != nil {
+		return err
+	}
+
+	var target *utils.Machine
+	for i, m := range machines {
+		if !m.Default && m.Name != config.MachineName {
+			continue
+		}
+		problems := machineSetup.problems(m)
+		if len(problems) == 0 {
+			fmt.Printf("The podman machine %s is suitable\n", m.Name)
+			target = &machines[i]
+			break
+		}
+		fmt.Printf("The podman machine %s is not suitable: %s\n", m.Name, strings.Join(problems, ", "))
+		if m.Name == config.MachineName {
+			if err := reconfigureMachine(m); err != nil {
+				return err
+			}
+			machines[i].Running = false
+			target = &machines[i]
+		}
+	}
+
+	if target == nil {
+		if err := createMachine(); err != nil {
+			return err
+		}
+		target = &utils.Machine{Name: config.MachineName}
+	}
+
+	if !target.Running {
+		if err := podmanMachine("start", target.Name); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Verifying the podman machine")
+	results := doctor.RunChecks(user)
+	if err := printDoctorResults(results, ""); err != nil {
+		return err
+	}
+	if doctor.Failed(results) {
+		return errors.New("the podman machine is not working, see the failed checks")
+	}
+	return nil
+}
+
+func createMachine() error {
+	if !machineSetup.assumeYes {
+		create, err := utils.AskYesNo(fmt.Sprintf("Create the rootful podman machine %s?", config.MachineName))
+		if err != nil {
+			return err
+		}
+		if !create {
+			return errors.New("no suitable podman machine")
+		}
+	}
+	return podmanMachine("init", "--rootful",
+		"--cpus", strconv.FormatUint(machineSetup.cpus, 10),
+		"--memory", strconv.FormatUint(machineSetup.memoryMiB, 10),
+		"--disk-size", strconv.FormatUint(machineSetup.diskGiB, 10),
+		config.MachineName)
+}
+
+// reconfigureMachine raises the machine settings to the minimums, the disk
+// can only grow
+func reconfigureMachine(m utils.Machine) error {
+	if !machineSetup.assumeYes {
+		reconfigure, err := utils.AskYesNo(fmt.Sprintf("Stop and reconfigure the podman machine %s?", m.Name))
+		if err != nil {
+			return err
+		}
+		if !reconfigure {
+			return fmt.Errorf("the podman machine %s is not suitable", m.Name)
+		}
+	}
+
+	if m.Running {
+		if err := podmanMachine("stop", m.Name); err != nil {
+			return err
+		}
+	}
+	args := []string{"set", "--rootful"}
+	if m.CPUs < machineSetup.cpus {
+		args = append(args, "--cpus", strconv.FormatUint(machineSetup.cpus, 10))
+	}
+	if m.MemoryMiB < machineSetup.memoryMiB {
+		args = append(args, "--memory", strconv.FormatUint(machineSetup.memoryMiB, 10))
+	}
+	if m.DiskGiB < machineSetup.diskGiB {
+		args = append(args, "--disk-size", strconv.FormatUint(machineSetup.diskGiB, 10))
+	}
+	return podmanMachine(append(args, m.Name)...)
+}
+
+// podmanMachine delegates to the podman machine commands, showing their output
+func podmanMachine(args ...string) error {
+	cmd := exec.Command("podman", append([]string{"machine"}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("podman machine %s: %w", args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"gitlab.com/bootc-org/podman-bootc/pkg/api"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+
+	"github.com/spf13/pflag"
+)
+
+// outputFlags control the output of the commands building disk images
+type outputFlags struct {
+	quiet   int
+	verbose int
+	// format is "json" for commands printing their result as JSON on stdout
+	format string
+	fancy  bool
+}
+
+var outputOpts outputFlags
+
+func addVerbosityFlags(flags *pflag.FlagSet, quietUsage string) {
+	flags.CountVarP(&outputOpts.quiet, "quiet", "q", quietUsage)
+	flags.CountVarP(&outputOpts.verbose, "verbose", "v", "Show more details, -vv also logs debug messages and the install container spec")
+	flags.BoolVar(&outputOpts.fancy, "fancy", false, "Show the steps and phases of the build with a spinner and the elapsed time, -v adds the install output; on by default on a terminal, off with --quiet or JSON output")
+}
+
+// changed reports if the verbosity differs from the default of the command
+func (f outputFlags) changed() bool {
+	return f.quiet != 0 || f.verbose != 0 || f.json()
+}
+
+// verbosity returns the verbosity of the flags. The JSON output mode only
+// reports errors on stderr unless -v is passed.
+func (f outputFlags) verbosity() api.Verbosity {
+	if f.json() && f.quiet == 0 && f.verbose == 0 {
+		return api.VerbositySilent
+	}
+	return api.NewVerbosity(f.quiet, f.verbose)
+}
+
+func (f outputFlags) json() bool {
+	return f.format == "json"
+}
+
+// fancyEnabled reports if the build shows the progress UI: with --fancy, or
+// by default on an interactive terminal, never when quiet or printing JSON
+func (f outputFlags) fancyEnabled(flags *pflag.FlagSet) bool {
+	if f.quiet > 0 || f.json() {
+		return false
+	}
+	if flags.Changed("fancy") {
+		return f.fancy
+	}
+	return utils.IsInteractive()
+}
+
+// buildVerbosity returns the verbosity of the build. The progress UI shows
+// the phases of the install instead of its output, unless -v is given.
+func (f outputFlags) buildVerbosity(fancy bool) api.Verbosity {
+	v := f.verbosity()
+	if fancy && v == api.VerbosityNormal {
+		return api.VerbosityQuiet
+	}
+	return v
+}
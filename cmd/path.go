@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/vm"
+
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pathFormat string
+	pathCmd    = &cobra.Command{
+		Use:   "path",
+		Short: "Print the locations used by podman-bootc",
+		Long:  "Print the locations used by podman-bootc, for scripts which must not hardcode them",
+		Args:  cobra.NoArgs,
+		RunE:  doPath,
+	}
+	pathCacheCmd = &cobra.Command{
+		Use:   "cache",
+		Short: "Print the directory of the disk image cache",
+		Args:  cobra.NoArgs,
+		RunE:  printUserPath(func(u user.User) string { return u.CacheDir() }),
+	}
+	pathStateCmd = &cobra.Command{
+		Use:   "state",
+		Short: "Print the directory of the state kept across invocations",
+		Args:  cobra.NoArgs,
+		RunE:  printUserPath(func(u user.User) string { return u.StateDir() }),
+	}
+	pathDiskCmd = &cobra.Command{
+		Use:   "disk <ID|image>",
+		Short: "Print the path of the disk image of a cached disk or a local image",
+		Args:  cobra.ExactArgs(1),
+		RunE:  doPathDisk,
+	}
+	pathInstanceCmd = &cobra.Command{
+		Use:   "instance <ID>",
+		Short: "Print the directory of a VM, holding its disk, configuration and SSH key",
+		Args:  cobra.ExactArgs(1),
+		RunE:  doPathInstance,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(pathCmd)
+	pathCmd.Flags().StringVar(&pathFormat, "format", "", "Output format of all the locations, either empty for a list or 'json'")
+	pathCmd.AddCommand(pathCacheCmd)
+	pathCmd.AddCommand(pathStateCmd)
+	pathCmd.AddCommand(pathDiskCmd)
+	pathCmd.AddCommand(pathInstanceCmd)
+}
+
+// userPaths are the locations of the user, independent of any image
+type userPaths struct {
+	Cache string `json:"cache"`
+	Run   string `json:"run"`
+	State string `json:"state"`
+	Logs  string `json:"logs"`
+}
+
+func doPath(_ *cobra.Command, _ []string) error {
+	u, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+	paths := userPaths{
+		Cache: u.CacheDir(),
+		Run:   u.RunDir(),
+		State: u.StateDir(),
+		Logs:  u.LogDir(),
+	}
+
+	switch pathFormat {
+	case "":
+		fmt.Printf("cache: %s\nrun: %s\nstate: %s\nlogs: %s\n", paths.Cache, paths.Run, paths.State, paths.Logs)
+		return nil
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(paths)
+	default:
+		return fmt.Errorf("unknown format %q", pathFormat)
+	}
+}
+
+func printUserPath(path func(user.User) string) func(*cobra.Command, []string) error {
+	return func(_ *cobra.Command, _ []string) error {
+		u, err := user.NewUser()
+		if err != nil {
+			return err
+		}
+		fmt.Println(path(u))
+		return nil
+	}
+}
+
+func doPathDisk(_ *cobra.Command, args []string) error {
+	u, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+	if longID, _, err := vm.GetVMCachePath(args[0], u); err == nil {
+		fmt.Println(u.DiskImagePath(longID))
+		return nil
+	}
+
+	// Not cached yet, the disk of a local image is built at its id
+	ctx, _, err := podmanConnection(u)
+	if err != nil {
+		return err
+	}
+	image, err := images.GetImage(ctx, args[0], &images.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("%s is neither a cached disk nor a local image: %w", args[0], err)
+	}
+	fmt.Println(u.DiskImagePath(image.ID))
+	return nil
+}
+
+func doPathInstance(_ *cobra.Command, args []string) error {
+	u, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+	_, dir, err := vm.GetVMCachePath(args[0], u)
+	if err != nil {
+		return err
+	}
+	fmt.Println(dir)
+	return nil
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/api"
+	"gitlab.com/bootc-org/podman-bootc/pkg/service"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+)
+
+var (
+	remoteService  string
+	remoteDetach   bool
+	remoteFormat   string
+	remoteBuildCmd = &cobra.Command{
+		Use:   "remote-build <image>",
+		Short: "Build a disk image with the build service of this host",
+		Long: "Submit the build of a disk image to 'podman-bootc serve' and follow its progress. " +
+			"The disk image is built in the cache of the user running the service.",
+		Args: cobra.ExactArgs(1),
+		RunE: doRemoteBuild,
+	}
+	remoteListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the jobs of the build service",
+		Args:  cobra.NoArgs,
+		RunE:  doRemoteList,
+	}
+	remoteStatusCmd = &cobra.Command{
+		Use:   "status <job ID>",
+		Short: "Show the status of a job of the build service",
+		Args:  cobra.ExactArgs(1),
+		RunE:  doRemoteStatus,
+	}
+	remoteCancelCmd = &cobra.Command{
+		Use:   "cancel <job ID>",
+		Short: "Cancel a job of the build service",
+		Args:  cobra.ExactArgs(1),
+		RunE:  doRemoteCancel,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(remoteBuildCmd)
+	remoteBuildCmd.AddCommand(remoteListCmd, remoteStatusCmd, remoteCancelCmd)
+	remoteBuildCmd.PersistentFlags().StringVar(&remoteService, "service", "", "Address of the build service, unix:///path/to/socket; build.sock in the run directory by default")
+	remoteBuildCmd.PersistentFlags().StringVar(&remoteFormat, "format", "", "Output format, either empty or 'json'")
+	remoteBuildCmd.Flags().BoolVarP(&remoteDetach, "detach", "d", false, "Print the job ID and exit instead of following the build")
+	addDiskImageOptionFlags(remoteBuildCmd.Flags())
+}
+
+func remoteClient() (*service.Client, error) {
+	switch remoteFormat {
+	case "", "json":
+	default:
+		return nil, fmt.Errorf("unknown format %q", remoteFormat)
+	}
+	user, err := user.NewUser()
+	if err != nil {
+		return nil, err
+	}
+	return service.NewClient(serviceAddress(user, remoteService))
+}
+
+func doRemoteBuild(_ *cobra.Command, args []string) error {
+	client, err := remoteClient()
+	if err != nil {
+		return err
+	}
+	diskImageConfigInstance.RunDefaults, err = api.ParseRunDefaults(runDefaultSettings)
+	if err != nil {
+		return err
+	}
+	diskImageConfigInstance.FilesystemOptions, err = api.ParseFilesystemOptions(mkfsOptionSettings)
+	if err != nil {
+		return err
+	}
+
+	job, err := client.Submit(operationCtx, service.BuildRequest{Image: args[0], Options: diskImageConfigInstance})
+	if err != nil {
+		return err
+	}
+	if remoteDetach {
+		fmt.Println(job.Id)
+		return nil
+	}
+
+	// stdout only carries the result in the JSON output mode
+	var progress io.Writer = os.Stdout
+	if remoteFormat == "json" {
+		progress = os.Stderr
+	}
+	err = client.Events(operationCtx, job.Id, 0, func(e service.Event) error {
+		switch {
+		case e.Progress != nil && e.Progress.Message != "":
+			fmt.Fprintln(progress, e.Progress.Message)
+		case e.State != "":
+			fmt.Fprintf(progress, "Job %s %s\n", job.Id, e.State)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	job, err = client.Job(operationCtx, job.Id)
+	if err != nil {
+		return err
+	}
+	if job.State != service.JobSucceeded {
+		return fmt.Errorf("job %s %s: %s", job.Id, job.State, job.Error)
+	}
+	if remoteFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diskBuildResult{Id: job.Result.Id, Path: job.Result.Path})
+	}
+	fmt.Println(job.Result.Path)
+	return nil
+}
+
+func doRemoteList(_ *cobra.Command, _ []string) error {
+	client, err := remoteClient()
+	if err != nil {
+		return err
+	}
+	jobs, err := client.Jobs(operationCtx)
+	if err != nil {
+		return err
+	}
+	if remoteFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(jobs)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB ID\tIMAGE\tSTATE\tSUBMITTED\tRESULT")
+	for _, job := range jobs {
+		result := job.Error
+		if job.Result != nil {
+			result = job.Result.Path
+		}
+		submitted := units.HumanDuration(time.Since(job.Submitted)) + " ago"
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", job.Id, job.Image, job.State, submitted, result)
+	}
+	return w.Flush()
+}
+
+func doRemoteStatus(_ *cobra.Command, args []string) error {
+	client, err := remoteClient()
+	if err != nil {
+		return err
+	}
+	job, err := client.Job(operationCtx, args[0])
+	if err != nil {
+		return err
+	}
+	return printJob(job)
+}
+
+func doRemoteCancel(_ *cobra.Command, args []string) error {
+	client, err := remoteClient()
+	if err != nil {
+		return err
+	}
+	job, err := client.Cancel(operationCtx, args[0])
+	if err != nil {
+		return err
+	}
+	return printJob(job)
+}
+
+// printJob prints the status of the job, as JSON with --format json
+func printJob(job service.Job) error {
+	if remoteFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(job)
+	}
+	fmt.Printf("Job %s building %s is %s\n", job.Id, job.Image, job.State)
+	if job.Result != nil {
+		fmt.Println(job.Result.Path)
+	} else if job.Error != "" {
+		fmt.Println(job.Error)
+	}
+	return nil
+}
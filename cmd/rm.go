@@ -1,14 +1,17 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
+	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
 	"gitlab.com/bootc-org/podman-bootc/pkg/config"
 	"gitlab.com/bootc-org/podman-bootc/pkg/user"
 	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
 	"gitlab.com/bootc-org/podman-bootc/pkg/vm"
 
+	"github.com/docker/go-units"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -16,6 +19,7 @@ import (
 var (
 	force     = false
 	removeAll = false
+	rmDryRun  = false
 	rmCmd     = &cobra.Command{
 		Use:   "rm <ID>",
 		Short: "Remove installed bootc VMs",
@@ -27,8 +31,9 @@ var (
 
 func init() {
 	RootCmd.AddCommand(rmCmd)
-	rmCmd.Flags().BoolVar(&removeAll, "all", false, "Removes all non-running bootc VMs")
-	rmCmd.Flags().BoolVarP(&force, "force", "f", false, "Terminate a running VM")
+	rmCmd.Flags().BoolVar(&removeAll, "all", false, "Stop all bootc VMs, then remove all cached disk images")
+	rmCmd.Flags().BoolVar(&rmDryRun, "dry-run", false, "With --all, only print what would be stopped and removed")
+	rmCmd.Flags().BoolVarP(&force, "force", "f", false, "Terminate a running VM and remove a pinned disk image; with --all, remove without asking, pinned disk images too")
 }
 
 func oneOrAll() cobra.PositionalArgs {
@@ -56,6 +61,12 @@ func prune(id string) error {
 	if err != nil {
 		return err
 	}
+	if _, cacheDir, err := vm.GetVMCachePath(id, user); err == nil && bootc.PinProtects(cacheDir, force) {
+		return fmt.Errorf("the disk image of %s is pinned, unpin it with podman-bootc disk unpin or use --force", id)
+	}
+	if err := disableAutostart(id, user); err != nil {
+		return err
+	}
 
 	bootcVM, err := vm.NewVM(vm.NewVMParameters{
 		ImageID:    id,
@@ -90,6 +101,25 @@ func prune(id string) error {
 	return nil
 }
 
+// pruneEntry is a cache entry removed by rm --all, it stays locked until
+// all of them are removed
+type pruneEntry struct {
+	id      string
+	vm      vm.BootcVM
+	usage   int64
+	skipped bool
+}
+
+// pruneFailure is a cache entry rm --all could not remove
+type pruneFailure struct {
+	id    string
+	err   error
+	inUse bool
+}
+
+// pruneAll stops all the instances, then removes all the cache entries.
+// Entries used by another invocation are skipped, the failures are reported
+// once the rest is removed and fail the command.
 func pruneAll() error {
 	user, err := user.NewUser()
 	if err != nil {
@@ -101,16 +131,118 @@ func pruneAll() error {
 		return err
 	}
 
+	var (
+		entries  []pruneEntry
+		failures []pruneFailure
+	)
+	defer func() {
+		for _, e := range entries {
+			e.vm.CloseConnection()
+			if err := e.vm.Unlock(); err != nil {
+				logrus.Warningf("unable to unlock VM %s: %v", e.id, err)
+			}
+		}
+	}()
+
+	interactive := utils.IsInteractive() && !rmDryRun && !force
 	for _, f := range files {
-		if f.IsDir() {
-			vmID := f.Name()
-			err := prune(vmID)
+		if !f.IsDir() || len(f.Name()) != 64 {
+			continue
+		}
+		id := f.Name()
+		bootcVM, err := vm.NewVM(vm.NewVMParameters{
+			ImageID:    id,
+			LibvirtUri: config.LibvirtUri,
+			User:       user,
+			Locking:    utils.Exclusive,
+		})
+		if err != nil {
+			if errors.Is(err, vm.ErrVMInUse) {
+				// e.g. a VM with an active ssh session, it is not an error
+				failures = append(failures, pruneFailure{id: id, err: errors.New("in use by another invocation"), inUse: true})
+				continue
+			}
+			failures = append(failures, pruneFailure{id: id, err: err})
+			continue
+		}
+		usage, err := utils.DiskUsage(user.ImageCacheDir(id))
+		if err != nil {
+			logrus.Debugf("unable to compute the disk usage of %s: %v", id, err)
+		}
+		entries = append(entries, pruneEntry{id: id, vm: bootcVM, usage: usage})
+
+		if bootc.PinProtects(user.ImageCacheDir(id), force) {
+			fmt.Printf("Skipping pinned %s (%s)\n", id[:12], units.HumanSize(float64(usage)))
+			entries[len(entries)-1].skipped = true
+			continue
+		}
+		if interactive {
+			ok, err := utils.AskYesNo(fmt.Sprintf("Remove %s (%s)?", id[:12], units.HumanSize(float64(usage))))
 			if err != nil {
-				logrus.Errorf("unable to remove %s: %v", vmID, err)
+				return err
+			}
+			if !ok {
+				// Kept locked until the end, like the other entries
+				entries[len(entries)-1].skipped = true
+			}
+		}
+	}
+
+	// Stop every instance before removing any disk
+	removable := entries[:0:0]
+	for _, e := range entries {
+		if e.skipped {
+			continue
+		}
+		running, err := e.vm.IsRunning()
+		if err != nil {
+			failures = append(failures, pruneFailure{id: e.id, err: err})
+			continue
+		}
+		if rmDryRun {
+			if running {
+				fmt.Printf("Would stop %s\n", e.id[:12])
 			}
+		} else if err := disableAutostart(e.id, user); err != nil {
+			failures = append(failures, pruneFailure{id: e.id, err: err})
+			continue
+		} else if err := e.vm.Delete(); err != nil {
+			failures = append(failures, pruneFailure{id: e.id, err: err})
+			continue
 		}
+		removable = append(removable, e)
 	}
 
+	var reclaimed int64
+	for _, e := range removable {
+		if rmDryRun {
+			fmt.Printf("Would remove %s (%s)\n", e.id[:12], units.HumanSize(float64(e.usage)))
+		} else if err := e.vm.DeleteFromCache(); err != nil {
+			failures = append(failures, pruneFailure{id: e.id, err: err})
+			continue
+		}
+		reclaimed += e.usage
+	}
+
+	if rmDryRun {
+		fmt.Printf("Would reclaim %s\n", units.HumanSize(float64(reclaimed)))
+	} else {
+		fmt.Printf("Reclaimed %s\n", units.HumanSize(float64(reclaimed)))
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	failed := 0
+	fmt.Fprintf(os.Stderr, "Unable to remove:\n")
+	for _, f := range failures {
+		fmt.Fprintf(os.Stderr, "  %s: %v\n", f.id[:12], f.err)
+		if !f.inUse {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("unable to remove %d cache entries", failed)
+	}
 	return nil
 }
 
@@ -1,9 +1,18 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
+	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
+	"gitlab.com/bootc-org/podman-bootc/pkg/logfile"
+	"gitlab.com/bootc-org/podman-bootc/pkg/owner"
 	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/vm"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -21,7 +30,27 @@ var (
 	ExitCode int
 )
 
-var rootLogLevel string
+var (
+	rootLogLevel string
+	rootLogFile  string
+	rootTimeout  time.Duration
+	// rootConnection and rootURL select the podman service instead of the
+	// rootful podman machine
+	rootConnection string
+	rootURL        string
+	rootChown      string
+)
+
+// artifactOwner is given the files created for the user, nil to keep the
+// owner of the process
+var artifactOwner *owner.Owner
+
+// operationCtx bounds the whole invocation with --timeout, canceling it
+// cancels the build
+var (
+	operationCtx    context.Context    = context.Background()
+	operationCancel context.CancelFunc = func() {}
+)
 
 func preExec(cmd *cobra.Command, args []string) error {
 	if rootLogLevel != "" {
@@ -30,6 +59,22 @@ func preExec(cmd *cobra.Command, args []string) error {
 			return err
 		}
 		logrus.SetLevel(level)
+	} else if outputOpts.changed() {
+		logrus.SetLevel(outputOpts.verbosity().LogLevel())
+	}
+
+	if rootTimeout < 0 {
+		return fmt.Errorf("invalid timeout %s", rootTimeout)
+	}
+	if rootTimeout > 0 {
+		operationCtx, operationCancel = context.WithTimeout(context.Background(), rootTimeout)
+	} else {
+		operationCtx, operationCancel = context.WithCancel(context.Background())
+	}
+
+	var err error
+	if artifactOwner, err = owner.Resolve(rootChown); err != nil {
+		return fmt.Errorf("invalid --chown: %w", err)
 	}
 
 	user, err := user.NewUser()
@@ -37,9 +82,30 @@ func preExec(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	// A cache created in the home of the invoking user under sudo belongs to
+	// them, the cache of root stays root's
+	if err := artifactOwner.InHome(user.CacheDir()).MkdirAll(user.CacheDir(), os.ModePerm); err != nil {
+		return err
+	}
 	if err := user.InitOSCDirs(); err != nil {
 		return err
 	}
+
+	logFile := rootLogFile
+	if logFile == "" && logrus.GetLevel() >= logrus.DebugLevel {
+		if err := artifactOwner.InHome(user.LogDir()).MkdirAll(user.LogDir(), 0o700); err != nil {
+			return err
+		}
+		logFile, err = logfile.DefaultPath(user.LogDir(), logfile.Kept)
+		if err != nil {
+			return err
+		}
+	}
+	if logFile != "" {
+		if err := logfile.Start(logFile, logrus.GetLevel()); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -47,12 +113,51 @@ func preExec(cmd *cobra.Command, args []string) error {
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	err := RootCmd.Execute()
+	operationCancel()
+	logFile := logfile.Stop()
+	if logFile != "" {
+		chownLogFile(logFile)
+	}
 	if err != nil {
+		if logFile != "" {
+			fmt.Fprintf(os.Stderr, "The log of this invocation is at %s\n", logFile)
+		}
+		var (
+			configErr *bootc.ConfigError
+			unitErr   *vm.UnitError
+		)
+		switch {
+		case errors.As(err, &configErr):
+			os.Exit(2)
+		case errors.As(err, &unitErr):
+			// The VM booted but the unit of --wait-unit did not become active
+			os.Exit(3)
+		case errors.Is(err, vm.ErrSSHNotReady):
+			os.Exit(4)
+		}
 		os.Exit(1)
 	}
 }
 
+// chownLogFile gives the log file and its rotated files to artifactOwner.
+// The default ones are only given to it in its home.
+func chownLogFile(path string) {
+	o := artifactOwner
+	if rootLogFile == "" {
+		o = o.InHome(path)
+	}
+	rotated, _ := filepath.Glob(path + ".*")
+	if err := o.Chown(append(rotated, path)...); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+}
+
 func init() {
 	logrus.SetLevel(logrus.WarnLevel)
 	RootCmd.PersistentFlags().StringVarP(&rootLogLevel, "log-level", "", "", "Set log level")
+	RootCmd.PersistentFlags().DurationVar(&rootTimeout, "timeout", 0, "Bound the pull and build of the disk image, e.g. 45m; 0 disables the timeout")
+	RootCmd.PersistentFlags().StringVarP(&rootConnection, "connection", "c", "", "Use this podman connection, see 'podman system connection list', instead of the rootful podman machine")
+	RootCmd.PersistentFlags().StringVar(&rootURL, "url", "", "Use the podman service at this URL, e.g. unix:///run/podman/podman.sock, instead of the rootful podman machine")
+	RootCmd.PersistentFlags().StringVar(&rootChown, "chown", "", "Give the files created for the user, e.g. exports, bundles and logs, to this user[:group]; defaults to the user invoking sudo")
+	RootCmd.PersistentFlags().StringVar(&rootLogFile, "log-file", "", "Write the whole log of the invocation, including the install output, to this file; defaults to a file in the state directory with --log-level debug")
 }
@@ -5,18 +5,23 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
+	"gitlab.com/bootc-org/podman-bootc/pkg/api"
 	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
 	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+	"gitlab.com/bootc-org/podman-bootc/pkg/systemd"
 	"gitlab.com/bootc-org/podman-bootc/pkg/user"
 	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
 	"gitlab.com/bootc-org/podman-bootc/pkg/vm"
 
 	"github.com/containers/podman/v5/pkg/bindings"
+	"github.com/containers/podman/v5/pkg/bindings/system"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 type osVmConfig struct {
@@ -27,7 +32,17 @@ type osVmConfig struct {
 	NoCredentials   bool
 	RemoveVm        bool // Kill the running VM when it exits
 	RemoveDiskImage bool // After exit of the VM, remove the disk image
-	Quiet           bool
+	Memory          string
+	CPUs            int
+	TPM             bool
+	Publish         []string
+	Generation      string
+	Restart         string // systemd restart policy, see systemd.ValidateRestart
+	SystemUnit      bool   // install a system unit instead of a user unit
+	Accel           string // see vm.ValidateAccel
+	WaitUnit        string // systemd unit of the guest to wait for, NAME[:timeout]
+	DataDisks       []string
+	RemoveDataDisks bool // remove the existing data disks before attaching the ones of DataDisks
 }
 
 var (
@@ -42,71 +57,282 @@ var (
 	}
 
 	vmConfig                = osVmConfig{}
-	diskImageConfigInstance = bootc.DiskImageConfig{}
+	diskImageConfigInstance = api.InstallOptions{}
+	runDefaultSettings      []string
+	mkfsOptionSettings      []string
 )
 
 func init() {
 	RootCmd.AddCommand(runCmd)
+	addDiskImageFlags(runCmd.Flags())
 	runCmd.Flags().StringVarP(&vmConfig.User, "user", "u", "root", "--user <user name> (default: root)")
 
 	runCmd.Flags().StringVar(&vmConfig.CloudInitDir, "cloudinit", "", "--cloudinit <cloud-init data directory>")
 
-	runCmd.Flags().StringVar(&diskImageConfigInstance.Filesystem, "filesystem", "", "Override the root filesystem (e.g. xfs, btrfs, ext4)")
 	runCmd.Flags().BoolVar(&vmConfig.NoCredentials, "no-creds", false, "Do not inject default SSH key via credentials; also implies --background")
 	runCmd.Flags().BoolVarP(&vmConfig.Background, "background", "B", false, "Do not spawn SSH, run in background")
 	runCmd.Flags().BoolVar(&vmConfig.RemoveVm, "rm", false, "Remove the VM and it's disk when the SSH session exits. Cannot be used with --background")
-	runCmd.Flags().BoolVar(&vmConfig.Quiet, "quiet", false, "Suppress output from bootc disk creation and VM boot console")
-	runCmd.Flags().StringVar(&diskImageConfigInstance.RootSizeMax, "root-size-max", "", "Maximum size of root filesystem in bytes; optionally accepts M, G, T suffixes")
-	runCmd.Flags().StringVar(&diskImageConfigInstance.DiskSize, "disk-size", "", "Allocate a disk image of this size in bytes; optionally accepts M, G, T suffixes")
+	addVerbosityFlags(runCmd.Flags(), "Suppress the output from bootc disk creation and the VM boot console, -qq also the progress updates")
+	runCmd.Flags().StringVar(&vmConfig.Memory, "memory", "2G", "Memory of the VM; optionally accepts M, G suffixes")
+	runCmd.Flags().IntVar(&vmConfig.CPUs, "cpus", 2, "Number of virtual CPUs of the VM")
+	runCmd.Flags().BoolVar(&vmConfig.TPM, "tpm", true, "Attach an emulated TPM 2.0 to the VM")
+	runCmd.Flags().StringVar(&vmConfig.Accel, "accel", vm.AccelAuto, "Accelerator of the VM: kvm, tcg for the slow software emulation, or auto for kvm when it is usable and tcg otherwise")
+	runCmd.Flags().StringVar(&vmConfig.Generation, "generation", "", "Boot a cached generation of the image repository, by number or id, instead of building the disk; see 'disk list'")
+	runCmd.Flags().StringArrayVarP(&vmConfig.Publish, "publish", "p", nil, "Forward a host TCP port to the VM, hostPort:guestPort")
+	runCmd.Flags().StringVar(&vmConfig.Restart, "restart", systemd.RestartNo, "Start the VM with the host and restart it with a systemd unit: always, on-failure or no")
+	runCmd.Flags().BoolVar(&vmConfig.SystemUnit, "system", false, "With --restart, install a system unit instead of a user unit")
+	runCmd.Flags().StringArrayVar(&vmConfig.DataDisks, "data-disk", nil, "Attach an empty data disk of this size, e.g. 20G, kept in the cache next to the disk image; a larger size grows an existing one; can be repeated")
+	runCmd.Flags().BoolVar(&vmConfig.RemoveDataDisks, "remove-data-disks", false, "Remove the existing data disks and their content before attaching the ones of --data-disk")
+	runCmd.Flags().StringVar(&vmConfig.WaitUnit, "wait-unit", "", "Wait for this systemd unit of the guest to become active, NAME[:timeout] with a default timeout of 5m; exits with 3 when it fails and 4 when the VM never boots")
 }
 
-func doRun(flags *cobra.Command, args []string) error {
-	//get user info who is running the podman bootc command
-	user, err := user.NewUser()
-	if err != nil {
-		return fmt.Errorf("unable to get user: %w", err)
+// podmanConnection connects to the podman service of the rootful podman
+// machine, or the one selected with --connection or --url
+func podmanConnection(user user.User) (context.Context, *utils.MachineInfo, error) {
+	if rootConnection != "" || rootURL != "" {
+		return selectedConnection()
 	}
 
-	//podman machine connection
 	machineInfo, err := utils.GetMachineInfo(user)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	if machineInfo == nil {
 		println(utils.PodmanMachineErrorMessage)
-		return errors.New("rootful podman machine is required, please run 'podman machine init --rootful'")
+		return nil, nil, errors.New("rootful podman machine is required, please run 'podman machine init --rootful'")
 	}
 
 	if !machineInfo.Rootful {
 		println(utils.PodmanMachineErrorMessage)
-		return errors.New("rootful podman machine is required, please run 'podman machine set --rootful'")
+		return nil, nil, errors.New("rootful podman machine is required, please run 'podman machine set --rootful'")
 	}
 
 	if _, err := os.Stat(machineInfo.PodmanSocket); err != nil {
 		println(utils.PodmanMachineErrorMessage)
 		logrus.Errorf("podman machine socket is missing. Is podman machine running?\n%s", err)
-		return err
+		return nil, nil, err
 	}
 
 	ctx, err := bindings.NewConnectionWithIdentity(
-		context.Background(),
+		operationCtx,
 		fmt.Sprintf("unix://%s", machineInfo.PodmanSocket),
 		machineInfo.SSHIdentityPath,
 		true)
 	if err != nil {
 		println(utils.PodmanMachineErrorMessage)
 		logrus.Errorf("failed to connect to the podman socket. Is podman machine running?\n%s", err)
+		return nil, nil, err
+	}
+	logrus.Debugf("Connected to podman API version %s", bindings.ServiceVersion(ctx))
+	diskImageConfigInstance.Connection = machineInfo.Name
+
+	return ctx, machineInfo, nil
+}
+
+// selectedConnection connects to the podman service selected with
+// --connection or --url, which must be rootful
+func selectedConnection() (context.Context, *utils.MachineInfo, error) {
+	conn, err := utils.ResolveConnection(rootConnection, rootURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Connecting pings the service
+	ctx, err := bindings.NewConnectionWithIdentity(operationCtx, conn.URI, conn.Identity, conn.Machine)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to reach the podman connection %s: %w", conn.Name, err)
+	}
+	info, err := system.Info(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get the info of the podman connection %s: %w", conn.Name, err)
+	}
+	if info.Host.Security.Rootless {
+		return nil, nil, fmt.Errorf("the podman connection %s is rootless, installing a disk image requires a rootful podman service", conn.Name)
+	}
+	logrus.Debugf("Connected to podman API version %s of connection %s", bindings.ServiceVersion(ctx), conn.Name)
+	diskImageConfigInstance.Connection = conn.Name
+
+	return ctx, &utils.MachineInfo{
+		Name:            conn.Name,
+		PodmanSocket:    conn.URI,
+		SSHIdentityPath: conn.Identity,
+		Rootful:         true,
+	}, nil
+}
+
+// addDiskImageFlags adds the flags configuring the disk image build
+func addDiskImageFlags(flags *pflag.FlagSet) {
+	addExplainConfigFlag(flags)
+	addDryRunInstallFlag(flags)
+	markDiskImageFlags(flags, func() { addDiskImageOptionFlags(flags) })
+}
+
+func addDiskImageOptionFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&diskImageConfigInstance.Filesystem, "filesystem", "", "Override the root filesystem (e.g. xfs, btrfs, ext4)")
+	flags.StringVar(&diskImageConfigInstance.ImageCeiling, "image-ceiling", "", "Maximum size of the cached disk images of the repository, the oldest generations are pruned after a build; optionally accepts K, M, G suffixes")
+	flags.StringArrayVar(&diskImageConfigInstance.Kargs, "karg", nil, "Kernel argument added by bootc install, e.g. console=ttyS0; can be repeated")
+	flags.StringArrayVar(&diskImageConfigInstance.ExtraInstallArgs, "install-arg", nil, "Argument appended verbatim to bootc install to-disk, e.g. --install-arg=--wipe; can be repeated")
+	flags.StringVar(&diskImageConfigInstance.InstallBackend, "install-backend", bootc.InstallBackendContainer, "Where bootc install runs: container, in a privileged container of the podman machine, or host, the bootc of the host against the image in its containers storage")
+	flags.StringVar(&diskImageConfigInstance.InstallConfig, "install-config", "", "bootc install configuration TOML mounted into the install container, it overrides the configuration of the image")
+	flags.StringVar(&diskImageConfigInstance.InstallMode, "install-mode", bootc.InstallModeDisk, "How bootc installs the image: to-disk, to a new cached disk image, or to-filesystem, to the existing --install-target (disk build only)")
+	flags.StringVar(&diskImageConfigInstance.InstallTarget, "install-target", "", "Mounted directory or partitioned disk image installed to by --install-mode to-filesystem, it is not cached")
+	flags.StringVar(&diskImageConfigInstance.InstallReplace, "install-replace", "", "How --install-mode to-filesystem replaces the system of the target: wipe or alongside; a previous install is wiped by default")
+	flags.StringVar(&diskImageConfigInstance.Preallocation, "preallocation", bootc.PreallocationSparse, "Allocation of the disk image: sparse, falloc allocates its blocks with fallocate, full writes zeros to it")
+	flags.StringVar(&diskImageConfigInstance.LosetupDirectIO, "losetup-direct-io", bootc.LosetupDirectIOAuto, "Direct IO of the loop devices of the install: auto disables it with a losetup wrapper when the bootc of the image needs it, on never disables it, off always does")
+	flags.StringVar(&diskImageConfigInstance.DiskMode, "disk-mode", "", "Octal permissions of the disk image, e.g. 0640, also restored on a cached disk image")
+	flags.StringVar(&diskImageConfigInstance.DiskOwner, "disk-owner", "", "Owner of the disk image, user[:group], e.g. qemu:qemu, also restored on a cached disk image; another user requires root")
+	flags.StringVar(&diskImageConfigInstance.PostInstallHook, "post-install-hook", "", "Executable run on the host with the path of the new disk image as $1 before it is cached, e.g. to add files; a non-zero exit aborts the build")
+	flags.StringVar(&diskImageConfigInstance.Format, "disk-format", "", "Format of the disk image, raw (default) or qcow2, converted with qemu-img from the install image")
+	flags.StringVar(&diskImageConfigInstance.BlockSetup, "block-setup", "", "Block setup of the root filesystem passed to bootc install, direct or tpm2-luks for a LUKS root bound to a TPM 2.0")
+	flags.StringArrayVar(&mkfsOptionSettings, "mkfs-option", nil, "Extra mkfs options of the root filesystem, filesystem=options, e.g. \"xfs=-i size=1024 -m reflink=1\"; can be repeated")
+	flags.StringArrayVar(&diskImageConfigInstance.RootSSHKeys, "root-ssh-key", nil, "SSH authorized keys file of root baked into the disk image by bootc install, e.g. ~/.ssh/id_ed25519.pub; can be repeated")
+	flags.StringVar(&diskImageConfigInstance.RootSizeMax, "root-size-max", "", "Maximum size of root filesystem in bytes; optionally accepts M, G, T suffixes")
+	flags.StringVar(&diskImageConfigInstance.DiskSize, "disk-size", "", "Allocate a disk image of this size in bytes; optionally accepts M, G, T suffixes")
+	flags.StringVar(&diskImageConfigInstance.MinDiskSize, "disk-size-minimum", "", "Minimum size of the disk image computed from the image size, 10GB when unset; optionally accepts M, G, T suffixes")
+	flags.StringVar(&diskImageConfigInstance.LargeDiskThreshold, "large-disk-threshold", "100GB", "Ask for confirmation before creating a disk image larger than this; optionally accepts M, G, T suffixes")
+	flags.BoolVarP(&diskImageConfigInstance.AssumeYes, "yes", "y", false, "Do not ask for confirmation before creating large disk images")
+	flags.BoolVar(&diskImageConfigInstance.AutoRepair, "auto-repair", false, "Remove and pull the image again, then retry once, when the install fails on corrupted image layers")
+	flags.StringVar(&diskImageConfigInstance.RegistryMirror, "registry-mirror", "", "Pull the image through this registry mirror (host[:port]) instead of its registry")
+	flags.BoolVar(&diskImageConfigInstance.MirrorFallback, "registry-mirror-fallback", false, "Pull from the image registry when pulling through the mirror fails")
+	flags.StringArrayVar(&runDefaultSettings, "set-run-default", nil, "Record a default run option on the disk image, key=value with key mem, cpus, tpm or publish")
+	flags.BoolVar(&diskImageConfigInstance.BoundImages, "bound-images", false, "Pull the logically bound images and copy them into the disk image, for offline use")
+	flags.StringVar(&diskImageConfigInstance.InstallerImage, "installer-image", "", "Run bootc from this image to install the image, for images not shipping bootc")
+	flags.DurationVar(&diskImageConfigInstance.MaxCacheAge, "max-cache-age", 0, "Rebuild cached disk images older than this, e.g. 720h; 0 disables it")
+	flags.DurationVar(&diskImageConfigInstance.InstallTimeout, "install-timeout", 0, "Stop bootc install and fail when it runs longer than this, e.g. 30m; 0 disables it")
+	flags.BoolVar(&diskImageConfigInstance.KeepOnFailure, "keep-on-failure", false, "Keep the install container and the temporary disk image when bootc install fails, for debugging; the next build of the image removes them")
+	flags.IntVar(&diskImageConfigInstance.InstallRetries, "install-retries", bootc.DefaultInstallRetries, "Retry bootc install this many times on a new disk image when it fails on a transient loop device error; 0 disables it")
+	flags.DurationVar(&diskImageConfigInstance.Heartbeat, "heartbeat", bootc.DefaultHeartbeat, "Print the phase in progress at this interval when the output is not a terminal, for CI logs; 0 disables it")
+	flags.BoolVar(&diskImageConfigInstance.ForceRebuild, "force-rebuild", false, "Run bootc install even when a matching disk image is cached, the cached one is replaced once the new one is built")
+	flags.BoolVar(&diskImageConfigInstance.VerifyContent, "verify-content", false, "Compare a sample of the files of /usr of a new disk image with the image, a failure keeps the disk image but marks it and fails the command")
+	flags.BoolVar(&diskImageConfigInstance.StrictCache, "strict-cache", false, "Fail instead of rebuilding when the cached disk image was modified outside of podman-bootc since it was built")
+	flags.StringVar(&diskImageConfigInstance.CacheStrictness, "cache-strictness", bootc.CacheStrictnessWarn, "Handling of cached disks built with different host inputs, e.g. on another host sharing the cache: off, warn or strict to rebuild them")
+	flags.StringVar(&diskImageConfigInstance.DigestFile, "digest-file", "", "Write the digest the image reference resolves to in this file, or use the digest it already contains, to use the same image across the commands of a pipeline")
+	flags.Uint16Var(&diskImageConfigInstance.IOWeight, "io-weight", 0, "Relative IO weight of the install container, 10 to 1000")
+	flags.StringVar(&diskImageConfigInstance.IOMax, "io-max", "", "Limit the reads and writes of the install container on the device of the cache, e.g. 50MB/s")
+	flags.BoolVar(&diskImageConfigInstance.Nice, "nice", false, "Run the install container with the lowest CPU and IO priority to keep the host responsive")
+	flags.BoolVar(&diskImageConfigInstance.Provenance, "provenance", false, "Write a SLSA provenance of the disk image linking it to the container image and the build options")
+	flags.StringVar(&diskImageConfigInstance.ProvenanceKey, "provenance-key", "", "Sign the provenance with this cosign key, implies --provenance")
+	flags.BoolVar(&diskImageConfigInstance.AdoptTemp, "adopt-temp", false, "Move a completed disk image which could not be renamed in place instead of rebuilding it")
+	flags.DurationVar(&diskImageConfigInstance.TombstoneWindow, "fail-fast-window", 0, "Fail fast when the same build failed within this duration, e.g. 1h; 0 disables it")
+	flags.BoolVar(&diskImageConfigInstance.Force, "force", false, "Retry a build which failed within the --fail-fast-window")
+	flags.DurationVar(&diskImageConfigInstance.LoopWait, "loop-wait", 0, "When the install fails without free loop devices in the podman machine, wait up to this long for them and retry, e.g. 5m")
+	flags.StringVar(&diskImageConfigInstance.RebuildStrategy, "rebuild-strategy", bootc.RebuildClean, "How to build the disk image of a new image version: 'clean' installs from scratch, 'upgrade' upgrades the previous disk image of the same repository")
+}
+
+func doRun(flags *cobra.Command, args []string) error {
+	//get user info who is running the podman bootc command
+	user, err := user.NewUser()
+	if err != nil {
+		return fmt.Errorf("unable to get user: %w", err)
+	}
+
+	//podman machine connection
+	ctx, machineInfo, err := podmanConnection(user)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range vmConfig.Publish {
+		if err := bootc.ValidatePublish(rule); err != nil {
+			return err
+		}
+	}
+	if err := vm.ValidateAccel(vmConfig.Accel); err != nil {
+		return err
+	}
+	for _, size := range vmConfig.DataDisks {
+		if _, err := bootc.ParseDataDiskSize(size); err != nil {
+			return err
+		}
+	}
+	if err := systemd.ValidateRestart(vmConfig.Restart); err != nil {
+		return err
+	}
+	var waitUnit *vm.WaitUnit
+	if vmConfig.WaitUnit != "" {
+		if vmConfig.NoCredentials {
+			return errors.New("--wait-unit queries the unit over SSH, it cannot be used with --no-creds")
+		}
+		unit, err := vm.ParseWaitUnit(vmConfig.WaitUnit)
+		if err != nil {
+			return err
+		}
+		waitUnit = &unit
+	}
+	if vmConfig.Restart != systemd.RestartNo {
+		if vmConfig.RemoveVm || len(args) > 1 {
+			return errors.New("--restart cannot be used with --rm or a command, the VM outlives the session")
+		}
+		if !systemd.Available() {
+			return systemd.ErrUnsupported
+		}
+	}
+	if diskImageConfigInstance.InstallMode == api.InstallModeFilesystem {
+		return fmt.Errorf("the VM boots a cached disk image, install to an existing target with disk build --install-mode %s", api.InstallModeFilesystem)
+	}
+	diskImageConfigInstance.RunDefaults, err = api.ParseRunDefaults(runDefaultSettings)
+	if err != nil {
 		return err
 	}
+	diskImageConfigInstance.FilesystemOptions, err = api.ParseFilesystemOptions(mkfsOptionSettings)
+	if err != nil {
+		return err
+	}
+
+	if explainFormat != "" {
+		return printConfigExplanation(ctx, user, args[0], flags.Flags())
+	}
+	if dryRunFormat != "" {
+		return printDryRunInstall(ctx, user, args[0], flags.Flags())
+	}
 
 	// create the disk image
 	idOrName := args[0]
-	bootcDisk := bootc.NewBootcDisk(idOrName, ctx, user)
-	err = bootcDisk.Install(vmConfig.Quiet, diskImageConfigInstance)
-
-	if err != nil {
-		return fmt.Errorf("unable to install bootc image: %w", err)
+	var generation bootc.Generation
+	repoTag := ""
+	if vmConfig.Generation != "" {
+		generation, err = bootc.ResolveGeneration(user, idOrName, vmConfig.Generation)
+		if err != nil {
+			return err
+		}
+		logrus.Infof("using generation %d (%s) of %s", generation.Number, generation.Id[:12], generation.Meta.Repository)
+	} else {
+		builder := api.NewDiskBuilder(ctx, user, idOrName)
+		activeBuilder = builder
+		ui := startFancy(flags.Flags())
+		if ui != nil {
+			builder.SetProgress(ui)
+			builder.SetProgressHook(ui.Event)
+		}
+		builder.SetVerbosity(outputOpts.buildVerbosity(ui != nil))
+		saveStats := recordStats(user, builder, "run")
+		result, err := builder.Build(diskImageConfigInstance)
+		saveStats()
+		if stopFancy() && err != nil {
+			err = fmt.Errorf("build cancelled: %w", err)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to install bootc image: %w", err)
+		}
+		if err := chownDigestFile(); err != nil {
+			return err
+		}
+		disk, err := builder.Disk()
+		if err != nil {
+			return err
+		}
+		meta, err := bootc.ReadDiskMeta(disk.Path)
+		if err != nil {
+			return err
+		}
+		generation = bootc.Generation{Id: disk.Id, Directory: filepath.Dir(disk.Path), Meta: meta}
+		repoTag = result.Image
+	}
+	bootcDisk := bootc.NewBootcDisk(generation.Id, ctx, user)
+	bootcDisk.UseGeneration(generation)
+	if repoTag != "" {
+		bootcDisk.RepoTag = repoTag
 	}
 
 	//start the VM
@@ -135,17 +361,33 @@ func doRun(flags *cobra.Command, args []string) error {
 		}
 	}()
 
+	// The generation may have been removed before the VM locked it
+	if vmConfig.Generation != "" {
+		if _, err := os.Stat(filepath.Join(bootcDisk.GetDirectory(), config.DiskImage)); err != nil {
+			return fmt.Errorf("generation %s was removed from the cache: %w", vmConfig.Generation, err)
+		}
+	}
+
+	applyRunDefaults(flags, bootcDisk.GetRunDefaults())
+
 	cmd := args[1:]
 	err = bootcVM.Run(vm.RunVMParameters{
-		Cmd:           cmd,
-		CloudInitDir:  vmConfig.CloudInitDir,
-		NoCredentials: vmConfig.NoCredentials,
-		CloudInitData: flags.Flags().Changed("cloudinit"),
-		RemoveVm:      vmConfig.RemoveVm,
-		Background:    vmConfig.Background,
-		SSHPort:       sshPort,
-		SSHIdentity:   machineInfo.SSHIdentityPath,
-		VMUser:        vmConfig.User,
+		Cmd:             cmd,
+		CloudInitDir:    vmConfig.CloudInitDir,
+		NoCredentials:   vmConfig.NoCredentials,
+		CloudInitData:   flags.Flags().Changed("cloudinit"),
+		RemoveVm:        vmConfig.RemoveVm,
+		Background:      vmConfig.Background,
+		SSHPort:         sshPort,
+		SSHIdentity:     machineInfo.SSHIdentityPath,
+		VMUser:          vmConfig.User,
+		Memory:          vmConfig.Memory,
+		CPUs:            vmConfig.CPUs,
+		TPM:             vmConfig.TPM,
+		Publish:         vmConfig.Publish,
+		Accel:           vmConfig.Accel,
+		DataDisks:       vmConfig.DataDisks,
+		RemoveDataDisks: vmConfig.RemoveDataDisks,
 	})
 
 	if err != nil {
@@ -157,8 +399,15 @@ func doRun(flags *cobra.Command, args []string) error {
 		return err
 	}
 
+	// The unit waits for the running VM and restarts it from now on
+	if vmConfig.Restart != systemd.RestartNo {
+		if err := enableAutostart(bootcDisk.GetImageId(), vmConfig.Restart, vmConfig.SystemUnit); err != nil {
+			return fmt.Errorf("enabling the restart policy: %w", err)
+		}
+	}
+
 	if !vmConfig.Background {
-		if !vmConfig.Quiet {
+		if outputOpts.verbosity() >= api.VerbosityNormal {
 			var vmConsoleWg sync.WaitGroup
 			vmConsoleWg.Add(1)
 			go func() {
@@ -189,6 +438,9 @@ func doRun(flags *cobra.Command, args []string) error {
 				return fmt.Errorf("WaitSshReady: %w", err)
 			}
 		}
+		if err := waitForUnit(bootcVM, waitUnit, vmConfig.RemoveVm || len(cmd) > 0); err != nil {
+			return err
+		}
 
 		// ssh into the VM
 		ExitCode, err = utils.WithExitCode(bootcVM.RunSSH(cmd))
@@ -203,7 +455,55 @@ func doRun(flags *cobra.Command, args []string) error {
 				return fmt.Errorf("unable to remove VM from cache: %w", err)
 			}
 		}
+	} else if waitUnit != nil {
+		if err := bootcVM.WaitForSSHToBeReady(); err != nil {
+			return fmt.Errorf("WaitSshReady: %w", err)
+		}
+		if err := waitForUnit(bootcVM, waitUnit, false); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
+
+// waitForUnit waits for the unit of --wait-unit to become active, the VM is
+// removed when it fails and removeVM is set
+func waitForUnit(bootcVM vm.BootcVM, unit *vm.WaitUnit, removeVM bool) error {
+	if unit == nil {
+		return nil
+	}
+	if outputOpts.verbosity() >= api.VerbosityNormal {
+		fmt.Printf("Waiting up to %s for %s to become active\n", unit.Timeout, unit.Name)
+	}
+	err := bootcVM.WaitForUnit(*unit)
+	if err == nil {
+		return nil
+	}
+	if removeVM {
+		if err := bootcVM.Delete(); err != nil {
+			logrus.Warnf("unable to remove the VM: %v", err)
+		}
+	}
+	return err
+}
+
+// applyRunDefaults uses the run defaults recorded on the disk image for
+// the options which are not set on the command line
+func applyRunDefaults(flags *cobra.Command, defaults *bootc.RunDefaults) {
+	if defaults == nil {
+		return
+	}
+	if defaults.Memory != "" && !flags.Flags().Changed("memory") {
+		vmConfig.Memory = defaults.Memory
+	}
+	if defaults.CPUs != 0 && !flags.Flags().Changed("cpus") {
+		vmConfig.CPUs = defaults.CPUs
+	}
+	if defaults.TPM != nil && !flags.Flags().Changed("tpm") {
+		vmConfig.TPM = *defaults.TPM
+	}
+	if len(defaults.Publish) > 0 && !flags.Flags().Changed("publish") {
+		vmConfig.Publish = defaults.Publish
+	}
+}
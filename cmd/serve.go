@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/api"
+	"gitlab.com/bootc-org/podman-bootc/pkg/service"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveListen  string
+	serveMaxJobs int
+	serveCmd     = &cobra.Command{
+		Use:   "serve",
+		Short: "Run a build service sharing the disk image cache of this host",
+		Long: "Accept disk image builds over an HTTP API on a unix socket, queue them and stream their progress to " +
+			"'podman-bootc remote-build'. The builds use the cache of the user running the service. " +
+			"The socket is only accessible to that user and its group, who may submit builds.",
+		Args: cobra.NoArgs,
+		RunE: doServe,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveListen, "listen", "", "Address to listen on, unix:///path/to/socket; build.sock in the run directory by default")
+	serveCmd.Flags().IntVar(&serveMaxJobs, "max-jobs", service.DefaultMaxJobs, "Number of builds running at once, the others are queued")
+}
+
+// serviceAddress returns the address of the build service, the socket in
+// the run directory of the user when address is empty
+func serviceAddress(u user.User, address string) string {
+	if address != "" {
+		return address
+	}
+	return "unix://" + filepath.Join(u.RunDir(), "build.sock")
+}
+
+func doServe(_ *cobra.Command, _ []string) error {
+	if serveMaxJobs <= 0 {
+		return fmt.Errorf("invalid --max-jobs %d", serveMaxJobs)
+	}
+	user, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+	ctx, _, err := podmanConnection(user)
+	if err != nil {
+		return err
+	}
+
+	address := serviceAddress(user, serveListen)
+	l, err := service.Listen(address)
+	if err != nil {
+		return err
+	}
+	m := service.NewManager(ctx, func(ctx context.Context, image string) service.Builder {
+		builder := api.NewDiskBuilder(ctx, user, image)
+		// The progress goes to the clients in the events of the job
+		builder.SetProgress(io.Discard)
+		return builder
+	}, serveMaxJobs)
+
+	// Cancel the builds ourselves instead of the global handler exiting
+	signal.Reset(os.Interrupt, syscall.SIGTERM)
+	serveCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Serving builds on %s, press Ctrl-C to stop\n", address)
+	return service.Serve(serveCtx, l, m)
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+	"gitlab.com/bootc-org/podman-bootc/pkg/systemd"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+	"gitlab.com/bootc-org/podman-bootc/pkg/vm"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// startPollInterval is how often start --wait checks the VM
+const startPollInterval = 5 * time.Second
+
+var (
+	startWait bool
+	startCmd  = &cobra.Command{
+		Use:   "start <ID>",
+		Short: "Start an existing VM in the background",
+		Long:  "Start an existing VM in the background with the options it was last run with",
+		Args:  cobra.ExactArgs(1),
+		RunE:  doStart,
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(startCmd)
+	startCmd.Flags().BoolVar(&startWait, "wait", false, "Wait until the VM stops, stop the VM on SIGTERM; used by the systemd units")
+}
+
+func doStart(_ *cobra.Command, args []string) error {
+	user, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+
+	longID, cacheDir, err := vm.GetVMCachePath(args[0], user)
+	if err != nil {
+		return err
+	}
+	bootcVM, err := vm.NewVM(vm.NewVMParameters{
+		ImageID:    longID,
+		LibvirtUri: config.LibvirtUri,
+		User:       user,
+		Locking:    utils.Shared,
+	})
+	if err != nil {
+		return err
+	}
+	defer bootcVM.CloseConnection()
+	locked := true
+	unlock := func() {
+		if !locked {
+			return
+		}
+		locked = false
+		if err := bootcVM.Unlock(); err != nil {
+			logrus.Warningf("unable to unlock VM %s: %v", longID, err)
+		}
+	}
+	defer unlock()
+
+	running, err := bootcVM.IsRunning()
+	if err != nil {
+		return err
+	}
+	if !running {
+		if err := startVM(bootcVM, user, longID, cacheDir); err != nil {
+			return err
+		}
+		fmt.Printf("Started %s\n", longID[:12])
+	}
+
+	if !startWait {
+		return nil
+	}
+	// stop and rm must be able to lock the VM while waiting
+	unlock()
+	return waitForVM(bootcVM)
+}
+
+// startVM boots the VM in the background with the options it was last run with
+func startVM(bootcVM vm.BootcVM, user user.User, longID, cacheDir string) error {
+	cfg, err := bootcVM.GetConfig()
+	if err != nil {
+		return fmt.Errorf("%s was never started with run: %w", longID[:12], err)
+	}
+	meta, err := bootc.ReadDiskMeta(filepath.Join(cacheDir, config.DiskImage))
+	if err != nil {
+		return err
+	}
+	bootcDisk := bootc.NewBootcDisk(longID, operationCtx, user)
+	bootcDisk.UseGeneration(bootc.Generation{Id: longID, Directory: cacheDir, Meta: meta})
+
+	sshPort, err := utils.GetFreeLocalTcpPort()
+	if err != nil {
+		return fmt.Errorf("unable to get free port for SSH: %w", err)
+	}
+	vmUser := cfg.VMUser
+	if vmUser == "" {
+		vmUser = "root"
+	}
+	if err := bootcVM.Run(vm.RunVMParameters{
+		Background:    true,
+		NoCredentials: cfg.SshIdentity == "",
+		SSHPort:       sshPort,
+		SSHIdentity:   cfg.SshIdentity,
+		VMUser:        vmUser,
+		Memory:        cfg.Memory,
+		CPUs:          cfg.CPUs,
+		TPM:           cfg.TPM,
+		Publish:       cfg.Publish,
+		Accel:         cfg.AccelOption,
+		DataDisks:     cfg.DataDisks,
+	}); err != nil {
+		return fmt.Errorf("runBootcVM: %w", err)
+	}
+	return bootcVM.WriteConfig(*bootcDisk)
+}
+
+// waitForVM returns when the VM stops, which is an error so that systemd
+// applies the restart policy, or stops it on SIGTERM
+func waitForVM(bootcVM vm.BootcVM) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(startPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return bootcVM.Delete()
+		case <-ticker.C:
+			running, err := bootcVM.IsRunning()
+			if err != nil {
+				return err
+			}
+			if !running {
+				return errors.New("the VM stopped")
+			}
+		}
+	}
+}
+
+// enableAutostart installs the systemd unit starting the VM with the host
+func enableAutostart(longID, restart string, system bool) error {
+	unit, err := autostartUnit(longID, restart, system)
+	if err != nil {
+		return err
+	}
+	return systemd.Install(unit)
+}
+
+// autostartUnit returns the systemd unit starting the VM of the image id
+func autostartUnit(longID, restart string, system bool) (systemd.Unit, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return systemd.Unit{}, err
+	}
+	unit := systemd.Unit{Id: longID, Restart: restart, System: system, Executable: executable}
+	if system {
+		user, err := user.NewUser()
+		if err != nil {
+			return systemd.Unit{}, err
+		}
+		unit.User = user.Username()
+	}
+	return unit, nil
+}
+
+// disableAutostart removes the systemd unit of the VM, if any
+func disableAutostart(id string, user user.User) error {
+	longID, _, err := vm.GetVMCachePath(id, user)
+	if err != nil {
+		return err
+	}
+	if err := systemd.Remove(longID); err != nil {
+		return fmt.Errorf("removing the systemd unit of %s: %w", longID[:12], err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/api"
+	"gitlab.com/bootc-org/podman-bootc/pkg/stats"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+
+	"github.com/docker/go-units"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statsFormat string
+	statsSince  time.Duration
+	statsCmd    = &cobra.Command{
+		Use:   "stats",
+		Short: "Show the local usage statistics of the disk builds",
+		Long: "Show how often the cached disk images were reused or rebuilt, the median build time and the bytes pulled, " +
+			"from the statistics kept on this host after 'stats enable'. The statistics are never sent anywhere.",
+		Args: cobra.NoArgs,
+		RunE: doStats,
+	}
+	statsEnableCmd = &cobra.Command{
+		Use:   "enable",
+		Short: "Keep local usage statistics of the disk builds",
+		Long:  "Append a record of every run and disk build to a file in the state directory. The statistics are never sent anywhere.",
+		Args:  cobra.NoArgs,
+		RunE:  func(_ *cobra.Command, _ []string) error { return setStatsEnabled(true) },
+	}
+	statsDisableCmd = &cobra.Command{
+		Use:   "disable",
+		Short: "Stop keeping local usage statistics, the records are kept",
+		Args:  cobra.NoArgs,
+		RunE:  func(_ *cobra.Command, _ []string) error { return setStatsEnabled(false) },
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(statsCmd)
+	statsCmd.AddCommand(statsEnableCmd, statsDisableCmd)
+	statsCmd.Flags().StringVar(&statsFormat, "format", "", "Output format, either empty for a table or 'json'")
+	statsCmd.Flags().DurationVar(&statsSince, "since", 0, "Only aggregate the records of this last period, e.g. 720h; 0 aggregates all of them")
+}
+
+func setStatsEnabled(enabled bool) error {
+	user, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return stats.Disable(user.StateDir())
+	}
+	if err := stats.Enable(user.StateDir()); err != nil {
+		return err
+	}
+	fmt.Printf("Usage statistics are kept in %s\n", filepath.Join(user.StateDir(), stats.File))
+	return nil
+}
+
+func doStats(_ *cobra.Command, _ []string) error {
+	switch statsFormat {
+	case "", "json":
+	default:
+		return fmt.Errorf("unknown format %s", statsFormat)
+	}
+	if statsSince < 0 {
+		return errors.New("--since must not be negative")
+	}
+	user, err := user.NewUser()
+	if err != nil {
+		return err
+	}
+
+	var since time.Time
+	if statsSince > 0 {
+		since = time.Now().Add(-statsSince)
+	}
+	records, err := stats.Read(filepath.Join(user.StateDir(), stats.File), since)
+	if err != nil {
+		return err
+	}
+	summary := stats.Summarize(records)
+	if statsFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(summary)
+	}
+
+	if !stats.Enabled(user.StateDir()) {
+		fmt.Fprintln(os.Stderr, "Usage statistics are disabled, enable them with 'podman-bootc stats enable'")
+	}
+	if summary.Invocations == 0 {
+		fmt.Println("No records")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Period:\t%s to %s\n", summary.From.Format(time.DateTime), summary.To.Format(time.DateTime))
+	fmt.Fprintf(w, "Invocations:\t%d\n", summary.Invocations)
+	fmt.Fprintf(w, "Cache hit ratio:\t%.0f%% (%d reused, %d built)\n", summary.CacheHitRatio*100, summary.CacheHits, summary.Builds)
+	fmt.Fprintf(w, "Failed builds:\t%d\n", summary.Failures)
+	fmt.Fprintf(w, "Median build time:\t%s\n", summary.MedianBuildTime.Round(time.Second))
+	fmt.Fprintf(w, "Bytes pulled:\t%s\n", units.HumanSize(float64(summary.BytesPulled)))
+	return w.Flush()
+}
+
+// recordStats sets a stats recorder on the disk build of command when the
+// statistics are enabled. The returned function appends its record.
+func recordStats(user user.User, disk interface{ SetMetrics(api.Metrics) }, command string) func() {
+	if !stats.Enabled(user.StateDir()) {
+		return func() {}
+	}
+	recorder := stats.NewRecorder(command)
+	disk.SetMetrics(recorder)
+	return func() {
+		path := filepath.Join(user.StateDir(), stats.File)
+		if err := stats.Append(path, recorder.Record()); err != nil {
+			logrus.Warnf("unable to record the usage statistics: %v", err)
+			return
+		}
+		if err := artifactOwner.InHome(path).Chown(path); err != nil {
+			logrus.Debugf("%v", err)
+		}
+	}
+}
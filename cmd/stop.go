@@ -29,6 +29,10 @@ func doStop(_ *cobra.Command, args []string) (err error) {
 	}
 
 	id := args[0]
+	// The unit would start the VM again
+	if err := disableAutostart(id, user); err != nil {
+		return err
+	}
 	bootcVM, err := vm.NewVM(vm.NewVMParameters{
 		ImageID:    id,
 		LibvirtUri: config.LibvirtUri,
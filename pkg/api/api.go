@@ -0,0 +1,680 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/bib"
+	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
+	"gitlab.com/bootc-org/podman-bootc/pkg/chunked"
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// User owns the cache of the disk images, get it with CurrentUser
+type User = user.User
+
+// InstallOptions configure the disk image build, the zero value uses the
+// defaults of bootc install
+type InstallOptions struct {
+	Filesystem  string
+	RootSizeMax string
+	DiskSize    string
+	// MinDiskSize is the floor of the disk size computed from the image,
+	// empty keeps 10GB
+	MinDiskSize string
+	// LargeDiskThreshold asks for confirmation before creating a larger disk
+	LargeDiskThreshold string
+	// AssumeYes never asks for confirmation
+	AssumeYes bool
+	// AutoRepair re-pulls the image and retries once when its local layers
+	// look corrupted
+	AutoRepair bool
+	// RegistryMirror pulls the image through this registry, e.g. host:port
+	RegistryMirror string
+	// MirrorFallback pulls from the upstream registry when the mirror fails
+	MirrorFallback bool
+	// RebuildStrategy is "clean" or "upgrade"
+	RebuildStrategy string
+	// RunDefaults are recorded on the disk, they don't affect its contents
+	RunDefaults *RunDefaults
+	// BoundImages copies the logically bound images into the disk image
+	BoundImages bool
+	// InstallerImage runs bootc from this image to install the image
+	InstallerImage string
+	// MaxCacheAge rebuilds cached disks older than this, zero disables it
+	MaxCacheAge time.Duration
+	// TombstoneWindow fails fast when the same build failed within this
+	// window, zero disables it
+	TombstoneWindow time.Duration
+	// Force retries builds which failed within the tombstone window
+	Force bool
+	// LoopWait waits up to this long for free loop devices when the install
+	// failed without them, then retries it
+	LoopWait time.Duration
+	// CacheStrictness is "off", "warn" or "strict"
+	CacheStrictness string
+	// DigestFile pins the image the reference resolves to in this file, or
+	// uses the pinned one
+	DigestFile string
+	// AdoptTemp moves a completed temporary disk which could not be renamed
+	// in place
+	AdoptTemp bool
+	// IOWeight is the relative IO weight of the install container, 10 to 1000
+	IOWeight uint16
+	// IOMax is the bytes per second the install container reads and
+	// writes, e.g. 50MB
+	IOMax string
+	// Nice runs the install container with the lowest CPU and IO priority
+	Nice bool
+	// Provenance writes a SLSA provenance of the disk image beside it
+	Provenance bool
+	// ProvenanceKey signs the provenance with this cosign key, it implies
+	// Provenance
+	ProvenanceKey string
+	// Format is the format of the disk image, raw or qcow2
+	Format string
+	// Kargs are the kernel arguments added by bootc install
+	Kargs []string
+	// ImageCeiling is the maximum size of the cached generations of the
+	// repository
+	ImageCeiling string
+	// InstallConfig is a bootc install configuration TOML
+	InstallConfig string
+	// ExtraInstallArgs are appended verbatim to the bootc install command line
+	ExtraInstallArgs []string
+	// InstallBackend is InstallBackendContainer or InstallBackendHost
+	InstallBackend string
+	// Connection is the name of the podman connection building the disk,
+	// recorded in its metadata
+	Connection string
+	// BlockSetup is the block setup of bootc install, e.g. tpm2-luks
+	BlockSetup string
+	// StrictCache fails instead of rebuilding when the cached disk was
+	// modified externally
+	StrictCache bool
+	// RootSSHKeys are authorized keys files of root baked into the disk
+	RootSSHKeys []string
+	// ForceRebuild always runs bootc install, the cached disk is replaced
+	// once the new one is built
+	ForceRebuild bool
+	// Heartbeat is the interval of the progress lines when the output is
+	// not a terminal, 0 disables them
+	Heartbeat time.Duration
+	// InstallTimeout stops the install when it runs longer than this, 0
+	// disables it
+	InstallTimeout time.Duration
+	// VerifyContent compares a sample of the files of a new disk with the
+	// image after the install
+	VerifyContent bool
+	// InstallRetries retries the install this many times on transient loop
+	// device errors
+	InstallRetries int
+	// KeepOnFailure keeps the install container and the temporary disk when
+	// bootc install fails
+	KeepOnFailure bool
+	// PostInstallHook runs this executable with the temporary disk before it
+	// is cached
+	PostInstallHook string
+	// InstallMode is InstallModeDisk or InstallModeFilesystem
+	InstallMode string
+	// LosetupDirectIO is "auto", "on" or "off"
+	LosetupDirectIO string
+	// Preallocation is "sparse", "falloc" or "full"
+	Preallocation string
+	// InstallTarget is the directory or partitioned disk image installed to
+	// by InstallModeFilesystem
+	InstallTarget string
+	// InstallReplace is "wipe" or "alongside", the system of the target
+	// replaced by InstallModeFilesystem
+	InstallReplace string
+	// DiskMode is the octal permissions of the disk image, e.g. 0640, empty
+	// keeps the umask
+	DiskMode string
+	// DiskOwner is the user[:group] owning the disk image, empty keeps the
+	// user of the process
+	DiskOwner string
+	// FilesystemOptions are extra mkfs options of the root filesystem by
+	// filesystem, e.g. "xfs": "-i size=1024"
+	FilesystemOptions map[string]string
+}
+
+const (
+	// InstallModeDisk builds a cached disk image
+	InstallModeDisk = bootc.InstallModeDisk
+	// InstallModeFilesystem installs to the InstallTarget of the options
+	InstallModeFilesystem = bootc.InstallModeFilesystem
+	// InstallBackendContainer runs bootc install in a container of the image
+	InstallBackendContainer = bootc.InstallBackendContainer
+	// InstallBackendHost runs the bootc of the host
+	InstallBackendHost = bootc.InstallBackendHost
+)
+
+// RunDefaults are the VM options recorded on a disk image
+type RunDefaults struct {
+	Memory  string   `json:"memory,omitempty"`
+	CPUs    int      `json:"cpus,omitempty"`
+	TPM     *bool    `json:"tpm,omitempty"`
+	Publish []string `json:"publish,omitempty"`
+}
+
+// ParseRunDefaults parses key=value settings, supported keys are mem (or
+// memory), cpus, tpm and publish, which can be repeated
+func ParseRunDefaults(settings []string) (*RunDefaults, error) {
+	defaults, err := bootc.ParseRunDefaults(settings)
+	if err != nil {
+		return nil, err
+	}
+	return runDefaultsFrom(defaults), nil
+}
+
+// ParseFilesystemOptions parses fs=options settings with the mkfs options of
+// the root filesystem fs, e.g. xfs=-i size=1024
+func ParseFilesystemOptions(settings []string) (map[string]string, error) {
+	return bootc.ParseFilesystemOptions(settings)
+}
+
+// InstallResult describes the disk image of a build
+type InstallResult struct {
+	// StartedAt is when the build started
+	StartedAt time.Time
+	// BuiltAt is when the disk image was built
+	BuiltAt time.Time
+	// CacheHit reports if the disk image was cached
+	CacheHit  bool
+	Directory string
+	// Pruned lists the generations pruned after the build
+	Pruned PruneReport
+	// ContentVerification is the verification of the disk contents, nil
+	// when it was not requested
+	ContentVerification *ContentVerification
+	// Target is the directory or disk image installed to by
+	// InstallModeFilesystem, no disk image is cached then
+	Target string
+	// Image is the repository and tag of the image, if it has one
+	Image string
+}
+
+// PruneReport lists the generations removed from the cache
+type PruneReport struct {
+	Pruned []PrunedGeneration `json:"pruned,omitempty"`
+	// Pinned are the generations the policies skipped because they are
+	// pinned
+	Pinned []PrunedGeneration `json:"pinned,omitempty"`
+}
+
+// PrunedGeneration is a generation removed from the cache
+type PrunedGeneration struct {
+	Id         string `json:"id"`
+	Generation int    `json:"generation,omitempty"`
+	Size       int64  `json:"size"`
+	Policy     string `json:"policy"`
+}
+
+// Reclaimed returns the space reclaimed by each policy
+func (r PruneReport) Reclaimed() map[string]int64 {
+	return r.internal().Reclaimed()
+}
+
+// Summary describes the reclaimed space per policy, e.g. "image-ceiling 12GB"
+func (r PruneReport) Summary() string {
+	return r.internal().Summary()
+}
+
+// FilesystemUsage is the usage of a filesystem of a disk image
+type FilesystemUsage struct {
+	Name  string `json:"name"`
+	Used  int64  `json:"used"`
+	Total int64  `json:"total"`
+}
+
+// ContentVerification is the comparison of a disk image with its image
+type ContentVerification struct {
+	Verified bool `json:"verified"`
+	// Checked is the number of files compared
+	Checked int `json:"checked"`
+	// Mismatches are the files differing from the image
+	Mismatches []string `json:"mismatches,omitempty"`
+	// Error is why the verification could not run, if it could not
+	Error string `json:"error,omitempty"`
+}
+
+// Metrics is the hook receiving counters about the builds
+type Metrics interface {
+	BuildStarted()
+	BuildSucceeded(duration time.Duration)
+	BuildFailed()
+	CacheHit()
+	CacheMiss()
+	// BytesPulled reports the size of images that had to be pulled
+	BytesPulled(bytes int64)
+	// CacheSize reports the disk usage of the whole podman-bootc cache
+	CacheSize(bytes int64)
+}
+
+// Verbosity controls the progress written during a build
+type Verbosity int
+
+const (
+	// VerbositySilent only reports errors
+	VerbositySilent Verbosity = iota - 2
+	// VerbosityQuiet hides the output of the install but keeps one-line
+	// phase updates
+	VerbosityQuiet
+	// VerbosityNormal shows the phase updates and the install output
+	VerbosityNormal
+	// VerbosityVerbose additionally shows informational log messages
+	VerbosityVerbose
+	// VerbosityDebug additionally shows debug log messages
+	VerbosityDebug
+)
+
+// NewVerbosity returns the verbosity of a number of -q and -v flags
+func NewVerbosity(quiet, verbose int) Verbosity {
+	v := VerbosityNormal + Verbosity(verbose-quiet)
+	if v < VerbositySilent {
+		return VerbositySilent
+	}
+	if v > VerbosityDebug {
+		return VerbosityDebug
+	}
+	return v
+}
+
+// LogLevel returns the logrus level matching the verbosity
+func (v Verbosity) LogLevel() logrus.Level {
+	return v.internal().LogLevel()
+}
+
+// ProgressStep is a coarse step of a build
+type ProgressStep int
+
+const (
+	// StepPull pulls the image and the installer image
+	StepPull ProgressStep = iota
+	// StepAllocate looks up the cache and allocates the disk image
+	StepAllocate
+	// StepInstall runs bootc install
+	StepInstall
+	// StepFinalize verifies, commits and post-processes the disk image
+	StepFinalize
+)
+
+// ProgressSteps are the steps of a build, in order
+var ProgressSteps = []ProgressStep{StepPull, StepAllocate, StepInstall, StepFinalize}
+
+func (s ProgressStep) String() string {
+	return s.internal().String()
+}
+
+// ProgressEvent is the state of a build sent to the progress hook
+type ProgressEvent struct {
+	Step ProgressStep
+	// Phase is the phase in progress, as named by the heartbeat
+	Phase string
+	// Message is the phase update line printed with the event, if any
+	Message string
+	// PulledLayers is the number of layers the current pull started copying
+	PulledLayers int
+	// PulledBytes is the size of the pulled image, set once the pull completed
+	PulledBytes int64
+	// InstallPhase is the phase of bootc install recognized in its output,
+	// e.g. "partitioning", empty until one is
+	InstallPhase string
+}
+
+// Owner is the user and group given to the exported files
+type Owner struct {
+	Uid int
+	Gid int
+}
+
+// The typed errors returned by the builds, use errors.As to inspect them.
+// They keep the message and the chain of the error they describe.
+type (
+	// ConfigError lists the problems of InstallOptions
+	ConfigError struct {
+		Problems []string
+		err      error
+	}
+	// PullError is an image which could not be pulled
+	PullError struct {
+		Image string
+		// Registry is the host of the last URL attempted, or the registry
+		// of the image
+		Registry string
+		// Layer is the digest of the failing layer, if any
+		Layer string
+		// Kind is "blob", "manifest", "auth" or empty
+		Kind string
+		Err  error
+		err  error
+	}
+	// PromotionError is a built disk image which could not be moved in place
+	PromotionError struct {
+		TempPath string
+		DiskPath string
+		Err      error
+		err      error
+	}
+	// CacheDirError is a cache directory which cannot hold disk images
+	CacheDirError struct {
+		Dir string
+		// Owner is the uid owning the directory, -1 if it is unknown
+		Owner      int
+		Limitation string
+		Hint       string
+		Err        error
+		err        error
+	}
+	// VerificationError is a built disk image whose contents do not match
+	// the image, it is kept
+	VerificationError struct {
+		DiskPath     string
+		Verification ContentVerification
+		err          error
+	}
+)
+
+// ErrInUse is returned for disk images locked by another operation
+var ErrInUse = errors.New("the disk image is in use")
+
+// CurrentUser returns the user running the program
+func CurrentUser() (User, error) {
+	return user.NewUser()
+}
+
+// CachedDisk is a disk image in the cache
+type CachedDisk struct {
+	// Id is the id of the container image the disk was built from
+	Id         string
+	Repository string
+	// Generation numbers the disks of the repository in build order, 0 if unknown
+	Generation int
+	// Path is the disk image file
+	Path    string
+	Size    int64
+	Created time.Time
+	// BootcVersion is the version of bootc which installed the disk, or unknown
+	BootcVersion string
+	// BuilderVersion is the version of podman-bootc which built the disk, empty if unknown
+	BuilderVersion string
+	// Format is the format of the disk image, raw or qcow2
+	Format      string
+	Filesystems []FilesystemUsage
+	// Pinned disks are never removed by the prune policies
+	Pinned bool
+	// RunDefaults are the VM options recorded on the disk, if any
+	RunDefaults *RunDefaults
+	// ContentVerification is nil when the contents were not verified
+	ContentVerification *ContentVerification
+}
+
+// readCachedDisk describes the cache entry of the image id
+func readCachedDisk(u User, id string) (CachedDisk, error) {
+	path := u.DiskImagePath(id)
+	st, err := os.Stat(path)
+	if err != nil {
+		return CachedDisk{}, err
+	}
+	meta, err := bootc.ReadDiskMeta(path)
+	if err != nil {
+		return CachedDisk{}, err
+	}
+	disk := CachedDisk{
+		Id:             id,
+		Repository:     meta.Repository,
+		Generation:     meta.Generation,
+		Path:           path,
+		Size:           st.Size(),
+		Created:        meta.Created,
+		BootcVersion:   meta.BootcVersion,
+		BuilderVersion: meta.BuilderVersion,
+		Format:         meta.DiskFormat(),
+		Filesystems:    filesystemsFrom(meta.Filesystems),
+		Pinned:         meta.Pinned,
+		RunDefaults:    runDefaultsFrom(meta.RunDefaults),
+
+		ContentVerification: contentVerificationFrom(meta.ContentVerification),
+	}
+	if disk.Created.IsZero() {
+		disk.Created = st.ModTime()
+	}
+	return disk, nil
+}
+
+// DiskBuilder builds the disk image of a container image
+type DiskBuilder struct {
+	disk      *bootc.BootcDisk
+	verbosity Verbosity
+}
+
+// NewDiskBuilder returns a builder of the disk image of the image name or
+// id. ctx carries the connection to the rootful podman service installing
+// the image, see bindings.NewConnection.
+func NewDiskBuilder(ctx context.Context, u User, image string) *DiskBuilder {
+	return &DiskBuilder{disk: bootc.NewDisk(image, ctx, u), verbosity: VerbosityQuiet}
+}
+
+// SetVerbosity sets the progress written during Build, VerbosityQuiet by default
+func (b *DiskBuilder) SetVerbosity(v Verbosity) {
+	b.verbosity = v
+}
+
+// SetProgress sets where the progress is written, os.Stdout by default
+func (b *DiskBuilder) SetProgress(w io.Writer) {
+	b.disk.SetOutput(w)
+}
+
+// SetProgressHook sets the function receiving the progress events of Build
+func (b *DiskBuilder) SetProgressHook(hook func(ProgressEvent)) {
+	b.disk.SetProgressHook(func(e bootc.ProgressEvent) {
+		hook(progressEventFrom(e))
+	})
+}
+
+// SetMetrics sets the hook receiving metrics about the build
+func (b *DiskBuilder) SetMetrics(m Metrics) {
+	b.disk.SetMetrics(m)
+}
+
+// Build pulls the image and builds its disk image, or reuses the cached one
+func (b *DiskBuilder) Build(opts InstallOptions) (InstallResult, error) {
+	if err := b.disk.Install(b.verbosity.internal(), opts.DiskImageConfig()); err != nil {
+		return InstallResult{}, errorFrom(err)
+	}
+	result := installResultFrom(b.disk.InstallResult())
+	result.Image = b.disk.GetRepoTag()
+	return result, nil
+}
+
+// Disk describes the disk image of the last Build
+func (b *DiskBuilder) Disk() (CachedDisk, error) {
+	if b.disk.GetImageId() == "" {
+		return CachedDisk{}, errors.New("no disk image was built")
+	}
+	return readCachedDisk(b.disk.User, b.disk.GetImageId())
+}
+
+// InstallLogPath returns the log of the last install of the disk image of
+// the last Build, kept in its cache entry
+func (b *DiskBuilder) InstallLogPath() string {
+	return b.disk.InstallLogPath()
+}
+
+// Cleanup removes the install container of an interrupted Build, e.g. from
+// a signal handler
+func (b *DiskBuilder) Cleanup() error {
+	return b.disk.Cleanup()
+}
+
+// List returns the disk images in the cache of the user, the entries
+// without a readable disk image are skipped
+func List(u User) ([]CachedDisk, error) {
+	entries, err := os.ReadDir(u.CacheDir())
+	if err != nil {
+		return nil, err
+	}
+	var disks []CachedDisk
+	for _, entry := range entries {
+		if !entry.IsDir() || len(entry.Name()) != 64 {
+			continue
+		}
+		if _, err := os.Stat(u.DiskImagePath(entry.Name())); err != nil {
+			continue
+		}
+		disk, err := readCachedDisk(u, entry.Name())
+		if err != nil {
+			logrus.Warningf("skipping disk %s reason: %v", entry.Name(), err)
+			continue
+		}
+		disks = append(disks, disk)
+	}
+	return disks, nil
+}
+
+// UnmanagedEntry is a file or directory in the cache which is not a cached
+// disk image
+type UnmanagedEntry struct {
+	Path string
+	// Reason is why the entry is not listed as a disk image
+	Reason string
+}
+
+// ListUnmanaged returns the entries of the cache of the user which List
+// skips, for the user to decide whether to delete them. Entries of earlier
+// releases are migrated when their image is next used.
+func ListUnmanaged(u User) ([]UnmanagedEntry, error) {
+	entries, err := os.ReadDir(u.CacheDir())
+	if err != nil {
+		return nil, err
+	}
+	var unmanaged []UnmanagedEntry
+	for _, entry := range entries {
+		path := filepath.Join(u.CacheDir(), entry.Name())
+		reason := ""
+		switch {
+		case !entry.IsDir():
+			// The VM console sockets live next to the cache entries
+			if entry.Type().IsRegular() {
+				reason = "unknown file"
+			}
+		case len(entry.Name()) != 64:
+			reason = "unknown directory"
+		case bootc.DetectLegacyLayout(path) != "":
+			reason = fmt.Sprintf("cache entry of an earlier release with the %s, migrated when the image is used", bootc.DetectLegacyLayout(path))
+		default:
+			if _, err := os.Stat(u.DiskImagePath(entry.Name())); err != nil {
+				reason = "no disk image"
+			} else if _, err := bootc.ReadDiskMeta(u.DiskImagePath(entry.Name())); err != nil {
+				reason = err.Error()
+			}
+		}
+		if reason != "" {
+			unmanaged = append(unmanaged, UnmanagedEntry{Path: path, Reason: reason})
+		}
+	}
+	return unmanaged, nil
+}
+
+// Resolve returns the cached disk image of the image id or a prefix of it
+func Resolve(u User, idPrefix string) (CachedDisk, error) {
+	entries, err := os.ReadDir(u.CacheDir())
+	if err != nil {
+		return CachedDisk{}, err
+	}
+	id := ""
+	for _, entry := range entries {
+		if entry.IsDir() && len(entry.Name()) == 64 && strings.HasPrefix(entry.Name(), idPrefix) {
+			id = entry.Name()
+		}
+	}
+	if id == "" {
+		return CachedDisk{}, fmt.Errorf("local installation '%s' does not exists", idPrefix)
+	}
+	return readCachedDisk(u, id)
+}
+
+// ExportOptions configure Export
+type ExportOptions struct {
+	// BibLayout writes the output directory layout of bootc-image-builder
+	// instead of checksummed chunks
+	BibLayout bool
+	// ChunkSize is the size of the chunks, 256MB if zero
+	ChunkSize int64
+	// Resume continues an interrupted export to the same directory
+	Resume bool
+	// Progress is called with the bytes exported so far, if set
+	Progress func(done, total int64)
+	// Owner is given the exported files, if set, e.g. the user invoking sudo
+	Owner *Owner
+}
+
+// ExportReport describes an export
+type ExportReport struct {
+	Id   string
+	Size int64
+	// Chunks is the number of chunks written, 0 for the bib layout
+	Chunks int
+}
+
+// Export writes the cached disk image of the image id, or a prefix of it,
+// to dir. It returns ErrInUse if the disk image is being rebuilt or removed.
+func Export(u User, idPrefix, dir string, opts ExportOptions) (ExportReport, error) {
+	disk, err := Resolve(u, idPrefix)
+	if err != nil {
+		return ExportReport{}, err
+	}
+	cacheDir := filepath.Dir(disk.Path)
+	lock := utils.NewCacheLock(u.RunDir(), cacheDir)
+	locked, err := lock.TryLock(utils.Shared)
+	if err != nil {
+		return ExportReport{}, fmt.Errorf("unable to lock the VM cache path: %w", err)
+	}
+	if !locked {
+		return ExportReport{}, ErrInUse
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			logrus.Warningf("unable to unlock VM %s: %v", disk.Id, err)
+		}
+	}()
+
+	if opts.BibLayout {
+		if err := bib.Export(cacheDir, dir); err != nil {
+			return ExportReport{}, err
+		}
+		return ExportReport{Id: disk.Id, Size: disk.Size}, opts.Owner.internal().Chown(dir)
+	}
+
+	chunkSize := opts.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = 256 * 1000 * 1000
+	}
+	manifest, err := chunked.Export(filepath.Join(cacheDir, config.DiskImage), dir, chunked.ExportOptions{
+		ChunkSize: chunkSize,
+		DiskId:    disk.Id,
+		Resume:    opts.Resume,
+		Progress:  opts.Progress,
+	})
+	if err != nil {
+		return ExportReport{}, err
+	}
+	return ExportReport{Id: disk.Id, Size: manifest.Size, Chunks: len(manifest.Chunks)}, opts.Owner.internal().Chown(dir)
+}
+
+// Prune removes the oldest generations of the repository until they use at
+// most ceiling bytes, keeping the newest one and the ones in use or with a VM
+func Prune(u User, repository string, ceiling int64) (PruneReport, error) {
+	report, err := bootc.PruneGenerations(u, repository, ceiling)
+	return pruneReportFrom(report), err
+}
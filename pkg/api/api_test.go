@@ -0,0 +1,200 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	osUser "os/user"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
+	"gitlab.com/bootc-org/podman-bootc/pkg/chunked"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestAPI(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "API Suite")
+}
+
+const (
+	testID    = "a025064b145ed339eeef86046aea3ee221a2a5a16f588aff4f43a42e5ca9f844"
+	testOldID = "b025064b145ed339eeef86046aea3ee221a2a5a16f588aff4f43a42e5ca9f844"
+)
+
+func newTestUser() User {
+	return User{OSUser: &osUser.User{Uid: "1000", Gid: "1000", Username: "test", HomeDir: GinkgoT().TempDir()}}
+}
+
+// writeTestDisk adds a cached disk image of size bytes to the cache of u
+func writeTestDisk(u User, id string, generation int, size int64) {
+	Expect(os.MkdirAll(u.ImageCacheDir(id), 0o755)).To(Succeed())
+	path := u.DiskImagePath(id)
+	Expect(os.WriteFile(path, make([]byte, size), 0o644)).To(Succeed())
+	Expect(bootc.WriteDiskMeta(path, &bootc.DiskMeta{
+		ImageDigest:    id,
+		Repository:     "quay.io/test/test",
+		ImageRef:       "quay.io/test/test:latest",
+		Generation:     generation,
+		Created:        time.Date(2024, 5, 1, 0, 0, generation, 0, time.UTC),
+		BootcVersion:   "1.1.0",
+		BuilderVersion: "v0.2.0",
+	})).To(Succeed())
+}
+
+// fieldNames returns the exported fields of the struct type of v
+func fieldNames(v any) []string {
+	var names []string
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).IsExported() {
+			names = append(names, t.Field(i).Name)
+		}
+	}
+	return names
+}
+
+var _ = Describe("API", func() {
+	Context("compatibility", func() {
+		// Fields may be added, removing or renaming one breaks v1
+		It("should keep the fields of the types", func() {
+			Expect(fieldNames(InstallOptions{})).To(ContainElements(
+				"Filesystem", "RootSizeMax", "DiskSize", "LargeDiskThreshold", "AssumeYes", "AutoRepair",
+				"RegistryMirror", "MirrorFallback", "RebuildStrategy", "RunDefaults", "BoundImages",
+				"InstallerImage", "MaxCacheAge", "TombstoneWindow", "Force", "LoopWait", "CacheStrictness",
+				"DigestFile", "AdoptTemp", "IOWeight", "IOMax", "Nice", "Provenance", "ProvenanceKey",
+				"Format", "Kargs", "ImageCeiling", "InstallConfig", "ExtraInstallArgs"))
+			Expect(fieldNames(InstallResult{})).To(ContainElements("StartedAt", "BuiltAt", "CacheHit", "Directory", "Pruned"))
+			Expect(fieldNames(PrunedGeneration{})).To(ContainElements("Id", "Generation", "Size", "Policy"))
+			Expect(fieldNames(PullError{})).To(ContainElements("Image", "Registry", "Layer", "Kind", "Err"))
+			Expect(fieldNames(CacheDirError{})).To(ContainElements("Dir", "Owner", "Limitation", "Hint", "Err"))
+		})
+
+		It("should convert every install option", func() {
+			Expect(fieldNames(InstallOptions{})).To(ConsistOf(fieldNames(bootc.DiskImageConfig{})))
+
+			var opts InstallOptions
+			v := reflect.ValueOf(&opts).Elem()
+			for i := 0; i < v.NumField(); i++ {
+				f := v.Field(i)
+				switch f.Kind() {
+				case reflect.String:
+					f.SetString("set")
+				case reflect.Bool:
+					f.SetBool(true)
+				case reflect.Int, reflect.Int64:
+					f.SetInt(1)
+				case reflect.Uint16:
+					f.SetUint(1)
+				case reflect.Slice:
+					f.Set(reflect.ValueOf([]string{"set"}))
+				case reflect.Map:
+					f.Set(reflect.ValueOf(map[string]string{"xfs": "set"}))
+				case reflect.Ptr:
+					f.Set(reflect.ValueOf(&RunDefaults{Memory: "4G"}))
+				default:
+					Fail("unhandled option " + v.Type().Field(i).Name)
+				}
+			}
+			config := reflect.ValueOf(opts.DiskImageConfig())
+			for _, name := range fieldNames(opts) {
+				Expect(config.FieldByName(name).IsZero()).To(BeFalse(), name)
+			}
+			Expect(opts.DiskImageConfig().RunDefaults).To(Equal(&bootc.RunDefaults{Memory: "4G"}))
+		})
+
+		It("should convert the typed errors", func() {
+			pullErr := &bootc.PullError{Image: "quay.io/test/test", Registry: "quay.io", Kind: bootc.PullErrorBlob, Err: errors.New("unexpected EOF")}
+			err := errorFrom(fmt.Errorf("unable to pull: %w", pullErr))
+			var apiErr *PullError
+			Expect(errors.As(err, &apiErr)).To(BeTrue())
+			Expect(apiErr.Registry).To(Equal("quay.io"))
+			Expect(apiErr.Kind).To(Equal("blob"))
+			Expect(err.Error()).To(Equal("unable to pull: " + pullErr.Error()))
+			Expect(errors.Is(err, pullErr)).To(BeTrue())
+
+			Expect((&ConfigError{Problems: []string{"unknown filesystem"}}).Error()).To(ContainSubstring("unknown filesystem"))
+			plain := errors.New("plain")
+			Expect(errorFrom(plain)).To(Equal(plain))
+		})
+	})
+
+	Context("cache", func() {
+		It("should list the cached disk images", func() {
+			u := newTestUser()
+			writeTestDisk(u, testID, 2, 4096)
+			Expect(os.MkdirAll(u.ImageCacheDir(testOldID), 0o755)).To(Succeed())
+
+			disks, err := List(u)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(disks).To(HaveLen(1))
+			Expect(disks[0].Id).To(Equal(testID))
+			Expect(disks[0].Generation).To(Equal(2))
+			Expect(disks[0].Size).To(Equal(int64(4096)))
+			Expect(disks[0].Format).To(Equal(bootc.FormatRaw))
+			Expect(disks[0].BuilderVersion).To(Equal("v0.2.0"))
+		})
+
+		It("should list the unmanaged entries of the cache", func() {
+			u := newTestUser()
+			writeTestDisk(u, testID, 1, 4096)
+			Expect(os.MkdirAll(u.ImageCacheDir(testOldID), 0o755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(u.CacheDir(), "leftovers"), 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(u.CacheDir(), "notes.txt"), nil, 0o644)).To(Succeed())
+
+			unmanaged, err := ListUnmanaged(u)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(unmanaged).To(ConsistOf(
+				UnmanagedEntry{Path: filepath.Join(u.CacheDir(), "leftovers"), Reason: "unknown directory"},
+				UnmanagedEntry{Path: filepath.Join(u.CacheDir(), "notes.txt"), Reason: "unknown file"},
+				UnmanagedEntry{Path: u.ImageCacheDir(testOldID), Reason: "no disk image"},
+			))
+		})
+
+		It("should export a cached disk image by id prefix", func() {
+			u := newTestUser()
+			writeTestDisk(u, testID, 1, 4096)
+			dir := filepath.Join(GinkgoT().TempDir(), "export")
+			report, err := Export(u, testID[:12], dir, ExportOptions{ChunkSize: 1024})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(report.Id).To(Equal(testID))
+			Expect(report.Size).To(Equal(int64(4096)))
+			Expect(filepath.Join(dir, chunked.ManifestFile)).To(BeAnExistingFile())
+		})
+
+		It("should give the exported files to the owner", func() {
+			if os.Geteuid() != 0 {
+				Skip("changing the owner requires root")
+			}
+			u := newTestUser()
+			writeTestDisk(u, testID, 1, 4096)
+			dir := filepath.Join(GinkgoT().TempDir(), "export")
+			_, err := Export(u, testID, dir, ExportOptions{ChunkSize: 1024, Owner: &Owner{Uid: 1234, Gid: 1235}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+				Expect(err).ToNot(HaveOccurred())
+				st := info.Sys().(*syscall.Stat_t)
+				Expect([]uint32{st.Uid, st.Gid}).To(Equal([]uint32{1234, 1235}), path)
+				return nil
+			})).To(Succeed())
+		})
+
+		It("should prune the oldest generations over the ceiling", func() {
+			u := newTestUser()
+			writeTestDisk(u, testOldID, 1, 1<<20)
+			writeTestDisk(u, testID, 2, 1<<20)
+
+			report, err := Prune(u, "quay.io/test/test", 1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(report.Pruned).To(HaveLen(1))
+			Expect(report.Pruned[0].Id).To(Equal(testOldID))
+			Expect(u.DiskImagePath(testID)).To(BeAnExistingFile())
+		})
+	})
+})
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"errors"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
+	"gitlab.com/bootc-org/podman-bootc/pkg/owner"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+)
+
+// The types of the package are converted field by field from and to the
+// ones of the internal packages, so that changing the internals does not
+// change the API.
+
+// DiskImageConfig converts the options to the configuration of pkg/bootc,
+// for podman-bootc itself. The result is not covered by the compatibility
+// promise of the package.
+func (o InstallOptions) DiskImageConfig() bootc.DiskImageConfig {
+	return bootc.DiskImageConfig{
+		Filesystem:         o.Filesystem,
+		RootSizeMax:        o.RootSizeMax,
+		DiskSize:           o.DiskSize,
+		MinDiskSize:        o.MinDiskSize,
+		LargeDiskThreshold: o.LargeDiskThreshold,
+		AssumeYes:          o.AssumeYes,
+		AutoRepair:         o.AutoRepair,
+		RegistryMirror:     o.RegistryMirror,
+		MirrorFallback:     o.MirrorFallback,
+		RebuildStrategy:    o.RebuildStrategy,
+		RunDefaults:        o.RunDefaults.internal(),
+		BoundImages:        o.BoundImages,
+		InstallerImage:     o.InstallerImage,
+		MaxCacheAge:        o.MaxCacheAge,
+		TombstoneWindow:    o.TombstoneWindow,
+		Force:              o.Force,
+		LoopWait:           o.LoopWait,
+		CacheStrictness:    o.CacheStrictness,
+		DigestFile:         o.DigestFile,
+		AdoptTemp:          o.AdoptTemp,
+		IOWeight:           o.IOWeight,
+		IOMax:              o.IOMax,
+		Nice:               o.Nice,
+		Provenance:         o.Provenance,
+		ProvenanceKey:      o.ProvenanceKey,
+		Format:             o.Format,
+		Kargs:              o.Kargs,
+		ImageCeiling:       o.ImageCeiling,
+		InstallConfig:      o.InstallConfig,
+		ExtraInstallArgs:   o.ExtraInstallArgs,
+		InstallBackend:     o.InstallBackend,
+		Connection:         o.Connection,
+		BlockSetup:         o.BlockSetup,
+		StrictCache:        o.StrictCache,
+		RootSSHKeys:        o.RootSSHKeys,
+		ForceRebuild:       o.ForceRebuild,
+		Heartbeat:          o.Heartbeat,
+		InstallTimeout:     o.InstallTimeout,
+		VerifyContent:      o.VerifyContent,
+		InstallRetries:     o.InstallRetries,
+		KeepOnFailure:      o.KeepOnFailure,
+		PostInstallHook:    o.PostInstallHook,
+		InstallMode:        o.InstallMode,
+		LosetupDirectIO:    o.LosetupDirectIO,
+		Preallocation:      o.Preallocation,
+		InstallTarget:      o.InstallTarget,
+		InstallReplace:     o.InstallReplace,
+		DiskMode:           o.DiskMode,
+		DiskOwner:          o.DiskOwner,
+		FilesystemOptions:  o.FilesystemOptions,
+	}
+}
+
+func (d *RunDefaults) internal() *bootc.RunDefaults {
+	if d == nil {
+		return nil
+	}
+	return &bootc.RunDefaults{Memory: d.Memory, CPUs: d.CPUs, TPM: d.TPM, Publish: d.Publish}
+}
+
+func runDefaultsFrom(d *bootc.RunDefaults) *RunDefaults {
+	if d == nil {
+		return nil
+	}
+	return &RunDefaults{Memory: d.Memory, CPUs: d.CPUs, TPM: d.TPM, Publish: d.Publish}
+}
+
+func installResultFrom(r bootc.InstallResult) InstallResult {
+	return InstallResult{
+		StartedAt: r.StartedAt,
+		BuiltAt:   r.BuiltAt,
+		CacheHit:  r.CacheHit,
+		Directory: r.Directory,
+		Pruned:    pruneReportFrom(r.Pruned),
+		Target:    r.Target,
+
+		ContentVerification: contentVerificationFrom(r.ContentVerification),
+	}
+}
+
+func pruneReportFrom(r bootc.PruneReport) PruneReport {
+	return PruneReport{Pruned: prunedGenerationsFrom(r.Pruned), Pinned: prunedGenerationsFrom(r.Pinned)}
+}
+
+func prunedGenerationsFrom(generations []bootc.PrunedGeneration) []PrunedGeneration {
+	var converted []PrunedGeneration
+	for _, g := range generations {
+		converted = append(converted, PrunedGeneration{Id: g.Id, Generation: g.Generation, Size: g.Size, Policy: g.Policy})
+	}
+	return converted
+}
+
+func (r PruneReport) internal() bootc.PruneReport {
+	convert := func(generations []PrunedGeneration) []bootc.PrunedGeneration {
+		var converted []bootc.PrunedGeneration
+		for _, g := range generations {
+			converted = append(converted, bootc.PrunedGeneration{Id: g.Id, Generation: g.Generation, Size: g.Size, Policy: g.Policy})
+		}
+		return converted
+	}
+	return bootc.PruneReport{Pruned: convert(r.Pruned), Pinned: convert(r.Pinned)}
+}
+
+func filesystemsFrom(filesystems []bootc.FilesystemUsage) []FilesystemUsage {
+	var converted []FilesystemUsage
+	for _, fs := range filesystems {
+		converted = append(converted, FilesystemUsage{Name: fs.Name, Used: fs.Used, Total: fs.Total})
+	}
+	return converted
+}
+
+func contentVerificationFrom(v *bootc.ContentVerification) *ContentVerification {
+	if v == nil {
+		return nil
+	}
+	return &ContentVerification{Verified: v.Verified, Checked: v.Checked, Mismatches: v.Mismatches, Error: v.Error}
+}
+
+func (v Verbosity) internal() bootc.Verbosity {
+	switch {
+	case v <= VerbositySilent:
+		return bootc.VerbositySilent
+	case v == VerbosityQuiet:
+		return bootc.VerbosityQuiet
+	case v == VerbosityNormal:
+		return bootc.VerbosityNormal
+	case v == VerbosityVerbose:
+		return bootc.VerbosityVerbose
+	default:
+		return bootc.VerbosityDebug
+	}
+}
+
+func (s ProgressStep) internal() bootc.ProgressStep {
+	switch s {
+	case StepPull:
+		return bootc.StepPull
+	case StepAllocate:
+		return bootc.StepAllocate
+	case StepInstall:
+		return bootc.StepInstall
+	}
+	return bootc.StepFinalize
+}
+
+func progressEventFrom(e bootc.ProgressEvent) ProgressEvent {
+	step := StepFinalize
+	switch e.Step {
+	case bootc.StepPull:
+		step = StepPull
+	case bootc.StepAllocate:
+		step = StepAllocate
+	case bootc.StepInstall:
+		step = StepInstall
+	}
+	return ProgressEvent{
+		Step:         step,
+		Phase:        e.Phase,
+		Message:      e.Message,
+		PulledLayers: e.PulledLayers,
+		PulledBytes:  e.PulledBytes,
+		InstallPhase: e.InstallPhase.String(),
+	}
+}
+
+func (o *Owner) internal() *owner.Owner {
+	if o == nil {
+		return nil
+	}
+	return &owner.Owner{Uid: o.Uid, Gid: o.Gid}
+}
+
+// errorFrom returns err as the typed error of the API describing it, if any
+func errorFrom(err error) error {
+	var (
+		configErr       *bootc.ConfigError
+		pullErr         *bootc.PullError
+		promotionErr    *bootc.PromotionError
+		cacheDirErr     *utils.CacheDirError
+		verificationErr *bootc.VerificationError
+	)
+	switch {
+	case errors.As(err, &configErr):
+		return &ConfigError{Problems: configErr.Problems, err: err}
+	case errors.As(err, &pullErr):
+		return &PullError{Image: pullErr.Image, Registry: pullErr.Registry, Layer: pullErr.Layer, Kind: pullErr.Kind, Err: pullErr.Err, err: err}
+	case errors.As(err, &promotionErr):
+		return &PromotionError{TempPath: promotionErr.TempPath, DiskPath: promotionErr.DiskPath, Err: promotionErr.Err, err: err}
+	case errors.As(err, &cacheDirErr):
+		return &CacheDirError{Dir: cacheDirErr.Dir, Owner: cacheDirErr.Owner, Limitation: cacheDirErr.Limitation, Hint: cacheDirErr.Hint, Err: cacheDirErr.Err, err: err}
+	case errors.As(err, &verificationErr):
+		return &VerificationError{DiskPath: verificationErr.DiskPath, Verification: *contentVerificationFrom(&verificationErr.Verification), err: err}
+	}
+	return err
+}
+
+// internalError returns the error of the internal packages of e, or builds
+// one from its fields when e was not returned by the package
+func internalError(err error, build func() error) error {
+	if err != nil {
+		return err
+	}
+	return build()
+}
+
+func (e *ConfigError) Error() string {
+	return internalError(e.err, func() error { return &bootc.ConfigError{Problems: e.Problems} }).Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.err
+}
+
+func (e *PullError) Error() string {
+	return internalError(e.err, func() error {
+		return &bootc.PullError{Image: e.Image, Registry: e.Registry, Layer: e.Layer, Kind: e.Kind, Err: e.Err}
+	}).Error()
+}
+
+func (e *PullError) Unwrap() error {
+	return internalError(e.err, func() error { return e.Err })
+}
+
+func (e *PromotionError) Error() string {
+	return internalError(e.err, func() error {
+		return &bootc.PromotionError{TempPath: e.TempPath, DiskPath: e.DiskPath, Err: e.Err}
+	}).Error()
+}
+
+func (e *PromotionError) Unwrap() error {
+	return internalError(e.err, func() error { return e.Err })
+}
+
+func (e *CacheDirError) Error() string {
+	return internalError(e.err, func() error {
+		return &utils.CacheDirError{Dir: e.Dir, Owner: e.Owner, Limitation: e.Limitation, Hint: e.Hint, Err: e.Err}
+	}).Error()
+}
+
+func (e *CacheDirError) Unwrap() error {
+	return internalError(e.err, func() error { return e.Err })
+}
+
+func (e *VerificationError) Error() string {
+	return internalError(e.err, func() error {
+		v := e.Verification
+		return &bootc.VerificationError{DiskPath: e.DiskPath, Verification: bootc.ContentVerification{
+			Verified: v.Verified, Checked: v.Checked, Mismatches: v.Mismatches, Error: v.Error,
+		}}
+	}).Error()
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,14 @@
+// Package api is the interface for embedding podman-bootc in other Go
+// programs: building disk images, listing, exporting and pruning the cache.
+//
+// The package follows semantic versioning within v1 of the module: its
+// functions, the methods of its types and the fields of its structs are only
+// added to, never removed or changed incompatibly. The types of the package
+// are its own and converted from and to the internal ones, the tests of the
+// package pin their fields; User is a handle obtained from CurrentUser. All
+// the other packages of the module are internal to podman-bootc and may
+// change in any release.
+//
+// The podman-bootc command builds, lists and exports disk images through
+// this package.
+package api
@@ -0,0 +1,81 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/api"
+
+	"github.com/containers/podman/v5/pkg/bindings"
+)
+
+func ExampleDiskBuilder_Build() {
+	u, err := api.CurrentUser()
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, err := bindings.NewConnection(context.Background(), "unix:///run/podman/podman.sock")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	builder := api.NewDiskBuilder(ctx, u, "quay.io/centos-bootc/centos-bootc:stream9")
+	builder.SetProgress(os.Stderr)
+	result, err := builder.Build(api.InstallOptions{Filesystem: "xfs", DiskSize: "20G"})
+	var pullErr *api.PullError
+	switch {
+	case errors.As(err, &pullErr):
+		log.Fatalf("pulling from %s failed on layer %s: %v", pullErr.Registry, pullErr.Layer, err)
+	case err != nil:
+		log.Fatal(err)
+	}
+	disk, err := builder.Disk()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(disk.Path, result.CacheHit)
+}
+
+func ExampleList() {
+	u, err := api.CurrentUser()
+	if err != nil {
+		log.Fatal(err)
+	}
+	disks, err := api.List(u)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, disk := range disks {
+		fmt.Println(disk.Id[:12], disk.Repository, disk.Generation, disk.Size)
+	}
+}
+
+func ExampleExport() {
+	u, err := api.CurrentUser()
+	if err != nil {
+		log.Fatal(err)
+	}
+	report, err := api.Export(u, "a025064b145e", "/tmp/export", api.ExportOptions{BibLayout: true})
+	if errors.Is(err, api.ErrInUse) {
+		log.Fatal("the disk image is being rebuilt, try again later")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("exported", report.Id)
+}
+
+func ExamplePrune() {
+	u, err := api.CurrentUser()
+	if err != nil {
+		log.Fatal(err)
+	}
+	report, err := api.Prune(u, "quay.io/centos-bootc/centos-bootc", 60*1000*1000*1000)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(report.Summary())
+}
@@ -0,0 +1,371 @@
+// Package bib converts between the cache entries of podman-bootc and the
+// output directories of bootc-image-builder
+package bib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+	"gitlab.com/bootc-org/podman-bootc/pkg/qcow2"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// RawDisk is the disk image of a bootc-image-builder raw build
+	RawDisk = "image/disk.raw"
+	// Qcow2Disk is the disk image of a bootc-image-builder qcow2 build
+	Qcow2Disk = "qcow2/disk.qcow2"
+	// RawManifest is the osbuild manifest of a raw build
+	RawManifest = "manifest-raw.json"
+	// Qcow2Manifest is the osbuild manifest of a qcow2 build
+	Qcow2Manifest = "manifest-qcow2.json"
+
+	containersStorageSource = "org.osbuild.containers-storage"
+	skopeoSource            = "org.osbuild.skopeo"
+	installStage            = "org.osbuild.bootc.install-to-filesystem"
+	copyBlockSize           = 1024 * 1024
+)
+
+// manifest is the part of an osbuild manifest naming the container image
+type manifest struct {
+	Version   string                    `json:"version"`
+	Pipelines []pipeline                `json:"pipelines"`
+	Sources   map[string]manifestSource `json:"sources"`
+}
+
+type pipeline struct {
+	Name   string  `json:"name"`
+	Stages []stage `json:"stages"`
+}
+
+type stage struct {
+	Type   string           `json:"type"`
+	Inputs map[string]input `json:"inputs,omitempty"`
+}
+
+type input struct {
+	Type       string          `json:"type"`
+	Origin     string          `json:"origin"`
+	References json.RawMessage `json:"references"`
+}
+
+type imageReference struct {
+	Name string `json:"name,omitempty"`
+}
+
+type manifestSource struct {
+	Items map[string]json.RawMessage `json:"items"`
+}
+
+type skopeoItem struct {
+	Image struct {
+		Name    string `json:"name"`
+		Digest  string `json:"digest"`
+		ImageId string `json:"image_id"`
+	} `json:"image"`
+}
+
+// Source is the container image a disk image was built from
+type Source struct {
+	// ImageId is the id of the image, the id of its cache entry
+	ImageId string
+	// Name is the reference of the image, if the manifest names it
+	Name string
+}
+
+// trimImageId returns the image id without the sha256 prefix, or an empty
+// string when id is not an image id
+func trimImageId(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if !bootc.IsCacheEntryId(id) {
+		return ""
+	}
+	return id
+}
+
+// ReadManifest returns the container image of an osbuild manifest
+func ReadManifest(path string) (Source, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return Source{}, err
+	}
+	var m manifest
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return Source{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	names := map[string]string{}
+	add := func(id, name string) {
+		if id = trimImageId(id); id == "" {
+			return
+		}
+		if name != "" || names[id] == "" {
+			names[id] = name
+		}
+	}
+	for id := range m.Sources[containersStorageSource].Items {
+		add(id, "")
+	}
+	for _, raw := range m.Sources[skopeoSource].Items {
+		var item skopeoItem
+		if err := json.Unmarshal(raw, &item); err == nil {
+			add(item.Image.ImageId, item.Image.Name)
+		}
+	}
+	for _, p := range m.Pipelines {
+		for _, s := range p.Stages {
+			for _, in := range s.Inputs {
+				// References are either a map of ids to options or a list of ids
+				var refs map[string]imageReference
+				if err := json.Unmarshal(in.References, &refs); err != nil {
+					continue
+				}
+				for id, ref := range refs {
+					add(id, ref.Name)
+				}
+			}
+		}
+	}
+
+	if len(names) != 1 {
+		ids := make([]string, 0, len(names))
+		for id := range names {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return Source{}, fmt.Errorf("%s must reference exactly one container image, found %d: %s", path, len(ids), strings.Join(ids, ", "))
+	}
+	var source Source
+	for id, name := range names {
+		source = Source{ImageId: id, Name: name}
+	}
+	return source, nil
+}
+
+// findOutput returns the manifest and the disk image of an output directory
+func findOutput(dir string) (manifestPath, diskPath string, err error) {
+	manifests, err := filepath.Glob(filepath.Join(dir, "manifest*.json"))
+	if err != nil {
+		return "", "", err
+	}
+	if len(manifests) != 1 {
+		return "", "", fmt.Errorf("%s must contain exactly one manifest*.json, found %d", dir, len(manifests))
+	}
+	for _, disk := range []string{Qcow2Disk, RawDisk} {
+		path := filepath.Join(dir, disk)
+		if _, err := os.Stat(path); err == nil {
+			return manifests[0], path, nil
+		}
+	}
+	return "", "", fmt.Errorf("%s contains neither %s nor %s", dir, Qcow2Disk, RawDisk)
+}
+
+// ReadOutput returns the container image of the output directory dir
+func ReadOutput(dir string) (Source, error) {
+	manifestPath, _, err := findOutput(dir)
+	if err != nil {
+		return Source{}, err
+	}
+	return ReadManifest(manifestPath)
+}
+
+// Options configures Import
+type Options struct {
+	// Force replaces an existing cache entry of the image
+	Force bool
+}
+
+// Import creates the cache entry of the disk image in the bootc-image-builder
+// output directory dir in cacheRoot, converting a qcow2 disk image to raw. It
+// returns the id of the entry. The caller must hold the lock of the entry.
+func Import(dir, cacheRoot string, opts Options) (string, error) {
+	manifestPath, diskPath, err := findOutput(dir)
+	if err != nil {
+		return "", err
+	}
+	source, err := ReadManifest(manifestPath)
+	if err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(cacheRoot, source.ImageId)
+	if _, err := os.Stat(filepath.Join(target, config.DiskImage)); err == nil && !opts.Force {
+		return "", fmt.Errorf("the cache entry %s exists, use --force to replace it", source.ImageId)
+	}
+
+	disk, err := qcow2.OpenDisk(diskPath)
+	if err != nil {
+		return "", err
+	}
+	defer disk.Close()
+	st, err := os.Stat(diskPath)
+	if err != nil {
+		return "", err
+	}
+
+	tmpDir, err := os.MkdirTemp(cacheRoot, ".bib-import-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	rawPath := filepath.Join(tmpDir, config.DiskImage)
+	if err := copySparse(rawPath, disk, disk.Size); err != nil {
+		return "", fmt.Errorf("converting %s: %w", diskPath, err)
+	}
+
+	meta := &bootc.DiskMeta{
+		ImageDigest: source.ImageId,
+		Repository:  repositoryOf(source.Name),
+		ImageRef:    source.Name,
+		Created:     st.ModTime().UTC(),
+	}
+	if err := meta.SetFingerprint(rawPath); err != nil {
+		return "", err
+	}
+	if err := bootc.WriteDiskMeta(rawPath, meta); err != nil {
+		return "", err
+	}
+	if err := bootc.ReplaceCacheEntry(tmpDir, target); err != nil {
+		return "", err
+	}
+	logrus.Debugf("imported the %s disk image %s as %s", disk.Format, diskPath, source.ImageId)
+	return source.ImageId, nil
+}
+
+// Export writes the cache entry in cacheDir to the directory dir in the
+// layout of bootc-image-builder for the format of the disk image: the disk
+// image and the manifest naming its container image, which Import reads back
+func Export(cacheDir, dir string) error {
+	cachePath := filepath.Join(cacheDir, config.DiskImage)
+	meta, err := bootc.ReadDiskMeta(cachePath)
+	if err != nil {
+		return err
+	}
+	id := trimImageId(meta.ImageDigest)
+	if id == "" {
+		return fmt.Errorf("invalid image digest %q in the disk metadata", meta.ImageDigest)
+	}
+	name := meta.ImageRef
+	if name == "" {
+		name = meta.Repository
+	}
+
+	diskFile, manifestFile := RawDisk, RawManifest
+	if meta.DiskFormat() == bootc.FormatQcow2 {
+		diskFile, manifestFile = Qcow2Disk, Qcow2Manifest
+	}
+	if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(diskFile)), 0o755); err != nil {
+		return err
+	}
+	src, err := os.Open(cachePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	st, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	diskPath := filepath.Join(dir, diskFile)
+	if err := copySparse(diskPath, src, st.Size()); err != nil {
+		return fmt.Errorf("writing %s: %w", diskPath, err)
+	}
+	if err := os.Chtimes(diskPath, meta.Created, meta.Created); err != nil {
+		logrus.Debugf("unable to set the mtime of %s: %v", diskPath, err)
+	}
+
+	ref, err := json.Marshal(map[string]imageReference{"sha256:" + id: {Name: name}})
+	if err != nil {
+		return err
+	}
+	m := manifest{
+		Version: "2",
+		Pipelines: []pipeline{{
+			Name: "image",
+			Stages: []stage{{
+				Type: installStage,
+				Inputs: map[string]input{
+					"images": {Type: "org.osbuild.containers-storage", Origin: "org.osbuild.source", References: ref},
+				},
+			}},
+		}},
+		Sources: map[string]manifestSource{
+			containersStorageSource: {Items: map[string]json.RawMessage{"sha256:" + id: json.RawMessage("{}")}},
+		},
+	}
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, manifestFile), buf, 0o644)
+}
+
+// copySparse copies size bytes of src to a new file at path, leaving holes
+// for the zero blocks
+func copySparse(path string, src io.ReaderAt, size int64) error {
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if err := dst.Truncate(size); err != nil {
+		return err
+	}
+
+	buf := make([]byte, copyBlockSize)
+	for offset := int64(0); offset < size; {
+		n, err := src.ReadAt(buf[:min64(copyBlockSize, size-offset)], offset)
+		if n > 0 && !isZero(buf[:n]) {
+			if _, err := dst.WriteAt(buf[:n], offset); err != nil {
+				return err
+			}
+		}
+		offset += int64(n)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return dst.Close()
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func isZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// repositoryOf returns the repository of an image reference, without tag or digest
+func repositoryOf(name string) string {
+	if name == "" {
+		return ""
+	}
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return ""
+	}
+	return reference.TrimNamed(named).Name()
+}
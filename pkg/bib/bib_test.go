@@ -0,0 +1,208 @@
+package bib
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestBib(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Bib Suite")
+}
+
+const (
+	testID   = "a025064b145ed339eeef86046aea3ee221a2a5a16f588aff4f43a42e5ca9f844"
+	testName = "quay.io/centos-bootc/centos-bootc:stream9"
+)
+
+// bibManifest is an excerpt of a manifest written by bootc-image-builder
+const bibManifest = `{
+  "version": "2",
+  "pipelines": [
+    {"name": "build", "stages": [{"type": "org.osbuild.rpm"}]},
+    {"name": "image", "stages": [{
+      "type": "org.osbuild.bootc.install-to-filesystem",
+      "inputs": {"images": {"type": "org.osbuild.containers-storage", "origin": "org.osbuild.source",
+        "references": {"sha256:` + testID + `": {"name": "` + testName + `"}}}}
+    }]}
+  ],
+  "sources": {"org.osbuild.containers-storage": {"items": {"sha256:` + testID + `": {}}}}
+}`
+
+// testQcow2 returns a qcow2 v3 image of 1MiB with 64KiB clusters holding
+// data in virtual cluster 1
+func testQcow2() []byte {
+	const clusterBits = 16
+	cluster := 1 << clusterBits
+	img := make([]byte, 4*cluster)
+	be := binary.BigEndian
+	copy(img, "QFI\xfb")
+	be.PutUint32(img[4:], 3)
+	be.PutUint32(img[20:], clusterBits)
+	be.PutUint64(img[24:], 1024*1024)
+	be.PutUint32(img[36:], 1)
+	be.PutUint64(img[40:], uint64(cluster))
+	be.PutUint32(img[96:], 4)
+	be.PutUint32(img[100:], 104)
+	be.PutUint64(img[cluster:], uint64(2*cluster)|1<<63)
+	be.PutUint64(img[2*cluster+8:], uint64(3*cluster)|1<<63)
+	copy(img[3*cluster:], "hello from cluster 1")
+	return img
+}
+
+// testEntry creates a cache entry with a sparse disk image of 64MiB
+func testEntry(cacheRoot string, meta *bootc.DiskMeta) string {
+	dir := filepath.Join(cacheRoot, meta.ImageDigest)
+	Expect(os.MkdirAll(dir, 0o755)).To(Succeed())
+	diskPath := filepath.Join(dir, config.DiskImage)
+	f, err := os.Create(diskPath)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(f.Truncate(64 * 1024 * 1024)).To(Succeed())
+	_, err = f.WriteAt([]byte("bootloader"), 0)
+	Expect(err).ToNot(HaveOccurred())
+	_, err = f.WriteAt([]byte("filesystem"), 32*1024*1024+5)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(f.Close()).To(Succeed())
+	Expect(bootc.WriteDiskMeta(diskPath, meta)).To(Succeed())
+	return dir
+}
+
+func fileDigest(path string) string {
+	f, err := os.Open(path)
+	Expect(err).ToNot(HaveOccurred())
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	Expect(err).ToNot(HaveOccurred())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+var _ = Describe("Bib", func() {
+	It("should read the image of a bootc-image-builder manifest", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "manifest-qcow2.json")
+		Expect(os.WriteFile(path, []byte(bibManifest), 0o644)).To(Succeed())
+		source, err := ReadManifest(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source).To(Equal(Source{ImageId: testID, Name: testName}))
+	})
+
+	It("should read the image id of skopeo sources", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "manifest.json")
+		Expect(os.WriteFile(path, []byte(`{"version": "2", "sources": {"org.osbuild.skopeo": {"items": {
+			"sha256:0000000000000000000000000000000000000000000000000000000000000001": {
+			"image": {"name": "`+testName+`", "digest": "sha256:0000000000000000000000000000000000000000000000000000000000000001", "image_id": "sha256:`+testID+`"}}}}}}`), 0o644)).To(Succeed())
+		source, err := ReadManifest(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source).To(Equal(Source{ImageId: testID, Name: testName}))
+	})
+
+	It("should reject manifests without exactly one image", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "manifest.json")
+		Expect(os.WriteFile(path, []byte(`{"version": "2", "sources": {}}`), 0o644)).To(Succeed())
+		_, err := ReadManifest(path)
+		Expect(err).To(MatchError(ContainSubstring("exactly one container image, found 0")))
+	})
+
+	It("should import a qcow2 disk image", func() {
+		output := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(output, "manifest-qcow2.json"), []byte(bibManifest), 0o644)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(output, "qcow2"), 0o755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(output, Qcow2Disk), testQcow2(), 0o644)).To(Succeed())
+
+		cacheRoot := GinkgoT().TempDir()
+		id, err := Import(output, cacheRoot, Options{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(id).To(Equal(testID))
+
+		diskPath := filepath.Join(cacheRoot, testID, config.DiskImage)
+		disk, err := os.ReadFile(diskPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(disk).To(HaveLen(1024 * 1024))
+		Expect(string(disk[64*1024 : 64*1024+20])).To(Equal("hello from cluster 1"))
+
+		meta, err := bootc.ReadDiskMeta(diskPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(meta.ImageDigest).To(Equal(testID))
+		Expect(meta.ImageRef).To(Equal(testName))
+		Expect(meta.Repository).To(Equal("quay.io/centos-bootc/centos-bootc"))
+
+		_, err = Import(output, cacheRoot, Options{})
+		Expect(err).To(MatchError(ContainSubstring("use --force")))
+		_, err = Import(output, cacheRoot, Options{Force: true})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should keep the data disks of the replaced entry", func() {
+		output := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(output, "manifest-qcow2.json"), []byte(bibManifest), 0o644)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(output, "qcow2"), 0o755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(output, Qcow2Disk), testQcow2(), 0o644)).To(Succeed())
+
+		cacheRoot := GinkgoT().TempDir()
+		dir := testEntry(cacheRoot, &bootc.DiskMeta{ImageDigest: testID})
+		Expect(os.WriteFile(filepath.Join(dir, config.DataDiskPrefix+"1.raw"), []byte("data"), 0o644)).To(Succeed())
+		_, err := Import(output, cacheRoot, Options{Force: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		data, err := os.ReadFile(filepath.Join(dir, config.DataDiskPrefix+"1.raw"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).To(Equal("data"))
+		st, err := os.Stat(filepath.Join(dir, config.DiskImage))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(st.Size()).To(Equal(int64(1024 * 1024)))
+	})
+
+	It("should keep the digests from the cache to the bib layout and back", func() {
+		created := time.Now().Add(-48 * time.Hour).UTC().Truncate(time.Second)
+		entry := testEntry(GinkgoT().TempDir(), &bootc.DiskMeta{
+			ImageDigest: testID,
+			Repository:  "quay.io/centos-bootc/centos-bootc",
+			ImageRef:    testName,
+			Created:     created,
+		})
+		digest := fileDigest(filepath.Join(entry, config.DiskImage))
+
+		output := GinkgoT().TempDir()
+		Expect(Export(entry, output)).To(Succeed())
+		Expect(fileDigest(filepath.Join(output, RawDisk))).To(Equal(digest))
+		source, err := ReadManifest(filepath.Join(output, RawManifest))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source).To(Equal(Source{ImageId: testID, Name: testName}))
+
+		cacheRoot := GinkgoT().TempDir()
+		id, err := Import(output, cacheRoot, Options{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(id).To(Equal(testID))
+		diskPath := filepath.Join(cacheRoot, testID, config.DiskImage)
+		Expect(fileDigest(diskPath)).To(Equal(digest))
+		meta, err := bootc.ReadDiskMeta(diskPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(meta.ImageDigest).To(Equal(testID))
+		Expect(meta.ImageRef).To(Equal(testName))
+		Expect(meta.Created.Equal(created)).To(BeTrue())
+
+		// and back to the bib layout again
+		again := GinkgoT().TempDir()
+		Expect(Export(filepath.Join(cacheRoot, testID), again)).To(Succeed())
+		Expect(fileDigest(filepath.Join(again, RawDisk))).To(Equal(digest))
+	})
+
+	It("should fail on output directories without a disk image", func() {
+		output := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(output, "manifest-qcow2.json"), []byte(bibManifest), 0o644)).To(Succeed())
+		_, err := Import(output, GinkgoT().TempDir(), Options{})
+		Expect(err).To(MatchError(ContainSubstring("contains neither")))
+	})
+})
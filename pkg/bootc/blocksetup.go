@@ -0,0 +1,28 @@
+package bootc
+
+const (
+	// BlockSetupDirect installs the root filesystem directly on its
+	// partition, the default of bootc install
+	BlockSetupDirect = "direct"
+	// BlockSetupTPM2LUKS encrypts the root filesystem with LUKS, its key
+	// bound to a TPM 2.0
+	BlockSetupTPM2LUKS = "tpm2-luks"
+)
+
+// blockSetups are the values of bootc install --block-setup
+var blockSetups = []string{BlockSetupDirect, BlockSetupTPM2LUKS}
+
+// blockSetup returns the block setup the disk was installed with, empty for
+// the default of bootc install
+func (m *DiskMeta) blockSetup() string {
+	if m.Inputs == nil {
+		return ""
+	}
+	return m.Inputs.BlockSetup
+}
+
+// RequiresTPM reports if the root filesystem of the disk only unlocks when
+// the VM presents a TPM
+func (m *DiskMeta) RequiresTPM() bool {
+	return m.blockSetup() == BlockSetupTPM2LUKS
+}
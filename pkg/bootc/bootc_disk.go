@@ -6,14 +6,18 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+	"gitlab.com/bootc-org/podman-bootc/pkg/partitions"
+	"gitlab.com/bootc-org/podman-bootc/pkg/qcow2"
 	"gitlab.com/bootc-org/podman-bootc/pkg/user"
 	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
 
@@ -32,33 +36,184 @@ import (
 // This is planned to be more configurable in the
 // future.  See also bootc-image-builder
 const containerSizeToDiskSizeMultiplier = 2
-const diskSizeMinimum = 10 * 1024 * 1024 * 1024 // 10GB
+const diskSizeMinimum = 10 * 1024 * 1024 * 1024            // 10GB
+const diskSizeMargin = 1024 * 1024 * 1024                  // room for the boot partitions and the filesystem metadata
+const defaultLargeDiskThreshold = 100 * 1000 * 1000 * 1000 // 100GB
 const imageMetaXattr = "user.bootc.meta"
 
-// tempLosetupWrapperContents is a workaround for https://github.com/containers/bootc/pull/487/commits/89d34c7dbcb8a1fa161f812c6ba0a8b49ccbe00f
-const tempLosetupWrapperContents = `#!/bin/bash
-set -euo pipefail
-args=(/usr/sbin/losetup --direct-io=off)
-for arg in "$@"; do
-	case $arg in
-		--direct-io=*) echo "ignoring: $arg" 1>&2;;
-		*) args+=("$arg") ;;
-	esac
-done
-exec "${args[@]}"
-`
+// tempDiskPrefix starts the names of the temporary disks
+const tempDiskPrefix = "podman-bootc-tempdisk"
+
+// installOutputTailSize is the amount of install container output kept
+// in memory to diagnose failures
+const installOutputTailSize = 64 * 1024
+
+// imageCorruptionPatterns match install failures caused by corrupted
+// layers in the local container storage, which a fresh pull fixes
+var imageCorruptionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)checksum mismatch`),
+	regexp.MustCompile(`(?i)digest mismatch`),
+	regexp.MustCompile(`(?i)invalid checksum`),
+	regexp.MustCompile(`(?i)corrupted (file )?object`),
+	regexp.MustCompile(`(?i)layer.*(unexpected EOF|input/output error)`),
+}
 
 // DiskImageConfig defines configuration for the
 type DiskImageConfig struct {
-	Filesystem  string
-	RootSizeMax string
-	DiskSize    string
+	Filesystem         string
+	RootSizeMax        string
+	DiskSize           string
+	MinDiskSize        string        // floor of the disk size computed from the image, empty keeps 10GB
+	LargeDiskThreshold string        // ask for confirmation before creating a disk larger than this
+	AssumeYes          bool          // never ask for confirmation
+	AutoRepair         bool          // re-pull the image and retry once when its local layers look corrupted
+	RegistryMirror     string        // pull the image through this registry mirror, e.g. host:port
+	MirrorFallback     bool          // pull from the upstream registry when the mirror fails
+	RebuildStrategy    string        // RebuildClean or RebuildUpgrade
+	RunDefaults        *RunDefaults  // recorded on the disk, they don't affect its contents
+	BoundImages        bool          // copy the logically bound images into the disk image
+	InstallerImage     string        // run bootc from this image to install the image
+	MaxCacheAge        time.Duration // rebuild cached disks older than this, zero disables it
+	TombstoneWindow    time.Duration // fail fast when the same build failed within this window, zero disables it
+	Force              bool          // retry builds which failed within the tombstone window
+	LoopWait           time.Duration // wait up to this long for free loop devices when the install failed without them
+	CacheStrictness    string        // CacheStrictnessOff, CacheStrictnessWarn or CacheStrictnessStrict
+	DigestFile         string        // pin the image the reference resolves to in this file, or use the pinned one
+	AdoptTemp          bool          // move a completed temporary disk which could not be renamed in place
+	IOWeight           uint16        // relative IO weight of the install container, 10 to 1000
+	IOMax              string        // bytes per second the install container reads and writes, e.g. 50MB
+	Nice               bool          // run the install container with the lowest CPU and IO priority
+	Provenance         bool          // write a SLSA provenance of the disk image beside it
+	ProvenanceKey      string        // sign the provenance with this cosign key, implies Provenance
+	Format             string        // format of the disk image, raw or qcow2
+	Kargs              []string      // kernel arguments added by bootc install
+	ImageCeiling       string        // maximum size of the cached generations of the repository
+	InstallConfig      string        // bootc install configuration TOML mounted into the install container
+	ExtraInstallArgs   []string      // appended verbatim to the bootc install command line
+	InstallBackend     string        // InstallBackendContainer or InstallBackendHost
+	Connection         string        // name of the podman connection building the disk, recorded in the metadata
+	BlockSetup         string        // bootc install --block-setup, e.g. tpm2-luks for an encrypted root
+	StrictCache        bool          // fail instead of rebuilding when the cached disk was modified externally
+	RootSSHKeys        []string      // authorized keys files of root baked into the disk by bootc install
+	ForceRebuild       bool          // always run bootc install, replacing the cached disk once the new one is built
+	Heartbeat          time.Duration // interval of the progress lines when the output is not a terminal, 0 disables them
+	InstallTimeout     time.Duration // remove the install container when it runs longer than this, 0 disables it
+	VerifyContent      bool          // compare a sample of the files of a new disk with the image after the install
+	InstallRetries     int           // retry the install container this many times on transient loop device errors
+	KeepOnFailure      bool          // keep the install container and the temporary disk when bootc install fails
+	PostInstallHook    string        // run this executable with the temporary disk before it is cached
+	InstallMode        string        // InstallModeDisk or InstallModeFilesystem
+	LosetupDirectIO    string        // LosetupDirectIOAuto, LosetupDirectIOOn or LosetupDirectIOOff
+	Preallocation      string        // PreallocationSparse, PreallocationFalloc or PreallocationFull
+	InstallTarget      string        // directory or partitioned disk image installed to by InstallModeFilesystem
+	InstallReplace     string        // InstallReplaceWipe or InstallReplaceAlongside, the system of the target replaced by InstallModeFilesystem
+	DiskMode           string        // octal permissions of the disk image, e.g. 0640, empty keeps the umask
+	DiskOwner          string        // user[:group] owning the disk image, empty keeps the user of the process
+
+	// FilesystemOptions are extra mkfs options of the root filesystem by
+	// filesystem, e.g. "xfs": "-i size=1024"
+	FilesystemOptions map[string]string
+
+	installConfigDigest   string
+	rootSSHKeys           string
+	postInstallHookDigest string
+	installTargetIsDisk   bool
 }
 
-// diskFromContainerMeta is serialized to JSON in a user xattr on a disk image
-type diskFromContainerMeta struct {
+// DiskMeta is serialized to JSON in a user xattr on a disk image, or in a
+// sidecar file on network filesystems and when it is too large for the xattr
+type DiskMeta struct {
 	// imageDigest is the digested sha256 of the container that was used to build this disk
 	ImageDigest string `json:"imageDigest"`
+	// Repository is the image repository, used to find a previous disk to upgrade
+	Repository string `json:"repository,omitempty"`
+	// ConfigHash identifies the install options the disk was built with
+	ConfigHash string `json:"configHash,omitempty"`
+	// UpgradedFrom is the digest of the image the disk was upgraded from,
+	// it is empty for disks built with a clean install
+	UpgradedFrom string `json:"upgradedFrom,omitempty"`
+	// RunDefaults are the VM options used by run unless overridden
+	RunDefaults *RunDefaults `json:"runDefaults,omitempty"`
+	// Pinned disks are never removed by the prune policies, it is kept
+	// when the disk of the entry is rebuilt
+	Pinned bool `json:"pinned,omitempty"`
+	// BoundImages maps the logically bound images copied into the disk to their ids
+	BoundImages map[string]string `json:"boundImages,omitempty"`
+	// Partitions are read from the partition table after the install
+	Partitions []partitions.Partition `json:"partitions,omitempty"`
+	// InstallerDigest is the id of the installer image, if any
+	InstallerDigest string `json:"installerDigest,omitempty"`
+	// Created is when the disk was built
+	Created time.Time `json:"created,omitempty"`
+	// BootcVersion is the version of bootc which installed the disk, or unknown
+	BootcVersion string `json:"bootcVersion,omitempty"`
+	// BuilderVersion is the version of podman-bootc which built the disk
+	BuilderVersion string `json:"builderVersion,omitempty"`
+	// Connection is the podman connection or machine which built the disk
+	Connection string `json:"connection,omitempty"`
+	// ImageRef is the image pinned by digest, used to replay the build
+	ImageRef string `json:"imageRef,omitempty"`
+	// Inputs are the build options, used to replay the build
+	Inputs *BuildInputs `json:"inputs,omitempty"`
+	// HostInputs are the inputs of the host which built the disk
+	HostInputs *HostInputs `json:"hostInputs,omitempty"`
+	// Generation numbers the disks built from the same repository
+	Generation int `json:"generation,omitempty"`
+	// Filesystems is the usage of the filesystems right after the install
+	Filesystems []FilesystemUsage `json:"filesystems,omitempty"`
+	// Format is the format of the disk image, empty for raw
+	Format string `json:"format,omitempty"`
+	// Fingerprint identifies the disk image when it was promoted, to detect
+	// external modifications
+	Fingerprint *DiskFingerprint `json:"fingerprint,omitempty"`
+	// Modified describes how the disk image was written after it was built,
+	// e.g. over a writable NBD export, the next build rebuilds it
+	Modified string `json:"modified,omitempty"`
+	// RootAuthorizedKeys are the public keys of root baked into the disk,
+	// kept when the VM injects its SSH key at runtime
+	RootAuthorizedKeys string `json:"rootAuthorizedKeys,omitempty"`
+	// ContentVerification is the comparison of the disk contents with the
+	// image after the install, if it was requested
+	ContentVerification *ContentVerification `json:"contentVerification,omitempty"`
+	// Preallocation is how the blocks of the disk image were allocated,
+	// sparse when fallocate was not supported
+	Preallocation string `json:"preallocation,omitempty"`
+}
+
+// BuildInputs are the user supplied options changing the disk image
+type BuildInputs struct {
+	Filesystem     string   `json:"filesystem,omitempty"`
+	RootSizeMax    string   `json:"rootSizeMax,omitempty"`
+	DiskSize       string   `json:"diskSize,omitempty"`
+	MinDiskSize    string   `json:"minDiskSize,omitempty"`
+	InstallerImage string   `json:"installerImage,omitempty"`
+	BoundImages    bool     `json:"boundImages,omitempty"`
+	Format         string   `json:"format,omitempty"`
+	Kargs          []string `json:"kargs,omitempty"`
+	// InstallConfig is the path of the install configuration, its digest
+	// identifies the contents used for the build
+	InstallConfig       string `json:"installConfig,omitempty"`
+	InstallConfigDigest string `json:"installConfigDigest,omitempty"`
+	// ExtraInstallArgs are passed through to bootc install
+	ExtraInstallArgs []string `json:"extraInstallArgs,omitempty"`
+	// BlockSetup is the block setup of the root filesystem, e.g. tpm2-luks
+	BlockSetup string `json:"blockSetup,omitempty"`
+	// RootSSHKeys are the authorized keys files of root, the digest of
+	// their concatenation identifies the keys used for the build
+	RootSSHKeys       []string `json:"rootSSHKeys,omitempty"`
+	RootSSHKeysDigest string   `json:"rootSSHKeysDigest,omitempty"`
+	// FilesystemOptions are the extra mkfs options of the root filesystem
+	FilesystemOptions map[string]string `json:"filesystemOptions,omitempty"`
+	// InstallMode is empty for InstallModeDisk, InstallTarget is the
+	// target of InstallModeFilesystem
+	InstallMode   string `json:"installMode,omitempty"`
+	InstallTarget string `json:"installTarget,omitempty"`
+	// PostInstallHook is the path of the post-install hook, its digest
+	// identifies the script the disk was modified with
+	PostInstallHook       string `json:"postInstallHook,omitempty"`
+	PostInstallHookDigest string `json:"postInstallHookDigest,omitempty"`
+	// Preallocation is the requested preallocation of the disk image
+	Preallocation string `json:"preallocation,omitempty"`
 }
 
 type BootcDisk struct {
@@ -68,10 +223,42 @@ type BootcDisk struct {
 	ImageId                 string
 	imageData               *types.ImageInspectReport
 	RepoTag                 string
-	CreatedAt               time.Time
+	CreatedAt               time.Time // when the disk image was built, same as BuiltAt
+	BuiltAt                 time.Time
+	StartedAt               time.Time // when Install started
 	Directory               string
 	file                    *os.File
 	bootcInstallContainerId string
+	installOutput           *outputPump
+	client                  podmanClient
+	metricsHook             Metrics
+	cacheHit                bool
+	runDefaults             *RunDefaults
+	installerImageId        string
+	bootcVersion            string
+	verbosity               Verbosity
+	output                  io.Writer
+	phases                  *phaseTracker
+	resources               *specs.LinuxResources
+	pruned                  PruneReport
+	installConfig           string
+	installTarget           string
+	graphRootPath           string
+	losetupDirectIO         string
+	preallocation           string
+	preallocated            string
+	diskPermissions         diskPermissions
+	installTargetIsDisk     bool
+	installTimeout          time.Duration
+	mkfsWrapper             string
+	mkfsOptions             map[string]string
+	console                 *consoleWriter
+	verification            *ContentVerification
+	keepOnFailure           bool
+	keptContainerId         string
+	progressHook            func(ProgressEvent)
+	installLogged           bool
+	progress                *progressState
 }
 
 // create singleton for easy cleanup
@@ -80,17 +267,46 @@ var (
 	instanceOnce sync.Once
 )
 
+// NewBootcDisk returns the disk of the command, the same one on every call
+// so the signal handler can clean it up
 func NewBootcDisk(imageNameOrId string, ctx context.Context, user user.User) *BootcDisk {
 	instanceOnce.Do(func() {
-		instance = &BootcDisk{
-			ImageNameOrId: imageNameOrId,
-			Ctx:           ctx,
-			User:          user,
-		}
+		instance = NewDisk(imageNameOrId, ctx, user)
 	})
 	return instance
 }
 
+// NewDisk returns a new disk of the image, for callers building several
+func NewDisk(imageNameOrId string, ctx context.Context, user user.User) *BootcDisk {
+	return &BootcDisk{
+		ImageNameOrId: imageNameOrId,
+		Ctx:           ctx,
+		User:          user,
+		client:        bindingsClient{},
+	}
+}
+
+// SetMetrics sets the hook receiving metrics about the disk build
+func (p *BootcDisk) SetMetrics(metrics Metrics) {
+	p.metricsHook = metrics
+}
+
+func (p *BootcDisk) metrics() Metrics {
+	if p.metricsHook == nil {
+		return noopMetrics{}
+	}
+	return p.metricsHook
+}
+
+// podman returns the client of the podman service, deriving the context of
+// every call from the one passed, see contextClient
+func (p *BootcDisk) podman() podmanClient {
+	if p.client == nil {
+		return contextClient{client: bindingsClient{}}
+	}
+	return contextClient{client: p.client}
+}
+
 func (p *BootcDisk) GetDirectory() string {
 	return p.Directory
 }
@@ -114,26 +330,111 @@ func (p *BootcDisk) GetRepoTag() string {
 	return p.RepoTag
 }
 
+// GetRunDefaults returns the VM options recorded on the disk image, or nil
+func (p *BootcDisk) GetRunDefaults() *RunDefaults {
+	return p.runDefaults
+}
+
 // GetCreatedAt returns the creation time of the disk image
 func (p *BootcDisk) GetCreatedAt() time.Time {
 	return p.CreatedAt
 }
 
-func (p *BootcDisk) Install(quiet bool, config DiskImageConfig) (err error) {
-	p.CreatedAt = time.Now()
+// InstallResult describes the disk image of Install
+type InstallResult struct {
+	// StartedAt is when Install started
+	StartedAt time.Time
+	// BuiltAt is when the disk image was built
+	BuiltAt time.Time
+	// CacheHit reports if the disk image was cached
+	CacheHit  bool
+	Directory string
+	// Pruned lists the generations pruned after the build
+	Pruned PruneReport
+	// ContentVerification is the verification of the disk contents, nil
+	// when it was not requested
+	ContentVerification *ContentVerification
+	// Target is the directory or disk image installed to by
+	// InstallModeFilesystem, no disk image is cached then
+	Target string
+}
+
+// InstallResult returns the result of Install
+func (p *BootcDisk) InstallResult() InstallResult {
+	return InstallResult{
+		StartedAt: p.StartedAt,
+		BuiltAt:   p.BuiltAt,
+		CacheHit:  p.cacheHit,
+		Directory: p.Directory,
+		Pruned:    p.pruned,
+		Target:    p.installTarget,
+
+		ContentVerification: p.verification,
+	}
+}
+
+// setBuiltAt records when the disk image was built
+func (p *BootcDisk) setBuiltAt(t time.Time) {
+	p.CreatedAt = t
+	p.BuiltAt = t
+}
 
-	err = p.pullImage()
+func (p *BootcDisk) Install(verbosity Verbosity, config DiskImageConfig) (err error) {
+	p.verbosity = verbosity
+	if err := p.prepareConfig(&config); err != nil {
+		return err
+	}
+	p.installTimeout = config.InstallTimeout
+	p.keepOnFailure = config.KeepOnFailure
+
+	// Fail before the pull when the disk image cannot be written
+	if err := utils.ProbeCacheDir(p.User.CacheDir()); err != nil {
+		return err
+	}
+	if !config.usesHostBackend() && !serviceIsLocal(p.Ctx) {
+		return fmt.Errorf("the podman service at %s is on another host, the install container cannot mount the cache directory %s; use a podman machine or a service on this host",
+			serviceURI(p.Ctx), p.User.CacheDir())
+	}
+
+	p.StartedAt = time.Now()
+	p.progress = &progressState{}
+	p.phases = &phaseTracker{onStart: func(name string) {
+		p.updateProgress(func(e *ProgressEvent) { e.Phase = name })
+	}}
+	defer func() {
+		err = p.phases.deadlineError(p.Ctx, err)
+	}()
+	defer p.startHeartbeat(config.Heartbeat)()
+
+	p.phases.start("pulling the image")
+	err = p.pullImage("missing", config)
 	if err != nil {
 		return
 	}
+	p.applyLabelDefaults(&config)
 
 	// Create VM cache dir; one per oci bootc image
-	p.Directory = filepath.Join(p.User.CacheDir(), p.ImageId)
+	p.Directory = p.User.ImageCacheDir(p.ImageId)
+	if fsType, err := utils.NetworkFilesystem(p.User.CacheDir()); err == nil && fsType != "" {
+		logrus.Warnf("The cache %s is on a network filesystem (%s), using lock files and sidecar metadata; hosts sharing it need synchronized clocks", p.User.CacheDir(), fsType)
+	}
 	lock := utils.NewCacheLock(p.User.RunDir(), p.Directory)
 	locked, err := lock.TryLock(utils.Exclusive)
 	if err != nil {
 		return fmt.Errorf("error locking the VM cache path: %w", err)
 	}
+	// Another invocation is using the same image, wait for it and
+	// reuse its disk image if it built a matching one in the meantime
+	joined := false
+	if !locked {
+		p.progressf("Waiting for a concurrent invocation using image %s", p.RepoTag)
+		p.phases.start("waiting for a concurrent invocation")
+		locked, err = lock.LockContext(p.Ctx, utils.Exclusive)
+		if err != nil {
+			return fmt.Errorf("error locking the VM cache path: %w", err)
+		}
+		joined = true
+	}
 	if !locked {
 		return fmt.Errorf("unable to lock the VM cache path")
 	}
@@ -144,25 +445,213 @@ func (p *BootcDisk) Install(quiet bool, config DiskImageConfig) (err error) {
 		}
 	}()
 
+	if err := p.migrateLegacyEntry(); err != nil {
+		logrus.Warnf("unable to migrate the cache entry of %s from an older layout: %v", p.RepoTag, err)
+	}
 	if err := os.MkdirAll(p.Directory, os.ModePerm); err != nil {
 		return fmt.Errorf("error while making bootc disk directory: %w", err)
 	}
+	p.removeKeptFailure()
+	p.resources = config.installResources()
 
-	err = p.getOrInstallImageToDisk(quiet, config)
+	if config.Force {
+		p.clearTombstone()
+	} else if config.TombstoneWindow > 0 {
+		if err := p.checkTombstone(config); err != nil {
+			return err
+		}
+	}
+
+	if config.InstallerImage != "" {
+		p.phases.start("pulling the installer image")
+		if err = p.pullInstallerImage(config.InstallerImage); err != nil {
+			return
+		}
+	}
+
+	p.setProgressStep(StepAllocate)
+	p.phases.start("building the disk image")
+	err = p.getOrInstallImageToDisk(config)
+	p.setProgressStep(StepFinalize)
+	// The disk image failing the verification is kept, the build
+	// completes and reports the failure last
+	var verifyErr error
+	if isVerificationError(err) {
+		verifyErr, err = err, nil
+	}
+	if err != nil && p.installFailedOnCorruptImage() {
+		err = p.repairImageAndRetry(config, err)
+	}
 	if err != nil {
+		if config.TombstoneWindow > 0 {
+			p.recordTombstone(config, err)
+		}
 		return
 	}
+	p.clearTombstone()
+	if config.BoundImages {
+		p.phases.start("copying the bound images")
+		if err = p.prePullBoundImages(); err != nil {
+			return fmt.Errorf("pre-pulling the bound images: %w", err)
+		}
+	}
+	if config.Provenance || config.ProvenanceKey != "" {
+		p.phases.start("writing the provenance")
+		if err = p.writeProvenance(config.ProvenanceKey); err != nil {
+			return fmt.Errorf("writing the provenance: %w", err)
+		}
+	}
+	if config.ImageCeiling != "" {
+		p.phases.start("pruning old generations")
+		// Validated, and pruning never fails the build
+		ceiling, _ := units.FromHumanSize(config.ImageCeiling)
+		if p.pruned, err = p.enforceImageCeiling(ceiling); err != nil {
+			logrus.Warnf("unable to prune the old generations of %s: %v", p.RepoTag, err)
+			err = nil
+		} else if len(p.pruned.Pruned) > 0 {
+			p.progressf("Reclaimed %s", p.pruned.Summary())
+		}
+	}
+	if joined && p.cacheHit {
+		p.progressf("The disk image was built by a concurrent invocation")
+	}
 
-	elapsed := time.Since(p.CreatedAt)
+	elapsed := time.Since(p.StartedAt)
 	logrus.Debugf("installImage elapsed: %v", elapsed)
 
+	// Walking the whole cache is only worth it for a metrics hook
+	if p.metricsHook != nil {
+		if cacheSize, err := utils.DiskUsage(p.User.CacheDir()); err == nil {
+			p.metricsHook.CacheSize(cacheSize)
+		} else {
+			logrus.Debugf("unable to compute the cache size: %v", err)
+		}
+	}
+
+	if verifyErr != nil {
+		err = verifyErr
+	}
 	return
 }
 
+// prepareConfig validates the config and reads the files it refers to
+func (p *BootcDisk) prepareConfig(config *DiskImageConfig) (err error) {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	config.normalize()
+	if config.InstallConfig != "" {
+		if config.InstallConfig, err = filepath.Abs(config.InstallConfig); err != nil {
+			return err
+		}
+		if config.installConfigDigest, err = readInstallConfig(config.InstallConfig); err != nil {
+			return fmt.Errorf("invalid install configuration: %w", err)
+		}
+		p.installConfig = config.InstallConfig
+	}
+	p.losetupDirectIO = config.LosetupDirectIO
+	p.preallocation = config.preallocation()
+	if p.diskPermissions, err = config.diskPermissions(); err != nil {
+		return err
+	}
+	if config.installsToFilesystem() {
+		if config.InstallTarget, err = filepath.Abs(config.InstallTarget); err != nil {
+			return err
+		}
+		if config.installTargetIsDisk, err = checkInstallTarget(config.InstallTarget); err != nil {
+			return fmt.Errorf("invalid install target: %w", err)
+		}
+		p.installTarget, p.installTargetIsDisk = config.InstallTarget, config.installTargetIsDisk
+	}
+	if config.PostInstallHook != "" {
+		if config.PostInstallHook, err = filepath.Abs(config.PostInstallHook); err != nil {
+			return err
+		}
+		if config.postInstallHookDigest, err = readPostInstallHook(config.PostInstallHook); err != nil {
+			return fmt.Errorf("invalid post-install hook: %w", err)
+		}
+	}
+	if len(config.RootSSHKeys) > 0 {
+		paths := make([]string, len(config.RootSSHKeys))
+		for i, path := range config.RootSSHKeys {
+			if paths[i], err = filepath.Abs(path); err != nil {
+				return err
+			}
+		}
+		config.RootSSHKeys = paths
+		if config.rootSSHKeys, err = readRootSSHKeys(config.RootSSHKeys); err != nil {
+			return fmt.Errorf("invalid root SSH keys: %w", err)
+		}
+	}
+	return nil
+}
+
+// installFailedOnCorruptImage checks the install output for signs of
+// corrupted layers in the local container storage
+func (p *BootcDisk) installFailedOnCorruptImage() bool {
+	if p.installOutput == nil {
+		return false
+	}
+
+	output := p.installOutput.String()
+	for _, pattern := range imageCorruptionPatterns {
+		if pattern.MatchString(output) {
+			return true
+		}
+	}
+	return false
+}
+
+// repairImageAndRetry removes the local image, pulls it again and retries the
+// install exactly once. The original error is returned if the retry fails too.
+func (p *BootcDisk) repairImageAndRetry(config DiskImageConfig, installErr error) error {
+	logrus.Warnf("the install failed with errors indicating corrupted image layers in the local container storage")
+
+	repair := config.AutoRepair
+	if !repair && utils.IsInteractive() {
+		var err error
+		repair, err = utils.AskYesNo(fmt.Sprintf("Remove %s and pull it again to retry the install?", p.RepoTag))
+		if err != nil {
+			return installErr
+		}
+	}
+	if !repair {
+		logrus.Warnf("remove the image with `podman rmi %s` or run again with --auto-repair", p.RepoTag)
+		return installErr
+	}
+
+	logrus.Warnf("removing image %s and pulling it again", p.ImageId)
+	force := true
+	if _, errs := p.podman().RemoveImage(p.Ctx, []string{p.ImageId}, &images.RemoveOptions{Force: &force}); len(errs) > 0 {
+		logrus.Errorf("unable to remove image %s: %v", p.ImageId, errs)
+		return installErr
+	}
+
+	previousImageId := p.ImageId
+	if err := p.pullImage("always", config); err != nil {
+		logrus.Errorf("unable to pull image again: %v", err)
+		return installErr
+	}
+	if p.ImageId != previousImageId {
+		logrus.Errorf("the image pulled again has a different id %s, not retrying", p.ImageId)
+		return installErr
+	}
+
+	logrus.Warnf("retrying the install with the freshly pulled image")
+	if err := p.bootcInstallImageToDisk(config); err != nil {
+		logrus.Errorf("retried install failed: %v", err)
+		return installErr
+	}
+	return nil
+}
+
 func (p *BootcDisk) Cleanup() (err error) {
 	force := true
-	if p.bootcInstallContainerId != "" {
-		_, err := containers.Remove(p.Ctx, p.bootcInstallContainerId, &containers.RemoveOptions{Force: &force})
+	// The container kept by --keep-on-failure is removed by the next build
+	if p.bootcInstallContainerId != "" && p.bootcInstallContainerId != p.keptContainerId {
+		// The context may have expired, the removal runs without its
+		// cancellation
+		_, err := p.podman().RemoveContainer(p.Ctx, p.bootcInstallContainerId, &containers.RemoveOptions{Force: &force})
 		if err != nil {
 			return fmt.Errorf("failed to remove bootc install container: %w", err)
 		}
@@ -171,40 +660,181 @@ func (p *BootcDisk) Cleanup() (err error) {
 	return
 }
 
+// cleanupAfterDeadline removes the running container when the context
+// expired, the signal handler only covers interrupts
+func (p *BootcDisk) cleanupAfterDeadline() {
+	if p.Ctx.Err() == nil {
+		return
+	}
+	if err := p.Cleanup(); err != nil {
+		logrus.Errorf("%v", err)
+	}
+}
+
 // getOrInstallImageToDisk checks if the disk is present and if not, installs the image to a new disk
-func (p *BootcDisk) getOrInstallImageToDisk(quiet bool, diskConfig DiskImageConfig) error {
+func (p *BootcDisk) getOrInstallImageToDisk(diskConfig DiskImageConfig) error {
+	if diskConfig.installsToFilesystem() {
+		// The target is outside of the cache, it is always installed
+		p.metrics().CacheMiss()
+		return p.bootcInstallToFilesystem(diskConfig)
+	}
 	diskPath := filepath.Join(p.Directory, config.DiskImage)
+	if diskConfig.AdoptTemp {
+		adopted, err := p.adoptTempDisk()
+		if err != nil {
+			return err
+		}
+		if !adopted {
+			logrus.Debugf("no completed temporary disk image of %s to adopt in %s", p.ImageId, p.Directory)
+		}
+	}
+	if diskConfig.ForceRebuild {
+		// The cached disk is replaced when the new one is renamed over it
+		p.progressf("Bypassing the cached disk, --force-rebuild is set")
+		p.metrics().CacheMiss()
+		return p.bootcInstallImageToDisk(diskConfig)
+	}
 	f, err := os.Open(diskPath)
 	if err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
 			return err
 		}
 		logrus.Debugf("No existing disk image found")
-		return p.bootcInstallImageToDisk(quiet, diskConfig)
+		p.metrics().CacheMiss()
+		return p.bootcInstallImageToDisk(diskConfig)
 	}
 	logrus.Debug("Found existing disk image, comparing digest")
 	defer f.Close()
-	buf := make([]byte, 4096)
-	len, err := unix.Fgetxattr(int(f.Fd()), imageMetaXattr, buf)
+	bufTrimmed, err := readMeta(f)
 	if err != nil {
-		// If there's no xattr, just remove it
+		// If there's no metadata, just remove it
 		os.Remove(diskPath)
-		logrus.Debugf("No %s xattr found", imageMetaXattr)
-		return p.bootcInstallImageToDisk(quiet, diskConfig)
+		logrus.Debugf("No disk metadata found: %v", err)
+		p.metrics().CacheMiss()
+		return p.bootcInstallImageToDisk(diskConfig)
 	}
-	bufTrimmed := buf[:len]
-	var serializedMeta diskFromContainerMeta
+	var serializedMeta DiskMeta
 	if err := json.Unmarshal(bufTrimmed, &serializedMeta); err != nil {
-		logrus.Warnf("failed to parse serialized meta from %s (%v) %v", diskPath, buf, err)
-		return p.bootcInstallImageToDisk(quiet, diskConfig)
+		logrus.Warnf("failed to parse serialized meta from %s (%v) %v", diskPath, bufTrimmed, err)
+		p.metrics().CacheMiss()
+		return p.bootcInstallImageToDisk(diskConfig)
 	}
 
 	logrus.Debugf("previous disk digest: %s current digest: %s", serializedMeta.ImageDigest, p.ImageId)
+	created := diskCreated(f, &serializedMeta)
 	if serializedMeta.ImageDigest == p.ImageId {
+		if reason := cacheMissReason(&serializedMeta, created, diskConfig); reason != "" {
+			p.progressf("%s", reason)
+			p.metrics().CacheMiss()
+			return p.bootcInstallImageToDisk(diskConfig)
+		}
+		changes, err := externalChanges(f, &serializedMeta)
+		if err != nil {
+			return err
+		}
+		if changes != "" {
+			if diskConfig.StrictCache {
+				return fmt.Errorf("the cached disk %s was modified externally (%s), remove it with podman-bootc rm to rebuild it", diskPath, changes)
+			}
+			logrus.Warnf("the cached disk %s was modified externally (%s), rebuilding", diskPath, changes)
+			p.metrics().CacheMiss()
+			return p.bootcInstallImageToDisk(diskConfig)
+		}
+		match, err := p.cachedInputsMatch(&serializedMeta, diskConfig)
+		if err != nil {
+			return err
+		}
+		if !match {
+			p.metrics().CacheMiss()
+			return p.bootcInstallImageToDisk(diskConfig)
+		}
+		if v := serializedMeta.ContentVerification; v != nil && v.failed() {
+			if diskConfig.VerifyContent {
+				p.progressf("The cached disk failed its content verification, rebuilding")
+				p.metrics().CacheMiss()
+				return p.bootcInstallImageToDisk(diskConfig)
+			}
+			logrus.Warnf("the cached disk %s failed its content verification: %s", diskPath, v.Summary())
+		}
+		if err := p.fixCachedDiskPermissions(f); err != nil {
+			return err
+		}
+		p.metrics().CacheHit()
+		p.cacheHit = true
+		p.verification = serializedMeta.ContentVerification
+		p.setBuiltAt(created)
+		p.progressf("Using cached disk built %s ago", formatAge(time.Since(created)))
+		checkBuilderVersion(&serializedMeta)
+		p.runDefaults = serializedMeta.RunDefaults
+		if diskConfig.RunDefaults != nil {
+			// Only the metadata changes, the disk is reused as is
+			serializedMeta.RunDefaults = diskConfig.RunDefaults
+			if err := WriteDiskMeta(diskPath, &serializedMeta); err != nil {
+				return fmt.Errorf("updating the run defaults: %w", err)
+			}
+			p.runDefaults = diskConfig.RunDefaults
+		}
 		return nil
 	}
 
-	return p.bootcInstallImageToDisk(quiet, diskConfig)
+	p.metrics().CacheMiss()
+	return p.bootcInstallImageToDisk(diskConfig)
+}
+
+// diskCreated returns when the disk of f was built
+func diskCreated(f *os.File, meta *DiskMeta) time.Time {
+	if !meta.Created.IsZero() {
+		return meta.Created
+	}
+	// Disks built before the timestamp was recorded
+	if st, err := f.Stat(); err == nil {
+		return st.ModTime()
+	}
+	return time.Time{}
+}
+
+// cacheMissReason returns the progress update explaining why the cached disk
+// of the same image, built at created, does not match the options of the
+// build, or an empty string if they match
+func cacheMissReason(meta *DiskMeta, created time.Time, diskConfig DiskImageConfig) string {
+	if diskConfig.MaxCacheAge > 0 {
+		age := time.Since(created)
+		switch {
+		case age <= diskConfig.MaxCacheAge:
+		case meta.Pinned:
+			// The age is a prune policy, pinned disks are never rebuilt for it
+			logrus.Debugf("keeping the pinned cached disk, it is %s old (max %s)", formatAge(age), formatAge(diskConfig.MaxCacheAge))
+		default:
+			return fmt.Sprintf("Cached disk is %s old (max %s), rebuilding", formatAge(age), formatAge(diskConfig.MaxCacheAge))
+		}
+	}
+	switch {
+	case meta.DiskFormat() != diskConfig.diskFormat():
+		return fmt.Sprintf("The cached disk is %s, rebuilding it as %s", meta.DiskFormat(), diskConfig.diskFormat())
+	case !equalArgs(meta.kargs(), diskConfig.Kargs):
+		return "The cached disk was built with different kernel arguments, rebuilding"
+	case !equalArgs(meta.extraInstallArgs(), diskConfig.ExtraInstallArgs):
+		return "The cached disk was built with different bootc install arguments, rebuilding"
+	case meta.rootSSHKeysDigest() != sshKeysDigest(diskConfig.rootSSHKeys):
+		return "The cached disk was built with different root SSH keys, rebuilding"
+	case meta.blockSetup() != diskConfig.BlockSetup:
+		return "The cached disk was built with a different block setup, rebuilding"
+	case !equalFilesystemOptions(meta.filesystemOptions(), diskConfig.FilesystemOptions):
+		return "The cached disk was built with different mkfs options, rebuilding"
+	case meta.installConfigDigest() != diskConfig.installConfigDigest:
+		return "The cached disk was built with a different install configuration, rebuilding"
+	case meta.postInstallHookDigest() != diskConfig.postInstallHookDigest:
+		return "The cached disk was modified by a different post-install hook, rebuilding"
+	}
+	return ""
+}
+
+// formatAge formats a duration in days, or with the duration format under a day
+func formatAge(d time.Duration) string {
+	if d < 24*time.Hour {
+		return d.Round(time.Second).String()
+	}
+	return fmt.Sprintf("%d days", int(d.Hours()/24))
 }
 
 func align(size int64, align int64) int64 {
@@ -215,71 +845,577 @@ func align(size int64, align int64) int64 {
 	return size
 }
 
-// bootcInstallImageToDisk creates a disk image from a bootc container
-func (p *BootcDisk) bootcInstallImageToDisk(quiet bool, diskConfig DiskImageConfig) (err error) {
-	fmt.Printf("Executing `bootc install to-disk` from container image %s to create disk image\n", p.RepoTag)
-	p.file, err = os.CreateTemp(p.Directory, "podman-bootc-tempdisk")
+// diskSizeEstimate describes how the size of a new disk image is derived
+type diskSizeEstimate struct {
+	containerSize int64
+	multiplier    int64
+	size          int64
+	// source is where the size comes from, label the image label setting it
+	source string
+	label  string
+}
+
+// The sources of the size of the disk image
+const (
+	DiskSizeFromHeuristic = "heuristic"
+	DiskSizeFromLabel     = "label"
+	DiskSizeFromFlag      = "flag"
+	DiskSizeFromMinimum   = "minimum"
+)
+
+// diskSizeLabels are the labels of the image setting the minimum size of
+// its disk image, the first valid one is used
+var diskSizeLabels = []string{"containers.bootc.disk-size", "bootc.diskSizeMinimum"}
+
+// labelDiskSize returns the minimum disk size set by a label of the image,
+// and the label. It returns 0 when no label sets a valid size, an invalid
+// size is ignored with a warning.
+func (p *BootcDisk) labelDiskSize() (int64, string) {
+	for _, label := range diskSizeLabels {
+		value, ok := p.imageData.Labels[label]
+		if !ok {
+			continue
+		}
+		size, err := units.FromHumanSize(strings.TrimSpace(value))
+		if err != nil || size <= 0 {
+			logrus.Warnf("ignoring the invalid disk size %q of the label %s of %s", value, label, p.RepoTag)
+			continue
+		}
+		return size, label
+	}
+	return 0, ""
+}
+
+const (
+	// filesystemLabel sets the default root filesystem of the image
+	filesystemLabel = "bootc.install.filesystem"
+	// rootSizeLabel sets the default maximum size of the root filesystem
+	rootSizeLabel = "bootc.install.root-size"
+)
+
+// applyLabelDefaults sets the filesystem and the root size left unset in
+// config from the labels of the image, the flags always win. Invalid values
+// are ignored with a warning. The merged config is the one recorded in the
+// metadata and compared by the cache lookup.
+func (p *BootcDisk) applyLabelDefaults(config *DiskImageConfig) {
+	labels := p.imageData.Labels
+	if value, ok := labels[filesystemLabel]; ok && config.Filesystem == "" {
+		fs := strings.ToLower(strings.TrimSpace(value))
+		if contains(installFilesystems, fs) {
+			config.Filesystem = fs
+		} else {
+			logrus.Warnf("ignoring the unsupported filesystem %q of the label %s of %s", value, filesystemLabel, p.RepoTag)
+		}
+	}
+	if value, ok := labels[rootSizeLabel]; ok && config.RootSizeMax == "" {
+		value = strings.TrimSpace(value)
+		rootSize, err := units.FromHumanSize(value)
+		// Validated, it is 0 when unset
+		diskSize, _ := units.FromHumanSize(config.DiskSize)
+		switch {
+		case err != nil || rootSize <= 0:
+			logrus.Warnf("ignoring the invalid root size %q of the label %s of %s", value, rootSizeLabel, p.RepoTag)
+		case diskSize > 0 && rootSize > diskSize:
+			logrus.Warnf("ignoring the root size %s of the label %s of %s, it is larger than the disk size %s", value, rootSizeLabel, p.RepoTag, config.DiskSize)
+		default:
+			config.RootSizeMax = value
+		}
+	}
+	logrus.Debugf("disk image options after the labels of %s: filesystem=%q root-size-max=%q", p.RepoTag, config.Filesystem, config.RootSizeMax)
+}
+
+// minDiskSize returns the floor of the disk size, diskSizeMinimum unless
+// MinDiskSize is set. A floor leaving no room for the content of an image of
+// containerSize bytes is refused.
+func (c DiskImageConfig) minDiskSize(containerSize int64) (int64, error) {
+	if c.MinDiskSize == "" {
+		return diskSizeMinimum, nil
+	}
+	minimum, err := units.FromHumanSize(c.MinDiskSize)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if needed := containerSize + diskSizeMargin; minimum < needed {
+		return 0, fmt.Errorf("the minimum disk size %s is too small for the image: its %s of content and the boot partitions need at least %s",
+			c.MinDiskSize, units.HumanSize(float64(containerSize)), units.HumanSize(float64(needed)))
+	}
+	return minimum, nil
+}
+
+// describeSource describes where the size of the disk image comes from
+func (e diskSizeEstimate) describeSource() string {
+	switch e.source {
+	case DiskSizeFromLabel:
+		return "the image label " + e.label
+	case DiskSizeFromFlag:
+		return "--disk-size"
+	case DiskSizeFromMinimum:
+		return "--disk-size-minimum"
 	}
-	size := p.imageData.Size * containerSizeToDiskSizeMultiplier
-	if size < diskSizeMinimum {
-		size = diskSizeMinimum
+	return fmt.Sprintf("%dx the container size", e.multiplier)
+}
+
+// estimateDiskSize computes the size of the disk image for the pulled image
+func (p *BootcDisk) estimateDiskSize(diskConfig DiskImageConfig) (diskSizeEstimate, error) {
+	estimate := diskSizeEstimate{
+		containerSize: p.imageData.Size,
+		multiplier:    containerSizeToDiskSizeMultiplier,
+	}
+
+	if estimate.containerSize < 0 {
+		return estimate, fmt.Errorf("invalid image size %d", estimate.containerSize)
+	}
+	if estimate.containerSize > math.MaxInt64/estimate.multiplier {
+		return estimate, fmt.Errorf("image size %d is too large to compute a disk size", estimate.containerSize)
+	}
+	minimum, err := diskConfig.minDiskSize(estimate.containerSize)
+	if err != nil {
+		return estimate, err
+	}
+	size := estimate.containerSize * estimate.multiplier
+	estimate.source = DiskSizeFromHeuristic
+	if size < minimum {
+		size = minimum
+		if diskConfig.MinDiskSize != "" {
+			estimate.source = DiskSizeFromMinimum
+		}
+	}
+	// The image author knows better than the heuristic, the flag may only
+	// grow the disk further
+	if labelSize, label := p.labelDiskSize(); labelSize > size {
+		size, estimate.source, estimate.label = labelSize, DiskSizeFromLabel, label
 	}
 	if diskConfig.DiskSize != "" {
 		diskConfigSize, err := units.FromHumanSize(diskConfig.DiskSize)
 		if err != nil {
-			return err
+			return estimate, err
 		}
 		if size < diskConfigSize {
-			size = diskConfigSize
+			size, estimate.source, estimate.label = diskConfigSize, DiskSizeFromFlag, ""
 		}
 	}
 	// Round up to 4k; loopback wants at least 512b alignment
-	size = align(size, 4096)
-	humanContainerSize := units.HumanSize(float64(p.imageData.Size))
+	if size > math.MaxInt64-4096 {
+		return estimate, fmt.Errorf("disk size %d is too large", size)
+	}
+	estimate.size = align(size, 4096)
+	logrus.Debugf("disk size %s from %s", units.HumanSize(float64(estimate.size)), estimate.describeSource())
+	return estimate, nil
+}
+
+// checkFileSizeLimit fails if the filesystem of directory cannot hold a file of size bytes
+func checkFileSizeLimit(directory string, size int64) error {
+	fsName, limit, err := fileSizeLimit(directory)
+	if err != nil {
+		logrus.Debugf("unable to get the file size limit of %s: %v", directory, err)
+		return nil
+	}
+	if size > limit {
+		return fmt.Errorf("filesystem %s of %s cannot hold a %s file, its maximum file size is %s",
+			fsName, directory, units.HumanSize(float64(size)), units.HumanSize(float64(limit)))
+	}
+	return nil
+}
+
+// summary returns a human readable description of the estimate,
+// including the free space left in the directory holding the disk
+func (e diskSizeEstimate) summary(directory string) string {
+	freeSpace := "unknown"
+	var st unix.Statfs_t
+	if err := unix.Statfs(directory, &st); err == nil {
+		freeSpace = units.HumanSize(float64(int64(st.Bavail) * int64(st.Bsize)))
+	}
+
+	return fmt.Sprintf("container size: %s, multiplier: %dx, disk size: %s from %s, free space in %s: %s",
+		units.HumanSize(float64(e.containerSize)), e.multiplier, units.HumanSize(float64(e.size)), e.describeSource(), directory, freeSpace)
+}
+
+// confirmLargeDisk asks for confirmation before creating a disk larger than
+// the configured threshold. Non-interactive runs proceed with a warning.
+func (p *BootcDisk) confirmLargeDisk(estimate diskSizeEstimate, diskConfig DiskImageConfig) error {
+	threshold := int64(defaultLargeDiskThreshold)
+	if diskConfig.LargeDiskThreshold != "" {
+		var err error
+		threshold, err = units.FromHumanSize(diskConfig.LargeDiskThreshold)
+		if err != nil {
+			return fmt.Errorf("invalid large disk threshold: %w", err)
+		}
+	}
+
+	if estimate.size <= threshold {
+		return nil
+	}
+
+	summary := estimate.summary(p.Directory)
+	if diskConfig.AssumeYes {
+		logrus.Infof("creating a large disk image, %s", summary)
+		return nil
+	}
+
+	if !utils.IsInteractive() {
+		logrus.Warnf("creating a disk image larger than %s, %s", units.HumanSize(float64(threshold)), summary)
+		return nil
+	}
+
+	fmt.Println(summary)
+	question := fmt.Sprintf("About to create a %s disk image in %s: continue?", units.HumanSize(float64(estimate.size)), p.Directory)
+	confirmed, err := utils.AskYesNo(question)
+	if err != nil {
+		return err
+	}
+	if !confirmed {
+		return errors.New("disk image creation cancelled")
+	}
+
+	return nil
+}
+
+// bootcInstallImageToDisk creates a disk image from a bootc container
+func (p *BootcDisk) bootcInstallImageToDisk(diskConfig DiskImageConfig) (err error) {
+	p.metrics().BuildStarted()
+	buildStart := time.Now()
+	defer func() {
+		if err != nil {
+			p.metrics().BuildFailed()
+		} else {
+			p.metrics().BuildSucceeded(time.Since(buildStart))
+		}
+	}()
+
+	estimate, err := p.estimateDiskSize(diskConfig)
+	if err != nil {
+		return err
+	}
+	if err := p.confirmLargeDisk(estimate, diskConfig); err != nil {
+		return err
+	}
+
+	if diskConfig.usesHostBackend() {
+		if p.bootcVersion, err = p.checkHostBackend(); err != nil {
+			return err
+		}
+	} else {
+		if diskConfig.IOMax != "" {
+			if err := p.throttleIODevice(diskConfig.IOMax, "/output"); err != nil {
+				return err
+			}
+		}
+		if err := p.checkFilesystemSupport(diskConfig.Filesystem); err != nil {
+			return err
+		}
+	}
+
+	if p.bootcVersion == "" {
+		p.bootcVersion = p.detectBootcVersion()
+	}
+	if p.verbosity.showInstallOutput() {
+		p.progressf("Using bootc version %s", p.bootcVersion)
+	}
+
+	if diskConfig.RebuildStrategy == RebuildUpgrade && !diskConfig.ForceRebuild {
+		upgraded, err := p.upgradePreviousDisk(diskConfig, estimate)
+		if err != nil {
+			logrus.Warnf("unable to upgrade the previous disk image, falling back to a clean install: %v", err)
+		}
+		if upgraded {
+			return nil
+		}
+	}
+
+	if resumed, err := p.resumeInstalledDisk(diskConfig); err != nil || resumed {
+		return err
+	}
+
+	if err := checkFileSizeLimit(p.Directory, estimate.size); err != nil {
+		return err
+	}
+
+	if diskConfig.usesHostBackend() {
+		p.progressf("Executing `bootc install to-disk` on the host from container image %s to create disk image", p.RepoTag)
+	} else {
+		p.progressf("Executing `bootc install to-disk` from container image %s to create disk image", p.RepoTag)
+	}
+	size := estimate.size
+	humanContainerSize := units.HumanSize(float64(estimate.containerSize))
 	humanSize := units.HumanSize(float64(size))
-	logrus.Infof("container size: %s, disk size: %s", humanContainerSize, humanSize)
+	logrus.Infof("container size: %s, disk size: %s from %s", humanContainerSize, humanSize, estimate.describeSource())
 
-	if err := syscall.Ftruncate(int(p.file.Fd()), size); err != nil {
+	if err := p.allocateTempDisk(size); err != nil {
 		return err
 	}
-	logrus.Debugf("Created %s with size %v", p.file.Name(), size)
 	doCleanupDisk := true
 	defer func() {
-		if doCleanupDisk {
+		if doCleanupDisk && !isPromotionError(err) {
 			os.Remove(p.file.Name())
 		}
 	}()
 
-	err = p.runInstallContainer(quiet, diskConfig)
+	removeKeys, err := p.writeRootSSHKeys(diskConfig)
 	if err != nil {
+		return err
+	}
+	defer removeKeys()
+	p.setProgressStep(StepInstall)
+	if diskConfig.usesHostBackend() {
+		err = p.runHostInstall(p.installCommand(diskConfig))
+	} else {
+		if p.mkfsWrapper, err = p.writeMkfsWrapper(diskConfig.FilesystemOptions); err != nil {
+			return err
+		}
+		p.mkfsOptions = diskConfig.FilesystemOptions
+		err = p.runDiagnosedInstall(diskConfig.LoopWait, func() error {
+			return p.runInstallWithRetries(diskConfig, size)
+		}, func() error {
+			return p.replaceTempDisk(size)
+		})
+		p.removeMkfsWrapper()
+	}
+	if err != nil {
+		if p.keepOnFailure && errors.Is(err, errInstallExited) {
+			doCleanupDisk = false
+			p.keepFailedInstall()
+		}
+		if p.installLogged {
+			return fmt.Errorf("failed to create disk image, the install output is in %s: %w", p.InstallLogPath(), err)
+		}
 		return fmt.Errorf("failed to create disk image: %w", err)
 	}
-	serializedMeta := diskFromContainerMeta{
-		ImageDigest: p.ImageId,
+	if err := p.checkMkfsApplied(diskConfig.FilesystemOptions); err != nil {
+		return err
+	}
+	p.setProgressStep(StepFinalize)
+	if err := p.runPostInstallHook(diskConfig); err != nil {
+		return err
+	}
+	meta := p.diskMeta(diskConfig)
+	if diskConfig.VerifyContent {
+		p.progressf("Verifying the contents of the disk image")
+		meta.ContentVerification = p.verifyContent()
+	}
+	// Keep the installed disk to resume when finalizing it fails
+	if err := p.markInstalled(diskConfig); err != nil {
+		logrus.Warnf("unable to record the install progress: %v", err)
+	} else {
+		doCleanupDisk = false
+	}
+	if err := p.commitDisk(meta); err != nil {
+		return err
+	}
+	doCleanupDisk = false
+	removeBuildProgress(p.file.Name())
+	return p.reportVerification(meta.ContentVerification)
+}
+
+// reportVerification fails when the contents of the promoted disk image did
+// not match the image, it only warns when the verification could not run
+func (p *BootcDisk) reportVerification(v *ContentVerification) error {
+	if v == nil {
+		return nil
 	}
-	buf, err := json.Marshal(serializedMeta)
+	p.verification = v
+	if v.Error != "" {
+		logrus.Warnf("%s", v.Summary())
+		return nil
+	}
+	if !v.Verified {
+		return &VerificationError{DiskPath: filepath.Join(p.Directory, config.DiskImage), Verification: *v}
+	}
+	p.progressf("Content verification passed, %s", v.Summary())
+	return nil
+}
+
+// createTempDisk creates a temporary disk in the cache entry. Its name has
+// the short image digest and a random nonce, so concurrent builds never
+// share one and an orphaned one tells which image it belongs to.
+func (p *BootcDisk) createTempDisk() (*os.File, error) {
+	return os.CreateTemp(p.Directory, fmt.Sprintf("%s-%s-*", tempDiskPrefix, shortID(p.ImageId)))
+}
+
+// allocateTempDisk creates a temporary disk of size bytes as p.file. It is
+// removed when the filesystem cannot hold it.
+func (p *BootcDisk) allocateTempDisk(size int64) (err error) {
+	p.file, err = p.createTempDisk()
 	if err != nil {
 		return err
 	}
-	if err := unix.Fsetxattr(int(p.file.Fd()), imageMetaXattr, buf, 0); err != nil {
-		return fmt.Errorf("failed to set xattr: %w", err)
+	defer func() {
+		if err != nil {
+			os.Remove(p.file.Name())
+		}
+	}()
+
+	if err := syscall.Ftruncate(int(p.file.Fd()), size); err != nil {
+		if errors.Is(err, syscall.EFBIG) {
+			return fmt.Errorf("the filesystem of %s cannot hold a %s file: %w", p.Directory, units.HumanSize(float64(size)), err)
+		}
+		return err
 	}
-	diskPath := filepath.Join(p.Directory, config.DiskImage)
+	// Some filesystems cap the size instead of failing
+	if st, err := p.file.Stat(); err != nil {
+		return err
+	} else if st.Size() != size {
+		return fmt.Errorf("the filesystem of %s cannot hold a %s file, it was truncated to %s",
+			p.Directory, units.HumanSize(float64(size)), units.HumanSize(float64(st.Size())))
+	}
+	if p.preallocated, err = p.preallocate(p.preallocation, size); err != nil {
+		return err
+	}
+	logrus.Debugf("Created %s with size %v, %s", p.file.Name(), size, p.preallocated)
+	return nil
+}
 
-	if err := os.Rename(p.file.Name(), diskPath); err != nil {
-		return fmt.Errorf("failed to rename to %s: %w", diskPath, err)
+// diskMeta returns the metadata describing a disk built from the current image
+func (p *BootcDisk) diskMeta(diskConfig DiskImageConfig) DiskMeta {
+	hostInputs := p.hostInputs(diskConfig, p.bootcVersion)
+	return DiskMeta{
+		ImageDigest:     p.ImageId,
+		Repository:      repositoryOf(p.RepoTag),
+		ConfigHash:      diskConfig.installHash(),
+		RunDefaults:     diskConfig.RunDefaults,
+		Pinned:          IsPinned(p.Directory),
+		InstallerDigest: p.installerImageId,
+		Created:         time.Now(),
+		BootcVersion:    p.bootcVersion,
+		BuilderVersion:  builderVersion(),
+		Connection:      diskConfig.Connection,
+		ImageRef:        p.pinnedImageRef(),
+		Generation:      p.nextGeneration(),
+		HostInputs:      &hostInputs,
+		Inputs: &BuildInputs{
+			Filesystem:            diskConfig.Filesystem,
+			RootSizeMax:           diskConfig.RootSizeMax,
+			DiskSize:              diskConfig.DiskSize,
+			MinDiskSize:           diskConfig.MinDiskSize,
+			InstallerImage:        diskConfig.InstallerImage,
+			BoundImages:           diskConfig.BoundImages,
+			Format:                diskConfig.Format,
+			Kargs:                 diskConfig.Kargs,
+			InstallConfig:         diskConfig.InstallConfig,
+			InstallConfigDigest:   diskConfig.installConfigDigest,
+			ExtraInstallArgs:      diskConfig.ExtraInstallArgs,
+			BlockSetup:            diskConfig.BlockSetup,
+			RootSSHKeys:           diskConfig.RootSSHKeys,
+			RootSSHKeysDigest:     sshKeysDigest(diskConfig.rootSSHKeys),
+			FilesystemOptions:     diskConfig.FilesystemOptions,
+			InstallTarget:         diskConfig.InstallTarget,
+			PostInstallHook:       diskConfig.PostInstallHook,
+			PostInstallHookDigest: diskConfig.postInstallHookDigest,
+			Preallocation:         diskConfig.Preallocation,
+		},
+		Format:             diskConfig.Format,
+		RootAuthorizedKeys: diskConfig.rootSSHKeys,
+		Preallocation:      p.preallocated,
 	}
-	doCleanupDisk = false
+}
 
+// commitDisk stores the metadata on the temporary disk and moves it in place
+func (p *BootcDisk) commitDisk(meta DiskMeta) error {
+	var disk io.ReaderAt = p.file
+	if qcow2.IsQcow2(p.file) {
+		// Resuming a build interrupted after the conversion
+		img, err := qcow2.Open(p.file)
+		if err != nil {
+			return fmt.Errorf("invalid disk image: %w", err)
+		}
+		disk = img
+	}
+	parts, err := partitions.Inspect(disk)
+	switch {
+	case errors.Is(err, partitions.ErrNoGPT):
+		logrus.Warnf("the disk image has no GUID partition table")
+	case err != nil:
+		return fmt.Errorf("invalid disk image: %w", err)
+	}
+	meta.Partitions = parts
+	if len(parts) > 0 && disk == p.file {
+		// The usage is informational, the disk is usable without it
+		if meta.Filesystems, err = p.filesystemUsage(); err != nil {
+			logrus.Warnf("unable to measure the filesystem usage of the disk image: %v", err)
+		}
+	}
+	if err := p.convertDisk(meta.DiskFormat()); err != nil {
+		return err
+	}
+	// Setting the xattr and renaming the disk image keep its mtime
+	if meta.Fingerprint, err = fingerprintDisk(p.file); err != nil {
+		return fmt.Errorf("fingerprinting the disk image: %w", err)
+	}
+
+	buf, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	diskPath := filepath.Join(p.Directory, config.DiskImage)
+	if err := removeProvenance(p.Directory); err != nil {
+		return err
+	}
+	// Crashing before writing the new sidecar leaves the disk without
+	// metadata, so it is rebuilt instead of being described by the old one
+	if err := os.Remove(sidecarPath(diskPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	sidecar := useSidecar(p.Directory)
+	if !sidecar {
+		sidecar, err = setMetaXattr(func(value []byte) error {
+			return unix.Fsetxattr(int(p.file.Fd()), imageMetaXattr, value, 0)
+		}, buf, meta.ImageDigest)
+		if err != nil {
+			return fmt.Errorf("failed to set xattr: %w", err)
+		}
+	}
+
+	if _, err := p.diskPermissions.apply(p.file); err != nil {
+		return err
+	}
+	if err := renameWithRetry(p.file.Name(), diskPath); err != nil {
+		return keepTempDisk(p.file.Name(), diskPath, buf, err)
+	}
+	if sidecar {
+		if err := writeSidecar(diskPath, buf); err != nil {
+			return fmt.Errorf("failed to write the disk metadata: %w", err)
+		}
+	}
+	p.runDefaults = meta.RunDefaults
+	p.setBuiltAt(meta.Created)
+	p.printFilesystemUsage(meta.Filesystems)
 	return nil
 }
 
-// pullImage fetches the container image if not present
-func (p *BootcDisk) pullImage() (err error) {
-	pullPolicy := "missing"
-	ids, err := images.Pull(p.Ctx, p.ImageNameOrId, &images.PullOptions{Policy: &pullPolicy})
+// pullImage fetches the container image according to the pull policy
+func (p *BootcDisk) pullImage(pullPolicy string, diskConfig DiskImageConfig) (err error) {
+	if err := p.requireAPI(featurePull); err != nil {
+		return err
+	}
+	pin, err := p.applyDigestPin(diskConfig.DigestFile)
+	if err != nil {
+		return err
+	}
+	if pin == nil {
+		if err := p.resolveShortName(); err != nil {
+			return err
+		}
+	}
+	reference := p.ImageNameOrId
+
+	// Used to approximate the pulled bytes with the size of the image
+	wasPresent, err := p.podman().ImageExists(p.Ctx, p.ImageNameOrId, &images.ExistsOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to check if image exists: %w", err)
+	}
+
+	var ids []string
+	if diskConfig.RegistryMirror != "" && (!wasPresent || pullPolicy == "always") {
+		ids, err = p.pullFromMirror(diskConfig.RegistryMirror, pullPolicy)
+		if err != nil && diskConfig.MirrorFallback {
+			logrus.Warnf("%v, falling back to the upstream registry", err)
+			ids, err = p.pull(p.ImageNameOrId, pullPolicy)
+		}
+	} else {
+		ids, err = p.pull(p.ImageNameOrId, pullPolicy)
+	}
+	if err != nil && pin != nil {
+		return fmt.Errorf("the image %s pinned in %s is no longer available: %w", pin.ImageRef, diskConfig.DigestFile, err)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to pull image: %w", err)
 	}
@@ -292,83 +1428,176 @@ func (p *BootcDisk) pullImage() (err error) {
 		return fmt.Errorf("multiple ids returned from image pull")
 	}
 
-	image, err := images.GetImage(p.Ctx, p.ImageNameOrId, &images.GetOptions{})
+	image, err := p.podman().GetImage(p.Ctx, p.ImageNameOrId, &images.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to get image: %w", err)
 	}
 	p.imageData = image
 
 	imageId := ids[0]
+	if !wasPresent || pullPolicy == "always" {
+		p.metrics().BytesPulled(image.Size)
+		p.updateProgress(func(e *ProgressEvent) { e.PulledBytes = image.Size })
+	}
 	p.ImageId = imageId
 	p.RepoTag = image.RepoTags[0]
 
+	switch {
+	case pin != nil && imageId != pin.ImageId:
+		return fmt.Errorf("%s resolved to %s instead of the image %s pinned in %s", pin.pinned(), imageId, pin.ImageId, diskConfig.DigestFile)
+	case pin == nil && diskConfig.DigestFile != "":
+		return writeDigestPin(diskConfig.DigestFile, digestPin{Reference: reference, ImageId: imageId, ImageRef: p.pinnedImageRef()})
+	}
 	return
 }
 
-// runInstallContainer runs the bootc installer in a container to create a disk image
-func (p *BootcDisk) runInstallContainer(quiet bool, config DiskImageConfig) (err error) {
-	// Create a temporary external shell script with the contents of our losetup wrapper
-	losetupTemp, err := os.CreateTemp(p.Directory, "losetup-wrapper")
+// installImage returns the image running the installer
+func (p *BootcDisk) installImage() string {
+	if p.installerImageId != "" {
+		return p.installerImageId
+	}
+	return p.ImageNameOrId
+}
+
+// pullInstallerImage pulls the installer image if missing and checks it contains bootc
+func (p *BootcDisk) pullInstallerImage(installerImage string) error {
+	pullPolicy := "missing"
+	ids, err := p.podman().PullImage(p.Ctx, installerImage, p.pullOptions(&pullPolicy))
 	if err != nil {
-		return fmt.Errorf("temp losetup wrapper: %w", err)
+		return fmt.Errorf("failed to pull installer image %s: %w", installerImage, err)
 	}
-	defer os.Remove(losetupTemp.Name())
-	if _, err := io.Copy(losetupTemp, strings.NewReader(tempLosetupWrapperContents)); err != nil {
-		return fmt.Errorf("temp losetup wrapper copy: %w", err)
+	if len(ids) != 1 {
+		return fmt.Errorf("pulling installer image %s returned %d ids", installerImage, len(ids))
 	}
-	if err := losetupTemp.Chmod(0o755); err != nil {
-		return fmt.Errorf("temp losetup wrapper chmod: %w", err)
+
+	version, err := p.runHelperContainer(ids[0], []string{"bootc", "--version"})
+	if err != nil {
+		return fmt.Errorf("installer image %s cannot run bootc: %w", installerImage, err)
 	}
+	p.installerImageId = ids[0]
+	p.bootcVersion = parseBootcVersion(version)
+	logrus.Debugf("Using installer image %s (%s)", installerImage, p.installerImageId)
+	return nil
+}
 
-	createResponse, err := p.createInstallContainer(config, losetupTemp.Name())
+// detectBootcVersion runs bootc --version in a short-lived container
+func (p *BootcDisk) detectBootcVersion() string {
+	output, err := p.runHelperContainer(p.installImage(), []string{"bootc", "--version"})
+	if err != nil {
+		logrus.Warnf("unable to determine the bootc version: %v", err)
+		return "unknown"
+	}
+	return parseBootcVersion(output)
+}
+
+// parseBootcVersion extracts the version from the output of bootc --version, e.g. "bootc 1.1.0"
+func parseBootcVersion(output string) string {
+	fields := strings.Fields(output)
+	if len(fields) < 2 || fields[0] != "bootc" {
+		return "unknown"
+	}
+	return fields[1]
+}
+
+// runInstallContainer runs command in a privileged container from the install image to create a disk image
+func (p *BootcDisk) runInstallContainer(command []string) (err error) {
+	// Suspending the host in the middle of the install breaks the loop devices
+	release := utils.InhibitSleep("podman-bootc: building disk image for " + p.RepoTag)
+	defer release()
+
+	losetupTemp, err := p.writeLosetupWrapper()
+	if err != nil {
+		return err
+	}
+	defer removeLosetupWrapper(losetupTemp)
+
+	s := p.installContainerSpec(p.installImage(), command, losetupTemp)
+	if p.keepOnFailure {
+		// Kept for podman logs when bootc install fails
+		autoRemove := false
+		s.Remove = &autoRemove
+	}
+	createResponse, err := p.createContainer(s)
 	if err != nil {
 		return fmt.Errorf("failed to create container: %w", err)
 	}
 
 	p.bootcInstallContainerId = createResponse.ID //save the id for possible cleanup
 	logrus.Debugf("Created install container, id=%s", createResponse.ID)
+	defer p.cleanupAfterDeadline()
+	if p.keepOnFailure {
+		defer func() {
+			if !errors.Is(err, errInstallExited) && p.Ctx.Err() == nil {
+				if err := p.Cleanup(); err != nil {
+					logrus.Errorf("%v", err)
+				}
+			}
+		}()
+	}
 
 	// run the container to create the disk
-	err = containers.Start(p.Ctx, p.bootcInstallContainerId, &containers.StartOptions{})
+	err = p.podman().StartContainer(p.Ctx, p.bootcInstallContainerId, &containers.StartOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to start container: %w", err)
 	}
 	logrus.Debugf("Started install container")
 
-	// Ensure we've cancelled the container attachment when exiting this function, as
-	// it takes over stdout/stderr handling
-	attachCancelCtx, cancelAttach := context.WithCancel(p.Ctx)
-	defer cancelAttach()
+	// The attachment takes over stdout/stderr handling, it is released
+	// when AttachContainer returns
 	var exitCode int32
-	if !quiet {
-		attachOpts := new(containers.AttachOptions).WithStream(true)
-		if err := containers.Attach(attachCancelCtx, p.bootcInstallContainerId, nil, os.Stdout, os.Stderr, nil, attachOpts); err != nil {
-			return fmt.Errorf("attaching: %w", err)
+	// Always attach to keep the end of the output for diagnosing failures
+	var closeOutput func()
+	p.installOutput, closeOutput = p.newInstallOutput()
+	// The pump reports and survives its own log errors
+	defer closeOutput()
+	var stdout, stderr io.Writer = p.installOutput, p.installOutput
+	if phases := p.newInstallPhaseWriter(); phases != nil {
+		stdout, stderr = io.MultiWriter(stdout, phases), io.MultiWriter(stderr, phases)
+	}
+	if p.verbosity.showInstallOutput() {
+		stdout = io.MultiWriter(p.out(), stdout)
+		stderr = io.MultiWriter(os.Stderr, stderr)
+	}
+	attachOpts := new(containers.AttachOptions).WithStream(true)
+	if err := p.podman().AttachContainer(p.Ctx, p.bootcInstallContainerId, nil, stdout, stderr, nil, attachOpts); err != nil {
+		return fmt.Errorf("attaching: %w", err)
+	}
+	waitCtx := p.Ctx
+	if p.installTimeout > 0 {
+		var cancelWait context.CancelFunc
+		waitCtx, cancelWait = context.WithTimeout(p.Ctx, p.installTimeout)
+		defer cancelWait()
+	}
+	exitCode, err = p.podman().WaitContainer(waitCtx, p.bootcInstallContainerId, nil)
+	if err != nil && p.Ctx.Err() == nil && errors.Is(waitCtx.Err(), context.DeadlineExceeded) {
+		if err := p.Cleanup(); err != nil {
+			logrus.Errorf("%v", err)
 		}
+		p.bootcInstallContainerId = ""
+		return fmt.Errorf("the install container did not finish within the install timeout of %s and was removed: %w", p.installTimeout, err)
 	}
-	exitCode, err = containers.Wait(p.Ctx, p.bootcInstallContainerId, nil)
 	if err != nil {
 		return fmt.Errorf("failed to wait for container: %w", err)
 	}
 
 	if exitCode != 0 {
-		return fmt.Errorf("failed to run bootc install")
+		return errInstallExited
 	}
 
 	return
 }
 
-// createInstallContainer creates a container to run the bootc installer
-func (p *BootcDisk) createInstallContainer(config DiskImageConfig, tempLosetup string) (createResponse types.ContainerCreateResponse, err error) {
-	privileged := true
-	autoRemove := true
-	labelNested := true
+// installCommand returns the bootc command installing the image to the temporary disk
+func (p *BootcDisk) installCommand(config DiskImageConfig) []string {
+	return p.installCommandFor(config, p.file.Name())
+}
 
-	targetEnv := make(map[string]string)
-	if v, ok := os.LookupEnv("BOOTC_INSTALL_LOG"); ok {
-		targetEnv["RUST_LOG"] = v
+// installCommandFor returns the bootc command installing the image to the
+// disk at diskPath, in the cache entry, or to the target of to-filesystem
+func (p *BootcDisk) installCommandFor(config DiskImageConfig, diskPath string) []string {
+	if config.installsToFilesystem() {
+		return p.toFilesystemCommand(config)
 	}
-
 	bootcInstallArgs := []string{
 		"bootc", "install", "to-disk", "--via-loopback", "--generic-image",
 		"--skip-fetch-check",
@@ -379,13 +1608,106 @@ func (p *BootcDisk) createInstallContainer(config DiskImageConfig, tempLosetup s
 	if config.RootSizeMax != "" {
 		bootcInstallArgs = append(bootcInstallArgs, "--root-size="+config.RootSizeMax)
 	}
-	bootcInstallArgs = append(bootcInstallArgs, "/output/"+filepath.Base(p.file.Name()))
+	if config.BlockSetup != "" {
+		bootcInstallArgs = append(bootcInstallArgs, "--block-setup", config.BlockSetup)
+	}
+	bootcInstallArgs = append(bootcInstallArgs, p.installOptions(config)...)
+	if config.usesHostBackend() {
+		return append(bootcInstallArgs, diskPath)
+	}
+	return append(bootcInstallArgs, "/output/"+filepath.Base(diskPath))
+}
+
+// installOptions returns the options of bootc install shared by to-disk
+// and to-filesystem
+func (p *BootcDisk) installOptions(config DiskImageConfig) []string {
+	var bootcInstallArgs []string
+	if config.rootSSHKeys != "" {
+		keys := "/output/" + rootSSHKeysFile
+		if config.usesHostBackend() {
+			keys = filepath.Join(p.Directory, rootSSHKeysFile)
+		}
+		bootcInstallArgs = append(bootcInstallArgs, "--root-ssh-authorized-keys", keys)
+	}
+	for _, karg := range config.Kargs {
+		bootcInstallArgs = append(bootcInstallArgs, "--karg="+karg)
+	}
+	if p.installerImageId != "" || config.usesHostBackend() {
+		bootcInstallArgs = append(bootcInstallArgs,
+			"--source-imgref", "containers-storage:"+p.ImageId,
+			"--target-imgref", p.RepoTag)
+	}
+	return append(bootcInstallArgs, config.ExtraInstallArgs...)
+}
+
+// kargs returns the kernel arguments the disk was installed with
+func (m *DiskMeta) kargs() []string {
+	if m.Inputs == nil {
+		return nil
+	}
+	return m.Inputs.Kargs
+}
+
+// extraInstallArgs returns the bootc install arguments passed through for the disk
+func (m *DiskMeta) extraInstallArgs() []string {
+	if m.Inputs == nil {
+		return nil
+	}
+	return m.Inputs.ExtraInstallArgs
+}
+
+// equalArgs reports if the arguments are the same, in the same order
+func equalArgs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// createInstallContainer creates a privileged container from image running command
+func (p *BootcDisk) createInstallContainer(image string, command []string, tempLosetup string) (createResponse types.ContainerCreateResponse, err error) {
+	return p.createContainer(p.installContainerSpec(image, command, tempLosetup))
+}
+
+// createContainer creates the privileged container of the spec
+func (p *BootcDisk) createContainer(s *specgen.SpecGenerator) (createResponse types.ContainerCreateResponse, err error) {
+	if err := p.requireAPI(featureInstall); err != nil {
+		return createResponse, err
+	}
+	p.dumpSpec(s)
+	createResponse, err = p.podman().CreateContainer(p.Ctx, s, &containers.CreateOptions{})
+	if err != nil {
+		return createResponse, fmt.Errorf("failed to create container: %w", err)
+	}
+
+	return
+}
+
+// installContainerSpec returns the spec of the privileged install container
+func (p *BootcDisk) installContainerSpec(image string, command []string, tempLosetup string) *specgen.SpecGenerator {
+	privileged := true
+	autoRemove := true
+	labelNested := true
+
+	targetEnv := make(map[string]string)
+	if v, ok := os.LookupEnv("BOOTC_INSTALL_LOG"); ok {
+		targetEnv["RUST_LOG"] = v
+	}
 
 	// Allocate pty so we can show progress bars, spinners etc.
 	trueDat := true
 	s := &specgen.SpecGenerator{
 		ContainerBasicConfig: specgen.ContainerBasicConfig{
-			Command:     bootcInstallArgs,
+			// Override the entrypoint of the image, a wrapper script would
+			// receive the command as its arguments. An empty entrypoint is
+			// dropped by the bindings, so the command is split.
+			Entrypoint:  command[:1],
+			Command:     command[1:],
 			PidNS:       specgen.Namespace{NSMode: specgen.Host},
 			Remove:      &autoRemove,
 			Annotations: map[string]string{"io.podman.annotations.label": "type:unconfined_t"},
@@ -393,13 +1715,8 @@ func (p *BootcDisk) createInstallContainer(config DiskImageConfig, tempLosetup s
 			Terminal:    &trueDat,
 		},
 		ContainerStorageConfig: specgen.ContainerStorageConfig{
-			Image: p.ImageNameOrId,
-			Mounts: []specs.Mount{
-				{
-					Source:      "/var/lib/containers",
-					Destination: "/var/lib/containers",
-					Type:        "bind",
-				},
+			Image: image,
+			Mounts: append(p.storageMounts(), []specs.Mount{
 				{
 					Source:      "/dev",
 					Destination: "/dev",
@@ -410,14 +1727,7 @@ func (p *BootcDisk) createInstallContainer(config DiskImageConfig, tempLosetup s
 					Destination: "/output",
 					Type:        "bind",
 				},
-				{
-					Source: tempLosetup,
-					// Note that the default $PATH has /usr/local/sbin first
-					Destination: "/usr/local/sbin/losetup",
-					Type:        "bind",
-					Options:     []string{"ro"},
-				},
-			},
+			}...),
 		},
 		ContainerSecurityConfig: specgen.ContainerSecurityConfig{
 			Privileged:  &privileged,
@@ -429,12 +1739,43 @@ func (p *BootcDisk) createInstallContainer(config DiskImageConfig, tempLosetup s
 				NSMode: specgen.Bridge,
 			},
 		},
+		ContainerResourceConfig: specgen.ContainerResourceConfig{
+			ResourceLimits: p.resources,
+		},
 	}
-
-	createResponse, err = containers.CreateWithSpec(p.Ctx, s, &containers.CreateOptions{})
-	if err != nil {
-		return createResponse, fmt.Errorf("failed to create container: %w", err)
+	if tempLosetup != "" {
+		s.Mounts = append(s.Mounts, specs.Mount{
+			Source: tempLosetup,
+			// Note that the default $PATH has /usr/local/sbin first
+			Destination: "/usr/local/sbin/losetup",
+			Type:        "bind",
+			Options:     []string{"ro"},
+		})
+	}
+	if p.mkfsWrapper != "" {
+		mounts, env := mkfsWrapperSpec(p.mkfsWrapper, p.mkfsOptions)
+		s.Mounts = append(s.Mounts, mounts...)
+		for k, v := range env {
+			s.Env[k] = v
+		}
 	}
+	if p.installTarget != "" {
+		s.Mounts = append(s.Mounts, specs.Mount{
+			Source:      p.installTarget,
+			Destination: p.installTargetPath(),
+			Type:        "bind",
+			Options:     []string{"rbind"},
+		})
+	}
+	if p.installConfig != "" {
+		s.Mounts = append(s.Mounts, specs.Mount{
+			Source:      p.installConfig,
+			Destination: installConfigPath,
+			Type:        "bind",
+			Options:     []string{"ro"},
+		})
+	}
+	p.applySpecCompat(&s.LabelNested, &s.SelinuxOpts)
 
-	return
+	return s
 }
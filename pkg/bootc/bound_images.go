@@ -0,0 +1,108 @@
+package bootc
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// boundImagesScript prints the quadlet files declaring the logically bound images
+const boundImagesScript = `for f in /usr/lib/bootc/bound-images.d/*.container /usr/lib/bootc/bound-images.d/*.image; do
+	[ -e "$f" ] && cat "$f" && echo
+done
+true
+`
+
+// copyBoundImagesScript copies the images given as arguments from the
+// container storage of the podman machine to the storage bootc keeps the
+// logically bound images in, /usr/lib/bootc/storage of the booted system
+// which links to ostree/bootc/storage of the root filesystem. It runs in
+// the image installing the disk with skopeo, which bootc requires, and sh.
+const copyBoundImagesScript = mountTargetScript + `storage="$mnt/ostree/bootc/storage"
+mkdir -p "$storage"
+for image in "$@"; do
+	echo "Copying bound image $image"
+	skopeo copy --quiet "containers-storage:[overlay@/var/lib/containers/storage+/run/containers/storage]$image" \
+		"containers-storage:[overlay@$storage+/run/podman-bootc-runroot]$image"
+done
+`
+
+// parseBoundImages returns the images referenced by the Image= keys of quadlet files
+func parseBoundImages(quadlets string) []string {
+	seen := make(map[string]bool)
+	var boundImages []string
+	scanner := bufio.NewScanner(strings.NewReader(quadlets))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || strings.TrimSpace(key) != "Image" {
+			continue
+		}
+		image := strings.TrimSpace(value)
+		if image != "" && !seen[image] {
+			seen[image] = true
+			boundImages = append(boundImages, image)
+		}
+	}
+	sort.Strings(boundImages)
+	return boundImages
+}
+
+// prePullBoundImages pulls the logically bound images of the image and
+// copies them into the container storage of the disk image, unless the
+// disk already holds the same image digests
+func (p *BootcDisk) prePullBoundImages() error {
+	quadlets, err := p.runHelperContainer(p.ImageNameOrId, []string{"sh", "-c", boundImagesScript})
+	if err != nil {
+		return fmt.Errorf("listing the bound images: %w", err)
+	}
+	boundImages := parseBoundImages(quadlets)
+	if len(boundImages) == 0 {
+		logrus.Debugf("%s has no logically bound images", p.RepoTag)
+		return nil
+	}
+
+	digests := make(map[string]string, len(boundImages))
+	policy := "missing"
+	for _, image := range boundImages {
+		ids, err := p.podman().PullImage(p.Ctx, image, p.pullOptions(&policy))
+		if err != nil {
+			return fmt.Errorf("pulling bound image %s: %w", image, err)
+		}
+		if len(ids) != 1 {
+			return fmt.Errorf("pulling bound image %s returned %d ids", image, len(ids))
+		}
+		digests[image] = ids[0]
+	}
+
+	diskPath := filepath.Join(p.Directory, config.DiskImage)
+	meta, err := ReadDiskMeta(diskPath)
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(meta.BoundImages, digests) {
+		logrus.Debugf("the bound images of %s are unchanged", diskPath)
+		return nil
+	}
+
+	p.progressf("Copying %d bound images into the disk image", len(boundImages))
+	command := append([]string{"sh", "-c", copyBoundImagesScript, "bound-images", "/output/" + config.DiskImage}, boundImages...)
+	if _, err := p.runHelperContainer(p.installImage(), command); err != nil {
+		return fmt.Errorf("copying the bound images: %w", err)
+	}
+
+	meta.BoundImages = digests
+	if meta.Fingerprint != nil {
+		// The copy changed the disk image
+		if err := meta.SetFingerprint(diskPath); err != nil {
+			return err
+		}
+	}
+	return WriteDiskMeta(diskPath, meta)
+}
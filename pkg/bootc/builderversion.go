@@ -0,0 +1,26 @@
+package bootc
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// builderVersionNote describes a cached disk built by another version of
+// podman-bootc than the running one, with the level to log it at. It returns
+// an empty note when there is nothing to tell.
+func builderVersionNote(built, running string) (string, logrus.Level) {
+	if built == "" || built == running {
+		// Disks built before the version was recorded are not reported
+		return "", logrus.DebugLevel
+	}
+	return fmt.Sprintf("the cached disk was built by podman-bootc %s, this is %s", built, running), logrus.InfoLevel
+}
+
+// checkBuilderVersion logs the note about the version which built a cached disk
+func checkBuilderVersion(meta *DiskMeta) {
+	note, level := builderVersionNote(meta.BuilderVersion, builderVersion())
+	if note != "" {
+		logrus.StandardLogger().Log(level, note)
+	}
+}
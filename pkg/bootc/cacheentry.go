@@ -0,0 +1,61 @@
+package bootc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+)
+
+// cacheEntryIdPattern matches the full image ids naming the cache entries
+var cacheEntryIdPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// IsCacheEntryId reports if id is a full image id, the name of a cache entry
+func IsCacheEntryId(id string) bool {
+	return cacheEntryIdPattern.MatchString(id)
+}
+
+// carriedOnReplace are the files of a cache entry kept when it is replaced:
+// the data disks belong to the VM, and the next build removes a kept install
+// failure
+func carriedOnReplace(name string) bool {
+	return strings.HasPrefix(name, config.DataDiskPrefix) || name == keptFailureFile ||
+		strings.HasPrefix(name, tempDiskPrefix)
+}
+
+// ReplaceCacheEntry moves the cache entry prepared in dir to target, e.g. an
+// unbundled or imported one. The data disks of the previous entry at target
+// are moved to the new one before the previous entry is removed. The caller
+// must hold the lock of the entry.
+func ReplaceCacheEntry(dir, target string) error {
+	old := dir + ".old"
+	if err := os.Rename(target, old); errors.Is(err, os.ErrNotExist) {
+		return os.Rename(dir, target)
+	} else if err != nil {
+		return err
+	}
+	if err := os.Rename(dir, target); err != nil {
+		if restoreErr := os.Rename(old, target); restoreErr != nil {
+			return fmt.Errorf("%w, the previous cache entry is kept in %s", err, old)
+		}
+		return err
+	}
+
+	entries, err := os.ReadDir(old)
+	if err != nil {
+		return fmt.Errorf("reading the previous cache entry, it is kept in %s: %w", old, err)
+	}
+	for _, entry := range entries {
+		if !carriedOnReplace(entry.Name()) {
+			continue
+		}
+		if err := os.Rename(filepath.Join(old, entry.Name()), filepath.Join(target, entry.Name())); err != nil {
+			return fmt.Errorf("moving %s to the new cache entry, the previous one is kept in %s: %w", entry.Name(), old, err)
+		}
+	}
+	return os.RemoveAll(old)
+}
@@ -0,0 +1,175 @@
+package bootc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The kinds of auxiliary artifacts of a cache entry removed by CleanEntry
+const (
+	CleanSeeds          = "seed"
+	CleanLogs           = "log"
+	CleanFailureBundles = "failure bundle"
+)
+
+// ErrEntryInUse is returned by CleanEntry for a cache entry locked by
+// another command, e.g. a build or a VM running in the foreground
+var ErrEntryInUse = errors.New("the cache entry is in use")
+
+// CleanOptions selects the auxiliary artifacts removed by CleanEntry
+type CleanOptions struct {
+	// Seeds is the cloud-init seed of the VM written by run --cloudinit
+	Seeds bool
+	// Logs is the log of the last install
+	Logs bool
+	// FailureBundles are the tombstone of the last failed build and the
+	// install container and temporary disk kept by --keep-on-failure
+	FailureBundles bool
+	// RemoveContainer removes the install container kept by a failed build.
+	// Without it, the container is kept and reported.
+	RemoveContainer func(id string) error
+}
+
+// CleanedArtifact is an artifact removed by CleanEntry
+type CleanedArtifact struct {
+	Kind string `json:"kind"`
+	// Path is the removed file, or the id of a removed container
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// CleanReport lists the artifacts removed from a cache entry
+type CleanReport struct {
+	Id      string            `json:"id"`
+	Removed []CleanedArtifact `json:"removed"`
+	// InUse is set when the entry was skipped because it is locked
+	InUse bool `json:"inUse,omitempty"`
+}
+
+// CleanEntry removes the selected auxiliary artifacts of the cache entry in
+// dir while holding its exclusive lock. The disk image and its metadata are
+// never removed. It returns ErrEntryInUse when the entry is locked.
+func CleanEntry(u user.User, dir string, opts CleanOptions) (CleanReport, error) {
+	report := CleanReport{Id: filepath.Base(dir), Removed: []CleanedArtifact{}}
+	lock := utils.NewCacheLock(u.RunDir(), dir)
+	locked, err := lock.TryLock(utils.Exclusive)
+	if err != nil {
+		return report, fmt.Errorf("locking %s: %w", dir, err)
+	}
+	if !locked {
+		return report, ErrEntryInUse
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			logrus.Warnf("unable to unlock %s: %v", dir, err)
+		}
+	}()
+
+	remove := func(kind, name string) error {
+		path := filepath.Join(dir, name)
+		st, err := os.Lstat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("removing %s: %w", path, err)
+		}
+		report.Removed = append(report.Removed, CleanedArtifact{Kind: kind, Path: path, Size: st.Size()})
+		return nil
+	}
+
+	if opts.Seeds {
+		if err := remove(CleanSeeds, config.CiDataIso); err != nil {
+			return report, err
+		}
+	}
+	if opts.Logs {
+		if err := remove(CleanLogs, installLogFile); err != nil {
+			return report, err
+		}
+	}
+	if opts.FailureBundles {
+		if err := removeKeptFailureOf(dir, opts.RemoveContainer, &report); err != nil {
+			return report, err
+		}
+		if err := remove(CleanFailureBundles, tombstoneFile); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// removeKeptFailureOf removes the temporary disk and the install container
+// kept by a failed build of the cache entry in dir, then their record. The
+// record is kept when the container could not be removed.
+func removeKeptFailureOf(dir string, removeContainer func(string) error, report *CleanReport) error {
+	recordPath := filepath.Join(dir, keptFailureFile)
+	buf, err := os.ReadFile(recordPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var kept keptFailure
+	if err := json.Unmarshal(buf, &kept); err != nil {
+		logrus.Warnf("removing the invalid kept install failure %s: %v", recordPath, err)
+	}
+
+	// Only temporary disks of this cache entry are removed
+	if kept.TempDisk != "" && filepath.Dir(kept.TempDisk) == dir {
+		if st, err := os.Lstat(kept.TempDisk); err == nil {
+			if err := os.Remove(kept.TempDisk); err != nil {
+				return fmt.Errorf("removing %s: %w", kept.TempDisk, err)
+			}
+			report.Removed = append(report.Removed, CleanedArtifact{Kind: CleanFailureBundles, Path: kept.TempDisk, Size: st.Size()})
+		}
+	}
+	if kept.ContainerId != "" {
+		if removeContainer == nil {
+			logrus.Warnf("keeping the install container %s of a failed build, remove it with podman rm", kept.ContainerId)
+		} else if err := removeContainer(kept.ContainerId); err != nil {
+			return fmt.Errorf("removing the install container %s kept by a failed build: %w", kept.ContainerId, err)
+		} else {
+			report.Removed = append(report.Removed, CleanedArtifact{Kind: CleanFailureBundles, Path: kept.ContainerId})
+		}
+	}
+	if err := os.Remove(recordPath); err != nil {
+		return fmt.Errorf("removing %s: %w", recordPath, err)
+	}
+	report.Removed = append(report.Removed, CleanedArtifact{Kind: CleanFailureBundles, Path: recordPath, Size: int64(len(buf))})
+	return nil
+}
+
+// CleanAllEntries runs CleanEntry on every cache entry of u, skipping the
+// ones in use
+func CleanAllEntries(u user.User, opts CleanOptions) ([]CleanReport, error) {
+	entries, err := os.ReadDir(u.CacheDir())
+	if err != nil {
+		return nil, err
+	}
+	var reports []CleanReport
+	for _, entry := range entries {
+		if !entry.IsDir() || len(entry.Name()) != 64 {
+			continue
+		}
+		report, err := CleanEntry(u, u.ImageCacheDir(entry.Name()), opts)
+		if errors.Is(err, ErrEntryInUse) {
+			report.InUse = true
+		} else if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
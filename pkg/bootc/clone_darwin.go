@@ -0,0 +1,11 @@
+package bootc
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile is not supported on an existing destination file
+func cloneFile(dst, src *os.File) error {
+	return errors.New("cloning files is not supported")
+}
@@ -0,0 +1,60 @@
+package bootc
+
+import (
+	"fmt"
+
+	"github.com/blang/semver/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// apiFeature is a podman API feature used to build disk images
+type apiFeature string
+
+const (
+	featurePull        apiFeature = "pulling images"
+	featureInstall     apiFeature = "creating the install container"
+	featureLabelNested apiFeature = "nested SELinux labels"
+)
+
+// minAPIVersion is the minimum podman service API version of each feature.
+// Features without fallback fail early with the required version.
+var minAPIVersion = map[apiFeature]semver.Version{
+	featurePull:        semver.MustParse("4.0.0"),
+	featureInstall:     semver.MustParse("4.0.0"),
+	featureLabelNested: semver.MustParse("4.8.0"),
+}
+
+// supports reports if the podman service supports the feature, services
+// with an unknown version are assumed to support all of them
+func (p *BootcDisk) supports(feature apiFeature) bool {
+	version := p.podman().ServiceVersion(p.Ctx)
+	if version == nil {
+		return true
+	}
+	required, ok := minAPIVersion[feature]
+	if !ok {
+		return true
+	}
+	return version.GE(required)
+}
+
+// requireAPI returns an error naming the minimum podman version of the
+// feature when the podman service is older
+func (p *BootcDisk) requireAPI(feature apiFeature) error {
+	if p.supports(feature) {
+		return nil
+	}
+	required := minAPIVersion[feature]
+	return fmt.Errorf("%s requires podman %s or later in the podman machine, it runs podman %s", feature, required, p.podman().ServiceVersion(p.Ctx))
+}
+
+// applySpecCompat replaces the spec fields the podman service does not know
+// with compatible settings
+func (p *BootcDisk) applySpecCompat(labelNested **bool, selinuxOpts *[]string) {
+	if p.supports(featureLabelNested) {
+		return
+	}
+	logrus.Debugf("podman %s does not support %s, disabling the SELinux separation of the install container", p.podman().ServiceVersion(p.Ctx), featureLabelNested)
+	*labelNested = nil
+	*selinuxOpts = append(*selinuxOpts, "disable")
+}
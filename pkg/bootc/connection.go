@@ -0,0 +1,46 @@
+package bootc
+
+import (
+	"context"
+	"net"
+	"net/url"
+
+	"github.com/containers/podman/v5/pkg/bindings"
+)
+
+// serviceIsLocal reports if the podman service of ctx sees the files of this
+// host at the same paths, which the bind mount of the cache directory at
+// /output relies on. A podman machine mounts the home directory and listens
+// on a local socket or a forwarded local port.
+func serviceIsLocal(ctx context.Context) bool {
+	conn, err := bindings.GetClient(ctx)
+	if err != nil || conn.URI == nil {
+		// Not a bindings connection, e.g. in the tests
+		return true
+	}
+	return uriIsLocal(conn.URI)
+}
+
+// uriIsLocal reports if the podman service URI is on this host
+func uriIsLocal(uri *url.URL) bool {
+	switch uri.Scheme {
+	case "unix":
+		return true
+	case "ssh", "tcp":
+		host := uri.Hostname()
+		if host == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	}
+	return false
+}
+
+// serviceURI returns the URI of the podman service of ctx, for error messages
+func serviceURI(ctx context.Context) string {
+	if conn, err := bindings.GetClient(ctx); err == nil && conn.URI != nil {
+		return conn.URI.Redacted()
+	}
+	return "unknown"
+}
@@ -0,0 +1,203 @@
+package bootc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+	"gitlab.com/bootc-org/podman-bootc/pkg/qcow2"
+
+	"github.com/docker/go-units"
+	"github.com/sirupsen/logrus"
+)
+
+// maxDataDisks is the number of data disks fitting in the virtio devices
+// vdb to vdz after the disk image
+const maxDataDisks = 25
+
+// DataDisk is an empty disk created by run --data-disk next to the disk
+// image of a cache entry. Its content belongs to the VM: it outlives the
+// rebuilds of the disk image and is removed with the cache entry.
+type DataDisk struct {
+	// Name is the file of the disk in the cache entry
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Format string `json:"format"`
+}
+
+// ParseDataDiskSize parses the size of a --data-disk, e.g. 20G
+func ParseDataDiskSize(value string) (int64, error) {
+	size, err := units.FromHumanSize(value)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid data disk size %q", value)
+	}
+	return align(size, 4096), nil
+}
+
+// ReadDataDisks returns the data disks of the cache entry in dir, none when
+// it has no data disk metadata
+func ReadDataDisks(dir string) ([]DataDisk, error) {
+	buf, err := os.ReadFile(filepath.Join(dir, config.DataDisksFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var disks []DataDisk
+	if err := json.Unmarshal(buf, &disks); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", config.DataDisksFile, err)
+	}
+	return disks, nil
+}
+
+func writeDataDisks(dir string, disks []DataDisk) error {
+	path := filepath.Join(dir, config.DataDisksFile)
+	if len(disks) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	buf, err := json.Marshal(disks)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf, 0o644)
+}
+
+// PrepareDataDisks returns the data disks of the cache entry in dir, the
+// existing ones with their content and new ones of the missing sizes in the
+// given format. A data disk is only grown when its size is increased, it is
+// never shrunk nor converted to another format, and the existing data disks
+// are kept when fewer are requested. remove removes the existing data disks
+// and their content first.
+func PrepareDataDisks(dir string, sizes []string, format string, remove bool) ([]DataDisk, error) {
+	existing, err := ReadDataDisks(dir)
+	if err != nil {
+		return nil, err
+	}
+	if remove {
+		if err := RemoveDataDisks(dir); err != nil {
+			return nil, err
+		}
+		existing = nil
+	}
+	if len(sizes) > maxDataDisks || len(existing) > maxDataDisks {
+		return nil, fmt.Errorf("too many data disks, at most %d are supported", maxDataDisks)
+	}
+
+	var disks []DataDisk
+	for i, value := range sizes {
+		size, err := ParseDataDiskSize(value)
+		if err != nil {
+			return nil, err
+		}
+		if i >= len(existing) {
+			disk := DataDisk{Name: fmt.Sprintf("%s%d.%s", config.DataDiskPrefix, i+1, format), Size: size, Format: format}
+			if err := createDataDisk(filepath.Join(dir, disk.Name), disk); err != nil {
+				return nil, err
+			}
+			disks = append(disks, disk)
+			continue
+		}
+
+		disk := existing[i]
+		switch {
+		case disk.Format != format:
+			return nil, fmt.Errorf("the data disk %d is %s and the disk image %s, remove the data disks with --remove-data-disks to recreate them", i+1, disk.Format, format)
+		case size < disk.Size:
+			return nil, fmt.Errorf("the data disk %d is larger than %s, shrinking it would lose its content, remove the data disks with --remove-data-disks to recreate them", i+1, value)
+		}
+		path := filepath.Join(dir, disk.Name)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			logrus.Warnf("The data disk %d is missing, creating an empty one", i+1)
+			disk.Size = size
+			if err := createDataDisk(path, disk); err != nil {
+				return nil, err
+			}
+		} else if err != nil {
+			return nil, err
+		} else if size > disk.Size {
+			logrus.Infof("growing the data disk %d to %s", i+1, units.HumanSize(float64(size)))
+			if err := resizeDataDisk(path, disk.Format, size); err != nil {
+				return nil, err
+			}
+			disk.Size = size
+		}
+		disks = append(disks, disk)
+	}
+	if len(existing) > len(sizes) {
+		disks = append(disks, existing[len(sizes):]...)
+	}
+
+	if err := writeDataDisks(dir, disks); err != nil {
+		return nil, err
+	}
+	return disks, nil
+}
+
+// RemoveDataDisks removes the data disks of the cache entry in dir with
+// their content
+func RemoveDataDisks(dir string) error {
+	disks, err := ReadDataDisks(dir)
+	if err != nil {
+		return err
+	}
+	for _, disk := range disks {
+		if err := os.Remove(filepath.Join(dir, disk.Name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return writeDataDisks(dir, nil)
+}
+
+// resizeDataDisk grows the data disk at path to size, keeping its content
+func resizeDataDisk(path, format string, size int64) error {
+	if format == FormatQcow2 {
+		qemuImg, err := qcow2.RequireQemuImg("resizing a qcow2 data disk")
+		if err != nil {
+			return err
+		}
+		out, err := exec.Command(qemuImg, "resize", "-q", "-f", "qcow2", path, fmt.Sprint(size)).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("resizing the data disk %s: %w: %s", path, err, out)
+		}
+		return nil
+	}
+	if err := os.Truncate(path, size); err != nil {
+		return fmt.Errorf("resizing the data disk %s: %w", path, err)
+	}
+	return nil
+}
+
+// createDataDisk creates the empty data disk at path, a sparse raw file or
+// a qcow2 image created by qemu-img
+func createDataDisk(path string, disk DataDisk) error {
+	logrus.Debugf("creating the data disk %s of %s", path, units.HumanSize(float64(disk.Size)))
+	if disk.Format == FormatQcow2 {
+		qemuImg, err := qcow2.RequireQemuImg("a qcow2 data disk")
+		if err != nil {
+			return err
+		}
+		out, err := exec.Command(qemuImg, "create", "-q", "-f", "qcow2", path, fmt.Sprint(disk.Size)).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("creating the data disk %s: %w: %s", path, err, out)
+		}
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("creating the data disk: %w", err)
+	}
+	if err := f.Truncate(disk.Size); err != nil {
+		f.Close()
+		os.Remove(path)
+		return fmt.Errorf("creating the data disk %s: %w", path, err)
+	}
+	return f.Close()
+}
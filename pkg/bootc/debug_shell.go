@@ -0,0 +1,128 @@
+package bootc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+
+	"github.com/containers/podman/v5/pkg/bindings/containers"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/term"
+)
+
+// shellQuote quotes the arguments to be pasted in a shell
+func shellQuote(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+/.:,@") == "" {
+			quoted = append(quoted, arg)
+		} else {
+			quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// debugShellScript starts bash when the image has it, sh otherwise
+const debugShellScript = `if command -v bash >/dev/null; then
+	exec bash
+fi
+exec sh
+`
+
+// DebugShell starts an interactive shell in a container configured like the
+// install container, with the temporary disk attached. The temporary disk is
+// kept when the shell exits.
+func (p *BootcDisk) DebugShell(config DiskImageConfig) (err error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return errors.New("the debug shell requires a terminal")
+	}
+
+	if err := p.pullImage("missing", config); err != nil {
+		return err
+	}
+	p.losetupDirectIO = config.LosetupDirectIO
+
+	p.Directory = p.User.ImageCacheDir(p.ImageId)
+	lock := utils.NewCacheLock(p.User.RunDir(), p.Directory)
+	locked, err := lock.TryLock(utils.Exclusive)
+	if err != nil {
+		return fmt.Errorf("error locking the VM cache path: %w", err)
+	}
+	if !locked {
+		return fmt.Errorf("the disk image of %s is in use", p.RepoTag)
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			logrus.Errorf("unable to unlock VM %s: %v", p.ImageId, err)
+		}
+	}()
+
+	if err := os.MkdirAll(p.Directory, os.ModePerm); err != nil {
+		return fmt.Errorf("error while making bootc disk directory: %w", err)
+	}
+	if config.InstallerImage != "" {
+		if err := p.pullInstallerImage(config.InstallerImage); err != nil {
+			return err
+		}
+	}
+
+	estimate, err := p.estimateDiskSize(config)
+	if err != nil {
+		return err
+	}
+	if err := checkFileSizeLimit(p.Directory, estimate.size); err != nil {
+		return err
+	}
+	p.file, err = p.createTempDisk()
+	if err != nil {
+		return err
+	}
+	defer p.file.Close()
+	if err := syscall.Ftruncate(int(p.file.Fd()), estimate.size); err != nil {
+		return err
+	}
+
+	losetupTemp, err := p.writeLosetupWrapper()
+	if err != nil {
+		return err
+	}
+	defer removeLosetupWrapper(losetupTemp)
+
+	s := p.installContainerSpec(p.installImage(), []string{"sh", "-c", debugShellScript}, losetupTemp)
+	stdin := true
+	s.Stdin = &stdin
+	createResponse, err := p.podman().CreateContainer(p.Ctx, s, &containers.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+	p.bootcInstallContainerId = createResponse.ID //save the id for possible cleanup
+
+	fmt.Printf("Starting a shell in the install environment, the install would run:\n  %s\n", shellQuote(p.installCommand(config)))
+	if err := p.podman().StartContainer(p.Ctx, createResponse.ID, &containers.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start container: %w", err)
+	}
+
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return fmt.Errorf("setting the terminal in raw mode: %w", err)
+	}
+	attachOpts := new(containers.AttachOptions).WithStream(true)
+	err = p.podman().AttachContainer(p.Ctx, createResponse.ID, os.Stdin, os.Stdout, os.Stderr, nil, attachOpts)
+	if err := term.Restore(int(os.Stdin.Fd()), state); err != nil {
+		logrus.Warnf("unable to restore the terminal: %v", err)
+	}
+	if err != nil {
+		return fmt.Errorf("attaching: %w", err)
+	}
+	if _, err := p.podman().WaitContainer(p.Ctx, createResponse.ID, nil); err != nil {
+		return fmt.Errorf("failed to wait for container: %w", err)
+	}
+
+	fmt.Printf("The temporary disk is kept at %s\n", p.file.Name())
+	return nil
+}
@@ -0,0 +1,82 @@
+package bootc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// diagnoseScript reports the kernel features and the loop devices of the
+// podman machine, separated by a line of dashes
+const diagnoseScript = kernelFeaturesScript + "echo ---\n" + loopDevicesScript
+
+// diagnoseInstall inspects the podman machine after the install container
+// failed, it returns the fatal kernel problems and the loop devices
+func (p *BootcDisk) diagnoseInstall() ([]string, loopUsage, error) {
+	output, err := p.runHelperContainer(p.installImage(), []string{"sh", "-c", diagnoseScript})
+	if err != nil {
+		return nil, loopUsage{}, err
+	}
+	kernel, loop, ok := strings.Cut(strings.ReplaceAll(output, "\r\n", "\n"), "\n---\n")
+	if !ok {
+		return nil, loopUsage{}, fmt.Errorf("unexpected diagnosis %q", output)
+	}
+	features, err := parseKernelFeatures(kernel)
+	if err != nil {
+		return nil, loopUsage{}, err
+	}
+	usage, err := parseLoopUsage(loop)
+	if err != nil {
+		return nil, loopUsage{}, err
+	}
+	logrus.Debugf("kernel features: %v", features.values)
+	logrus.Debugf("loop devices: max=%d total=%d used=%d", usage.max, usage.total, usage.used)
+
+	problems, warnings := evaluateKernel(features, kernelRequirements)
+	for _, w := range warnings {
+		logrus.Warnf("%s", w)
+	}
+	return problems, usage, nil
+}
+
+// runDiagnosedInstall runs the install with run. The podman machine is only
+// checked once the install container failed: the checks pass on nearly
+// every machine and would cost a container per build. When the machine
+// lacks free loop devices, the install runs once more after other users
+// released theirs, waiting up to wait, with prepareRetry called first.
+func (p *BootcDisk) runDiagnosedInstall(wait time.Duration, run, prepareRetry func() error) error {
+	err := run()
+	if err == nil || !errors.Is(err, errInstallExited) || p.Ctx.Err() != nil {
+		return err
+	}
+	// The failed install container stays the one cleaned up or kept
+	installContainerId := p.bootcInstallContainerId
+	problems, usage, diagErr := p.diagnoseInstall()
+	p.bootcInstallContainerId = installContainerId
+	if diagErr != nil {
+		logrus.Warnf("unable to diagnose the install failure: %v", diagErr)
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w, the kernel of the podman machine does not support the install:\n  %s", err, strings.Join(problems, "\n  "))
+	}
+	if !usage.short() {
+		return err
+	}
+	waitErr := p.waitForLoopDevices(usage, wait)
+	p.bootcInstallContainerId = installContainerId
+	if waitErr != nil {
+		return fmt.Errorf("%w, %v", err, waitErr)
+	}
+
+	logrus.Warnf("the install failed without free loop devices, retrying now that they were released")
+	if prepareRetry != nil {
+		if err := prepareRetry(); err != nil {
+			return err
+		}
+	}
+	return run()
+}
@@ -0,0 +1,108 @@
+package bootc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// digestPin is the content of a digest file, it pins the image a reference
+// resolved to for the following commands of a pipeline
+type digestPin struct {
+	// Reference is the image reference as given by the user
+	Reference string `json:"reference"`
+	// ImageId is the id of the image the reference resolved to
+	ImageId string `json:"imageId"`
+	// ImageRef is the reference pinned by digest, or the tag of local images
+	ImageRef string `json:"imageRef"`
+}
+
+// pinned returns the reference pulling the pinned image
+func (pin *digestPin) pinned() string {
+	if strings.Contains(pin.ImageRef, "@") {
+		return pin.ImageRef
+	}
+	return pin.ImageId
+}
+
+// readDigestPin reads the digest file, it returns nil if it doesn't exist yet
+func readDigestPin(path string) (*digestPin, error) {
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pin digestPin
+	if err := json.Unmarshal(buf, &pin); err != nil {
+		return nil, fmt.Errorf("invalid digest file %s: %w", path, err)
+	}
+	if pin.ImageId == "" {
+		return nil, fmt.Errorf("invalid digest file %s: no image id", path)
+	}
+	return &pin, nil
+}
+
+// writeDigestPin creates the digest file unless another command created it
+// first, in which case the image it pins must be the same
+func writeDigestPin(path string, pin digestPin) error {
+	buf, err := json.Marshal(pin)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(buf, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// Linking fails instead of replacing a digest file written concurrently
+	if err := os.Link(tmp.Name(), path); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		current, err := readDigestPin(path)
+		if err != nil {
+			return err
+		}
+		if current != nil && current.ImageId != pin.ImageId {
+			return fmt.Errorf("%s was pinned to %s by a concurrent command while resolving it to %s", pin.Reference, current.ImageRef, pin.ImageRef)
+		}
+	}
+	return nil
+}
+
+// applyDigestPin replaces the image reference with the image pinned in the
+// digest file, if any. It returns the pin to check the pulled image against.
+func (p *BootcDisk) applyDigestPin(digestFile string) (*digestPin, error) {
+	if digestFile == "" {
+		return nil, nil
+	}
+	pin, err := readDigestPin(digestFile)
+	if err != nil || pin == nil {
+		return nil, err
+	}
+	if p.ImageNameOrId == pin.pinned() {
+		// already pinned by a previous pull, e.g. when repairing the image
+		return pin, nil
+	}
+	if pin.Reference != p.ImageNameOrId {
+		return nil, fmt.Errorf("the digest file %s pins %s, not %s", digestFile, pin.Reference, p.ImageNameOrId)
+	}
+	logrus.Infof("using %s pinned in %s", pin.ImageRef, digestFile)
+	p.ImageNameOrId = pin.pinned()
+	return pin, nil
+}
@@ -0,0 +1,102 @@
+package bootc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/owner"
+)
+
+// diskPermissions are the mode of --disk-mode and the owner of --disk-owner
+// given to the disk image, unset ones keep what the umask and the user of
+// the process give it
+type diskPermissions struct {
+	mode      *fs.FileMode
+	owner     *owner.Owner
+	ownerSpec string
+}
+
+// parseDiskMode parses octal permissions, e.g. 0640
+func parseDiskMode(value string) (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid disk mode %q, use octal permissions, e.g. 0640", value)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// diskPermissions resolves --disk-mode and --disk-owner. Only root may give
+// the disk image to another user, the check fails before the build instead
+// of after it.
+func (c DiskImageConfig) diskPermissions() (diskPermissions, error) {
+	var perms diskPermissions
+	if c.DiskMode != "" {
+		mode, err := parseDiskMode(c.DiskMode)
+		if err != nil {
+			return perms, err
+		}
+		perms.mode = &mode
+	}
+	if c.DiskOwner != "" {
+		o, err := owner.Parse(c.DiskOwner)
+		if err != nil {
+			return perms, fmt.Errorf("invalid disk owner: %w", err)
+		}
+		if euid := os.Geteuid(); euid != 0 && o.Uid != euid {
+			return perms, fmt.Errorf("giving the disk image to %s requires root privileges", c.DiskOwner)
+		}
+		perms.owner, perms.ownerSpec = o, c.DiskOwner
+	}
+	return perms, nil
+}
+
+// apply gives the disk image f the mode and the owner which differ from
+// them, and returns what it changed. The mode is set first, the process may
+// no longer own the disk image afterwards.
+func (perms diskPermissions) apply(f *os.File) ([]string, error) {
+	if perms.mode == nil && perms.owner == nil {
+		return nil, nil
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	if perms.mode != nil && st.Mode().Perm() != *perms.mode {
+		if err := f.Chmod(*perms.mode); err != nil {
+			return nil, fmt.Errorf("changing the mode of the disk image to %#o: %w", *perms.mode, err)
+		}
+		changed = append(changed, "mode")
+	}
+	if perms.owner != nil {
+		sys, ok := st.Sys().(*syscall.Stat_t)
+		if !ok || int(sys.Uid) != perms.owner.Uid || int(sys.Gid) != perms.owner.Gid {
+			if err := f.Chown(perms.owner.Uid, perms.owner.Gid); err != nil {
+				if errors.Is(err, fs.ErrPermission) {
+					return nil, fmt.Errorf("giving the disk image to %s requires root privileges, or membership of the group: %w", perms.ownerSpec, err)
+				}
+				return nil, fmt.Errorf("changing the owner of the disk image to %s: %w", perms.ownerSpec, err)
+			}
+			changed = append(changed, "owner")
+		}
+	}
+	return changed, nil
+}
+
+// fixCachedDiskPermissions gives the cached disk image f the permissions of
+// the options when they drifted, e.g. after a manual chmod
+func (p *BootcDisk) fixCachedDiskPermissions(f *os.File) error {
+	changed, err := p.diskPermissions.apply(f)
+	if err != nil {
+		return err
+	}
+	if len(changed) > 0 {
+		p.progressf("Restored the %s of the cached disk", strings.Join(changed, " and "))
+	}
+	return nil
+}
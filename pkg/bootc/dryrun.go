@@ -0,0 +1,269 @@
+package bootc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+
+	"github.com/docker/go-units"
+)
+
+// DryRunMount is a bind mount of the install container
+type DryRunMount struct {
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// The sources of the effective disk image options
+const (
+	ConfigFromDefault = "default"
+	ConfigFromFlag    = "flag"
+	ConfigFromEnv     = "env"
+	ConfigFromLabel   = "label"
+)
+
+// ConfigOption is an effective disk image option and where it comes from,
+// one of the ConfigFrom sources
+type ConfigOption struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	// Label is the image label of ConfigFromLabel
+	Label string `json:"label,omitempty"`
+}
+
+// Origin describes the source of the option, with its label
+func (o ConfigOption) Origin() string {
+	if o.Label != "" {
+		return o.Source + " " + o.Label
+	}
+	return o.Source
+}
+
+// ResolveConfig returns options with the ones left to their default which
+// the labels of the image set, the way Install merges them: the filesystem,
+// the maximum root size and the disk size. The image is pulled if missing.
+func (p *BootcDisk) ResolveConfig(config DiskImageConfig, options []ConfigOption) ([]ConfigOption, error) {
+	if err := p.prepareConfig(&config); err != nil {
+		return nil, err
+	}
+	if p.imageData == nil {
+		if err := p.pullImage("missing", config); err != nil {
+			return nil, err
+		}
+	}
+	labeled := config
+	p.applyLabelDefaults(&labeled)
+	labels := map[string]ConfigOption{}
+	if labeled.Filesystem != config.Filesystem {
+		labels["filesystem"] = ConfigOption{Value: labeled.Filesystem, Label: filesystemLabel}
+	}
+	if labeled.RootSizeMax != config.RootSizeMax {
+		labels["root-size-max"] = ConfigOption{Value: labeled.RootSizeMax, Label: rootSizeLabel}
+	}
+	estimate, err := p.estimateDiskSize(labeled)
+	if err != nil {
+		return nil, err
+	}
+	if estimate.source == DiskSizeFromLabel {
+		labels["disk-size"] = ConfigOption{Value: strings.TrimSpace(p.imageData.Labels[estimate.label]), Label: estimate.label}
+	}
+
+	resolved := make([]ConfigOption, 0, len(options))
+	for _, o := range options {
+		if l, ok := labels[o.Name]; ok && o.Source == ConfigFromDefault {
+			o.Value, o.Source, o.Label = l.Value, ConfigFromLabel, l.Label
+		}
+		resolved = append(resolved, o)
+	}
+	return resolved, nil
+}
+
+// DryRunPlan is what Install would do for an image, computed without
+// creating the temporary disk image or the install container. The random
+// part of the names of the temporary files is shown as *.
+type DryRunPlan struct {
+	Image     string `json:"image"`
+	ImageId   string `json:"imageId"`
+	Directory string `json:"directory"`
+	// Reuse reports if the cached disk image would be used as is, Reason
+	// is the progress update of the cache lookup
+	Reuse  bool   `json:"reuse"`
+	Reason string `json:"reason"`
+
+	ContainerSize int64 `json:"containerSize"`
+	DiskSize      int64 `json:"diskSize"`
+	// DiskSizeSource is DiskSizeFromHeuristic, DiskSizeFromLabel,
+	// DiskSizeFromFlag or DiskSizeFromMinimum, DiskSizeLabel is the label
+	// of DiskSizeFromLabel
+	DiskSizeSource string `json:"diskSizeSource"`
+	DiskSizeLabel  string `json:"diskSizeLabel,omitempty"`
+	BootcVersion   string `json:"bootcVersion"`
+	// InstallImage is the image of the install container, empty with the
+	// host backend
+	InstallImage string        `json:"installImage,omitempty"`
+	Command      []string      `json:"command"`
+	Mounts       []DryRunMount `json:"mounts,omitempty"`
+	// Config are the disk image options and their sources, filled in by
+	// the caller parsing them
+	Config []ConfigOption `json:"config,omitempty"`
+}
+
+// DryRun pulls the image and computes the plan of Install with config: the
+// cache lookup, the disk size, the bootc install command and the mounts of
+// the install container. Only the container of bootc --version runs.
+func (p *BootcDisk) DryRun(config DiskImageConfig) (*DryRunPlan, error) {
+	if err := p.prepareConfig(&config); err != nil {
+		return nil, err
+	}
+	if err := p.pullImage("missing", config); err != nil {
+		return nil, err
+	}
+	p.applyLabelDefaults(&config)
+	p.Directory = p.User.ImageCacheDir(p.ImageId)
+	if config.InstallerImage != "" {
+		if err := p.pullInstallerImage(config.InstallerImage); err != nil {
+			return nil, err
+		}
+	}
+
+	plan := &DryRunPlan{Image: p.RepoTag, ImageId: p.ImageId, Directory: p.Directory}
+	var err error
+	if plan.Reuse, plan.Reason, err = p.cacheLookup(config); err != nil {
+		return nil, err
+	}
+
+	estimate, err := p.estimateDiskSize(config)
+	if err != nil {
+		return nil, err
+	}
+	plan.ContainerSize, plan.DiskSize = estimate.containerSize, estimate.size
+	plan.DiskSizeSource, plan.DiskSizeLabel = estimate.source, estimate.label
+
+	if p.bootcVersion == "" && !config.usesHostBackend() {
+		// The helper container mounts the cache entry like the install
+		// container, a new one is removed again
+		if !exists(p.Directory) {
+			if err := os.MkdirAll(p.Directory, os.ModePerm); err != nil {
+				return nil, fmt.Errorf("error while making bootc disk directory: %w", err)
+			}
+			defer os.Remove(p.Directory)
+		}
+		p.bootcVersion = p.detectBootcVersion()
+	}
+	plan.BootcVersion = p.bootcVersion
+	tempDisk := filepath.Join(p.Directory, fmt.Sprintf("%s-%s-*", tempDiskPrefix, shortID(p.ImageId)))
+	plan.Command = p.installCommandFor(config, tempDisk)
+	if config.usesHostBackend() {
+		return plan, nil
+	}
+
+	plan.InstallImage = p.installImage()
+	var losetupTemp string
+	if p.needsLosetupWrapper() {
+		losetupTemp = filepath.Join(p.Directory, "losetup-wrapper*")
+	}
+	if len(config.FilesystemOptions) > 0 {
+		p.mkfsWrapper, p.mkfsOptions = filepath.Join(p.Directory, "mkfs-wrapper*"), config.FilesystemOptions
+		defer func() { p.mkfsWrapper, p.mkfsOptions = "", nil }()
+	}
+	for _, m := range p.installContainerSpec(plan.InstallImage, plan.Command, losetupTemp).Mounts {
+		plan.Mounts = append(plan.Mounts, DryRunMount{Source: m.Source, Destination: m.Destination, Options: m.Options})
+	}
+	return plan, nil
+}
+
+// cacheLookup reports if Install would use the cached disk image, with the
+// progress update explaining its decision, without changing the cache
+func (p *BootcDisk) cacheLookup(diskConfig DiskImageConfig) (bool, string, error) {
+	if diskConfig.installsToFilesystem() {
+		return false, fmt.Sprintf("Installing to %s, outside of the cache", diskConfig.InstallTarget), nil
+	}
+	if diskConfig.ForceRebuild {
+		return false, "Bypassing the cached disk, --force-rebuild is set", nil
+	}
+	diskPath := filepath.Join(p.Directory, config.DiskImage)
+	f, err := os.Open(diskPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, "No cached disk image found, building one", nil
+	} else if err != nil {
+		return false, "", err
+	}
+	defer f.Close()
+	meta, err := ReadDiskMeta(diskPath)
+	if err != nil {
+		return false, fmt.Sprintf("The cached disk has no usable metadata (%v), rebuilding", err), nil
+	}
+	if meta.ImageDigest != p.ImageId {
+		return false, "The cached disk is of another image, rebuilding", nil
+	}
+	created := diskCreated(f, meta)
+	if reason := cacheMissReason(meta, created, diskConfig); reason != "" {
+		return false, reason, nil
+	}
+	changes, err := externalChanges(f, meta)
+	if err != nil {
+		return false, "", err
+	}
+	if changes != "" {
+		if diskConfig.StrictCache {
+			return false, "", fmt.Errorf("the cached disk %s was modified externally (%s), remove it with podman-bootc rm to rebuild it", diskPath, changes)
+		}
+		return false, fmt.Sprintf("The cached disk was modified externally (%s), rebuilding", changes), nil
+	}
+	if diskConfig.CacheStrictness == CacheStrictnessStrict && meta.HostInputs != nil {
+		if diffs := meta.HostInputs.mismatches(p.hostInputs(diskConfig, meta.BootcVersion)); len(diffs) > 0 {
+			return false, fmt.Sprintf("The cached disk was built with different host inputs (%s), rebuilding", strings.Join(diffs, "; ")), nil
+		}
+	}
+	if v := meta.ContentVerification; v != nil && v.failed() && diskConfig.VerifyContent {
+		return false, "The cached disk failed its content verification, rebuilding", nil
+	}
+	return true, fmt.Sprintf("Using cached disk built %s ago", formatAge(time.Since(created))), nil
+}
+
+// Print writes the plan for humans
+func (plan *DryRunPlan) Print(w io.Writer) {
+	fmt.Fprintf(w, "Image:          %s (%s)\n", plan.Image, shortID(plan.ImageId))
+	fmt.Fprintf(w, "Cache entry:    %s\n", plan.Directory)
+	fmt.Fprintf(w, "Cached disk:    %s\n", plan.Reason)
+	source := plan.DiskSizeSource
+	if plan.DiskSizeLabel != "" {
+		source += " " + plan.DiskSizeLabel
+	}
+	fmt.Fprintf(w, "Disk size:      %s (container size %s, from the %s)\n", units.HumanSize(float64(plan.DiskSize)), units.HumanSize(float64(plan.ContainerSize)), source)
+	if plan.InstallImage == "" {
+		fmt.Fprintf(w, "Install:        on the host\n")
+	} else {
+		fmt.Fprintf(w, "Install image:  %s\n", plan.InstallImage)
+	}
+	if plan.BootcVersion != "" {
+		fmt.Fprintf(w, "bootc version:  %s\n", plan.BootcVersion)
+	}
+	fmt.Fprintf(w, "Command:        %s\n", shellQuote(plan.Command))
+	for i, m := range plan.Mounts {
+		label := ""
+		if i == 0 {
+			label = "Mounts:"
+		}
+		mount := m.Source + ":" + m.Destination
+		if len(m.Options) > 0 {
+			mount += ":" + strings.Join(m.Options, ",")
+		}
+		fmt.Fprintf(w, "%-15s %s\n", label, mount)
+	}
+	for i, o := range plan.Config {
+		label := ""
+		if i == 0 {
+			label = "Options:"
+		}
+		fmt.Fprintf(w, "%-15s %s=%s (%s)\n", label, o.Name, o.Value, o.Origin())
+	}
+}
@@ -0,0 +1,335 @@
+package bootc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/blang/semver/v4"
+	"github.com/containers/podman/v5/libpod/define"
+	"github.com/containers/podman/v5/pkg/bindings/containers"
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/containers/podman/v5/pkg/bindings/system"
+	"github.com/containers/podman/v5/pkg/domain/entities/reports"
+	"github.com/containers/podman/v5/pkg/domain/entities/types"
+	"github.com/containers/podman/v5/pkg/inspect"
+	"github.com/containers/podman/v5/pkg/specgen"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// fakePodman simulates a podman service with a single image, containers
+// exit immediately with exitCode after writing output
+type fakePodman struct {
+	mu       sync.Mutex
+	image    *types.ImageInspectReport
+	pulled   bool
+	exitCode int32
+	output   string
+	runTime  time.Duration
+	specs    []*specgen.SpecGenerator
+	removed  []string
+	pullErr  error
+	// pullFailures fail the next pulls in order, streaming pullStream first,
+	// which successful pulls stream too
+	pullFailures []error
+	pullStream   string
+	// installFailures are the outputs of the next install containers, which
+	// exit with 1
+	installFailures []string
+	failed          map[string]string
+	// helperOutput overrides the output of the containers it returns true for
+	helperOutput func(argv []string) (string, bool)
+	// mkfsFromPath simulates a bootc running the mkfs wrapper
+	mkfsFromPath bool
+	pulls        int
+	removedImg   int
+	apiVersion   *semver.Version
+	listed       []*types.ImageSummary
+	// graphRoot is the graph root of the service, unknown when empty
+	graphRoot   string
+	infoQueries int
+	// cancel is called by the first call of the phase cancelAt, for the
+	// install container for the container phases
+	cancelAt string
+	cancel   context.CancelFunc
+	// callsAfterCancel are the calls made on a cancelled context,
+	// cancelledRemovals the removals among them
+	callsAfterCancel  []string
+	cancelledRemovals []string
+	exited            []string
+}
+
+func newFakePodman() *fakePodman {
+	return &fakePodman{
+		graphRoot: defaultGraphRoot,
+		image: &types.ImageInspectReport{
+			ImageData: &inspect.ImageData{
+				ID:       testImageID,
+				RepoTags: []string{testRepoTag},
+				Size:     1024 * 1024 * 1024,
+				Labels:   map[string]string{},
+			},
+		},
+	}
+}
+
+// specArgv returns the command line of the container
+func specArgv(s *specgen.SpecGenerator) []string {
+	return append(append([]string{}, s.Entrypoint...), s.Command...)
+}
+
+// specMounts returns the mounts of the container
+func specMounts(s *specgen.SpecGenerator) []specs.Mount {
+	return s.Mounts
+}
+
+// isInstall reports if argv is the command of an install container, which
+// installs or upgrades the disk image
+func isInstall(argv []string) bool {
+	return len(argv) > 2 && (argv[0] == "bootc" && argv[1] == "install" || argv[2] == upgradeScript)
+}
+
+// enter records a call of phase, cancelling the build when it is the phase
+// of cancelAt. It returns the error of ctx, the calls on a cancelled context
+// fail like they would with the bindings.
+func (f *fakePodman) enter(ctx context.Context, phase string, install bool) error {
+	f.mu.Lock()
+	if ctx.Err() != nil {
+		f.callsAfterCancel = append(f.callsAfterCancel, phase)
+		f.mu.Unlock()
+		return ctx.Err()
+	}
+	cancel := f.cancel
+	if cancel == nil || phase != f.cancelAt || !install {
+		f.mu.Unlock()
+		return nil
+	}
+	f.cancel = nil
+	f.mu.Unlock()
+	cancel()
+	return ctx.Err()
+}
+
+// leakedContainers returns the containers created which neither exited
+// nor were removed
+func (f *fakePodman) leakedContainers() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	gone := map[string]bool{}
+	for _, id := range append(append([]string{}, f.exited...), f.removed...) {
+		gone[id] = true
+	}
+	var leaked []string
+	for i := range f.specs {
+		if id := fmt.Sprintf("fake-%d", i+1); !gone[id] {
+			leaked = append(leaked, id)
+		}
+	}
+	return leaked
+}
+
+// containersCreated returns the number of install containers created
+func (f *fakePodman) containersCreated() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	created := 0
+	for _, s := range f.specs {
+		if isInstall(specArgv(s)) {
+			created++
+		}
+	}
+	return created
+}
+
+func (f *fakePodman) ServiceVersion(_ context.Context) *semver.Version {
+	return f.apiVersion
+}
+
+func (f *fakePodman) SystemInfo(_ context.Context, _ *system.InfoOptions) (*define.Info, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.infoQueries++
+	if f.graphRoot == "" {
+		return nil, fmt.Errorf("no info")
+	}
+	return &define.Info{Store: &define.StoreInfo{GraphRoot: f.graphRoot}}, nil
+}
+
+func (f *fakePodman) PullImage(ctx context.Context, _ string, options *images.PullOptions) ([]string, error) {
+	if err := f.enter(ctx, "pull", true); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pulls++
+	if len(f.pullFailures) > 0 {
+		err := f.pullFailures[0]
+		f.pullFailures = f.pullFailures[1:]
+		if w := options.GetProgressWriter(); w != nil {
+			fmt.Fprint(w, f.pullStream)
+		}
+		return nil, err
+	}
+	if f.pullErr != nil {
+		return nil, f.pullErr
+	}
+	if w := options.GetProgressWriter(); w != nil {
+		fmt.Fprint(w, f.pullStream)
+	}
+	f.pulled = true
+	return []string{f.image.ID}, nil
+}
+
+func (f *fakePodman) ImageExists(_ context.Context, _ string, _ *images.ExistsOptions) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pulled, nil
+}
+
+func (f *fakePodman) GetImage(ctx context.Context, _ string, _ *images.GetOptions) (*types.ImageInspectReport, error) {
+	if err := f.enter(ctx, "get-image", true); err != nil {
+		return nil, err
+	}
+	return f.image, nil
+}
+
+func (f *fakePodman) ListImages(_ context.Context, _ *images.ListOptions) ([]*types.ImageSummary, error) {
+	return f.listed, nil
+}
+
+func (f *fakePodman) RemoveImage(_ context.Context, _ []string, _ *images.RemoveOptions) (*types.ImageRemoveReport, []error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pulled = false
+	f.removedImg++
+	return &types.ImageRemoveReport{}, nil
+}
+
+func (f *fakePodman) TagImage(_ context.Context, _, _, _ string, _ *images.TagOptions) error {
+	return nil
+}
+
+func (f *fakePodman) UntagImage(_ context.Context, _, _, _ string, _ *images.UntagOptions) error {
+	return nil
+}
+
+func (f *fakePodman) CreateContainer(ctx context.Context, s *specgen.SpecGenerator, _ *containers.CreateOptions) (types.ContainerCreateResponse, error) {
+	if err := f.enter(ctx, "create", isInstall(specArgv(s))); err != nil {
+		return types.ContainerCreateResponse{}, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.specs = append(f.specs, s)
+	id := fmt.Sprintf("fake-%d", len(f.specs))
+	if isInstall(specArgv(s)) && len(f.installFailures) > 0 {
+		if f.failed == nil {
+			f.failed = map[string]string{}
+		}
+		f.failed[id] = f.installFailures[0]
+		f.installFailures = f.installFailures[1:]
+		return types.ContainerCreateResponse{ID: id}, nil
+	}
+	if argv := specArgv(s); len(argv) == 6 && argv[2] == convertScript && f.exitCode == 0 {
+		f.convert(s, argv[5])
+	}
+	if applied, ok := s.Env["PODMAN_BOOTC_MKFS_APPLIED"]; ok && f.mkfsFromPath && f.exitCode == 0 {
+		f.runMkfsWrapper(s, applied)
+	}
+	return types.ContainerCreateResponse{ID: id}, nil
+}
+
+// convert simulates qemu-img convert writing the qcow2 disk image
+func (f *fakePodman) convert(s *specgen.SpecGenerator, target string) {
+	for _, m := range s.Mounts {
+		if m.Destination == "/output" {
+			_ = os.WriteFile(filepath.Join(m.Source, filepath.Base(target)), []byte("QFI\xfb\x00\x00\x00\x03"), 0o644)
+		}
+	}
+}
+
+// runMkfsWrapper simulates the mkfs wrapper creating the file applied
+func (f *fakePodman) runMkfsWrapper(s *specgen.SpecGenerator, applied string) {
+	for _, m := range s.Mounts {
+		if m.Destination == "/output" {
+			_ = os.WriteFile(filepath.Join(m.Source, filepath.Base(applied)), nil, 0o644)
+		}
+	}
+}
+
+func (f *fakePodman) StartContainer(ctx context.Context, id string, _ *containers.StartOptions) error {
+	return f.enter(ctx, "start", f.isInstallContainer(id))
+}
+
+// isInstallContainer reports if id is an install container
+func (f *fakePodman) isInstallContainer(id string) bool {
+	s := f.spec(id)
+	return s != nil && isInstall(specArgv(s))
+}
+
+func (f *fakePodman) AttachContainer(ctx context.Context, id string, _ io.Reader, stdout io.Writer, _ io.Writer, _ chan bool, _ *containers.AttachOptions) error {
+	if err := f.enter(ctx, "attach", f.isInstallContainer(id)); err != nil {
+		return err
+	}
+	if stdout == nil {
+		return nil
+	}
+	output := f.output
+	f.mu.Lock()
+	failedOutput, failed := f.failed[id]
+	f.mu.Unlock()
+	if failed {
+		output = failedOutput
+	} else if f.helperOutput != nil {
+		if s := f.spec(id); s != nil {
+			if out, ok := f.helperOutput(specArgv(s)); ok {
+				output = out
+			}
+		}
+	}
+	_, _ = io.WriteString(stdout, output)
+	return nil
+}
+
+// spec returns the spec of the container id
+func (f *fakePodman) spec(id string) *specgen.SpecGenerator {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int
+	if _, err := fmt.Sscanf(id, "fake-%d", &n); err != nil || n < 1 || n > len(f.specs) {
+		return nil
+	}
+	return f.specs[n-1]
+}
+
+func (f *fakePodman) WaitContainer(ctx context.Context, id string, _ *containers.WaitOptions) (int32, error) {
+	if err := f.enter(ctx, "wait", f.isInstallContainer(id)); err != nil {
+		return -1, err
+	}
+	select {
+	case <-time.After(f.runTime):
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.exited = append(f.exited, id)
+	if _, failed := f.failed[id]; failed {
+		return 1, nil
+	}
+	return f.exitCode, nil
+}
+
+func (f *fakePodman) RemoveContainer(ctx context.Context, id string, _ *containers.RemoveOptions) ([]*reports.RmReport, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if ctx.Err() != nil {
+		f.cancelledRemovals = append(f.cancelledRemovals, id)
+		return nil, ctx.Err()
+	}
+	f.removed = append(f.removed, id)
+	return []*reports.RmReport{{Id: id}}, nil
+}
@@ -0,0 +1,114 @@
+package bootc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// fingerprintBlock is the size of the blocks hashed at both ends of the
+// disk image, the GPT headers and the start of the first partitions are
+// written by any tool touching the disk
+const fingerprintBlock = 1024 * 1024
+
+// DiskFingerprint identifies the contents of a cached disk image when it was
+// promoted, cheaply enough to be checked on every cache hit
+type DiskFingerprint struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// Head and Tail are the sha256 of the first and last MiB
+	Head string `json:"head"`
+	Tail string `json:"tail"`
+}
+
+// fingerprintDisk returns the fingerprint of the disk image f
+func fingerprintDisk(f *os.File) (*DiskFingerprint, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	fp := &DiskFingerprint{Size: st.Size(), ModTime: st.ModTime().UTC()}
+	if fp.Head, err = hashBlock(f, 0); err != nil {
+		return nil, err
+	}
+	if fp.Tail, err = hashBlock(f, st.Size()-fingerprintBlock); err != nil {
+		return nil, err
+	}
+	return fp, nil
+}
+
+// SetFingerprint records the fingerprint of the disk image at diskPath, for
+// the callers adding a disk image to the cache
+func (m *DiskMeta) SetFingerprint(diskPath string) error {
+	f, err := os.Open(diskPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if m.Fingerprint, err = fingerprintDisk(f); err != nil {
+		return fmt.Errorf("fingerprinting %s: %w", diskPath, err)
+	}
+	return nil
+}
+
+// hashBlock returns the sha256 of the block at offset, clamped to the file
+func hashBlock(f *os.File, offset int64) (string, error) {
+	if offset < 0 {
+		offset = 0
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, offset, fingerprintBlock)); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// changes describes how the disk image differs from the fingerprint, empty
+// when it is unchanged
+func (fp *DiskFingerprint) changes(current *DiskFingerprint) string {
+	var changes []string
+	if current.Size != fp.Size {
+		changes = append(changes, fmt.Sprintf("size %d, expected %d", current.Size, fp.Size))
+	}
+	if !current.ModTime.Equal(fp.ModTime) {
+		changes = append(changes, fmt.Sprintf("modified at %s, promoted at %s", current.ModTime.Format(time.RFC3339), fp.ModTime.Format(time.RFC3339)))
+	}
+	if current.Head != fp.Head || current.Tail != fp.Tail {
+		changes = append(changes, "contents changed")
+	}
+	return strings.Join(changes, ", ")
+}
+
+// MarkModified records in the metadata of the disk image at diskPath that
+// it was written by how, e.g. "written over NBD", for the writers the
+// fingerprint cannot catch
+func MarkModified(diskPath, how string) error {
+	meta, err := ReadDiskMeta(diskPath)
+	if err != nil {
+		return err
+	}
+	meta.Modified = how
+	return WriteDiskMeta(diskPath, meta)
+}
+
+// externalChanges describes how the cached disk image f was modified since
+// it was promoted. Disks promoted before the fingerprint was recorded are
+// only checked for the modifications recorded by MarkModified.
+func externalChanges(f *os.File, meta *DiskMeta) (string, error) {
+	if meta.Modified != "" {
+		return meta.Modified, nil
+	}
+	if meta.Fingerprint == nil {
+		return "", nil
+	}
+	current, err := fingerprintDisk(f)
+	if err != nil {
+		return "", fmt.Errorf("fingerprinting %s: %w", f.Name(), err)
+	}
+	return meta.Fingerprint.changes(current), nil
+}
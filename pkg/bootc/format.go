@@ -0,0 +1,76 @@
+package bootc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/qcow2"
+)
+
+const (
+	// FormatRaw is a raw disk image, the format bootc install writes
+	FormatRaw = "raw"
+	// FormatQcow2 is a qcow2 disk image, converted from the raw one
+	FormatQcow2 = "qcow2"
+)
+
+// diskFormats are the supported formats of the disk image
+var diskFormats = []string{FormatRaw, FormatQcow2}
+
+// convertScript converts the raw disk image to qcow2, which bootc install
+// cannot write. Arguments: raw disk image, qcow2 disk image
+const convertScript = `set -eu
+if ! command -v qemu-img >/dev/null; then
+	echo "qemu-img is not installed in the install image, use --disk-format=raw or an installer image with qemu-img" 1>&2
+	exit 127
+fi
+qemu-img convert -f raw -O qcow2 "$1" "$2"
+`
+
+// diskFormat returns the format of the disk image to build
+func (c DiskImageConfig) diskFormat() string {
+	if c.Format == "" {
+		return FormatRaw
+	}
+	return c.Format
+}
+
+// DiskFormat returns the format of the disk image, raw for the disks built
+// before the format was recorded
+func (m *DiskMeta) DiskFormat() string {
+	if m.Format == "" {
+		return FormatRaw
+	}
+	return m.Format
+}
+
+// convertDisk replaces the raw temporary disk with a copy in format, keeping
+// its name. A temporary disk which is already converted, e.g. when resuming,
+// is kept.
+func (p *BootcDisk) convertDisk(format string) error {
+	if format == FormatRaw || qcow2.IsQcow2(p.file) {
+		return nil
+	}
+	tempPath := p.file.Name()
+	convertedPath := tempPath + "." + format
+	defer os.Remove(convertedPath)
+
+	p.progressf("Converting the disk image to %s", format)
+	command := []string{"sh", "-c", convertScript, "convert",
+		"/output/" + filepath.Base(tempPath), "/output/" + filepath.Base(convertedPath)}
+	if _, err := p.runHelperContainer(p.installImage(), command); err != nil {
+		return fmt.Errorf("converting the disk image to %s: %w", format, err)
+	}
+
+	if err := os.Rename(convertedPath, tempPath); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(tempPath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	p.file.Close()
+	p.file = f
+	return nil
+}
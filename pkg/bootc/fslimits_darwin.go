@@ -0,0 +1,24 @@
+package bootc
+
+import (
+	"math"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileSizeLimit returns the name of the filesystem holding directory and the
+// largest file it can hold, or math.MaxInt64 when it has no practical limit
+func fileSizeLimit(directory string) (string, int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(directory, &st); err != nil {
+		return "", 0, err
+	}
+
+	name := unix.ByteSliceToString(st.Fstypename[:])
+	switch name {
+	case "msdos":
+		return name, 4*1024*1024*1024 - 1, nil
+	default:
+		return name, math.MaxInt64, nil
+	}
+}
@@ -0,0 +1,32 @@
+package bootc
+
+import (
+	"math"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileSizeLimit returns the name of the filesystem holding directory and the
+// largest file it can hold, or math.MaxInt64 when it has no practical limit
+func fileSizeLimit(directory string) (string, int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(directory, &st); err != nil {
+		return "", 0, err
+	}
+
+	switch int64(st.Type) {
+	case unix.MSDOS_SUPER_MAGIC:
+		return "vfat", 4*1024*1024*1024 - 1, nil
+	case unix.EXT4_SUPER_MAGIC:
+		// 2^32 blocks per file with extents
+		return "ext4", int64(st.Bsize) << 32, nil
+	case unix.TMPFS_MAGIC:
+		return "tmpfs", math.MaxInt64, nil
+	case unix.XFS_SUPER_MAGIC:
+		return "xfs", math.MaxInt64, nil
+	case unix.BTRFS_SUPER_MAGIC:
+		return "btrfs", math.MaxInt64, nil
+	default:
+		return "", math.MaxInt64, nil
+	}
+}
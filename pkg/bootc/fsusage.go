@@ -0,0 +1,107 @@
+package bootc
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/docker/go-units"
+)
+
+// filesystemUsageScript mounts the filesystems of the disk read-only and
+// prints "<name> <block size> <blocks> <free blocks>" of each of them
+const filesystemUsageScript = `set -eu
+disk=$1
+mnt=/run/podman-bootc-target
+dev=$(losetup --show -frP "$disk")
+cleanup() {
+	umount -R "$mnt" || true
+	losetup -d "$dev"
+}
+trap cleanup EXIT
+udevadm settle || true
+root=$(lsblk -lnpo NAME,LABEL "$dev" | awk '$2 == "root" { print $1 }')
+boot=$(lsblk -lnpo NAME,LABEL "$dev" | awk '$2 == "boot" { print $1 }')
+if [ -z "$root" ]; then
+	echo "no root partition found on $disk" 1>&2
+	exit 1
+fi
+mkdir -p "$mnt"
+mount -o ro "$root" "$mnt"
+echo "root $(stat -f -c '%S %b %f' "$mnt")"
+if [ -n "$boot" ]; then
+	mount -o ro "$boot" "$mnt/boot"
+	echo "boot $(stat -f -c '%S %b %f' "$mnt/boot")"
+fi
+`
+
+// FilesystemUsage is the usage of a filesystem of the disk right after the install
+type FilesystemUsage struct {
+	Name  string `json:"name"`
+	Used  int64  `json:"used"`
+	Total int64  `json:"total"`
+}
+
+// parseFilesystemUsage parses the output of filesystemUsageScript
+func parseFilesystemUsage(output string) ([]FilesystemUsage, error) {
+	var usage []FilesystemUsage
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 {
+			continue
+		}
+		var values [3]int64
+		valid := true
+		for i, field := range fields[1:] {
+			v, err := strconv.ParseInt(field, 10, 64)
+			if err != nil || v < 0 {
+				valid = false
+				break
+			}
+			values[i] = v
+		}
+		if !valid || values[2] > values[1] {
+			continue
+		}
+		usage = append(usage, FilesystemUsage{
+			Name:  fields[0],
+			Used:  (values[1] - values[2]) * values[0],
+			Total: values[1] * values[0],
+		})
+	}
+	if len(usage) == 0 {
+		return nil, fmt.Errorf("unexpected filesystem usage output: %q", strings.TrimSpace(output))
+	}
+	return usage, nil
+}
+
+// filesystemUsage returns the usage of the filesystems of the temporary disk
+func (p *BootcDisk) filesystemUsage() ([]FilesystemUsage, error) {
+	command := []string{"sh", "-c", filesystemUsageScript, "fs-usage", "/output/" + filepath.Base(p.file.Name())}
+	output, err := p.runHelperContainer(p.installImage(), command)
+	if err != nil {
+		return nil, err
+	}
+	return parseFilesystemUsage(output)
+}
+
+// printFilesystemUsage prints the usage table of the install summary
+func (p *BootcDisk) printFilesystemUsage(usage []FilesystemUsage) {
+	if !p.verbosity.showInstallOutput() || len(usage) == 0 {
+		return
+	}
+	w := tabwriter.NewWriter(p.out(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILESYSTEM\tUSED\tSIZE\tUSE%")
+	for _, u := range usage {
+		percent := 0
+		if u.Total > 0 {
+			percent = int(u.Used * 100 / u.Total)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d%%\n", u.Name, units.HumanSize(float64(u.Used)), units.HumanSize(float64(u.Total)), percent)
+	}
+	w.Flush()
+}
@@ -0,0 +1,151 @@
+package bootc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+)
+
+// Generation is a cached disk image of a repository. Generations are
+// numbered in build order from 1 and keep their number when older ones are
+// removed, so a number always refers to the same disk.
+type Generation struct {
+	Number    int
+	Id        string
+	Directory string
+	Meta      *DiskMeta
+}
+
+// built returns when the generation was built, for disks without a number
+func (g Generation) built() time.Time {
+	if !g.Meta.Created.IsZero() {
+		return g.Meta.Created
+	}
+	if st, err := os.Stat(filepath.Join(g.Directory, config.DiskImage)); err == nil {
+		return st.ModTime()
+	}
+	return time.Time{}
+}
+
+// newer reports if g was built after other
+func (g Generation) newer(other Generation) bool {
+	if g.Number != other.Number {
+		return g.Number > other.Number
+	}
+	return g.built().After(other.built())
+}
+
+// ListGenerations returns the cached disk images of the repository, oldest first
+func ListGenerations(u user.User, repository string) ([]Generation, error) {
+	entries, err := os.ReadDir(u.CacheDir())
+	if err != nil {
+		return nil, err
+	}
+	var generations []Generation
+	for _, entry := range entries {
+		if !entry.IsDir() || len(entry.Name()) != 64 {
+			continue
+		}
+		dir := u.ImageCacheDir(entry.Name())
+		meta, err := ReadDiskMeta(filepath.Join(dir, config.DiskImage))
+		if err != nil || meta.Repository != repository {
+			continue
+		}
+		generations = append(generations, Generation{
+			Number:    meta.Generation,
+			Id:        entry.Name(),
+			Directory: dir,
+			Meta:      meta,
+		})
+	}
+	sort.SliceStable(generations, func(i, j int) bool {
+		return generations[j].newer(generations[i])
+	})
+	return generations, nil
+}
+
+// nextGeneration returns the number of the disk image built now
+func (p *BootcDisk) nextGeneration() int {
+	generations, err := ListGenerations(p.User, repositoryOf(p.RepoTag))
+	if err != nil {
+		return 1
+	}
+	next := 1
+	for _, g := range generations {
+		if g.Id != p.ImageId && g.Number >= next {
+			next = g.Number + 1
+		}
+	}
+	return next
+}
+
+// ResolveGeneration returns the cached disk image of the repository of image
+// selected by its generation number or a prefix of its id
+func ResolveGeneration(u user.User, image string, selector string) (Generation, error) {
+	repository := repositoryOf(image)
+	if repository == "" {
+		return Generation{}, fmt.Errorf("unable to parse the repository of %s", image)
+	}
+	generations, err := ListGenerations(u, repository)
+	if err != nil {
+		return Generation{}, err
+	}
+	if len(generations) == 0 {
+		return Generation{}, fmt.Errorf("no cached disk image of %s", repository)
+	}
+
+	number, err := strconv.Atoi(selector)
+	if err != nil {
+		id := strings.TrimPrefix(selector, "sha256:")
+		for _, g := range generations {
+			if strings.HasPrefix(g.Id, id) {
+				return g, nil
+			}
+		}
+		return Generation{}, fmt.Errorf("no cached disk image of %s with id %s, it may have been removed", repository, selector)
+	}
+
+	if number < 1 {
+		return Generation{}, fmt.Errorf("invalid generation %d, generations are numbered from 1", number)
+	}
+
+	var available []string
+	newest := 0
+	for _, g := range generations {
+		if g.Number == number {
+			return g, nil
+		}
+		if g.Number > 0 {
+			available = append(available, strconv.Itoa(g.Number))
+		}
+		if g.Number > newest {
+			newest = g.Number
+		}
+	}
+	if number > newest {
+		return Generation{}, fmt.Errorf("generation %d of %s does not exist, the newest is %d", number, repository, newest)
+	}
+	return Generation{}, fmt.Errorf("generation %d of %s has been removed from the cache, the cached generations are %s",
+		number, repository, strings.Join(available, ", "))
+}
+
+// UseGeneration makes the disk refer to a cached generation instead of
+// installing the image, without making it the current generation
+func (p *BootcDisk) UseGeneration(g Generation) {
+	p.ImageId = g.Id
+	p.Directory = g.Directory
+	p.RepoTag = g.Meta.ImageRef
+	if p.RepoTag == "" {
+		p.RepoTag = g.Meta.Repository
+	}
+	p.setBuiltAt(g.built())
+	p.runDefaults = g.Meta.RunDefaults
+	p.cacheHit = true
+}
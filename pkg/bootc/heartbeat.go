@@ -0,0 +1,117 @@
+package bootc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// DefaultHeartbeat is the default interval of the heartbeat lines
+const DefaultHeartbeat = time.Minute
+
+// consoleWriter serializes the writes to the output of the build and tracks
+// if the last line is complete, so a heartbeat never splits a line of the
+// install container output
+type consoleWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	midLine bool
+	// pending is the heartbeat waiting for the end of the current line
+	pending string
+}
+
+func (c *consoleWriter) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	if c.pending != "" {
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			if _, err := c.w.Write(b[:i+1]); err != nil {
+				return 0, err
+			}
+			n = i + 1
+			c.midLine = false
+			c.flushPending()
+		}
+	}
+	m, err := c.w.Write(b[n:])
+	if m > 0 {
+		c.midLine = b[n+m-1] != '\n'
+	}
+	return n + m, err
+}
+
+// beat writes the heartbeat line now, or after the end of the current line
+func (c *consoleWriter) beat(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = line + "\n"
+	if !c.midLine {
+		c.flushPending()
+	}
+}
+
+func (c *consoleWriter) flushPending() {
+	if _, err := io.WriteString(c.w, c.pending); err == nil {
+		c.pending = ""
+	}
+}
+
+// isTerminal reports if w is a terminal, which shows its own progress
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// startHeartbeat prints the phase in progress and its elapsed time every
+// interval, so CI logs do not look stalled during a long install. It only
+// runs when the install output is shown, not on a terminal and without a
+// progress hook showing the phase. The returned function stops it.
+func (p *BootcDisk) startHeartbeat(interval time.Duration) func() {
+	console := p.out().(*consoleWriter)
+	if interval <= 0 || !p.verbosity.showInstallOutput() || isTerminal(console.w) || p.progressHook != nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	poll := interval / 10
+	if poll < 10*time.Millisecond {
+		poll = 10 * time.Millisecond
+	}
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+		var lastBeat time.Time
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				name, start, ok := p.phases.current()
+				if !ok {
+					continue
+				}
+				if start.After(lastBeat) {
+					lastBeat = start
+				}
+				if now.Sub(lastBeat) < interval {
+					continue
+				}
+				lastBeat = now
+				console.beat(fmt.Sprintf("[heartbeat] %s, %s elapsed", name, now.Sub(start).Round(time.Second)))
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
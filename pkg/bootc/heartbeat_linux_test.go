@@ -0,0 +1,60 @@
+package bootc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// openPty returns the master and the slave of a new pseudo terminal
+func openPty() (*os.File, *os.File) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		Skip(fmt.Sprintf("no pseudo terminal: %v", err))
+	}
+	Expect(unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0)).To(Succeed())
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	Expect(err).ToNot(HaveOccurred())
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+	Expect(err).ToNot(HaveOccurred())
+	return master, slave
+}
+
+var _ = Describe("Heartbeat on a terminal", func() {
+	BeforeEach(func() {
+		Expect(testUser.InitOSCDirs()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(testUser.CacheDir())).To(Succeed())
+	})
+
+	It("should not print heartbeats to a terminal", func() {
+		master, slave := openPty()
+		defer master.Close()
+		var out bytes.Buffer
+		read := make(chan struct{})
+		go func() {
+			defer close(read)
+			_, _ = io.Copy(&out, master)
+		}()
+
+		podman := newFakePodman()
+		podman.runTime = 300 * time.Millisecond
+		disk := newTestDisk(podman)
+		disk.SetOutput(slave)
+		Expect(disk.Install(VerbosityNormal, DiskImageConfig{Heartbeat: 50 * time.Millisecond})).To(Succeed())
+		// Closing the slave ends the reads of the master
+		slave.Close()
+		Eventually(read).Should(BeClosed())
+		Expect(out.String()).To(ContainSubstring("bootc install to-disk"))
+		Expect(out.String()).ToNot(ContainSubstring("[heartbeat]"))
+	})
+})
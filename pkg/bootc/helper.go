@@ -0,0 +1,76 @@
+package bootc
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/containers/podman/v5/pkg/bindings/containers"
+	"github.com/sirupsen/logrus"
+)
+
+// mountTargetScript is sourced by the helper scripts working on a disk
+// image: it loop-mounts the disk passed as first argument on $mnt and
+// shifts it from the arguments
+const mountTargetScript = `set -eu
+disk=$1
+shift
+mnt=/run/podman-bootc-target
+dev=$(losetup --show -fP "$disk")
+cleanup() {
+	umount -R "$mnt" || true
+	losetup -d "$dev"
+}
+trap cleanup EXIT
+udevadm settle || true
+root=$(lsblk -lnpo NAME,LABEL "$dev" | awk '$2 == "root" { print $1 }')
+boot=$(lsblk -lnpo NAME,LABEL "$dev" | awk '$2 == "boot" { print $1 }')
+if [ -z "$root" ]; then
+	echo "no root partition found on $disk" 1>&2
+	exit 1
+fi
+mkdir -p "$mnt"
+mount "$root" "$mnt"
+if [ -n "$boot" ]; then
+	mount "$boot" "$mnt/boot"
+fi
+`
+
+// runHelperContainer runs command in a privileged container from image,
+// like the install container, and returns its output
+func (p *BootcDisk) runHelperContainer(image string, command []string) (string, error) {
+	losetupTemp, err := p.writeLosetupWrapper()
+	if err != nil {
+		return "", err
+	}
+	defer removeLosetupWrapper(losetupTemp)
+
+	createResponse, err := p.createInstallContainer(image, command, losetupTemp)
+	if err != nil {
+		return "", fmt.Errorf("failed to create helper container: %w", err)
+	}
+	p.bootcInstallContainerId = createResponse.ID //save the id for possible cleanup
+	logrus.Debugf("Created helper container, id=%s command=%v", createResponse.ID, command)
+	defer p.cleanupAfterDeadline()
+
+	if err := p.podman().StartContainer(p.Ctx, createResponse.ID, &containers.StartOptions{}); err != nil {
+		return "", fmt.Errorf("failed to start helper container: %w", err)
+	}
+
+	var output bytes.Buffer
+	attachOpts := new(containers.AttachOptions).WithStream(true)
+	if err := p.podman().AttachContainer(p.Ctx, createResponse.ID, nil, &output, &output, nil, attachOpts); err != nil {
+		return "", fmt.Errorf("attaching: %w", err)
+	}
+	exitCode, err := p.podman().WaitContainer(p.Ctx, createResponse.ID, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to wait for helper container: %w", err)
+	}
+
+	// The container has a terminal
+	out := strings.ReplaceAll(output.String(), "\r\n", "\n")
+	if exitCode != 0 {
+		return out, fmt.Errorf("helper container exited with %d: %s", exitCode, strings.TrimSpace(out))
+	}
+	return out, nil
+}
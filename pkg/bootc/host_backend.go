@@ -0,0 +1,147 @@
+package bootc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+
+	"github.com/blang/semver/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// InstallBackendContainer runs bootc install in a privileged container
+	// of the podman machine
+	InstallBackendContainer = "container"
+	// InstallBackendHost runs the bootc of the host against the image in
+	// the containers storage of the host
+	InstallBackendHost = "host"
+)
+
+// hostInstallStopTimeout is how long bootc install on the host has to exit
+// after the install timeout fired before being killed
+const hostInstallStopTimeout = 30 * time.Second
+
+// hostBootcMinVersion is the first bootc installing a loopback disk from
+// --source-imgref without running in the image
+var hostBootcMinVersion = semver.MustParse("1.1.0")
+
+// hostCommand returns the command running args on the host as root,
+// escalating with sudo when needed
+func hostCommand(ctx context.Context, args ...string) *exec.Cmd {
+	if os.Geteuid() != 0 {
+		args = append([]string{"sudo", "--"}, args...)
+	}
+	return exec.CommandContext(ctx, args[0], args[1:]...)
+}
+
+// hostOutput runs args on the host as root and returns its trimmed stdout
+func hostOutput(ctx context.Context, args ...string) (string, error) {
+	cmd := hostCommand(ctx, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// usesHostBackend reports if the install runs on the host
+func (c DiskImageConfig) usesHostBackend() bool {
+	return c.InstallBackend == InstallBackendHost
+}
+
+// checkHostBackend refuses hosts which cannot install the image: a bootc
+// too old or missing, or a containers storage without the image or with a
+// driver bootc cannot read. It returns the version of the host bootc.
+func (p *BootcDisk) checkHostBackend() (string, error) {
+	if runtime.GOOS != "linux" {
+		return "", fmt.Errorf("the host install backend requires Linux, use --install-backend=%s", InstallBackendContainer)
+	}
+	if _, err := exec.LookPath("bootc"); err != nil {
+		return "", fmt.Errorf("the host install backend requires bootc on the host: %w", err)
+	}
+	out, err := exec.CommandContext(p.Ctx, "bootc", "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to run the host bootc: %w", err)
+	}
+	version := parseBootcVersion(string(out))
+	parsed, err := semver.ParseTolerant(version)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse the host bootc version %q", strings.TrimSpace(string(out)))
+	}
+	if parsed.LT(hostBootcMinVersion) {
+		return "", fmt.Errorf("the host bootc %s is older than %s, which the host install backend requires; upgrade it or use --install-backend=%s",
+			version, hostBootcMinVersion, InstallBackendContainer)
+	}
+
+	driver, err := hostOutput(p.Ctx, "podman", "info", "--format", "{{.Store.GraphDriverName}}")
+	if err != nil {
+		return "", fmt.Errorf("unable to inspect the containers storage of the host: %w", err)
+	}
+	if driver != "overlay" {
+		return "", fmt.Errorf("the containers storage of the host uses the %s driver, bootc install requires overlay", driver)
+	}
+	if err := hostCommand(p.Ctx, "podman", "image", "exists", p.ImageId).Run(); err != nil {
+		return "", fmt.Errorf("the image %s is not in the containers storage of the host, pull it with 'sudo podman pull %s' or use --install-backend=%s",
+			shortID(p.ImageId), p.RepoTag, InstallBackendContainer)
+	}
+	return version, nil
+}
+
+// runHostInstall runs the bootc install command on the host, writing the
+// temporary disk in place like the install container
+func (p *BootcDisk) runHostInstall(command []string) error {
+	release := utils.InhibitSleep("podman-bootc: building disk image for " + p.RepoTag)
+	defer release()
+
+	if os.Geteuid() != 0 {
+		p.progressf("Running bootc install on the host with sudo, it may ask for your password")
+	}
+	logrus.Debugf("running on the host: %s", strings.Join(command, " "))
+	ctx := p.Ctx
+	if p.installTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(p.Ctx, p.installTimeout)
+		defer cancel()
+	}
+	cmd := hostCommand(ctx, command...)
+	// sudo relays SIGTERM to bootc, killing sudo would leave bootc running
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = hostInstallStopTimeout
+	// sudo may prompt for the password
+	cmd.Stdin = os.Stdin
+
+	var closeOutput func()
+	p.installOutput, closeOutput = p.newInstallOutput()
+	defer closeOutput()
+	var stdout, stderr io.Writer = p.installOutput, p.installOutput
+	if phases := p.newInstallPhaseWriter(); phases != nil {
+		stdout, stderr = io.MultiWriter(stdout, phases), io.MultiWriter(stderr, phases)
+	}
+	if p.verbosity.showInstallOutput() {
+		stdout = io.MultiWriter(p.out(), stdout)
+		stderr = io.MultiWriter(os.Stderr, stderr)
+	}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Run(); err != nil {
+		if p.Ctx.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("bootc install on the host did not finish within the install timeout of %s and was stopped: %w", p.installTimeout, err)
+		}
+		return fmt.Errorf("failed to run bootc install on the host: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package bootc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// CacheStrictnessOff reuses cached disks of the same image regardless
+	// of how they were built
+	CacheStrictnessOff = "off"
+	// CacheStrictnessWarn reuses cached disks built with different host
+	// inputs with a warning
+	CacheStrictnessWarn = "warn"
+	// CacheStrictnessStrict rebuilds cached disks built with different host inputs
+	CacheStrictnessStrict = "strict"
+
+	// installBackendLoopback installs to a loop device in the podman machine
+	installBackendLoopback = "loopback"
+)
+
+// HostInputs are the inputs of the host building a disk which change it
+// besides the image, hosts sharing a cache may use different ones
+type HostInputs struct {
+	// LosetupWrapper is set when losetup was wrapped to disable direct IO
+	LosetupWrapper bool `json:"losetupWrapper"`
+	// InstallerDigest is the id of the installer image, if any
+	InstallerDigest string `json:"installerDigest,omitempty"`
+	// ConfigHash identifies the install options
+	ConfigHash string `json:"configHash"`
+	// Backend is how the disk is attached during the install
+	Backend string `json:"backend"`
+}
+
+// hostInputs returns the host inputs of building the disk now, given the
+// version of bootc which installs it
+func (p *BootcDisk) hostInputs(diskConfig DiskImageConfig, bootcVersion string) HostInputs {
+	losetupWrapper, _ := losetupWrapperNeeded(diskConfig.LosetupDirectIO, bootcVersion)
+	return HostInputs{
+		LosetupWrapper:  losetupWrapper,
+		InstallerDigest: p.installerImageId,
+		ConfigHash:      diskConfig.installHash(),
+		Backend:         installBackendLoopback,
+	}
+}
+
+// mismatches describes the components of the cached inputs differing from now
+func (cached HostInputs) mismatches(now HostInputs) []string {
+	var diffs []string
+	if cached.LosetupWrapper != now.LosetupWrapper {
+		diffs = append(diffs, fmt.Sprintf("losetup wrapper %s, now %s", onOff(cached.LosetupWrapper), onOff(now.LosetupWrapper)))
+	}
+	if cached.InstallerDigest != now.InstallerDigest {
+		diffs = append(diffs, fmt.Sprintf("installer image %q, now %q", shortID(cached.InstallerDigest), shortID(now.InstallerDigest)))
+	}
+	if cached.ConfigHash != now.ConfigHash {
+		diffs = append(diffs, "install options changed")
+	}
+	if cached.Backend != now.Backend {
+		diffs = append(diffs, fmt.Sprintf("install backend %s, now %s", cached.Backend, now.Backend))
+	}
+	return diffs
+}
+
+// cachedInputsMatch checks the host inputs of a cached disk of the same image
+// according to the strictness, it returns false when the disk must be rebuilt
+func (p *BootcDisk) cachedInputsMatch(meta *DiskMeta, diskConfig DiskImageConfig) (bool, error) {
+	if diskConfig.CacheStrictness == CacheStrictnessOff {
+		return true, nil
+	}
+	if meta.HostInputs == nil {
+		// Disks built before the host inputs were recorded
+		return true, nil
+	}
+	diffs := meta.HostInputs.mismatches(p.hostInputs(diskConfig, meta.BootcVersion))
+	if len(diffs) == 0 {
+		return true, nil
+	}
+	if diskConfig.CacheStrictness == CacheStrictnessStrict {
+		p.progressf("The cached disk was built with different host inputs (%s), rebuilding", strings.Join(diffs, "; "))
+		return false, nil
+	}
+	logrus.Warnf("the cached disk image was built with different host inputs (%s), reusing it; use --cache-strictness=strict to rebuild it", strings.Join(diffs, "; "))
+	return true, nil
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,39 @@
+package bootc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/BurntSushi/toml"
+)
+
+// installConfigPath is where the install configuration is mounted in the
+// install container, bootc reads the files of the directory in order so the
+// last one overrides the configuration of the image
+const installConfigPath = "/usr/lib/bootc/install/99-podman-bootc.toml"
+
+// readInstallConfig checks that the bootc install configuration at path is
+// TOML and returns its sha256 digest
+func readInstallConfig(path string) (string, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var parsed map[string]any
+	if err := toml.Unmarshal(buf, &parsed); err != nil {
+		return "", fmt.Errorf("parsing %s: %w", path, err)
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// installConfigDigest returns the digest of the install configuration the
+// disk was installed with, if any
+func (m *DiskMeta) installConfigDigest() string {
+	if m.Inputs == nil {
+		return ""
+	}
+	return m.Inputs.InstallConfigDigest
+}
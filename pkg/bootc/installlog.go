@@ -0,0 +1,42 @@
+package bootc
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/logfile"
+
+	"github.com/sirupsen/logrus"
+)
+
+// installLogFile keeps the output of the last install of a cache entry, each
+// install truncates it
+const installLogFile = "install.log"
+
+// InstallLogPath returns the log of the last install of the disk image,
+// written even when the install output is not shown
+func (p *BootcDisk) InstallLogPath() string {
+	return filepath.Join(p.Directory, installLogFile)
+}
+
+// newInstallOutput returns the pump of the output of an install, streaming
+// it to the log of the invocation and to a new install log. The returned
+// function closes both.
+func (p *BootcDisk) newInstallOutput() (*outputPump, func()) {
+	p.installLogged = false
+	f, err := os.OpenFile(p.InstallLogPath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		logrus.Warnf("unable to write the install log: %v", err)
+		pump := newOutputPump(installOutputTailSize, logfile.Stream())
+		return pump, func() { pump.Close() }
+	}
+	p.installLogged = true
+	pump := newOutputPump(installOutputTailSize, io.MultiWriter(logfile.Stream(), f))
+	return pump, func() {
+		pump.Close()
+		if err := f.Close(); err != nil {
+			logrus.Warnf("unable to close the install log: %v", err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package bootc
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// InstallPhase is a phase of bootc install recognized in its output
+type InstallPhase int
+
+const (
+	// InstallPhaseNone is before any phase was recognized, or when the
+	// output of bootc is not understood
+	InstallPhaseNone InstallPhase = iota
+	InstallPhasePartitioning
+	InstallPhaseCopying
+	InstallPhaseBootloader
+	InstallPhaseFinishing
+)
+
+func (ph InstallPhase) String() string {
+	switch ph {
+	case InstallPhasePartitioning:
+		return "partitioning"
+	case InstallPhaseCopying:
+		return "copying the ostree commit"
+	case InstallPhaseBootloader:
+		return "installing the bootloader"
+	case InstallPhaseFinishing:
+		return "finishing"
+	}
+	return ""
+}
+
+// installPhaseParser recognizes the phases of the install in the lines of
+// its output. The output of bootc changes between versions, so unknown
+// lines must be ignored rather than fail.
+type installPhaseParser interface {
+	// parse returns the phase started by the line, if any
+	parse(line string) (InstallPhase, bool)
+}
+
+// bootcPhaseParser matches the lines bootc install to-disk prints when it
+// starts a phase
+type bootcPhaseParser struct{}
+
+var bootcPhasePatterns = []struct {
+	phase   InstallPhase
+	pattern *regexp.Regexp
+}{
+	{InstallPhasePartitioning, regexp.MustCompile(`^(Block setup:|Wiping |Creating partition|> (sgdisk|sfdisk|cryptsetup)|Creating \w+ filesystem|> mkfs)`)},
+	{InstallPhaseCopying, regexp.MustCompile(`^(Initializing ostree layout|Initializing sysroot|Importing|Fetching layer|Deploying)`)},
+	{InstallPhaseBootloader, regexp.MustCompile(`^(Installing bootloader|> bootupctl|Installed: )`)},
+	{InstallPhaseFinishing, regexp.MustCompile(`^(Trimming|Finalizing filesystem|Installation complete)`)},
+}
+
+func (bootcPhaseParser) parse(line string) (InstallPhase, bool) {
+	for _, p := range bootcPhasePatterns {
+		if p.pattern.MatchString(line) {
+			return p.phase, true
+		}
+	}
+	return InstallPhaseNone, false
+}
+
+// escapeRegexp matches the terminal escape sequences of the install output
+var escapeRegexp = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]|\x1b\][^\x07]*\x07`)
+
+// installPhaseWriter splits the install output in lines and reports the
+// phases found by its parser. Phases only move forward, a line of an
+// earlier phase printed late does not go back.
+type installPhaseWriter struct {
+	mu      sync.Mutex
+	parser  installPhaseParser
+	line    []byte
+	phase   InstallPhase
+	onPhase func(InstallPhase)
+}
+
+func (w *installPhaseWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.line = append(w.line, b...)
+	for {
+		i := bytes.IndexAny(w.line, "\r\n")
+		if i < 0 {
+			return len(b), nil
+		}
+		w.parseLine(string(w.line[:i]))
+		w.line = w.line[i+1:]
+	}
+}
+
+func (w *installPhaseWriter) parseLine(line string) {
+	line = strings.TrimSpace(escapeRegexp.ReplaceAllString(line, ""))
+	phase, ok := w.parser.parse(line)
+	if !ok || phase <= w.phase {
+		return
+	}
+	w.phase = phase
+	logrus.Debugf("install phase: %s", phase)
+	w.onPhase(phase)
+}
+
+// newInstallPhaseWriter returns the writer reporting the phases of the
+// install output to the progress hook, nil without a hook
+func (p *BootcDisk) newInstallPhaseWriter() *installPhaseWriter {
+	if p.progressHook == nil {
+		return nil
+	}
+	onPhase := func(phase InstallPhase) {
+		p.updateProgress(func(e *ProgressEvent) { e.InstallPhase = phase })
+	}
+	onPhase(InstallPhaseNone)
+	return &installPhaseWriter{parser: bootcPhaseParser{}, onPhase: onPhase}
+}
@@ -0,0 +1,77 @@
+package bootc
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/containers/podman/v5/pkg/bindings/containers"
+	"github.com/sirupsen/logrus"
+)
+
+// keptFailureFile records the install container and the temporary disk of a
+// failed build kept by --keep-on-failure, the next build removes them
+const keptFailureFile = "kept-failure.json"
+
+// keptFailure is the evidence of a failed install kept for debugging
+type keptFailure struct {
+	ContainerId string `json:"containerId"`
+	TempDisk    string `json:"tempDisk"`
+}
+
+func (p *BootcDisk) keptFailurePath() string {
+	return filepath.Join(p.Directory, keptFailureFile)
+}
+
+// keepFailedInstall keeps the install container and the temporary disk of
+// the failed install and records them to be removed by the next build
+func (p *BootcDisk) keepFailedInstall() {
+	kept := keptFailure{ContainerId: p.bootcInstallContainerId}
+	if p.file != nil {
+		kept.TempDisk = p.file.Name()
+	}
+	p.keptContainerId = kept.ContainerId
+	buf, err := json.Marshal(kept)
+	if err == nil {
+		err = os.WriteFile(p.keptFailurePath(), buf, 0o644)
+	}
+	if err != nil {
+		logrus.Warnf("unable to record the kept install container and temporary disk, remove them manually: %v", err)
+	}
+	p.progressf("Kept the install container %s (see podman logs %s) and the temporary disk %s; the next build of %s removes them",
+		kept.ContainerId, kept.ContainerId, kept.TempDisk, p.RepoTag)
+}
+
+// removeKeptFailure removes the install container and the temporary disk
+// kept by a previous failed build, if any
+func (p *BootcDisk) removeKeptFailure() {
+	buf, err := os.ReadFile(p.keptFailurePath())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logrus.Warnf("unable to read the kept install failure: %v", err)
+		}
+		return
+	}
+	var kept keptFailure
+	if err := json.Unmarshal(buf, &kept); err != nil {
+		logrus.Warnf("ignoring invalid kept install failure: %v", err)
+		os.Remove(p.keptFailurePath())
+		return
+	}
+
+	logrus.Infof("removing the install container %s and the temporary disk %s kept by a failed build", kept.ContainerId, kept.TempDisk)
+	if kept.ContainerId != "" {
+		force := true
+		if _, err := p.podman().RemoveContainer(p.Ctx, kept.ContainerId, &containers.RemoveOptions{Force: &force}); err != nil {
+			logrus.Warnf("unable to remove the kept install container %s: %v", kept.ContainerId, err)
+		}
+	}
+	// Only temporary disks of this cache entry are removed
+	if kept.TempDisk != "" && filepath.Dir(kept.TempDisk) == p.Directory {
+		if err := os.Remove(kept.TempDisk); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logrus.Warnf("unable to remove the kept temporary disk %s: %v", kept.TempDisk, err)
+		}
+	}
+	os.Remove(p.keptFailurePath())
+}
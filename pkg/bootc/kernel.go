@@ -0,0 +1,136 @@
+package bootc
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// kernelFeaturesScript prints the kernel features of the podman machine the
+// loopback install depends on, as key=value lines
+const kernelFeaturesScript = `echo "release=$(uname -r)"
+if [ -e /sys/module/loop ] || [ -e /dev/loop-control ]; then echo loop=yes; else echo loop=no; fi
+echo "max_part=$(cat /sys/module/loop/parameters/max_part 2>/dev/null || echo unknown)"
+if grep -qw overlay /proc/filesystems; then echo overlay=yes; else echo overlay=no; fi
+`
+
+// kernelFeatures are the kernel features reported by kernelFeaturesScript
+type kernelFeatures struct {
+	release string
+	major   int
+	minor   int
+	values  map[string]string
+}
+
+func parseKernelFeatures(output string) (kernelFeatures, error) {
+	features := kernelFeatures{values: map[string]string{}}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if ok {
+			features.values[key] = value
+		}
+	}
+	features.release = features.values["release"]
+	major, rest, _ := strings.Cut(features.release, ".")
+	minor, _, _ := strings.Cut(rest, ".")
+	minor, _, _ = strings.Cut(minor, "-")
+	var errMajor, errMinor error
+	features.major, errMajor = strconv.Atoi(major)
+	features.minor, errMinor = strconv.Atoi(minor)
+	if errMajor != nil || errMinor != nil {
+		return features, fmt.Errorf("unexpected kernel release %q", features.release)
+	}
+	return features, nil
+}
+
+// atLeast reports if the kernel is at least major.minor
+func (f kernelFeatures) atLeast(major, minor int) bool {
+	return f.major > major || (f.major == major && f.minor >= minor)
+}
+
+// kernelRequirement is a kernel feature the install depends on. check
+// returns an explanation when the feature is missing.
+type kernelRequirement struct {
+	name  string
+	fatal bool // fail the build instead of warning
+	check func(kernelFeatures) string
+	hint  string
+}
+
+// kernelRequirements are checked in order when the install fails, new
+// requirements of bootc are added here
+var kernelRequirements = []kernelRequirement{
+	{
+		name:  "kernel version",
+		fatal: true,
+		check: func(f kernelFeatures) string {
+			if !f.atLeast(4, 18) {
+				return fmt.Sprintf("kernel %s is older than 4.18, it lacks loop direct-IO and reliable partition scanning", f.release)
+			}
+			return ""
+		},
+		hint: "upgrade the podman machine with 'podman machine os apply' or recreate it",
+	},
+	{
+		name: "known-good kernel",
+		check: func(f kernelFeatures) string {
+			if !f.atLeast(5, 14) {
+				return fmt.Sprintf("kernel %s is older than the 5.14 baseline the install is tested with", f.release)
+			}
+			return ""
+		},
+		hint: "upgrade the podman machine if the install fails",
+	},
+	{
+		name:  "loop module",
+		fatal: true,
+		check: func(f kernelFeatures) string {
+			if f.values["loop"] != "yes" {
+				return "the loop module is not loaded and /dev/loop-control is missing"
+			}
+			return ""
+		},
+		hint: "load it with 'podman machine ssh sudo modprobe loop'",
+	},
+	{
+		name: "loop partitions",
+		check: func(f kernelFeatures) string {
+			// zero allocates the partition minors dynamically
+			if n, err := strconv.Atoi(f.values["max_part"]); err == nil && n > 0 && n < 4 {
+				return fmt.Sprintf("the loop module allows %d partitions per device, the disk image has up to 4", n)
+			}
+			return ""
+		},
+		hint: "reload the module with 'podman machine ssh sudo sh -c \"rmmod loop; modprobe loop max_part=0\"'",
+	},
+	{
+		name:  "overlayfs",
+		fatal: true,
+		check: func(f kernelFeatures) string {
+			if f.values["overlay"] != "yes" {
+				return "overlayfs is not available to the install container"
+			}
+			return ""
+		},
+		hint: "load it with 'podman machine ssh sudo modprobe overlay'",
+	},
+}
+
+// evaluateKernel returns the fatal problems and the warnings of the kernel
+func evaluateKernel(features kernelFeatures, requirements []kernelRequirement) (problems, warnings []string) {
+	for _, r := range requirements {
+		detail := r.check(features)
+		if detail == "" {
+			continue
+		}
+		message := fmt.Sprintf("%s: %s; %s", r.name, detail, r.hint)
+		if r.fatal {
+			problems = append(problems, message)
+		} else {
+			warnings = append(warnings, message)
+		}
+	}
+	return problems, warnings
+}
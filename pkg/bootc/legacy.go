@@ -0,0 +1,82 @@
+package bootc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// legacyLayout is a layout of the cache entries of an earlier release.
+// Every layout change must add one, with a fixture in the tests.
+type legacyLayout struct {
+	name string
+	// detect reports if the cache entry in dir has the layout
+	detect func(dir string) bool
+	// migrate converts the cache entry in dir to the next layout
+	migrate func(dir string) error
+}
+
+// legacyLayouts are the layouts of a cache entry, oldest first, migrated in
+// order so an entry several releases old goes through all of them
+var legacyLayouts = []legacyLayout{
+	{
+		// The first releases only recorded the image digest, the creation
+		// time falls back to the modification time of the disk image,
+		// which changes whenever the VM writes to it
+		name: "metadata of the first releases, without the creation time",
+		detect: func(dir string) bool {
+			meta, err := ReadDiskMeta(filepath.Join(dir, config.DiskImage))
+			return err == nil && meta.ImageDigest != "" && meta.Created.IsZero()
+		},
+		migrate: func(dir string) error {
+			diskPath := filepath.Join(dir, config.DiskImage)
+			meta, err := ReadDiskMeta(diskPath)
+			if err != nil {
+				return err
+			}
+			st, err := os.Stat(diskPath)
+			if err != nil {
+				return err
+			}
+			meta.Created = st.ModTime()
+			return WriteDiskMeta(diskPath, meta)
+		},
+	},
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// DetectLegacyLayout returns the name of the first older layout of the
+// cache entry in dir, or an empty string if it has the current one
+func DetectLegacyLayout(dir string) string {
+	for _, layout := range legacyLayouts {
+		if layout.detect(dir) {
+			return layout.name
+		}
+	}
+	return ""
+}
+
+// migrateLegacyEntry converts the cache entry of the image from the layouts
+// of earlier releases. It runs under the cache lock and does nothing for an
+// entry with the current layout.
+func (p *BootcDisk) migrateLegacyEntry() error {
+	dir := p.User.ImageCacheDir(p.ImageId)
+	for _, layout := range legacyLayouts {
+		if !layout.detect(dir) {
+			continue
+		}
+		if err := layout.migrate(dir); err != nil {
+			return fmt.Errorf("migrating %s from the layout with the %s: %w", dir, layout.name, err)
+		}
+		logrus.Infof("migrated the cache entry %s from the layout with the %s", dir, layout.name)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package bootc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// loopDevicesRequired is the number of free loop devices bootc needs to install
+const loopDevicesRequired = 2
+
+// loopRetryInterval is how often the free loop devices are counted when waiting
+const loopRetryInterval = 10 * time.Second
+
+// loopDevicesScript counts the loop devices in the podman machine without
+// allocating any. The first line is the summary, followed by losetup -l.
+const loopDevicesScript = `max=$(cat /sys/module/loop/parameters/max_loop 2>/dev/null || echo 0)
+total=0
+used=0
+for d in /sys/block/loop*; do
+	[ -e "$d" ] || continue
+	total=$((total + 1))
+	if [ -s "$d/loop/backing_file" ]; then
+		used=$((used + 1))
+	fi
+done
+echo "max=$max total=$total used=$used"
+losetup -l -n -O NAME,BACK-FILE 2>/dev/null || true
+`
+
+// loopUsage describes the loop devices of the podman machine
+type loopUsage struct {
+	max     int // max_loop, zero when loop devices are created on demand
+	total   int
+	used    int
+	summary string // losetup -l output
+}
+
+// free returns the number of loop devices which can be used, -1 if unlimited
+func (u loopUsage) free() int {
+	if u.max == 0 {
+		return -1
+	}
+	return u.max - u.used
+}
+
+func parseLoopUsage(output string) (loopUsage, error) {
+	var usage loopUsage
+	first, rest, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	fields := strings.Fields(first)
+	if len(fields) != 3 {
+		return usage, fmt.Errorf("unexpected loop device summary %q", first)
+	}
+	for _, field := range fields {
+		key, value, _ := strings.Cut(field, "=")
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return usage, fmt.Errorf("unexpected loop device summary %q", first)
+		}
+		switch key {
+		case "max":
+			usage.max = n
+		case "total":
+			usage.total = n
+		case "used":
+			usage.used = n
+		}
+	}
+	usage.summary = strings.TrimSpace(rest)
+	return usage, nil
+}
+
+// short reports if the install lacks free loop devices
+func (u loopUsage) short() bool {
+	free := u.free()
+	return free >= 0 && free < loopDevicesRequired
+}
+
+// waitForLoopDevices waits up to wait for other users to release enough
+// loop devices for the install, usage is the last count
+func (p *BootcDisk) waitForLoopDevices(usage loopUsage, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for usage.short() {
+		free := usage.free()
+		if time.Now().Add(loopRetryInterval).After(deadline) {
+			return fmt.Errorf("only %d of %d loop devices are free in the podman machine, the install needs %d.\n"+
+				"In use:\n%s\n"+
+				"Free some loop devices with 'losetup -d' or raise the limit with 'podman machine ssh sudo sh -c \"rmmod loop; modprobe loop max_loop=64\"'",
+				free, usage.max, loopDevicesRequired, usage.summary)
+		}
+
+		p.progressf("Waiting for loop devices, %d of %d free", free, loopDevicesRequired)
+		select {
+		case <-time.After(loopRetryInterval):
+		case <-p.Ctx.Done():
+			return p.Ctx.Err()
+		}
+		output, err := p.runHelperContainer(p.installImage(), []string{"sh", "-c", loopDevicesScript})
+		if err != nil {
+			return fmt.Errorf("unable to count the free loop devices: %w", err)
+		}
+		if usage, err = parseLoopUsage(output); err != nil {
+			return fmt.Errorf("unable to count the free loop devices: %w", err)
+		}
+		logrus.Debugf("loop devices: max=%d total=%d used=%d", usage.max, usage.total, usage.used)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+#!/bin/sh
+# losetup wrapper forcing --direct-io=off, a workaround for
+# https://github.com/containers/bootc/pull/487/commits/89d34c7dbcb8a1fa161f812c6ba0a8b49ccbe00f
+# It is POSIX sh as the image may not have bash.
+set -eu
+for arg do
+	shift
+	case $arg in
+		--direct-io=*) echo "ignoring: $arg" 1>&2 ;;
+		*) set -- "$@" "$arg" ;;
+	esac
+done
+exec /usr/sbin/losetup --direct-io=off "$@"
@@ -0,0 +1,93 @@
+package bootc
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+
+	"github.com/blang/semver/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// losetupWrapper replaces losetup in the install container to force
+// --direct-io=off
+//
+//go:embed losetup-wrapper.sh
+var losetupWrapper []byte
+
+// losetupFixedVersion is the first bootc release which does not need the
+// losetup wrapper
+var losetupFixedVersion = semver.MustParse("0.1.11")
+
+// The values of --losetup-direct-io
+const (
+	// LosetupDirectIOAuto wraps losetup when the bootc version of the
+	// install image needs it
+	LosetupDirectIOAuto = "auto"
+	// LosetupDirectIOOn never wraps losetup, bootc sets up the loop
+	// devices with direct IO
+	LosetupDirectIOOn = "on"
+	// LosetupDirectIOOff always wraps losetup to disable direct IO
+	LosetupDirectIOOff = "off"
+)
+
+var losetupDirectIOModes = []string{LosetupDirectIOAuto, LosetupDirectIOOn, LosetupDirectIOOff}
+
+// needsLosetupWrapper reports if bootc of the install image needs the
+// losetup wrapper
+func (p *BootcDisk) needsLosetupWrapper() bool {
+	needed, _ := losetupWrapperNeeded(p.losetupDirectIO, p.bootcVersion)
+	return needed
+}
+
+// losetupWrapperNeeded reports if the install needs the losetup wrapper,
+// with the reason of the decision. The direct IO mode overrides the
+// detection from the bootc version, which needs it when it is unknown.
+func losetupWrapperNeeded(directIO, bootcVersion string) (bool, string) {
+	switch directIO {
+	case LosetupDirectIOOn:
+		return false, "direct IO is forced on"
+	case LosetupDirectIOOff:
+		return true, "direct IO is forced off"
+	}
+	version, err := semver.ParseTolerant(bootcVersion)
+	if err != nil {
+		return true, fmt.Sprintf("the bootc version %q is unknown", bootcVersion)
+	}
+	if version.LT(losetupFixedVersion) {
+		return true, fmt.Sprintf("bootc %s is older than %s", bootcVersion, losetupFixedVersion)
+	}
+	return false, fmt.Sprintf("bootc %s supports direct IO", bootcVersion)
+}
+
+// writeLosetupWrapper writes the losetup wrapper to a temporary file shared
+// with the container. It returns an empty path when bootc does not need it.
+func (p *BootcDisk) writeLosetupWrapper() (string, error) {
+	needed, reason := losetupWrapperNeeded(p.losetupDirectIO, p.bootcVersion)
+	if !needed {
+		logrus.Debugf("not using the losetup wrapper: %s", reason)
+		return "", nil
+	}
+	logrus.Debugf("using the losetup wrapper: %s", reason)
+	losetupTemp, err := os.CreateTemp(p.Directory, "losetup-wrapper")
+	if err != nil {
+		return "", fmt.Errorf("temp losetup wrapper: %w", err)
+	}
+	defer losetupTemp.Close()
+	if _, err := losetupTemp.Write(losetupWrapper); err != nil {
+		os.Remove(losetupTemp.Name())
+		return "", fmt.Errorf("temp losetup wrapper copy: %w", err)
+	}
+	if err := losetupTemp.Chmod(0o755); err != nil {
+		os.Remove(losetupTemp.Name())
+		return "", fmt.Errorf("temp losetup wrapper chmod: %w", err)
+	}
+	return losetupTemp.Name(), nil
+}
+
+// removeLosetupWrapper removes the file written by writeLosetupWrapper
+func removeLosetupWrapper(path string) {
+	if path != "" {
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,211 @@
+package bootc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// maxMetaXattrSize bounds the metadata stored in the xattr. ext4 limits a
+// xattr value to a block minus its headers, larger metadata goes to the
+// sidecar file.
+const maxMetaXattrSize = 2048
+
+// slimMetaVersion is the schema version of slimMeta, the full metadata
+// stored in the xattr by earlier versions has none
+const slimMetaVersion = 2
+
+// slimMeta is stored in the xattr of a disk image whose metadata is in its
+// sidecar file. The digest tells a stale sidecar apart.
+type slimMeta struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	ImageDigest   string `json:"imageDigest"`
+	Sidecar       string `json:"sidecar"`
+}
+
+// parseSlimMeta returns the slim metadata of a xattr, or nil for the full
+// metadata
+func parseSlimMeta(buf []byte) *slimMeta {
+	var slim slimMeta
+	if err := json.Unmarshal(buf, &slim); err != nil || slim.SchemaVersion < slimMetaVersion || slim.Sidecar == "" {
+		return nil
+	}
+	return &slim
+}
+
+// ReadDiskMeta reads the metadata stored on a disk image
+func ReadDiskMeta(diskPath string) (*DiskMeta, error) {
+	f, err := os.Open(diskPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf, err := readMeta(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading disk metadata: %w", err)
+	}
+	var meta DiskMeta
+	if err := json.Unmarshal(buf, &meta); err != nil {
+		return nil, fmt.Errorf("parsing disk metadata: %w", err)
+	}
+	return &meta, nil
+}
+
+// useSidecar reports if the metadata of the disk images in dir is stored in
+// a sidecar file instead of a xattr, which is the default on network
+// filesystems since their xattr support varies
+func useSidecar(dir string) bool {
+	fsType, err := utils.NetworkFilesystem(dir)
+	return err == nil && fsType != ""
+}
+
+func sidecarPath(diskPath string) string {
+	return filepath.Join(filepath.Dir(diskPath), config.DiskMetaFile)
+}
+
+// readMeta reads the serialized metadata of the disk image f from its
+// sidecar file, falling back to its xattr. The xattr holds either the full
+// metadata or, when it is too large, a slimMeta pointing to the sidecar.
+func readMeta(f *os.File) ([]byte, error) {
+	xattr, xattrErr := readMetaXattr(f)
+	var slim *slimMeta
+	if xattrErr == nil {
+		slim = parseSlimMeta(xattr)
+	}
+
+	buf, err := os.ReadFile(sidecarPath(f.Name()))
+	switch {
+	case err == nil:
+		if slim != nil {
+			var sidecar struct {
+				ImageDigest string `json:"imageDigest"`
+			}
+			if err := json.Unmarshal(buf, &sidecar); err != nil || sidecar.ImageDigest != slim.ImageDigest {
+				return nil, fmt.Errorf("the metadata in %s does not belong to the disk image of %s", slim.Sidecar, slim.ImageDigest)
+			}
+		}
+		return buf, nil
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	case xattrErr != nil:
+		return nil, xattrErr
+	case slim != nil:
+		return nil, fmt.Errorf("the metadata of the disk image is missing, %s does not exist", slim.Sidecar)
+	}
+	return xattr, nil
+}
+
+// readMetaXattr reads the xattr of the disk image f
+func readMetaXattr(f *os.File) ([]byte, error) {
+	size, err := unix.Fgetxattr(int(f.Fd()), imageMetaXattr, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s xattr: %w", imageMetaXattr, err)
+	}
+	buf := make([]byte, size)
+	size, err = unix.Fgetxattr(int(f.Fd()), imageMetaXattr, buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s xattr: %w", imageMetaXattr, err)
+	}
+	return buf[:size], nil
+}
+
+// isXattrSizeError reports if the filesystem refused a xattr for its size
+func isXattrSizeError(err error) bool {
+	return errors.Is(err, unix.E2BIG) || errors.Is(err, unix.ENOSPC) || errors.Is(err, unix.ERANGE)
+}
+
+// setMetaXattr stores the serialized metadata buf of the image digest in the
+// xattr with set. Metadata larger than maxMetaXattrSize, or refused by the
+// filesystem for its size, is replaced by a slimMeta; it returns true when
+// the metadata must be written to the sidecar file.
+func setMetaXattr(set func([]byte) error, buf []byte, digest string) (bool, error) {
+	if len(buf) <= maxMetaXattrSize {
+		err := set(buf)
+		if err == nil || !isXattrSizeError(err) {
+			return false, err
+		}
+		logrus.Debugf("the filesystem refused %d bytes of disk metadata in a xattr (%v), writing them to %s", len(buf), err, config.DiskMetaFile)
+	} else {
+		logrus.Debugf("the disk metadata has %d bytes, over the xattr limit of %d, writing it to %s", len(buf), maxMetaXattrSize, config.DiskMetaFile)
+	}
+	slim, err := json.Marshal(slimMeta{SchemaVersion: slimMetaVersion, ImageDigest: digest, Sidecar: config.DiskMetaFile})
+	if err != nil {
+		return false, err
+	}
+	return true, set(slim)
+}
+
+// writeSidecar atomically replaces the sidecar file of a disk image
+func writeSidecar(diskPath string, buf []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(diskPath), "podman-bootc-tempmeta")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), sidecarPath(diskPath))
+}
+
+// setXattrReadOnly sets the xattr of the file at path. Setting a user xattr
+// requires write access, a disk image made read-only by --disk-mode is made
+// writable by its owner for the time of the write.
+func setXattrReadOnly(path, name string, value []byte) error {
+	err := unix.Setxattr(path, name, value, 0)
+	if !errors.Is(err, unix.EACCES) {
+		return err
+	}
+	st, statErr := os.Stat(path)
+	if statErr != nil || st.Mode().Perm()&0o200 != 0 {
+		return err
+	}
+	if os.Chmod(path, st.Mode().Perm()|0o200) != nil {
+		// Only the owner may change the mode
+		return err
+	}
+	err = unix.Setxattr(path, name, value, 0)
+	if chmodErr := os.Chmod(path, st.Mode().Perm()); chmodErr != nil && err == nil {
+		err = fmt.Errorf("restoring the mode of %s: %w", path, chmodErr)
+	}
+	return err
+}
+
+// WriteDiskMeta replaces the metadata stored on a disk image
+func WriteDiskMeta(diskPath string, meta *DiskMeta) error {
+	buf, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if useSidecar(filepath.Dir(diskPath)) {
+		return writeSidecar(diskPath, buf)
+	}
+	// Without the sidecar, a crash leaves a disk image without metadata
+	// rather than described by the previous one
+	if err := os.Remove(sidecarPath(diskPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	sidecar, err := setMetaXattr(func(value []byte) error {
+		return setXattrReadOnly(diskPath, imageMetaXattr, value)
+	}, buf, meta.ImageDigest)
+	if err != nil {
+		return fmt.Errorf("failed to set xattr: %w", err)
+	}
+	if sidecar {
+		return writeSidecar(diskPath, buf)
+	}
+	return nil
+}
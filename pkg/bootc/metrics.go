@@ -0,0 +1,30 @@
+package bootc
+
+import (
+	"time"
+)
+
+// Metrics receives counters and gauges about disk image builds, e.g. to
+// export them to Prometheus when the package is embedded in a service
+type Metrics interface {
+	BuildStarted()
+	BuildSucceeded(duration time.Duration)
+	BuildFailed()
+	CacheHit()
+	CacheMiss()
+	// BytesPulled reports the size of images that had to be pulled
+	BytesPulled(bytes int64)
+	// CacheSize reports the disk usage of the whole podman-bootc cache
+	CacheSize(bytes int64)
+}
+
+// noopMetrics is used when no Metrics are set
+type noopMetrics struct{}
+
+func (noopMetrics) BuildStarted()                  {}
+func (noopMetrics) BuildSucceeded(_ time.Duration) {}
+func (noopMetrics) BuildFailed()                   {}
+func (noopMetrics) CacheHit()                      {}
+func (noopMetrics) CacheMiss()                     {}
+func (noopMetrics) BytesPulled(_ int64)            {}
+func (noopMetrics) CacheSize(_ int64)              {}
@@ -0,0 +1,65 @@
+package bootc
+
+import (
+	"fmt"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/sirupsen/logrus"
+)
+
+// mirrorReference replaces the registry of the image reference with the mirror
+func mirrorReference(rawImage, mirror string) (mirrored string, original reference.Named, err error) {
+	named, err := reference.ParseNormalizedNamed(rawImage)
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing image reference %s: %w", rawImage, err)
+	}
+	named = reference.TagNameOnly(named)
+
+	mirrored = mirror + "/" + reference.Path(named)
+	if tagged, ok := named.(reference.Tagged); ok {
+		mirrored += ":" + tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		mirrored += "@" + digested.Digest().String()
+	}
+	return mirrored, named, nil
+}
+
+// pullFromMirror pulls the image through the registry mirror and names it
+// like the original reference. The pull is executed by the podman service,
+// where a registries.conf drop-in written by us would not be visible, so
+// the mirror is applied by rewriting the reference instead.
+func (p *BootcDisk) pullFromMirror(mirror string, pullPolicy string) ([]string, error) {
+	mirrored, original, err := mirrorReference(p.ImageNameOrId, mirror)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Debugf("Pulling %s from mirror as %s", p.ImageNameOrId, mirrored)
+	ids, err := p.pull(mirrored, pullPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pull image from mirror %s: %w", mirror, err)
+	}
+	if len(ids) != 1 {
+		return ids, nil
+	}
+
+	if tagged, ok := original.(reference.Tagged); ok {
+		if err := p.podman().TagImage(p.Ctx, ids[0], tagged.Tag(), original.Name(), &images.TagOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to tag image pulled from mirror: %w", err)
+		}
+		mirroredNamed, err := reference.ParseNormalizedNamed(mirrored)
+		if err == nil {
+			if err := p.podman().UntagImage(p.Ctx, ids[0], tagged.Tag(), mirroredNamed.Name(), &images.UntagOptions{}); err != nil {
+				logrus.Debugf("unable to untag %s: %v", mirrored, err)
+			}
+		}
+	} else {
+		// a digest can't be tagged, refer to the pulled image by its id
+		p.ImageNameOrId = ids[0]
+	}
+
+	p.progressf("Pulled %s via mirror %s", original.String(), mirror)
+	return ids, nil
+}
@@ -0,0 +1,33 @@
+#!/bin/sh
+# mkfs wrapper adding the options of --mkfs-option when bootc formats the
+# root filesystem, recognized by its label. podman-bootc validates the
+# options and passes them in PODMAN_BOOTC_MKFS_<FS>, they are split on
+# whitespace. It is POSIX sh as the image may not have bash.
+set -eu
+fs=${0##*mkfs.}
+case $fs in
+	xfs) options=${PODMAN_BOOTC_MKFS_XFS:-} ;;
+	ext4) options=${PODMAN_BOOTC_MKFS_EXT4:-} ;;
+	btrfs) options=${PODMAN_BOOTC_MKFS_BTRFS:-} ;;
+	*) options= ;;
+esac
+root=
+label=
+for arg do
+	if [ -n "$label" ] && [ "$arg" = root ]; then
+		root=1
+	fi
+	label=
+	case $arg in
+		-L | --label) label=1 ;;
+		-Lroot | --label=root) root=1 ;;
+	esac
+done
+if [ -z "$root" ] || [ -z "$options" ]; then
+	exec /usr/sbin/mkfs."$fs" "$@"
+fi
+echo "podman-bootc: adding mkfs.$fs options: $options" 1>&2
+: >"$PODMAN_BOOTC_MKFS_APPLIED"
+set -f
+# shellcheck disable=SC2086
+exec /usr/sbin/mkfs."$fs" $options "$@"
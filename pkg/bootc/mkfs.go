@@ -0,0 +1,211 @@
+package bootc
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// mkfsWrapper replaces mkfs.<fs> in the install container to add the mkfs
+// options of the root filesystem. bootc has no install option for them, it
+// runs mkfs.<fs> from $PATH.
+//
+//go:embed mkfs-wrapper.sh
+var mkfsWrapper []byte
+
+// mkfsAppliedFile is created in the cache entry by the wrapper when it adds
+// the options, bootc versions not running mkfs from $PATH never create it
+const mkfsAppliedFile = ".podman-bootc-mkfs-applied"
+
+// mkfsOptionRegexp matches the characters allowed in mkfs options, they are
+// split on whitespace by the wrapper and must not reach a shell or a path
+var mkfsOptionRegexp = regexp.MustCompile(`^[A-Za-z0-9=,._:+-]+$`)
+
+// refusedMkfsOptions are the options of each mkfs breaking the install or
+// reading host files
+var refusedMkfsOptions = map[string]map[string]string{
+	"xfs": {
+		"-L": "sets the label bootc mounts the root by",
+		"-N": "only prints the geometry",
+		"-p": "populates the filesystem from a host file",
+	},
+	"ext4": {
+		"-L": "sets the label bootc mounts the root by",
+		"-n": "only prints the geometry",
+		"-d": "populates the filesystem from a host directory",
+		"-U": "sets the UUID bootc generates",
+	},
+	"btrfs": {
+		"-L":        "sets the label bootc mounts the root by",
+		"--label":   "sets the label bootc mounts the root by",
+		"-r":        "populates the filesystem from a host directory",
+		"--rootdir": "populates the filesystem from a host directory",
+		"-U":        "sets the UUID bootc generates",
+		"--uuid":    "sets the UUID bootc generates",
+	},
+}
+
+// ParseFilesystemOptions parses the --mkfs-option settings, fs=options
+// with the mkfs options of the root filesystem fs, e.g. xfs=-i size=1024
+func ParseFilesystemOptions(settings []string) (map[string]string, error) {
+	if len(settings) == 0 {
+		return nil, nil
+	}
+	options := map[string]string{}
+	for _, setting := range settings {
+		fs, value, ok := strings.Cut(setting, "=")
+		if !ok || strings.ContainsAny(fs, " -") {
+			return nil, fmt.Errorf("invalid mkfs option %q, expected filesystem=options", setting)
+		}
+		fs = strings.ToLower(strings.TrimSpace(fs))
+		if previous, ok := options[fs]; ok {
+			value = previous + " " + value
+		}
+		options[fs] = value
+	}
+	return options, nil
+}
+
+// normalizeFilesystemOptions returns a copy of the options with lowercase
+// filesystems and the options separated by single spaces
+func normalizeFilesystemOptions(options map[string]string) map[string]string {
+	if len(options) == 0 {
+		return nil
+	}
+	normalized := make(map[string]string, len(options))
+	for fs, value := range options {
+		normalized[strings.ToLower(strings.TrimSpace(fs))] = strings.Join(strings.Fields(value), " ")
+	}
+	return normalized
+}
+
+// checkMkfsOptions refuses the mkfs options of fs which are not a plain list
+// of flags and values, or which break the install
+func checkMkfsOptions(fs, options string) error {
+	if !contains(installFilesystems, fs) {
+		return fmt.Errorf("unsupported filesystem %q, use one of %s", fs, strings.Join(installFilesystems, ", "))
+	}
+	fields := strings.Fields(options)
+	if len(fields) == 0 {
+		return fmt.Errorf("no mkfs.%s options", fs)
+	}
+	for _, field := range fields {
+		if !mkfsOptionRegexp.MatchString(field) {
+			return fmt.Errorf("mkfs.%s option %q has characters other than letters, digits and =,._:+-", fs, field)
+		}
+		name, _, _ := strings.Cut(field, "=")
+		if reason, ok := refusedMkfsOptions[fs][name]; ok {
+			return fmt.Errorf("mkfs.%s option %s is not allowed, it %s", fs, name, reason)
+		}
+		if strings.Contains(field, "name=") {
+			return fmt.Errorf("mkfs.%s option %q names a file, the root filesystem is formatted on the disk image", fs, field)
+		}
+	}
+	return nil
+}
+
+// sortedFilesystems returns the filesystems of the options in order
+func sortedFilesystems(options map[string]string) []string {
+	filesystems := make([]string, 0, len(options))
+	for fs := range options {
+		filesystems = append(filesystems, fs)
+	}
+	sort.Strings(filesystems)
+	return filesystems
+}
+
+// equalFilesystemOptions reports if a and b have the same mkfs options
+func equalFilesystemOptions(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for fs, value := range a {
+		if other, ok := b[fs]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// filesystemOptions returns the mkfs options the disk was installed with
+func (m *DiskMeta) filesystemOptions() map[string]string {
+	if m.Inputs == nil {
+		return nil
+	}
+	return m.Inputs.FilesystemOptions
+}
+
+// writeMkfsWrapper writes the mkfs wrapper to a temporary file shared with
+// the install container. It returns an empty path without mkfs options.
+func (p *BootcDisk) writeMkfsWrapper(options map[string]string) (string, error) {
+	if len(options) == 0 {
+		return "", nil
+	}
+	os.Remove(filepath.Join(p.Directory, mkfsAppliedFile))
+	mkfsTemp, err := os.CreateTemp(p.Directory, "mkfs-wrapper")
+	if err != nil {
+		return "", fmt.Errorf("temp mkfs wrapper: %w", err)
+	}
+	defer mkfsTemp.Close()
+	if _, err := mkfsTemp.Write(mkfsWrapper); err != nil {
+		os.Remove(mkfsTemp.Name())
+		return "", fmt.Errorf("temp mkfs wrapper copy: %w", err)
+	}
+	if err := mkfsTemp.Chmod(0o755); err != nil {
+		os.Remove(mkfsTemp.Name())
+		return "", fmt.Errorf("temp mkfs wrapper chmod: %w", err)
+	}
+	return mkfsTemp.Name(), nil
+}
+
+// removeMkfsWrapper removes the file written by writeMkfsWrapper, the
+// containers created afterwards run the mkfs of the image
+func (p *BootcDisk) removeMkfsWrapper() {
+	if p.mkfsWrapper != "" {
+		os.Remove(p.mkfsWrapper)
+	}
+	p.mkfsWrapper, p.mkfsOptions = "", nil
+}
+
+// mkfsWrapperSpec returns the mounts of the mkfs wrapper and the environment
+// passing it the options
+func mkfsWrapperSpec(wrapper string, options map[string]string) ([]specs.Mount, map[string]string) {
+	var mounts []specs.Mount
+	env := map[string]string{"PODMAN_BOOTC_MKFS_APPLIED": "/output/" + mkfsAppliedFile}
+	for _, fs := range sortedFilesystems(options) {
+		mounts = append(mounts, specs.Mount{
+			Source:      wrapper,
+			Destination: "/usr/local/sbin/mkfs." + fs,
+			Type:        "bind",
+			Options:     []string{"ro"},
+		})
+		env["PODMAN_BOOTC_MKFS_"+strings.ToUpper(fs)] = options[fs]
+	}
+	return mounts, env
+}
+
+// checkMkfsApplied fails when bootc formatted the root filesystem without
+// running the wrapper, the disk would silently lack the options
+func (p *BootcDisk) checkMkfsApplied(options map[string]string) error {
+	if len(options) == 0 {
+		return nil
+	}
+	marker := filepath.Join(p.Directory, mkfsAppliedFile)
+	err := os.Remove(marker)
+	if errors.Is(err, os.ErrNotExist) {
+		var tools []string
+		for _, fs := range sortedFilesystems(options) {
+			tools = append(tools, "mkfs."+fs)
+		}
+		return fmt.Errorf("the mkfs options were not applied: bootc %s did not format the root filesystem with %s from $PATH, either the root filesystem is another one or this bootc cannot take mkfs options",
+			p.bootcVersion, strings.Join(tools, " or "))
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package bootc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// phase is a step of Install, timed to explain where a deadline was spent
+type phase struct {
+	name    string
+	start   time.Time
+	elapsed time.Duration
+}
+
+// phaseTracker records the phases of Install, the heartbeat reads the
+// current one concurrently
+type phaseTracker struct {
+	mu     sync.Mutex
+	phases []phase
+	// onStart is called with the name of every phase started, if set
+	onStart func(name string)
+}
+
+// start ends the current phase and starts the named one
+func (t *phaseTracker) start(name string) {
+	t.mu.Lock()
+	t.endLocked()
+	t.phases = append(t.phases, phase{name: name, start: time.Now()})
+	t.mu.Unlock()
+	if t.onStart != nil {
+		t.onStart(name)
+	}
+}
+
+// end ends the current phase, if any
+func (t *phaseTracker) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.endLocked()
+}
+
+func (t *phaseTracker) endLocked() {
+	if n := len(t.phases); n > 0 && t.phases[n-1].elapsed == 0 {
+		t.phases[n-1].elapsed = time.Since(t.phases[n-1].start)
+	}
+}
+
+// current returns the phase in progress and when it started
+func (t *phaseTracker) current() (string, time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n := len(t.phases); n > 0 && t.phases[n-1].elapsed == 0 {
+		return t.phases[n-1].name, t.phases[n-1].start, true
+	}
+	return "", time.Time{}, false
+}
+
+// deadlineError explains err when ctx expired: the phase in progress and
+// the time taken by the completed ones
+func (t *phaseTracker) deadlineError(ctx context.Context, err error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) || len(t.phases) == 0 {
+		return err
+	}
+	current := t.phases[len(t.phases)-1]
+	var completed []string
+	for _, p := range t.phases[:len(t.phases)-1] {
+		completed = append(completed, fmt.Sprintf("%s %s", p.name, p.elapsed.Round(time.Millisecond)))
+	}
+	summary := "none"
+	if len(completed) > 0 {
+		summary = strings.Join(completed, ", ")
+	}
+	return fmt.Errorf("timed out while %s after %s (completed phases: %s): %w",
+		current.name, time.Since(current.start).Round(time.Millisecond), summary, err)
+}
@@ -0,0 +1,56 @@
+package bootc
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SetPinned pins or unpins the disk image of the cache entry in dir. A
+// pinned disk image is never removed by the prune policies, and rm requires
+// --force. The shared lock lets the VM of the entry keep running while it
+// keeps builds and removals out.
+func SetPinned(u user.User, dir string, pinned bool) error {
+	lock := utils.NewCacheLock(u.RunDir(), dir)
+	locked, err := lock.TryLock(utils.Shared)
+	if err != nil {
+		return fmt.Errorf("locking %s: %w", dir, err)
+	}
+	if !locked {
+		return ErrEntryInUse
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			logrus.Warnf("unable to unlock %s: %v", dir, err)
+		}
+	}()
+
+	diskPath := filepath.Join(dir, config.DiskImage)
+	meta, err := ReadDiskMeta(diskPath)
+	if err != nil {
+		return err
+	}
+	if meta.Pinned == pinned {
+		return nil
+	}
+	meta.Pinned = pinned
+	return WriteDiskMeta(diskPath, meta)
+}
+
+// IsPinned reports if the disk image of the cache entry in dir is pinned,
+// entries without a readable disk image are not
+func IsPinned(dir string) bool {
+	meta, err := ReadDiskMeta(filepath.Join(dir, config.DiskImage))
+	return err == nil && meta.Pinned
+}
+
+// PinProtects reports if the pin of the cache entry in dir protects it from
+// rm, force overrides it
+func PinProtects(dir string, force bool) bool {
+	return !force && IsPinned(dir)
+}
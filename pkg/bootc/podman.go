@@ -0,0 +1,198 @@
+package bootc
+
+import (
+	"context"
+	"io"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+
+	"github.com/blang/semver/v4"
+	"github.com/containers/podman/v5/libpod/define"
+	"github.com/containers/podman/v5/pkg/bindings"
+	"github.com/containers/podman/v5/pkg/bindings/containers"
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/containers/podman/v5/pkg/bindings/system"
+	"github.com/containers/podman/v5/pkg/domain/entities/reports"
+	"github.com/containers/podman/v5/pkg/domain/entities/types"
+	"github.com/containers/podman/v5/pkg/specgen"
+)
+
+// podmanClient is the subset of the podman bindings used to create a disk
+// image, it allows replacing the podman service in tests
+type podmanClient interface {
+	PullImage(ctx context.Context, rawImage string, options *images.PullOptions) ([]string, error)
+	ImageExists(ctx context.Context, nameOrId string, options *images.ExistsOptions) (bool, error)
+	GetImage(ctx context.Context, nameOrId string, options *images.GetOptions) (*types.ImageInspectReport, error)
+	ListImages(ctx context.Context, options *images.ListOptions) ([]*types.ImageSummary, error)
+	RemoveImage(ctx context.Context, ids []string, options *images.RemoveOptions) (*types.ImageRemoveReport, []error)
+	TagImage(ctx context.Context, nameOrId, tag, repo string, options *images.TagOptions) error
+	UntagImage(ctx context.Context, nameOrId, tag, repo string, options *images.UntagOptions) error
+	CreateContainer(ctx context.Context, s *specgen.SpecGenerator, options *containers.CreateOptions) (types.ContainerCreateResponse, error)
+	StartContainer(ctx context.Context, id string, options *containers.StartOptions) error
+	AttachContainer(ctx context.Context, id string, stdin io.Reader, stdout io.Writer, stderr io.Writer, attachReady chan bool, options *containers.AttachOptions) error
+	WaitContainer(ctx context.Context, id string, options *containers.WaitOptions) (int32, error)
+	RemoveContainer(ctx context.Context, id string, options *containers.RemoveOptions) ([]*reports.RmReport, error)
+	// ServiceVersion returns the API version of the podman service, nil if unknown
+	ServiceVersion(ctx context.Context) *semver.Version
+	SystemInfo(ctx context.Context, options *system.InfoOptions) (*define.Info, error)
+}
+
+// bindingsClient talks to the podman service through the podman bindings
+type bindingsClient struct{}
+
+func (bindingsClient) ServiceVersion(ctx context.Context) *semver.Version {
+	version := bindings.ServiceVersion(ctx)
+	if version.Equals(semver.Version{}) {
+		return nil
+	}
+	return version
+}
+
+func (bindingsClient) SystemInfo(ctx context.Context, options *system.InfoOptions) (*define.Info, error) {
+	return system.Info(ctx, options)
+}
+
+func (bindingsClient) PullImage(ctx context.Context, rawImage string, options *images.PullOptions) ([]string, error) {
+	return images.Pull(ctx, rawImage, options)
+}
+
+func (bindingsClient) ImageExists(ctx context.Context, nameOrId string, options *images.ExistsOptions) (bool, error) {
+	return images.Exists(ctx, nameOrId, options)
+}
+
+func (bindingsClient) GetImage(ctx context.Context, nameOrId string, options *images.GetOptions) (*types.ImageInspectReport, error) {
+	return images.GetImage(ctx, nameOrId, options)
+}
+
+func (bindingsClient) ListImages(ctx context.Context, options *images.ListOptions) ([]*types.ImageSummary, error) {
+	return images.List(ctx, options)
+}
+
+func (bindingsClient) RemoveImage(ctx context.Context, ids []string, options *images.RemoveOptions) (*types.ImageRemoveReport, []error) {
+	return images.Remove(ctx, ids, options)
+}
+
+func (bindingsClient) TagImage(ctx context.Context, nameOrId, tag, repo string, options *images.TagOptions) error {
+	return images.Tag(ctx, nameOrId, tag, repo, options)
+}
+
+func (bindingsClient) UntagImage(ctx context.Context, nameOrId, tag, repo string, options *images.UntagOptions) error {
+	return images.Untag(ctx, nameOrId, tag, repo, options)
+}
+
+func (bindingsClient) CreateContainer(ctx context.Context, s *specgen.SpecGenerator, options *containers.CreateOptions) (types.ContainerCreateResponse, error) {
+	return containers.CreateWithSpec(ctx, s, options)
+}
+
+func (bindingsClient) StartContainer(ctx context.Context, id string, options *containers.StartOptions) error {
+	return containers.Start(ctx, id, options)
+}
+
+func (bindingsClient) AttachContainer(ctx context.Context, id string, stdin io.Reader, stdout io.Writer, stderr io.Writer, attachReady chan bool, options *containers.AttachOptions) error {
+	return containers.Attach(ctx, id, stdin, stdout, stderr, attachReady, options)
+}
+
+func (bindingsClient) WaitContainer(ctx context.Context, id string, options *containers.WaitOptions) (int32, error) {
+	return containers.Wait(ctx, id, options)
+}
+
+func (bindingsClient) RemoveContainer(ctx context.Context, id string, options *containers.RemoveOptions) ([]*reports.RmReport, error) {
+	return containers.Remove(ctx, id, options)
+}
+
+// contextClient derives the context of every call from the one passed by
+// the caller, the context of the build. The calls doing the work of the
+// build are cancelled with it, and whatever the bindings started for a call,
+// e.g. the stream of an attach or a pull, is released when the call returns.
+// Removing a container cleans up after the build, it runs on a context
+// without the cancellation so it completes after an interrupt or a deadline.
+type contextClient struct {
+	client podmanClient
+}
+
+// call returns the context of a call doing the work of the build
+func call(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithCancel(ctx)
+}
+
+func (c contextClient) ServiceVersion(ctx context.Context) *semver.Version {
+	ctx, cancel := call(ctx)
+	defer cancel()
+	return c.client.ServiceVersion(ctx)
+}
+
+func (c contextClient) SystemInfo(ctx context.Context, options *system.InfoOptions) (*define.Info, error) {
+	ctx, cancel := call(ctx)
+	defer cancel()
+	return c.client.SystemInfo(ctx, options)
+}
+
+func (c contextClient) PullImage(ctx context.Context, rawImage string, options *images.PullOptions) ([]string, error) {
+	ctx, cancel := call(ctx)
+	defer cancel()
+	return c.client.PullImage(ctx, rawImage, options)
+}
+
+func (c contextClient) ImageExists(ctx context.Context, nameOrId string, options *images.ExistsOptions) (bool, error) {
+	ctx, cancel := call(ctx)
+	defer cancel()
+	return c.client.ImageExists(ctx, nameOrId, options)
+}
+
+func (c contextClient) GetImage(ctx context.Context, nameOrId string, options *images.GetOptions) (*types.ImageInspectReport, error) {
+	ctx, cancel := call(ctx)
+	defer cancel()
+	return c.client.GetImage(ctx, nameOrId, options)
+}
+
+func (c contextClient) ListImages(ctx context.Context, options *images.ListOptions) ([]*types.ImageSummary, error) {
+	ctx, cancel := call(ctx)
+	defer cancel()
+	return c.client.ListImages(ctx, options)
+}
+
+func (c contextClient) RemoveImage(ctx context.Context, ids []string, options *images.RemoveOptions) (*types.ImageRemoveReport, []error) {
+	ctx, cancel := call(ctx)
+	defer cancel()
+	return c.client.RemoveImage(ctx, ids, options)
+}
+
+func (c contextClient) TagImage(ctx context.Context, nameOrId, tag, repo string, options *images.TagOptions) error {
+	ctx, cancel := call(ctx)
+	defer cancel()
+	return c.client.TagImage(ctx, nameOrId, tag, repo, options)
+}
+
+func (c contextClient) UntagImage(ctx context.Context, nameOrId, tag, repo string, options *images.UntagOptions) error {
+	ctx, cancel := call(ctx)
+	defer cancel()
+	return c.client.UntagImage(ctx, nameOrId, tag, repo, options)
+}
+
+func (c contextClient) CreateContainer(ctx context.Context, s *specgen.SpecGenerator, options *containers.CreateOptions) (types.ContainerCreateResponse, error) {
+	ctx, cancel := call(ctx)
+	defer cancel()
+	return c.client.CreateContainer(ctx, s, options)
+}
+
+func (c contextClient) StartContainer(ctx context.Context, id string, options *containers.StartOptions) error {
+	ctx, cancel := call(ctx)
+	defer cancel()
+	return c.client.StartContainer(ctx, id, options)
+}
+
+func (c contextClient) AttachContainer(ctx context.Context, id string, stdin io.Reader, stdout io.Writer, stderr io.Writer, attachReady chan bool, options *containers.AttachOptions) error {
+	ctx, cancel := call(ctx)
+	defer cancel()
+	return c.client.AttachContainer(ctx, id, stdin, stdout, stderr, attachReady, options)
+}
+
+func (c contextClient) WaitContainer(ctx context.Context, id string, options *containers.WaitOptions) (int32, error) {
+	ctx, cancel := call(ctx)
+	defer cancel()
+	return c.client.WaitContainer(ctx, id, options)
+}
+
+func (c contextClient) RemoveContainer(ctx context.Context, id string, options *containers.RemoveOptions) ([]*reports.RmReport, error) {
+	return c.client.RemoveContainer(utils.WithoutCancel(ctx), id, options)
+}
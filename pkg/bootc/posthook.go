@@ -0,0 +1,89 @@
+package bootc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// postInstallHookTail is the end of the hook output kept for its error
+const postInstallHookTail = 4096
+
+// readPostInstallHook checks that the post-install hook at path is an
+// executable file and returns its sha256 digest
+func readPostInstallHook(path string) (string, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !st.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", path)
+	}
+	if st.Mode().Perm()&0o111 == 0 {
+		return "", fmt.Errorf("%s is not executable", path)
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// postInstallHookDigest returns the digest of the post-install hook the disk
+// was modified with, if any
+func (m *DiskMeta) postInstallHookDigest() string {
+	if m.Inputs == nil {
+		return ""
+	}
+	return m.Inputs.PostInstallHookDigest
+}
+
+// postInstallHookEnv returns the environment of the post-install hook, the
+// one of podman-bootc with the variables describing the disk
+func (p *BootcDisk) postInstallHookEnv(diskPath string) []string {
+	return append(os.Environ(),
+		"PODMAN_BOOTC_DISK="+diskPath,
+		"PODMAN_BOOTC_IMAGE="+p.RepoTag,
+		"PODMAN_BOOTC_IMAGE_ID="+p.ImageId,
+		"PODMAN_BOOTC_CACHE_DIR="+p.Directory,
+	)
+}
+
+// runPostInstallHook runs the post-install hook on the host with the raw
+// temporary disk as its argument, before the metadata is written and the
+// disk is moved in place. It fails when the hook exits with a non-zero
+// status, the caller then removes the temporary disk.
+func (p *BootcDisk) runPostInstallHook(diskConfig DiskImageConfig) error {
+	if diskConfig.PostInstallHook == "" {
+		return nil
+	}
+	p.progressf("Running the post-install hook %s", diskConfig.PostInstallHook)
+	diskPath := p.file.Name()
+	logrus.Debugf("running the post-install hook: %s %s", diskConfig.PostInstallHook, diskPath)
+	cmd := exec.CommandContext(p.Ctx, diskConfig.PostInstallHook, diskPath)
+	cmd.Dir = p.Directory
+	cmd.Env = p.postInstallHookEnv(diskPath)
+
+	tail := utils.NewTailBuffer(postInstallHookTail)
+	var stdout, stderr io.Writer = tail, tail
+	if p.verbosity.showInstallOutput() {
+		stdout, stderr = io.MultiWriter(p.out(), tail), io.MultiWriter(os.Stderr, tail)
+	}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Run(); err != nil {
+		if output := strings.TrimSpace(tail.String()); output != "" {
+			return fmt.Errorf("the post-install hook %s failed: %w: %s", diskConfig.PostInstallHook, err, output)
+		}
+		return fmt.Errorf("the post-install hook %s failed: %w", diskConfig.PostInstallHook, err)
+	}
+	return nil
+}
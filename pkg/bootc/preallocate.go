@@ -0,0 +1,93 @@
+package bootc
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/docker/go-units"
+	"github.com/sirupsen/logrus"
+)
+
+// The values of --preallocation
+const (
+	// PreallocationSparse only sets the size of the disk image, its blocks
+	// are allocated as bootc install writes them
+	PreallocationSparse = "sparse"
+	// PreallocationFalloc allocates the blocks with fallocate(2), falling
+	// back to sparse on filesystems without it
+	PreallocationFalloc = "falloc"
+	// PreallocationFull writes zeros to the whole disk image
+	PreallocationFull = "full"
+)
+
+var preallocationModes = []string{PreallocationSparse, PreallocationFalloc, PreallocationFull}
+
+// zeroFillChunk is the size of the writes of PreallocationFull
+const zeroFillChunk = 4 * 1024 * 1024
+
+// errFallocateUnsupported is returned by fallocate on the platforms without it
+var errFallocateUnsupported = errors.New("fallocate is not supported")
+
+// preallocate allocates the blocks of the temporary disk of size bytes with
+// mode, and returns the mode it was allocated with
+func (p *BootcDisk) preallocate(mode string, size int64) (string, error) {
+	switch mode {
+	case PreallocationFalloc:
+		err := fallocate(p.file, size)
+		switch {
+		case err == nil:
+			return mode, nil
+		case errors.Is(err, syscall.EOPNOTSUPP), errors.Is(err, errFallocateUnsupported):
+			logrus.Warnf("the filesystem of %s does not support fallocate, the disk image is sparse", p.Directory)
+			return PreallocationSparse, nil
+		}
+		return "", p.preallocationError(size, err)
+	case PreallocationFull:
+		if err := p.zeroFill(size); err != nil {
+			return "", p.preallocationError(size, err)
+		}
+		return mode, nil
+	}
+	return PreallocationSparse, nil
+}
+
+// preallocationError explains a failed preallocation
+func (p *BootcDisk) preallocationError(size int64, err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("the filesystem of %s has no space for a preallocated %s disk image: %w", p.Directory, units.HumanSize(float64(size)), err)
+	}
+	return fmt.Errorf("preallocating the disk image: %w", err)
+}
+
+// zeroFill writes zeros to the size bytes of the temporary disk, reporting
+// the progress every 10%
+func (p *BootcDisk) zeroFill(size int64) error {
+	zeros := make([]byte, zeroFillChunk)
+	reported := int64(0)
+	for offset := int64(0); offset < size; offset += zeroFillChunk {
+		if err := p.Ctx.Err(); err != nil {
+			return err
+		}
+		chunk := zeros
+		if remaining := size - offset; remaining < zeroFillChunk {
+			chunk = zeros[:remaining]
+		}
+		if _, err := p.file.WriteAt(chunk, offset); err != nil {
+			return err
+		}
+		if percent := (offset + int64(len(chunk))) * 100 / size; percent >= reported+10 {
+			reported = percent - percent%10
+			p.progressf("Preallocating the disk image: %d%% of %s", reported, units.HumanSize(float64(size)))
+		}
+	}
+	return nil
+}
+
+// preallocation returns the preallocation mode of config, sparse when unset
+func (c DiskImageConfig) preallocation() string {
+	if c.Preallocation == "" {
+		return PreallocationSparse
+	}
+	return c.Preallocation
+}
@@ -0,0 +1,10 @@
+package bootc
+
+import (
+	"os"
+)
+
+// fallocate is not supported, the disk image stays sparse
+func fallocate(f *os.File, size int64) error {
+	return errFallocateUnsupported
+}
@@ -0,0 +1,12 @@
+package bootc
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fallocate allocates the blocks of the size bytes of f
+func fallocate(f *os.File, size int64) error {
+	return unix.Fallocate(int(f.Fd()), 0, 0, size)
+}
@@ -0,0 +1,86 @@
+package bootc
+
+import "sync"
+
+// ProgressStep is a coarse step of Install, as shown by interactive progress
+// displays
+type ProgressStep int
+
+const (
+	// StepPull pulls the image and the installer image
+	StepPull ProgressStep = iota
+	// StepAllocate looks up the cache and allocates the disk image
+	StepAllocate
+	// StepInstall runs bootc install
+	StepInstall
+	// StepFinalize verifies, commits and post-processes the disk image
+	StepFinalize
+)
+
+// ProgressSteps are the steps of Install, in order
+var ProgressSteps = []ProgressStep{StepPull, StepAllocate, StepInstall, StepFinalize}
+
+func (s ProgressStep) String() string {
+	switch s {
+	case StepPull:
+		return "pull"
+	case StepAllocate:
+		return "allocate"
+	case StepInstall:
+		return "install"
+	case StepFinalize:
+		return "finalize"
+	}
+	return "unknown"
+}
+
+// ProgressEvent is the state of Install sent to the progress hook on every
+// change. The plain output is unchanged, the events only mirror it.
+type ProgressEvent struct {
+	Step ProgressStep
+	// Phase is the phase in progress, as named by the heartbeat
+	Phase string
+	// Message is the phase update line printed with the event, if any
+	Message string
+	// PulledLayers is the number of layers the current pull started copying
+	PulledLayers int
+	// PulledBytes is the size of the pulled image, set once the pull completed
+	PulledBytes int64
+	// InstallPhase is the phase of bootc install recognized in its output
+	InstallPhase InstallPhase
+}
+
+// progressState is the last event sent to the progress hook, pulls update
+// it from the goroutine streaming their output
+type progressState struct {
+	mu    sync.Mutex
+	event ProgressEvent
+}
+
+// SetProgressHook sets the function receiving the progress events of
+// Install. It is called synchronously and must not block.
+func (p *BootcDisk) SetProgressHook(hook func(ProgressEvent)) {
+	p.progressHook = hook
+}
+
+// updateProgress applies update to the progress state of Install and sends
+// the result to the progress hook, if any
+func (p *BootcDisk) updateProgress(update func(e *ProgressEvent)) {
+	if p.progressHook == nil || p.progress == nil {
+		return
+	}
+	p.progress.mu.Lock()
+	update(&p.progress.event)
+	event := p.progress.event
+	// The message only goes with its own event
+	p.progress.event.Message = ""
+	p.progress.mu.Unlock()
+	p.progressHook(event)
+}
+
+// setProgressStep moves the progress to step
+func (p *BootcDisk) setProgressStep(step ProgressStep) {
+	p.updateProgress(func(e *ProgressEvent) {
+		e.Step = step
+	})
+}
@@ -0,0 +1,114 @@
+package bootc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	renameAttempts = 5
+	renameBackoff  = 100 * time.Millisecond
+	// tempMetaSuffix is appended to the path of a completed temporary disk
+	// which could not be moved in place to store its metadata
+	tempMetaSuffix = ".meta.json"
+)
+
+// PromotionError is returned when a completed disk image could not be moved
+// in place, it is kept to be adopted without rebuilding it
+type PromotionError struct {
+	TempPath string
+	DiskPath string
+	Err      error
+}
+
+func (e *PromotionError) Error() string {
+	return fmt.Sprintf("the disk image was built but could not be moved to %s: %v; it is kept at %s, "+
+		"move it there or rerun with --adopt-temp", e.DiskPath, e.Err, e.TempPath)
+}
+
+func (e *PromotionError) Unwrap() error {
+	return e.Err
+}
+
+// isPromotionError reports if err kept the temporary disk
+func isPromotionError(err error) bool {
+	var promotionErr *PromotionError
+	return errors.As(err, &promotionErr)
+}
+
+// renameWithRetry renames the file, retrying while something briefly holds
+// the target open, e.g. an indexer or a virus scanner
+func renameWithRetry(src, dst string) error {
+	backoff := renameBackoff
+	var err error
+	for attempt := 1; attempt <= renameAttempts; attempt++ {
+		err = os.Rename(src, dst)
+		if err == nil || !(errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.ETXTBSY)) {
+			return err
+		}
+		logrus.Debugf("renaming %s failed (attempt %d of %d): %v", src, attempt, renameAttempts, err)
+		if attempt < renameAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return err
+}
+
+// keepTempDisk stores the metadata of the completed temporary disk next to
+// it so it can be adopted later
+func keepTempDisk(tempPath, diskPath string, buf []byte, renameErr error) error {
+	if err := os.WriteFile(tempPath+tempMetaSuffix, buf, 0o644); err != nil {
+		return fmt.Errorf("failed to rename to %s: %w", diskPath, renameErr)
+	}
+	return &PromotionError{TempPath: tempPath, DiskPath: diskPath, Err: renameErr}
+}
+
+// adoptTempDisk moves a completed temporary disk of the image, kept after a
+// failed promotion, in place. It returns false when there is none.
+func (p *BootcDisk) adoptTempDisk() (bool, error) {
+	matches, err := filepath.Glob(filepath.Join(p.Directory, tempDiskPrefix+"*"+tempMetaSuffix))
+	if err != nil {
+		return false, err
+	}
+	for _, metaPath := range matches {
+		buf, err := os.ReadFile(metaPath)
+		if err != nil {
+			return false, err
+		}
+		var meta DiskMeta
+		if err := json.Unmarshal(buf, &meta); err != nil {
+			logrus.Warnf("ignoring %s: %v", metaPath, err)
+			continue
+		}
+		if meta.ImageDigest != p.ImageId {
+			continue
+		}
+
+		tempPath := strings.TrimSuffix(metaPath, tempMetaSuffix)
+		diskPath := filepath.Join(p.Directory, config.DiskImage)
+		if err := renameWithRetry(tempPath, diskPath); err != nil {
+			return false, fmt.Errorf("adopting %s: %w", tempPath, err)
+		}
+		if err := WriteDiskMeta(diskPath, &meta); err != nil {
+			return false, fmt.Errorf("adopting %s: %w", tempPath, err)
+		}
+		if err := os.Remove(metaPath); err != nil {
+			logrus.Warnf("unable to remove %s: %v", metaPath, err)
+		}
+		removeBuildProgress(tempPath)
+		p.progressf("Adopted the disk image built in %s", tempPath)
+		return true, nil
+	}
+	return false, nil
+}
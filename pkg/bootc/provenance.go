@@ -0,0 +1,211 @@
+package bootc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	inTotoStatementType = "https://in-toto.io/Statement/v1"
+	slsaProvenanceType  = "https://slsa.dev/provenance/v1"
+	provenanceBuildType = "https://gitlab.com/bootc-org/podman-bootc/disk-image@v1"
+	provenanceBuilderId = "https://gitlab.com/bootc-org/podman-bootc"
+	// provenanceSignatureSuffix is appended to the provenance file for its cosign signature
+	provenanceSignatureSuffix = ".sig"
+)
+
+// Provenance is an in-toto statement with a SLSA provenance predicate
+// linking the disk image to the container image and options it was built from
+type Provenance struct {
+	Type          string              `json:"_type"`
+	Subject       []ProvenanceSubject `json:"subject"`
+	PredicateType string              `json:"predicateType"`
+	Predicate     ProvenancePredicate `json:"predicate"`
+}
+
+// ProvenanceSubject is an artifact and its digests
+type ProvenanceSubject struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+// ProvenancePredicate describes how the disk image was built
+type ProvenancePredicate struct {
+	BuildDefinition struct {
+		BuildType            string              `json:"buildType"`
+		ExternalParameters   *BuildInputs        `json:"externalParameters"`
+		ResolvedDependencies []ProvenanceSubject `json:"resolvedDependencies"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			Id      string            `json:"id"`
+			Version map[string]string `json:"version,omitempty"`
+		} `json:"builder"`
+		Metadata struct {
+			StartedOn  time.Time `json:"startedOn"`
+			FinishedOn time.Time `json:"finishedOn"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+// builderVersion returns the version of podman-bootc from the build info
+func builderVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// fileSHA256 returns the hex sha256 of the contents of the file
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// newProvenance returns the provenance of the disk image with the metadata
+func newProvenance(diskSHA256 string, meta *DiskMeta, started time.Time) *Provenance {
+	prov := &Provenance{
+		Type: inTotoStatementType,
+		Subject: []ProvenanceSubject{
+			{Name: config.DiskImage, Digest: map[string]string{"sha256": diskSHA256}},
+		},
+		PredicateType: slsaProvenanceType,
+	}
+	def := &prov.Predicate.BuildDefinition
+	def.BuildType = provenanceBuildType
+	def.ExternalParameters = meta.Inputs
+	image := ProvenanceSubject{Name: meta.Repository, Digest: map[string]string{"sha256": meta.ImageDigest}}
+	if meta.ImageRef != "" {
+		image.URI = "oci://" + meta.ImageRef
+	}
+	def.ResolvedDependencies = []ProvenanceSubject{image}
+	if meta.InstallerDigest != "" {
+		installer := ProvenanceSubject{Name: "installer", Digest: map[string]string{"sha256": meta.InstallerDigest}}
+		if meta.Inputs != nil {
+			installer.Name = meta.Inputs.InstallerImage
+		}
+		def.ResolvedDependencies = append(def.ResolvedDependencies, installer)
+	}
+
+	run := &prov.Predicate.RunDetails
+	run.Builder.Id = provenanceBuilderId
+	run.Builder.Version = map[string]string{"podman-bootc": builderVersion()}
+	if meta.BootcVersion != "" {
+		run.Builder.Version["bootc"] = meta.BootcVersion
+	}
+	run.Metadata.StartedOn = started.UTC()
+	run.Metadata.FinishedOn = meta.Created.UTC()
+	return prov
+}
+
+// removeProvenance removes the provenance of a disk image being replaced
+func removeProvenance(dir string) error {
+	path := filepath.Join(dir, config.ProvenanceFile)
+	for _, p := range []string{path, path + provenanceSignatureSuffix} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeProvenance writes the provenance of the disk image beside it and
+// signs it with the cosign key, if any. A disk reused from the cache keeps
+// its provenance.
+func (p *BootcDisk) writeProvenance(signingKey string) error {
+	path := filepath.Join(p.Directory, config.ProvenanceFile)
+	if _, err := os.Stat(path); err == nil && p.cacheHit {
+		return nil
+	}
+
+	diskPath := filepath.Join(p.Directory, config.DiskImage)
+	meta, err := ReadDiskMeta(diskPath)
+	if err != nil {
+		return err
+	}
+	p.progressf("Writing the provenance of the disk image")
+	digest, err := fileSHA256(diskPath)
+	if err != nil {
+		return fmt.Errorf("hashing the disk image: %w", err)
+	}
+	started := meta.Created
+	if !p.cacheHit {
+		started = p.StartedAt
+	}
+	buf, err := json.MarshalIndent(newProvenance(digest, meta, started), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		return err
+	}
+
+	if signingKey == "" {
+		return nil
+	}
+	cmd := exec.CommandContext(p.Ctx, "cosign", "sign-blob", "--yes", "--key", signingKey,
+		"--output-signature", path+provenanceSignatureSuffix, path)
+	// cosign may ask for the password of the key
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	logrus.Debugf("signing the provenance: %v", cmd.Args)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("signing the provenance with cosign: %w", err)
+	}
+	return nil
+}
+
+// VerifyProvenance checks that the provenance in the file describes the
+// disk image, it returns the provenance
+func VerifyProvenance(provenancePath, diskPath string) (*Provenance, error) {
+	buf, err := os.ReadFile(provenancePath)
+	if err != nil {
+		return nil, err
+	}
+	var prov Provenance
+	if err := json.Unmarshal(buf, &prov); err != nil {
+		return nil, fmt.Errorf("parsing the provenance: %w", err)
+	}
+	if prov.Type != inTotoStatementType || prov.PredicateType != slsaProvenanceType {
+		return nil, fmt.Errorf("unsupported provenance %s of %s", prov.PredicateType, prov.Type)
+	}
+	var expected string
+	for _, s := range prov.Subject {
+		if s.Name == config.DiskImage {
+			expected = s.Digest["sha256"]
+		}
+	}
+	if expected == "" {
+		return nil, fmt.Errorf("the provenance does not describe %s", config.DiskImage)
+	}
+	digest, err := fileSHA256(diskPath)
+	if err != nil {
+		return nil, fmt.Errorf("hashing the disk image: %w", err)
+	}
+	if digest != expected {
+		return nil, fmt.Errorf("the provenance does not match the disk image: expected sha256 %s, got %s", expected, digest)
+	}
+	return &prov, nil
+}
@@ -0,0 +1,171 @@
+package bootc
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// PullErrorBlob is a layer which could not be fetched or verified
+	PullErrorBlob = "blob"
+	// PullErrorManifest is a manifest which could not be fetched
+	PullErrorManifest = "manifest"
+	// PullErrorAuth is a registry refusing the credentials
+	PullErrorAuth = "auth"
+)
+
+// pullAttempts and pullBackoff bound the retries of blob failures, variables
+// so the tests don't wait
+var (
+	pullAttempts = 3
+	pullBackoff  = 2 * time.Second
+)
+
+var (
+	copyingBlobRegexp = regexp.MustCompile(`Copying blob (?:sha256:)?([0-9a-f]{12,64})`)
+	blobDigestRegexp  = regexp.MustCompile(`blob (sha256:[0-9a-f]{64})`)
+	urlHostRegexp     = regexp.MustCompile(`https?://([^/\s"]+)`)
+)
+
+// PullError is a failed pull with the layer and the registry involved, when
+// the pull output names them
+type PullError struct {
+	Image string
+	// Registry is the host of the last URL attempted, or the registry of the image
+	Registry string
+	// Layer is the digest of the failing layer, if any
+	Layer string
+	// Kind is PullErrorBlob, PullErrorManifest, PullErrorAuth or empty
+	Kind string
+	Err  error
+}
+
+func (e *PullError) Error() string {
+	msg := "pulling " + e.Image
+	if e.Layer != "" {
+		msg += " failed on layer " + e.Layer
+	}
+	if e.Registry != "" {
+		msg += " from " + e.Registry
+	}
+	return fmt.Sprintf("%s: %v", msg, e.Err)
+}
+
+func (e *PullError) Unwrap() error {
+	return e.Err
+}
+
+// retryable reports if the pull may succeed when attempted again: blobs
+// missing or timing out on a CDN or a mirror often do, credentials and
+// manifests do not change between attempts
+func (e *PullError) retryable() bool {
+	if e.Kind != PullErrorBlob {
+		return false
+	}
+	msg := strings.ToLower(e.Err.Error())
+	for _, transient := range []string{"404", "not found", "timeout", "deadline exceeded", "connection reset", "unexpected eof"} {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// newPullError classifies the error of pulling image, layer is the last one
+// the progress output reported copying
+func newPullError(image, layer string, err error) *PullError {
+	msg := err.Error()
+	lower := strings.ToLower(msg)
+	e := &PullError{Image: image, Err: err}
+
+	if m := blobDigestRegexp.FindStringSubmatch(msg); m != nil {
+		e.Layer = m[1]
+	}
+	switch {
+	case strings.Contains(lower, "unauthorized") || strings.Contains(lower, "authentication required") ||
+		strings.Contains(lower, "denied") || strings.Contains(msg, "StatusCode: 401") || strings.Contains(msg, "StatusCode: 403"):
+		e.Kind = PullErrorAuth
+	case e.Layer != "" || strings.Contains(lower, "blob"):
+		e.Kind = PullErrorBlob
+	case strings.Contains(lower, "manifest"):
+		e.Kind = PullErrorManifest
+	}
+	if e.Kind == PullErrorBlob && e.Layer == "" {
+		e.Layer = layer
+	}
+
+	if hosts := urlHostRegexp.FindAllStringSubmatch(msg, -1); hosts != nil {
+		e.Registry = hosts[len(hosts)-1][1]
+	} else if named, err := reference.ParseNormalizedNamed(image); err == nil {
+		e.Registry = reference.Domain(named)
+	}
+	return e
+}
+
+// pullProgress forwards the progress of a pull and remembers the last layer
+// it started copying
+type pullProgress struct {
+	out    io.Writer
+	layer  string
+	layers int
+	// onLayers is called with the number of layers copied so far, if set
+	onLayers func(layers int)
+}
+
+func (w *pullProgress) Write(b []byte) (int, error) {
+	if m := copyingBlobRegexp.FindAllSubmatch(b, -1); m != nil {
+		w.layer = string(m[len(m)-1][1])
+		w.layers += len(m)
+		if w.onLayers != nil {
+			w.onLayers(w.layers)
+		}
+	}
+	return w.out.Write(b)
+}
+
+// pullOutput is where the progress of the pulls is shown, the output of the
+// build when one is set
+func (p *BootcDisk) pullOutput() io.Writer {
+	if !p.verbosity.showInstallOutput() {
+		return io.Discard
+	}
+	if p.output != nil {
+		return p.out()
+	}
+	return os.Stderr
+}
+
+// pull pulls the image, retrying with a backoff when a layer is missing or
+// timing out. Failures are returned as a *PullError.
+func (p *BootcDisk) pull(image, policy string) ([]string, error) {
+	backoff := pullBackoff
+	var pullErr *PullError
+	for attempt := 1; attempt <= pullAttempts; attempt++ {
+		progress := &pullProgress{out: p.pullOutput(), onLayers: func(layers int) {
+			p.updateProgress(func(e *ProgressEvent) { e.PulledLayers = layers })
+		}}
+		ids, err := p.podman().PullImage(p.Ctx, image, p.pullOptions(&policy).WithProgressWriter(progress))
+		if err == nil {
+			return ids, nil
+		}
+		pullErr = newPullError(image, progress.layer, err)
+		if !pullErr.retryable() || attempt == pullAttempts {
+			break
+		}
+		logrus.Warnf("%v, retrying in %s (attempt %d of %d)", pullErr, backoff, attempt, pullAttempts)
+		select {
+		case <-p.Ctx.Done():
+			return nil, pullErr
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return nil, pullErr
+}
@@ -0,0 +1,88 @@
+package bootc
+
+import (
+	"bufio"
+	"io"
+	"sync"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// pumpLogBufferSize is the amount of output buffered before writing it to the log
+const pumpLogBufferSize = 32 * 1024
+
+// outputPump receives the install container output. It keeps the end of
+// the output in a fixed-size buffer for diagnosing failures and streams the
+// output to the log. When writing the log fails, e.g. in a read-only
+// directory, the pump only keeps the end of the output instead of failing
+// the install or buffering the output in memory.
+type outputPump struct {
+	mu     sync.Mutex
+	tail   *utils.TailBuffer
+	log    *bufio.Writer
+	logErr error
+	last   byte
+	closed bool
+}
+
+// newOutputPump returns a pump keeping tailSize bytes and streaming to log
+func newOutputPump(tailSize int, log io.Writer) *outputPump {
+	return &outputPump{
+		tail: utils.NewTailBuffer(tailSize),
+		log:  bufio.NewWriterSize(log, pumpLogBufferSize),
+	}
+}
+
+// Write never fails, a failure to write the log only ends streaming to it
+func (o *outputPump) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.tail.Write(p)
+	if o.logErr == nil && !o.closed && len(p) > 0 {
+		if _, err := o.log.Write(p); err != nil {
+			o.degrade(err)
+		} else {
+			o.last = p[len(p)-1]
+		}
+	}
+	return len(p), nil
+}
+
+// degrade stops streaming to the log after err
+func (o *outputPump) degrade(err error) {
+	o.logErr = err
+	logrus.Warnf("unable to write the install output to the log, only keeping its end: %v", err)
+}
+
+// Close flushes the output to the log, ending the last line, and returns the
+// error writing the log if any. The pump keeps its tail after Close.
+func (o *outputPump) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return o.logErr
+	}
+	o.closed = true
+	if o.logErr != nil {
+		return o.logErr
+	}
+	if o.last != 0 && o.last != '\n' {
+		if err := o.log.WriteByte('\n'); err != nil {
+			o.degrade(err)
+			return err
+		}
+	}
+	if err := o.log.Flush(); err != nil {
+		o.degrade(err)
+	}
+	return o.logErr
+}
+
+// String returns the end of the output
+func (o *outputPump) String() string {
+	return o.tail.String()
+}
@@ -0,0 +1,154 @@
+package bootc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+
+	"github.com/docker/go-units"
+	"github.com/sirupsen/logrus"
+)
+
+// PrunePolicyImageCeiling prunes the oldest generations of an image using
+// more than its ceiling
+const PrunePolicyImageCeiling = "image-ceiling"
+
+// PrunedGeneration is a generation removed from the cache by a policy
+type PrunedGeneration struct {
+	Id         string `json:"id"`
+	Generation int    `json:"generation,omitempty"`
+	Size       int64  `json:"size"`
+	Policy     string `json:"policy"`
+}
+
+// PruneReport lists the generations pruned after a build
+type PruneReport struct {
+	Pruned []PrunedGeneration `json:"pruned,omitempty"`
+	// Pinned are the generations the policies skipped because they are
+	// pinned
+	Pinned []PrunedGeneration `json:"pinned,omitempty"`
+}
+
+// Reclaimed returns the space reclaimed by each policy
+func (r PruneReport) Reclaimed() map[string]int64 {
+	reclaimed := map[string]int64{}
+	for _, g := range r.Pruned {
+		reclaimed[g.Policy] += g.Size
+	}
+	return reclaimed
+}
+
+// Summary describes the reclaimed space per policy, e.g. "image-ceiling 12GB"
+func (r PruneReport) Summary() string {
+	reclaimed := r.Reclaimed()
+	policies := make([]string, 0, len(reclaimed))
+	for policy := range reclaimed {
+		policies = append(policies, policy)
+	}
+	sort.Strings(policies)
+	parts := make([]string, 0, len(policies))
+	for _, policy := range policies {
+		parts = append(parts, fmt.Sprintf("%s %s", policy, units.HumanSize(float64(reclaimed[policy]))))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// enforceImageCeiling removes the oldest generations of the repository of
+// the image until its generations use at most ceiling bytes. The current
+// generation, the pinned ones, the ones in use and the ones with a VM are
+// kept, even if the ceiling cannot be met.
+func (p *BootcDisk) enforceImageCeiling(ceiling int64) (PruneReport, error) {
+	return pruneGenerations(p.User, repositoryOf(p.RepoTag), p.Directory, ceiling, p.progressf)
+}
+
+// PruneGenerations removes the oldest generations of the repository until
+// they use at most ceiling bytes, keeping the newest one, the pinned ones and
+// the ones in use or with a VM
+func PruneGenerations(u user.User, repository string, ceiling int64) (PruneReport, error) {
+	generations, err := ListGenerations(u, repository)
+	if err != nil || len(generations) == 0 {
+		return PruneReport{}, err
+	}
+	return pruneGenerations(u, repository, generations[len(generations)-1].Directory, ceiling, logrus.Infof)
+}
+
+// pruneGenerations enforces the ceiling keeping the generation in current
+func pruneGenerations(u user.User, repository, current string, ceiling int64, progressf func(string, ...any)) (PruneReport, error) {
+	var report PruneReport
+	generations, err := ListGenerations(u, repository)
+	if err != nil {
+		return report, err
+	}
+
+	sizes := make([]int64, len(generations))
+	var total int64
+	for i, g := range generations {
+		if sizes[i], err = utils.DiskUsage(g.Directory); err != nil {
+			return report, fmt.Errorf("computing the size of %s: %w", g.Directory, err)
+		}
+		total += sizes[i]
+	}
+
+	// generations are sorted oldest first
+	for i, g := range generations {
+		if total <= ceiling {
+			break
+		}
+		if g.Directory == current {
+			continue
+		}
+		if g.Meta.Pinned {
+			report.Pinned = append(report.Pinned, PrunedGeneration{Id: g.Id, Generation: g.Number, Size: sizes[i], Policy: PrunePolicyImageCeiling})
+			progressf("Skipped pinned generation %d of %s (%s) over the image ceiling", g.Number, repository, units.HumanSize(float64(sizes[i])))
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(g.Directory, config.CfgFile)); err == nil {
+			logrus.Debugf("keeping generation %d of %s over the image ceiling, it has a VM", g.Number, repository)
+			continue
+		}
+		removed, err := removeUnusedGeneration(u.RunDir(), g.Directory)
+		if err != nil {
+			return report, err
+		}
+		if !removed {
+			logrus.Debugf("keeping generation %d of %s over the image ceiling, it is in use", g.Number, repository)
+			continue
+		}
+		total -= sizes[i]
+		report.Pruned = append(report.Pruned, PrunedGeneration{Id: g.Id, Generation: g.Number, Size: sizes[i], Policy: PrunePolicyImageCeiling})
+		progressf("Pruned generation %d of %s (%s), the image uses more than %s", g.Number, repository,
+			units.HumanSize(float64(sizes[i])), units.HumanSize(float64(ceiling)))
+	}
+	if total > ceiling {
+		logrus.Warnf("the disk images of %s use %s, more than the ceiling of %s, but the remaining ones are in use",
+			repository, units.HumanSize(float64(total)), units.HumanSize(float64(ceiling)))
+	}
+	return report, nil
+}
+
+// removeUnusedGeneration removes the cache entry in dir unless it is locked
+func removeUnusedGeneration(runDir, dir string) (bool, error) {
+	lock := utils.NewCacheLock(runDir, dir)
+	locked, err := lock.TryLock(utils.Exclusive)
+	if err != nil {
+		return false, fmt.Errorf("locking %s: %w", dir, err)
+	}
+	if !locked {
+		return false, nil
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			logrus.Errorf("unable to unlock %s: %v", dir, err)
+		}
+	}()
+	if err := os.RemoveAll(dir); err != nil {
+		return false, fmt.Errorf("removing %s: %w", dir, err)
+	}
+	return true, nil
+}
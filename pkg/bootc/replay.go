@@ -0,0 +1,126 @@
+package bootc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pinnedImageRef returns the image reference pinned by digest, preferring the
+// digest of the repository the image was pulled from
+func (p *BootcDisk) pinnedImageRef() string {
+	if p.imageData == nil || len(p.imageData.RepoDigests) == 0 {
+		return p.RepoTag
+	}
+	repository := repositoryOf(p.RepoTag)
+	for _, digest := range p.imageData.RepoDigests {
+		if repository != "" && strings.HasPrefix(digest, repository+"@") {
+			return digest
+		}
+	}
+	return p.imageData.RepoDigests[0]
+}
+
+// ReplayCommand returns a podman-bootc invocation rebuilding the disk image
+// from its metadata, and warnings about the inputs which are not reproducible
+func (m *DiskMeta) ReplayCommand() (string, []string) {
+	warnings := []string{"bootc install is not bit-for-bit reproducible, filesystem UUIDs and timestamps differ between builds"}
+
+	args := []string{"podman-bootc", "disk", "build"}
+	if in := m.Inputs; in != nil {
+		if in.Filesystem != "" {
+			args = append(args, "--filesystem", in.Filesystem)
+		}
+		if in.RootSizeMax != "" {
+			args = append(args, "--root-size-max", in.RootSizeMax)
+		}
+		if in.DiskSize != "" {
+			args = append(args, "--disk-size", in.DiskSize)
+		}
+		if in.MinDiskSize != "" {
+			args = append(args, "--disk-size-minimum", in.MinDiskSize)
+		}
+		if in.Preallocation != "" && in.Preallocation != PreallocationSparse {
+			args = append(args, "--preallocation", in.Preallocation)
+		}
+		if in.Format != "" {
+			args = append(args, "--disk-format", in.Format)
+		}
+		if in.BlockSetup != "" {
+			args = append(args, "--block-setup", in.BlockSetup)
+		}
+		for _, fs := range sortedFilesystems(in.FilesystemOptions) {
+			args = append(args, "--mkfs-option", fs+"="+in.FilesystemOptions[fs])
+		}
+		for _, karg := range in.Kargs {
+			args = append(args, "--karg", karg)
+		}
+		for _, arg := range in.ExtraInstallArgs {
+			args = append(args, "--install-arg="+arg)
+		}
+		if in.InstallConfig != "" {
+			args = append(args, "--install-config", in.InstallConfig)
+			warnings = append(warnings, fmt.Sprintf("the install configuration %s may have changed, the build used sha256 %s", in.InstallConfig, in.InstallConfigDigest))
+		}
+		if in.InstallMode != "" {
+			args = append(args, "--install-mode", in.InstallMode, "--install-target", in.InstallTarget)
+		}
+		if in.PostInstallHook != "" {
+			args = append(args, "--post-install-hook", in.PostInstallHook)
+			warnings = append(warnings, fmt.Sprintf("the post-install hook %s may have changed, the build used sha256 %s", in.PostInstallHook, in.PostInstallHookDigest))
+		}
+		for _, path := range in.RootSSHKeys {
+			args = append(args, "--root-ssh-key", path)
+		}
+		if len(in.RootSSHKeys) > 0 {
+			warnings = append(warnings, fmt.Sprintf("the root SSH keys may have changed, the build used sha256 %s", in.RootSSHKeysDigest))
+		}
+		if in.InstallerImage != "" {
+			args = append(args, "--installer-image", in.InstallerImage)
+			if !strings.Contains(in.InstallerImage, "@") {
+				warnings = append(warnings, fmt.Sprintf("the installer image %s is not pinned by digest, the build used image id %s", in.InstallerImage, m.InstallerDigest))
+			}
+		}
+		if in.BoundImages {
+			args = append(args, "--bound-images")
+			warnings = append(warnings, "the logically bound images are pulled again and may have changed")
+		}
+	} else {
+		warnings = append(warnings, "the disk image predates recorded build inputs, the install options are unknown")
+	}
+
+	if d := m.RunDefaults; d != nil {
+		if d.Memory != "" {
+			args = append(args, "--set-run-default", "mem="+d.Memory)
+		}
+		if d.CPUs > 0 {
+			args = append(args, "--set-run-default", "cpus="+strconv.Itoa(d.CPUs))
+		}
+		if d.TPM != nil {
+			args = append(args, "--set-run-default", "tpm="+strconv.FormatBool(*d.TPM))
+		}
+		for _, rule := range d.Publish {
+			args = append(args, "--set-run-default", "publish="+rule)
+		}
+	}
+
+	if m.UpgradedFrom != "" {
+		warnings = append(warnings, fmt.Sprintf("the disk image was upgraded from %s, the replay installs it from scratch", m.UpgradedFrom))
+	}
+	if m.BootcVersion == "" || m.BootcVersion == "unknown" {
+		warnings = append(warnings, "the bootc version used for the build is unknown")
+	} else {
+		warnings = append(warnings, fmt.Sprintf("the build used bootc %s, the replay uses the bootc shipped by the image", m.BootcVersion))
+	}
+
+	image := m.ImageRef
+	if !strings.Contains(image, "@") {
+		if image == "" {
+			image = m.ImageDigest
+		}
+		warnings = append(warnings, "the image was not pulled from a registry, the replay refers to the local image id")
+	}
+	args = append(args, image)
+
+	return shellQuote(args), warnings
+}
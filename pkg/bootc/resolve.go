@@ -0,0 +1,95 @@
+package bootc
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/pkg/shortnames"
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/containers/podman/v5/pkg/domain/entities/types"
+)
+
+// imageIdPattern matches image ids, which are never ambiguous
+var imageIdPattern = regexp.MustCompile(`^[a-f0-9]{12,64}$`)
+
+// imageCandidate is a local image matching a short name
+type imageCandidate struct {
+	repoTag string
+	id      string
+	created time.Time
+}
+
+func (c imageCandidate) String() string {
+	return fmt.Sprintf("%s (%s, created %s)", c.repoTag, shortID(c.id), c.created.Format(time.RFC3339))
+}
+
+// shortNameCandidates returns the local images whose tags match the short
+// name, one per image
+func shortNameCandidates(shortName string, list []*types.ImageSummary) []imageCandidate {
+	name, tag := shortName, "latest"
+	if i := strings.LastIndex(shortName, ":"); i > strings.LastIndex(shortName, "/") {
+		name, tag = shortName[:i], shortName[i+1:]
+	}
+
+	seen := make(map[string]bool)
+	var candidates []imageCandidate
+	for _, image := range list {
+		for _, repoTag := range image.RepoTags {
+			named, err := reference.ParseNormalizedNamed(repoTag)
+			if err != nil {
+				continue
+			}
+			tagged, ok := named.(reference.NamedTagged)
+			if !ok || tagged.Tag() != tag {
+				continue
+			}
+			path := reference.Path(named)
+			if path != name && !strings.HasSuffix(path, "/"+name) {
+				continue
+			}
+			if !seen[image.ID] {
+				seen[image.ID] = true
+				candidates = append(candidates, imageCandidate{repoTag: repoTag, id: image.ID, created: time.Unix(image.Created, 0)})
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].repoTag < candidates[j].repoTag })
+	return candidates
+}
+
+// resolveShortName makes sure a short name refers to a single local image:
+// it lets the user pick one of the matching images or fails with them when
+// there is no terminal
+func (p *BootcDisk) resolveShortName() error {
+	if imageIdPattern.MatchString(p.ImageNameOrId) || !shortnames.IsShortName(p.ImageNameOrId) {
+		return nil
+	}
+	list, err := p.podman().ListImages(p.Ctx, &images.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("listing the local images: %w", err)
+	}
+	candidates := shortNameCandidates(p.ImageNameOrId, list)
+	if len(candidates) < 2 {
+		return nil
+	}
+	options := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		options = append(options, c.String())
+	}
+	if !utils.IsInteractive() {
+		return fmt.Errorf("%s is ambiguous, use a fully qualified reference of one of the local images:\n  %s",
+			p.ImageNameOrId, strings.Join(options, "\n  "))
+	}
+	choice, err := utils.Choose(fmt.Sprintf("%s matches several local images:", p.ImageNameOrId), options)
+	if err != nil {
+		return err
+	}
+	p.ImageNameOrId = candidates[choice].repoTag
+	return nil
+}
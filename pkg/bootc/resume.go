@@ -0,0 +1,141 @@
+package bootc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/qcow2"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// progressSuffix is appended to the path of a temporary disk to record
+	// the phase its build reached
+	progressSuffix = ".progress.json"
+	// phaseInstalled means the install container completed successfully,
+	// only the verification and the promotion are left
+	phaseInstalled = "installed"
+)
+
+// buildProgress is the in-progress marker of a temporary disk
+type buildProgress struct {
+	ImageDigest string `json:"imageDigest"`
+	ConfigHash  string `json:"configHash"`
+	Phase       string `json:"phase"`
+}
+
+// markInstalled records that the install completed on the temporary disk,
+// a failure to finalize it keeps it to resume from there
+func (p *BootcDisk) markInstalled(diskConfig DiskImageConfig) error {
+	buf, err := json.Marshal(buildProgress{
+		ImageDigest: p.ImageId,
+		ConfigHash:  diskConfig.installHash(),
+		Phase:       phaseInstalled,
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p.file.Name()+progressSuffix, buf, 0o644)
+}
+
+// removeBuildProgress removes the in-progress marker of the temporary disk
+func removeBuildProgress(tempPath string) {
+	if err := os.Remove(tempPath + progressSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logrus.Warnf("unable to remove %s: %v", tempPath+progressSuffix, err)
+	}
+}
+
+// readBuildProgress returns the in-progress marker of the temporary disk,
+// or nil if there is none
+func readBuildProgress(tempPath string) *buildProgress {
+	buf, err := os.ReadFile(tempPath + progressSuffix)
+	if err != nil {
+		return nil
+	}
+	var progress buildProgress
+	if err := json.Unmarshal(buf, &progress); err != nil {
+		logrus.Debugf("ignoring %s: %v", tempPath+progressSuffix, err)
+		return nil
+	}
+	return &progress
+}
+
+// findResumableDisk returns the temporary disk left by an interrupted build
+// of the image with the same options whose install completed. Temporary
+// disks interrupted earlier are removed, the cache entry is locked so none of
+// them is in use. Disks waiting for --adopt-temp are left alone.
+func (p *BootcDisk) findResumableDisk(diskConfig DiskImageConfig) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(p.Directory, tempDiskPrefix+"*"))
+	if err != nil {
+		return "", err
+	}
+	resumable := ""
+	for _, path := range matches {
+		if strings.HasSuffix(path, progressSuffix) || strings.HasSuffix(path, tempMetaSuffix) {
+			continue
+		}
+		if _, err := os.Stat(path + tempMetaSuffix); err == nil {
+			continue
+		}
+		progress := readBuildProgress(path)
+		if resumable == "" && progress != nil && progress.Phase == phaseInstalled &&
+			progress.ImageDigest == p.ImageId && progress.ConfigHash == diskConfig.installHash() {
+			resumable = path
+			continue
+		}
+		logrus.Debugf("removing the temporary disk %s of an interrupted build", path)
+		if err := os.Remove(path); err != nil {
+			logrus.Warnf("unable to remove %s: %v", path, err)
+		}
+		removeBuildProgress(path)
+	}
+	return resumable, nil
+}
+
+// resumeInstalledDisk verifies and promotes the temporary disk of an
+// interrupted build whose install completed, it returns false if there is
+// none or the rebuild is forced. A temporary disk which cannot be promoted is
+// removed, retrying it would fail the same way on every build.
+func (p *BootcDisk) resumeInstalledDisk(diskConfig DiskImageConfig) (bool, error) {
+	if diskConfig.ForceRebuild {
+		return false, nil
+	}
+	path, err := p.findResumableDisk(diskConfig)
+	if err != nil || path == "" {
+		return false, err
+	}
+	p.file, err = os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	p.progressf("Resuming the interrupted build of %s, the install already completed", p.RepoTag)
+	meta := p.diskMeta(diskConfig)
+	if diskConfig.VerifyContent {
+		p.progressf("Verifying the contents of the disk image")
+		if qcow2.IsQcow2(p.file) {
+			meta.ContentVerification = &ContentVerification{Error: "the disk image was already converted to qcow2"}
+		} else {
+			meta.ContentVerification = p.verifyContent()
+		}
+	}
+	if err := p.commitDisk(meta); err != nil {
+		if isPromotionError(err) {
+			return false, fmt.Errorf("resuming the build from %s: %w", path, err)
+		}
+		logrus.Warnf("unable to resume the build from %s, installing again: %v", path, err)
+		p.file.Close()
+		p.file = nil
+		if err := os.Remove(path); err != nil {
+			logrus.Warnf("unable to remove %s: %v", path, err)
+		}
+		removeBuildProgress(path)
+		return false, nil
+	}
+	removeBuildProgress(path)
+	return true, p.reportVerification(meta.ContentVerification)
+}
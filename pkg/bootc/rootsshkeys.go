@@ -0,0 +1,79 @@
+package bootc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// rootSSHKeysFile is written next to the disk image during the install and
+// passed to bootc install --root-ssh-authorized-keys
+const rootSSHKeysFile = "podman-bootc-root-authorized-keys"
+
+// readRootSSHKeys concatenates the authorized keys files at paths, checking
+// that every line is an SSH public key
+func readRootSSHKeys(paths []string) (string, error) {
+	var keys bytes.Buffer
+	for _, path := range paths {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		found := false
+		for i, line := range bytes.Split(buf, []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 || line[0] == '#' {
+				continue
+			}
+			if bytes.Contains(line, []byte("PRIVATE KEY")) {
+				return "", fmt.Errorf("%s is a private key, pass the .pub file", path)
+			}
+			if _, _, _, _, err := ssh.ParseAuthorizedKey(line); err != nil {
+				return "", fmt.Errorf("%s: line %d is not an SSH public key: %w", path, i+1, err)
+			}
+			keys.Write(line)
+			keys.WriteByte('\n')
+			found = true
+		}
+		if !found {
+			return "", fmt.Errorf("%s has no SSH public key", path)
+		}
+	}
+	return keys.String(), nil
+}
+
+// sshKeysDigest returns the sha256 of the authorized keys, empty without keys
+func sshKeysDigest(keys string) string {
+	if keys == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(keys))
+	return hex.EncodeToString(sum[:])
+}
+
+// rootSSHKeysDigest returns the digest of the root authorized keys the disk
+// was installed with, if any
+func (m *DiskMeta) rootSSHKeysDigest() string {
+	if m.Inputs == nil {
+		return ""
+	}
+	return m.Inputs.RootSSHKeysDigest
+}
+
+// writeRootSSHKeys writes the root authorized keys of the install next to
+// the disk image, it returns a function removing them
+func (p *BootcDisk) writeRootSSHKeys(config DiskImageConfig) (func(), error) {
+	if config.rootSSHKeys == "" {
+		return func() {}, nil
+	}
+	path := filepath.Join(p.Directory, rootSSHKeysFile)
+	if err := os.WriteFile(path, []byte(config.rootSSHKeys), 0o644); err != nil {
+		return nil, fmt.Errorf("writing the root SSH keys: %w", err)
+	}
+	return func() { os.Remove(path) }, nil
+}
@@ -0,0 +1,75 @@
+package bootc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+)
+
+// RunDefaults are the VM options recorded at build time, which the run
+// path uses unless they are overridden by flags
+type RunDefaults struct {
+	Memory  string   `json:"memory,omitempty"`
+	CPUs    int      `json:"cpus,omitempty"`
+	TPM     *bool    `json:"tpm,omitempty"`
+	Publish []string `json:"publish,omitempty"`
+}
+
+// ParseRunDefaults parses key=value settings, supported keys are mem
+// (or memory), cpus, tpm and publish, which can be repeated
+func ParseRunDefaults(settings []string) (*RunDefaults, error) {
+	if len(settings) == 0 {
+		return nil, nil
+	}
+
+	defaults := &RunDefaults{}
+	for _, setting := range settings {
+		key, value, ok := strings.Cut(setting, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid run default %q, expected key=value", setting)
+		}
+		switch key {
+		case "mem", "memory":
+			if _, err := units.RAMInBytes(value); err != nil {
+				return nil, fmt.Errorf("invalid memory %q: %w", value, err)
+			}
+			defaults.Memory = value
+		case "cpus":
+			cpus, err := strconv.Atoi(value)
+			if err != nil || cpus < 1 {
+				return nil, fmt.Errorf("invalid number of cpus %q", value)
+			}
+			defaults.CPUs = cpus
+		case "tpm":
+			tpm, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tpm value %q: %w", value, err)
+			}
+			defaults.TPM = &tpm
+		case "publish":
+			if err := ValidatePublish(value); err != nil {
+				return nil, err
+			}
+			defaults.Publish = append(defaults.Publish, value)
+		default:
+			return nil, fmt.Errorf("unknown run default %q, use mem, cpus, tpm or publish", key)
+		}
+	}
+	return defaults, nil
+}
+
+// ValidatePublish checks a hostPort:guestPort publish rule
+func ValidatePublish(rule string) error {
+	host, guest, ok := strings.Cut(rule, ":")
+	if !ok {
+		return fmt.Errorf("invalid publish rule %q, expected hostPort:guestPort", rule)
+	}
+	for _, port := range []string{host, guest} {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid port %q in publish rule %q", port, rule)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package bootc
+
+import (
+	"path/filepath"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultGraphRoot is where the default storage.conf, which bootc images
+// ship, expects the container storage
+const defaultGraphRoot = "/var/lib/containers/storage"
+
+// graphRoot returns the graph root of the podman service, queried once. The
+// default is assumed when the service does not tell.
+func (p *BootcDisk) graphRoot() string {
+	if p.graphRootPath != "" {
+		return p.graphRootPath
+	}
+	p.graphRootPath = defaultGraphRoot
+	info, err := p.podman().SystemInfo(p.Ctx, nil)
+	if err != nil {
+		logrus.Warnf("unable to query the graph root of the podman service, assuming %s: %v", defaultGraphRoot, err)
+	} else if info.Store != nil && info.Store.GraphRoot != "" {
+		p.graphRootPath = filepath.Clean(info.Store.GraphRoot)
+	}
+	logrus.Debugf("the graph root of the podman service is %s", p.graphRootPath)
+	return p.graphRootPath
+}
+
+// storageMounts returns the mounts giving the install container the
+// container storage of the podman service, where bootc finds the layers of
+// the image. A graph root relocated by the storage.conf of the host is
+// mounted at the default location and at its own path, so it is found
+// whether the storage.conf of the image is the default one or the one of
+// the host.
+func (p *BootcDisk) storageMounts() []specs.Mount {
+	graphRoot := p.graphRoot()
+	if graphRoot == defaultGraphRoot {
+		return []specs.Mount{{
+			Source:      "/var/lib/containers",
+			Destination: "/var/lib/containers",
+			Type:        "bind",
+		}}
+	}
+	return []specs.Mount{
+		{
+			Source:      graphRoot,
+			Destination: defaultGraphRoot,
+			Type:        "bind",
+		},
+		{
+			Source:      graphRoot,
+			Destination: graphRoot,
+			Type:        "bind",
+		},
+	}
+}
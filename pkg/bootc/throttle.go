@@ -0,0 +1,112 @@
+package bootc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ioWeightMin and ioWeightMax bound the blkio weight, the runtime maps
+	// it to io.weight on cgroup v2
+	ioWeightMin = 10
+	ioWeightMax = 1000
+	// niceCPUShares and niceIOWeight are the lowest priorities, the install
+	// only slows down when the host is busy
+	niceCPUShares = 2
+	niceIOWeight  = ioWeightMin
+)
+
+// parseIOMax parses a rate such as 50MB or 50MB/s
+func parseIOMax(rate string) (uint64, error) {
+	n, err := units.FromHumanSize(strings.TrimSuffix(rate, "/s"))
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("the rate must be positive")
+	}
+	return uint64(n), nil
+}
+
+// ioDeviceScript prints the major:minor of the device holding the path of
+// its first argument
+const ioDeviceScript = `stat -c '%Hd:%Ld' "$1"
+`
+
+// installResources returns the resource limits of the install container,
+// the IO rate limit is added by throttleIODevice
+func (c DiskImageConfig) installResources() *specs.LinuxResources {
+	if c.IOWeight == 0 && c.IOMax == "" && !c.Nice {
+		return nil
+	}
+	blockIO := &specs.LinuxBlockIO{}
+	resources := &specs.LinuxResources{BlockIO: blockIO}
+	if c.Nice {
+		shares := uint64(niceCPUShares)
+		weight := uint16(niceIOWeight)
+		resources.CPU = &specs.LinuxCPU{Shares: &shares}
+		blockIO.Weight = &weight
+	}
+	if c.IOWeight != 0 {
+		weight := c.IOWeight
+		blockIO.Weight = &weight
+	}
+	return resources
+}
+
+// parseIODevice parses the output of ioDeviceScript
+func parseIODevice(output string) (major, minor int64, err error) {
+	majorStr, minorStr, ok := strings.Cut(strings.TrimSpace(output), ":")
+	if ok {
+		if major, err = strconv.ParseInt(majorStr, 10, 64); err == nil {
+			minor, err = strconv.ParseInt(minorStr, 10, 64)
+		}
+	}
+	if !ok || err != nil {
+		return 0, 0, fmt.Errorf("unexpected device %q", strings.TrimSpace(output))
+	}
+	return major, minor, nil
+}
+
+// throttleIODevice caps the reads and writes of the install container to
+// ioMax on the device holding path, a path of the install container. The
+// disk is written through a loop device, the cap applies to the device
+// holding its backing file. The device is looked up by the podman service:
+// a remote service or a podman machine has its own devices.
+func (p *BootcDisk) throttleIODevice(ioMax, path string) error {
+	rate, err := parseIOMax(ioMax)
+	if err != nil {
+		return fmt.Errorf("invalid IO rate %q: %w", ioMax, err)
+	}
+	output, err := p.runHelperContainer(p.installImage(), []string{"sh", "-c", ioDeviceScript, "sh", path})
+	if err != nil {
+		logrus.Warnf("Ignoring the IO rate limit, unable to find the device holding %s: %v", path, err)
+		return nil
+	}
+	major, minor, err := parseIODevice(output)
+	if err != nil {
+		logrus.Warnf("Ignoring the IO rate limit, unable to find the device holding %s: %v", path, err)
+		return nil
+	}
+	if major == 0 {
+		logrus.Warnf("Ignoring the IO rate limit, %s is not on a block device of the podman machine", path)
+		return nil
+	}
+
+	if p.resources == nil {
+		p.resources = &specs.LinuxResources{}
+	}
+	if p.resources.BlockIO == nil {
+		p.resources.BlockIO = &specs.LinuxBlockIO{}
+	}
+	device := specs.LinuxThrottleDevice{Rate: rate}
+	device.Major, device.Minor = major, minor
+	p.resources.BlockIO.ThrottleReadBpsDevice = []specs.LinuxThrottleDevice{device}
+	p.resources.BlockIO.ThrottleWriteBpsDevice = []specs.LinuxThrottleDevice{device}
+	return nil
+}
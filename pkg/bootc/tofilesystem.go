@@ -0,0 +1,222 @@
+package bootc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// InstallModeDisk creates a new disk image in the cache with bootc
+	// install to-disk
+	InstallModeDisk = "to-disk"
+	// InstallModeFilesystem installs to the existing InstallTarget with
+	// bootc install to-filesystem, outside of the cache
+	InstallModeFilesystem = "to-filesystem"
+)
+
+const (
+	// InstallReplaceWipe wipes the system of the target before installing
+	InstallReplaceWipe = "wipe"
+	// InstallReplaceAlongside installs beside the system of the target
+	InstallReplaceAlongside = "alongside"
+)
+
+const (
+	// installTargetDir is where a directory target is mounted in the
+	// install container
+	installTargetDir = "/target"
+	// installTargetDisk is where a disk image target is mounted in the
+	// install container
+	installTargetDisk = "/target.img"
+	// filesystemInstallFile records the last to-filesystem install in the
+	// cache entry of the image, the target lives outside of it
+	filesystemInstallFile = "filesystem-install.json"
+)
+
+// toFilesystemScript mounts the root, boot and EFI partitions of an existing
+// disk image and runs bootc install to-filesystem on them.
+// Arguments: disk image, bootc install to-filesystem without the target
+const toFilesystemScript = mountTargetScript + `esp=$(lsblk -lnpo NAME,LABEL "$dev" | awk '$2 == "EFI-SYSTEM" { print $1 }')
+if [ -n "$esp" ]; then
+	mkdir -p "$mnt/boot/efi"
+	mount "$esp" "$mnt/boot/efi"
+fi
+"$@" "$mnt"
+`
+
+// FilesystemInstall is the record of a to-filesystem install
+type FilesystemInstall struct {
+	// Target is the directory or disk image the image was installed to
+	Target string   `json:"target"`
+	Meta   DiskMeta `json:"meta"`
+}
+
+// installsToFilesystem reports if the image is installed to an existing
+// target instead of a new disk image
+func (c DiskImageConfig) installsToFilesystem() bool {
+	return c.InstallMode == InstallModeFilesystem
+}
+
+// checkInstallTarget returns if the target of to-filesystem is a disk image
+// rather than a directory
+func checkInstallTarget(target string) (bool, error) {
+	st, err := os.Stat(target)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case st.IsDir():
+		return false, nil
+	case st.Mode().IsRegular():
+		return true, nil
+	}
+	return false, fmt.Errorf("%s is neither a directory nor a disk image", target)
+}
+
+// installReplace returns how bootc install to-filesystem replaces the system
+// of the target: InstallReplace, or wiping the previous install of a
+// reinstall, which bootc refuses without --replace
+func (c DiskImageConfig) installReplace() string {
+	if c.InstallReplace != "" {
+		return c.InstallReplace
+	}
+	if c.installTargetIsDisk {
+		if _, err := ReadDiskMeta(c.InstallTarget); err == nil {
+			return InstallReplaceWipe
+		}
+	} else if exists(filepath.Join(c.InstallTarget, "ostree")) {
+		return InstallReplaceWipe
+	}
+	return ""
+}
+
+// toFilesystemCommand returns the command installing the image to the
+// target of config with bootc install to-filesystem
+func (p *BootcDisk) toFilesystemCommand(config DiskImageConfig) []string {
+	command := []string{"bootc", "install", "to-filesystem", "--generic-image", "--skip-fetch-check"}
+	if replace := config.installReplace(); replace != "" {
+		command = append(command, "--replace="+replace)
+	}
+	command = append(command, p.installOptions(config)...)
+	switch {
+	case config.usesHostBackend():
+		return append(command, config.InstallTarget)
+	case config.installTargetIsDisk:
+		return append([]string{"sh", "-c", toFilesystemScript, InstallModeFilesystem, installTargetDisk}, command...)
+	}
+	return append(command, installTargetDir)
+}
+
+// installTargetPath returns where the install target is mounted in the
+// install container
+func (p *BootcDisk) installTargetPath() string {
+	if p.installTargetIsDisk {
+		return installTargetDisk
+	}
+	return installTargetDir
+}
+
+// bootcInstallToFilesystem installs the image to the existing target of
+// diskConfig with bootc install to-filesystem. No disk image is created in
+// the cache, the install is recorded in the cache entry instead.
+func (p *BootcDisk) bootcInstallToFilesystem(diskConfig DiskImageConfig) (err error) {
+	p.metrics().BuildStarted()
+	buildStart := time.Now()
+	defer func() {
+		if err != nil {
+			p.metrics().BuildFailed()
+		} else {
+			p.metrics().BuildSucceeded(time.Since(buildStart))
+		}
+	}()
+
+	if diskConfig.usesHostBackend() {
+		if p.bootcVersion, err = p.checkHostBackend(); err != nil {
+			return err
+		}
+	} else if diskConfig.IOMax != "" {
+		if err := p.throttleIODevice(diskConfig.IOMax, p.installTargetPath()); err != nil {
+			return err
+		}
+	}
+	if p.bootcVersion == "" {
+		p.bootcVersion = p.detectBootcVersion()
+	}
+	if p.verbosity.showInstallOutput() {
+		p.progressf("Using bootc version %s", p.bootcVersion)
+	}
+
+	p.progressf("Executing `bootc install to-filesystem` from container image %s to %s", p.RepoTag, diskConfig.InstallTarget)
+	removeKeys, err := p.writeRootSSHKeys(diskConfig)
+	if err != nil {
+		return err
+	}
+	defer removeKeys()
+	p.setProgressStep(StepInstall)
+	command := p.toFilesystemCommand(diskConfig)
+	if diskConfig.usesHostBackend() {
+		err = p.runHostInstall(command)
+	} else if diskConfig.installTargetIsDisk {
+		err = p.runDiagnosedInstall(diskConfig.LoopWait, func() error {
+			return p.runInstallContainer(command)
+		}, nil)
+	} else {
+		err = p.runInstallContainer(command)
+	}
+	if err != nil {
+		if p.keepOnFailure && errors.Is(err, errInstallExited) {
+			p.keepFailedInstall()
+		}
+		if p.installLogged {
+			return fmt.Errorf("failed to install to %s, the install output is in %s: %w", diskConfig.InstallTarget, p.InstallLogPath(), err)
+		}
+		return fmt.Errorf("failed to install to %s: %w", diskConfig.InstallTarget, err)
+	}
+
+	p.setProgressStep(StepFinalize)
+	meta := p.diskMeta(diskConfig)
+	meta.Generation = 0
+	meta.Inputs.InstallMode = InstallModeFilesystem
+	p.setBuiltAt(meta.Created)
+	p.runDefaults = meta.RunDefaults
+	return p.recordFilesystemInstall(diskConfig, meta)
+}
+
+// recordFilesystemInstall writes the record of the install in the cache
+// entry and, for disk images, the metadata on the target
+func (p *BootcDisk) recordFilesystemInstall(diskConfig DiskImageConfig, meta DiskMeta) error {
+	if diskConfig.installTargetIsDisk {
+		// The disk image is the user's, it stays usable without metadata
+		if err := WriteDiskMeta(diskConfig.InstallTarget, &meta); err != nil {
+			logrus.Warnf("unable to write the metadata of %s: %v", diskConfig.InstallTarget, err)
+		}
+	}
+	buf, err := json.Marshal(FilesystemInstall{Target: diskConfig.InstallTarget, Meta: meta})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(p.Directory, filesystemInstallFile), buf, 0o644); err != nil {
+		return fmt.Errorf("recording the install to %s: %w", diskConfig.InstallTarget, err)
+	}
+	return nil
+}
+
+// ReadFilesystemInstall returns the last to-filesystem install of the cache
+// entry in dir
+func ReadFilesystemInstall(dir string) (*FilesystemInstall, error) {
+	buf, err := os.ReadFile(filepath.Join(dir, filesystemInstallFile))
+	if err != nil {
+		return nil, err
+	}
+	var install FilesystemInstall
+	if err := json.Unmarshal(buf, &install); err != nil {
+		return nil, fmt.Errorf("invalid record of the install to the filesystem: %w", err)
+	}
+	return &install, nil
+}
@@ -0,0 +1,95 @@
+package bootc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tombstoneFile records the last failed build in the cache directory
+const tombstoneFile = "build-failure.json"
+
+// tombstone describes a failed build of an image with a given configuration
+type tombstone struct {
+	ImageDigest string    `json:"imageDigest"`
+	ConfigHash  string    `json:"configHash"`
+	ErrorClass  string    `json:"errorClass"`
+	Error       string    `json:"error"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// errorClass classifies a failed install, it returns an empty string for
+// failures which are not worth recording because they aren't deterministic
+func (p *BootcDisk) errorClass(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ""
+	case p.installFailedOnCorruptImage():
+		return "corrupted-image"
+	case p.installOutput != nil:
+		return "install-failed"
+	default:
+		return "setup-failed"
+	}
+}
+
+func (p *BootcDisk) tombstonePath() string {
+	return filepath.Join(p.Directory, tombstoneFile)
+}
+
+// checkTombstone fails if the same build failed within the window
+func (p *BootcDisk) checkTombstone(diskConfig DiskImageConfig) error {
+	buf, err := os.ReadFile(p.tombstonePath())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logrus.Warnf("unable to read the build failure tombstone: %v", err)
+		}
+		return nil
+	}
+	var t tombstone
+	if err := json.Unmarshal(buf, &t); err != nil {
+		logrus.Warnf("ignoring invalid build failure tombstone: %v", err)
+		return nil
+	}
+
+	age := time.Since(t.Timestamp)
+	if t.ImageDigest != p.ImageId || t.ConfigHash != diskConfig.installHash() || age > diskConfig.TombstoneWindow {
+		return nil
+	}
+	return fmt.Errorf("the same build of %s failed %s ago (%s): %s; pass --force to retry", p.RepoTag, formatAge(age), t.ErrorClass, t.Error)
+}
+
+// recordTombstone records a failed build
+func (p *BootcDisk) recordTombstone(diskConfig DiskImageConfig, installErr error) {
+	class := p.errorClass(installErr)
+	if class == "" {
+		return
+	}
+	buf, err := json.Marshal(tombstone{
+		ImageDigest: p.ImageId,
+		ConfigHash:  diskConfig.installHash(),
+		ErrorClass:  class,
+		Error:       installErr.Error(),
+		Timestamp:   time.Now(),
+	})
+	if err != nil {
+		logrus.Warnf("unable to record the build failure: %v", err)
+		return
+	}
+	if err := os.WriteFile(p.tombstonePath(), buf, 0644); err != nil {
+		logrus.Warnf("unable to record the build failure: %v", err)
+	}
+}
+
+// clearTombstone removes the record of a failed build
+func (p *BootcDisk) clearTombstone() {
+	if err := os.Remove(p.tombstonePath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logrus.Warnf("unable to remove the build failure tombstone: %v", err)
+	}
+}
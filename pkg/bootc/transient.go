@@ -0,0 +1,80 @@
+package bootc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultInstallRetries is the default number of retries of an install
+// failing on a transient loop device error
+const DefaultInstallRetries = 2
+
+// errInstallExited is an install container exiting with an error
+var errInstallExited = errors.New("failed to run bootc install")
+
+// transientInstallPatterns match install failures caused by races attaching
+// the loop device, which a new attempt usually does not hit
+var transientInstallPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)failed to set up loop device.*(resource busy|resource temporarily unavailable)`),
+	regexp.MustCompile(`(?i)LOOP_(SET_FD|CONFIGURE|SET_STATUS64).*(EBUSY|EAGAIN|resource busy)`),
+	regexp.MustCompile(`(?i)loop device.*disappeared`),
+}
+
+// installFailedOnTransientError checks the install output for a loop device
+// race worth retrying
+func (p *BootcDisk) installFailedOnTransientError() bool {
+	if p.installOutput == nil {
+		return false
+	}
+
+	output := p.installOutput.String()
+	for _, pattern := range transientInstallPatterns {
+		if pattern.MatchString(output) {
+			return true
+		}
+	}
+	return false
+}
+
+// runInstallWithRetries runs the install container, retrying up to retries
+// times on a new temporary disk of size bytes when the install exited on a
+// transient loop device error
+func (p *BootcDisk) runInstallWithRetries(diskConfig DiskImageConfig, size int64) error {
+	for retry := 0; ; retry++ {
+		err := p.runInstallContainer(p.installCommand(diskConfig))
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, errInstallExited) || !p.installFailedOnTransientError() || p.Ctx.Err() != nil {
+			return err
+		}
+		if retry == diskConfig.InstallRetries {
+			if retry == 0 {
+				return err
+			}
+			return fmt.Errorf("%w: transient loop device error, still failing after %d retries", err, retry)
+		}
+
+		logrus.Warnf("the install failed on a transient loop device error, retrying on a new disk image (%d/%d)", retry+1, diskConfig.InstallRetries)
+		if err := p.replaceTempDisk(size); err != nil {
+			return err
+		}
+	}
+}
+
+// replaceTempDisk replaces the temporary disk of a failed install with a new
+// one of size bytes
+func (p *BootcDisk) replaceTempDisk(size int64) error {
+	if p.keepOnFailure {
+		if err := p.Cleanup(); err != nil {
+			logrus.Errorf("%v", err)
+		}
+	}
+	p.file.Close()
+	os.Remove(p.file.Name())
+	return p.allocateTempDisk(size)
+}
@@ -0,0 +1,250 @@
+package bootc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// RebuildClean always creates new disk images with bootc install
+	RebuildClean = "clean"
+	// RebuildUpgrade upgrades the previous disk image of the same repository
+	// to the new image, falling back to a clean install
+	RebuildUpgrade = "upgrade"
+)
+
+// upgradeScript deploys the new image on a copy of the previous disk image.
+// Arguments: disk image, image id, image reference
+const upgradeScript = mountTargetScript + `image=$1 target=$2
+# The kernel arguments of the previous deployment, as positional parameters
+set --
+for karg in $(sed -n 's/^options //p' "$mnt"/boot/loader/entries/*.conf | head -n 1); do
+	set -- "$@" --karg "$karg"
+done
+ostree container image deploy --sysroot "$mnt" --stateroot default \
+	--imgref "ostree-unverified-image:containers-storage:$image" \
+	--target-imgref "ostree-unverified-registry:$target" "$@"
+ostree admin undeploy --sysroot="$mnt" 1
+ostree admin cleanup --sysroot="$mnt"
+`
+
+// installHash identifies the options changing the contents of the disk image
+func (c DiskImageConfig) installHash() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "filesystem=%s\nroot-size-max=%s\ndisk-size=%s\n", c.Filesystem, c.RootSizeMax, c.DiskSize)
+	// Hashed only when set, to keep matching the raw disks built before
+	if format := c.diskFormat(); format != FormatRaw {
+		fmt.Fprintf(h, "format=%s\n", format)
+	}
+	if c.MinDiskSize != "" {
+		fmt.Fprintf(h, "disk-size-minimum=%s\n", c.MinDiskSize)
+	}
+	for _, karg := range c.Kargs {
+		fmt.Fprintf(h, "karg=%s\n", karg)
+	}
+	for _, arg := range c.ExtraInstallArgs {
+		fmt.Fprintf(h, "install-arg=%s\n", arg)
+	}
+	if c.BlockSetup != "" {
+		fmt.Fprintf(h, "block-setup=%s\n", c.BlockSetup)
+	}
+	for _, fs := range sortedFilesystems(c.FilesystemOptions) {
+		fmt.Fprintf(h, "mkfs-options=%s:%s\n", fs, c.FilesystemOptions[fs])
+	}
+	if c.rootSSHKeys != "" {
+		fmt.Fprintf(h, "root-ssh-keys=%s\n", sshKeysDigest(c.rootSSHKeys))
+	}
+	if c.installConfigDigest != "" {
+		fmt.Fprintf(h, "install-config=%s\n", c.installConfigDigest)
+	}
+	if c.postInstallHookDigest != "" {
+		fmt.Fprintf(h, "post-install-hook=%s\n", c.postInstallHookDigest)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// repositoryOf returns the repository of an image reference, without tag or digest
+func repositoryOf(repoTag string) string {
+	named, err := reference.ParseNormalizedNamed(repoTag)
+	if err != nil {
+		return ""
+	}
+	return reference.TrimNamed(named).Name()
+}
+
+// findPreviousDisk returns the directory of the most recent generation built
+// from the same repository with the same install options. Generations are
+// ordered by build, booting an older one does not make it the most recent.
+func (p *BootcDisk) findPreviousDisk(diskConfig DiskImageConfig) (string, *DiskMeta, error) {
+	repository := repositoryOf(p.RepoTag)
+	if repository == "" {
+		return "", nil, fmt.Errorf("unable to parse the repository of %s", p.RepoTag)
+	}
+
+	generations, err := ListGenerations(p.User, repository)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var (
+		previous       *Generation
+		optionsChanged bool
+	)
+	for i := range generations {
+		g := &generations[i]
+		if g.Directory == p.Directory {
+			continue
+		}
+		if g.Meta.ConfigHash != diskConfig.installHash() {
+			optionsChanged = true
+			logrus.Debugf("previous disk %s was built with different options", g.Directory)
+			continue
+		}
+		// generations are sorted oldest first
+		previous = g
+	}
+
+	if previous == nil {
+		if optionsChanged {
+			return "", nil, errors.New("the install options changed since the previous disk image was built")
+		}
+		return "", nil, fmt.Errorf("no previous disk image for %s", repository)
+	}
+	return previous.Directory, previous.Meta, nil
+}
+
+// upgradePreviousDisk creates the disk image by deploying the image on a
+// copy of the previous disk image of the same repository. It returns false
+// if there is nothing to upgrade.
+func (p *BootcDisk) upgradePreviousDisk(diskConfig DiskImageConfig, estimate diskSizeEstimate) (upgraded bool, err error) {
+	previousDir, previousMeta, err := p.findPreviousDisk(diskConfig)
+	if err != nil {
+		return false, err
+	}
+	previousPath := filepath.Join(previousDir, config.DiskImage)
+	if format := previousMeta.DiskFormat(); format != FormatRaw {
+		return false, fmt.Errorf("upgrading a %s disk image is not supported", format)
+	}
+	if previousMeta.RequiresTPM() {
+		return false, errors.New("upgrading a disk image with an encrypted root is not supported")
+	}
+
+	// The previous disk must not be rebuilt or removed while copying it
+	lock := utils.NewCacheLock(p.User.RunDir(), previousDir)
+	locked, err := lock.TryLock(utils.Shared)
+	if err != nil {
+		return false, fmt.Errorf("locking %s: %w", previousDir, err)
+	}
+	if !locked {
+		return false, fmt.Errorf("%s is in use", previousDir)
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			logrus.Errorf("unable to unlock %s: %v", previousDir, err)
+		}
+	}()
+
+	st, err := os.Stat(previousPath)
+	if err != nil {
+		return false, err
+	}
+	if st.Size() < estimate.size {
+		return false, fmt.Errorf("the previous disk image is smaller than the required %d bytes", estimate.size)
+	}
+
+	p.progressf("Upgrading the disk image of %s to container image %s", previousMeta.ImageDigest, p.RepoTag)
+	p.file, err = p.createTempDisk()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if upgraded {
+			return
+		}
+		// The caller falls back to a clean install with a new temporary disk
+		p.file.Close()
+		if !isPromotionError(err) {
+			os.Remove(p.file.Name())
+		}
+		p.file = nil
+	}()
+	if err := copyDisk(previousPath, p.file); err != nil {
+		return false, fmt.Errorf("copying %s: %w", previousPath, err)
+	}
+
+	command := []string{"sh", "-c", upgradeScript, "upgrade",
+		"/output/" + filepath.Base(p.file.Name()), p.ImageId, p.RepoTag}
+	if err := p.runInstallContainer(command); err != nil {
+		return false, fmt.Errorf("failed to upgrade disk image: %w", err)
+	}
+	if err := p.runPostInstallHook(diskConfig); err != nil {
+		return false, err
+	}
+
+	meta := p.diskMeta(diskConfig)
+	meta.UpgradedFrom = previousMeta.ImageDigest
+	if err := p.commitDisk(meta); err != nil {
+		return false, err
+	}
+	p.progressf("Disk image upgraded from %s", previousMeta.ImageDigest)
+	return true, nil
+}
+
+// copyDisk clones the source disk when the filesystem supports reflinks,
+// otherwise it copies it preserving holes
+func copyDisk(source string, dst *os.File) error {
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := cloneFile(dst, src); err == nil {
+		return nil
+	}
+
+	st, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if err := dst.Truncate(st.Size()); err != nil {
+		return err
+	}
+	buf := make([]byte, 1024*1024)
+	var offset int64
+	for {
+		n, err := src.ReadAt(buf, offset)
+		if n > 0 && !isZero(buf[:n]) {
+			if _, err := dst.WriteAt(buf[:n], offset); err != nil {
+				return err
+			}
+		}
+		offset += int64(n)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func isZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
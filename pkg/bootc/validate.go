@@ -0,0 +1,307 @@
+package bootc
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/owner"
+
+	"github.com/docker/go-units"
+	"github.com/sirupsen/logrus"
+)
+
+// installFilesystems are the root filesystems bootc install supports
+var installFilesystems = []string{"xfs", "ext4", "btrfs"}
+
+// mkfsScript prints missing when the image has no mkfs for the filesystem
+// passed as first argument, bootc install formats the root with it
+const mkfsScript = `command -v "mkfs.$1" >/dev/null || echo missing`
+
+// ConfigError lists every problem of a DiskImageConfig
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid disk image options:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// normalize trims the options and lowercases the filesystem
+func (c *DiskImageConfig) normalize() {
+	c.Filesystem = strings.ToLower(strings.TrimSpace(c.Filesystem))
+	c.Format = strings.ToLower(strings.TrimSpace(c.Format))
+	c.RootSizeMax = strings.TrimSpace(c.RootSizeMax)
+	c.DiskSize = strings.TrimSpace(c.DiskSize)
+	c.MinDiskSize = strings.TrimSpace(c.MinDiskSize)
+	// A zero minimum keeps the default one
+	if n, err := units.FromHumanSize(c.MinDiskSize); err == nil && n == 0 {
+		c.MinDiskSize = ""
+	}
+	c.LargeDiskThreshold = strings.TrimSpace(c.LargeDiskThreshold)
+	c.ImageCeiling = strings.TrimSpace(c.ImageCeiling)
+	c.InstallConfig = strings.TrimSpace(c.InstallConfig)
+	c.PostInstallHook = strings.TrimSpace(c.PostInstallHook)
+	c.InstallMode = strings.ToLower(strings.TrimSpace(c.InstallMode))
+	c.InstallTarget = strings.TrimSpace(c.InstallTarget)
+	c.InstallReplace = strings.ToLower(strings.TrimSpace(c.InstallReplace))
+	c.LosetupDirectIO = strings.ToLower(strings.TrimSpace(c.LosetupDirectIO))
+	c.Preallocation = strings.ToLower(strings.TrimSpace(c.Preallocation))
+	c.BlockSetup = strings.ToLower(strings.TrimSpace(c.BlockSetup))
+	c.FilesystemOptions = normalizeFilesystemOptions(c.FilesystemOptions)
+	c.IOMax = strings.TrimSpace(c.IOMax)
+	c.DiskMode = strings.TrimSpace(c.DiskMode)
+	c.DiskOwner = strings.TrimSpace(c.DiskOwner)
+}
+
+// Validate checks the options before any work is done, it returns a
+// *ConfigError listing all the problems found
+func (c DiskImageConfig) Validate() error {
+	c.normalize()
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Filesystem != "" && !contains(installFilesystems, c.Filesystem) {
+		add("unsupported filesystem %q, use one of %s", c.Filesystem, strings.Join(installFilesystems, ", "))
+	}
+	for _, karg := range c.Kargs {
+		if strings.TrimSpace(karg) == "" {
+			add("empty kernel argument")
+		}
+	}
+	for _, arg := range c.ExtraInstallArgs {
+		switch {
+		case strings.TrimSpace(arg) == "":
+			add("empty install argument")
+		case strings.HasPrefix(arg, "/output") || strings.Contains(arg, "=/output"):
+			add("install argument %q refers to /output, the disk image path is set by podman-bootc", arg)
+		}
+	}
+	if c.InstallConfig != "" {
+		if _, err := readInstallConfig(c.InstallConfig); err != nil {
+			add("invalid install configuration: %v", err)
+		}
+	}
+	if c.PostInstallHook != "" {
+		if _, err := readPostInstallHook(c.PostInstallHook); err != nil {
+			add("invalid post-install hook: %v", err)
+		}
+	}
+	if c.BlockSetup != "" && !contains(blockSetups, c.BlockSetup) {
+		add("unsupported block setup %q, use one of %s", c.BlockSetup, strings.Join(blockSetups, ", "))
+	}
+	if c.VerifyContent && c.BlockSetup == BlockSetupTPM2LUKS {
+		add("the content verification cannot mount the encrypted root of the %s block setup", BlockSetupTPM2LUKS)
+	}
+	if c.BoundImages && c.BlockSetup == BlockSetupTPM2LUKS {
+		add("the bound images cannot be copied into the encrypted root of the %s block setup", BlockSetupTPM2LUKS)
+	}
+	if c.BoundImages && c.Format == FormatQcow2 {
+		add("the bound images can only be copied into a %s disk image", FormatRaw)
+	}
+	for _, fs := range sortedFilesystems(c.FilesystemOptions) {
+		if err := checkMkfsOptions(fs, c.FilesystemOptions[fs]); err != nil {
+			add("invalid mkfs options: %v", err)
+		}
+	}
+	if len(c.RootSSHKeys) > 0 {
+		if _, err := readRootSSHKeys(c.RootSSHKeys); err != nil {
+			add("invalid root SSH keys: %v", err)
+		}
+	}
+	if c.Format != "" && !contains(diskFormats, c.Format) {
+		add("unsupported disk format %q, use one of %s", c.Format, strings.Join(diskFormats, ", "))
+	}
+
+	size := func(name, value string) int64 {
+		if value == "" {
+			return 0
+		}
+		n, err := units.FromHumanSize(value)
+		if err != nil || n <= 0 {
+			add("invalid %s %q", name, value)
+			return 0
+		}
+		return n
+	}
+	rootSize := size("root size", c.RootSizeMax)
+	diskSize := size("disk size", c.DiskSize)
+	size("minimum disk size", c.MinDiskSize)
+	size("large disk threshold", c.LargeDiskThreshold)
+	size("image ceiling", c.ImageCeiling)
+	if rootSize > 0 && diskSize > 0 && rootSize > diskSize {
+		add("the root size %s is larger than the disk size %s", c.RootSizeMax, c.DiskSize)
+	}
+
+	switch c.InstallBackend {
+	case "", InstallBackendContainer:
+	case InstallBackendHost:
+		if c.InstallerImage != "" {
+			add("the installer image requires the %s install backend", InstallBackendContainer)
+		}
+		if c.InstallConfig != "" {
+			add("the install configuration requires the %s install backend", InstallBackendContainer)
+		}
+		if len(c.FilesystemOptions) > 0 {
+			add("the mkfs options require the %s install backend, the bootc of the host runs the mkfs of the host", InstallBackendContainer)
+		}
+	default:
+		add("invalid install backend %q, use %q or %q", c.InstallBackend, InstallBackendContainer, InstallBackendHost)
+	}
+
+	if c.LosetupDirectIO != "" && !contains(losetupDirectIOModes, c.LosetupDirectIO) {
+		add("invalid losetup direct IO %q, use one of %s", c.LosetupDirectIO, strings.Join(losetupDirectIOModes, ", "))
+	}
+	if c.Preallocation != "" && !contains(preallocationModes, c.Preallocation) {
+		add("invalid preallocation %q, use one of %s", c.Preallocation, strings.Join(preallocationModes, ", "))
+	}
+
+	if c.DiskMode != "" {
+		if _, err := parseDiskMode(c.DiskMode); err != nil {
+			add("%v", err)
+		}
+	}
+	if c.DiskOwner != "" {
+		if _, err := owner.Parse(c.DiskOwner); err != nil {
+			add("invalid disk owner: %v", err)
+		}
+	}
+
+	switch c.InstallMode {
+	case "", InstallModeDisk:
+		if c.InstallTarget != "" {
+			add("the install target requires the %s install mode", InstallModeFilesystem)
+		}
+		if c.InstallReplace != "" {
+			add("replacing the system of the target requires the %s install mode", InstallModeFilesystem)
+		}
+	case InstallModeFilesystem:
+		c.validateFilesystemInstall(add)
+	default:
+		add("invalid install mode %q, use %q or %q", c.InstallMode, InstallModeDisk, InstallModeFilesystem)
+	}
+
+	switch c.RebuildStrategy {
+	case "", RebuildClean, RebuildUpgrade:
+	default:
+		add("invalid rebuild strategy %q, use %q or %q", c.RebuildStrategy, RebuildClean, RebuildUpgrade)
+	}
+	switch c.CacheStrictness {
+	case "", CacheStrictnessOff, CacheStrictnessWarn, CacheStrictnessStrict:
+	default:
+		add("invalid cache strictness %q, use %q, %q or %q",
+			c.CacheStrictness, CacheStrictnessOff, CacheStrictnessWarn, CacheStrictnessStrict)
+	}
+
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"max cache age", c.MaxCacheAge},
+		{"tombstone window", c.TombstoneWindow},
+		{"loop wait", c.LoopWait},
+		{"install timeout", c.InstallTimeout},
+	} {
+		if d.value < 0 {
+			add("invalid %s %s, it must not be negative", d.name, d.value)
+		}
+	}
+
+	if c.InstallRetries < 0 {
+		add("invalid install retries %d, it must not be negative", c.InstallRetries)
+	}
+	if c.IOWeight != 0 && (c.IOWeight < ioWeightMin || c.IOWeight > ioWeightMax) {
+		add("invalid IO weight %d, use %d to %d", c.IOWeight, ioWeightMin, ioWeightMax)
+	}
+	if c.IOMax != "" {
+		if _, err := parseIOMax(c.IOMax); err != nil {
+			add("invalid IO rate %q", c.IOMax)
+		}
+	}
+
+	if c.ProvenanceKey != "" {
+		if _, err := exec.LookPath("cosign"); err != nil {
+			add("signing the provenance requires cosign in $PATH")
+		}
+	}
+
+	if c.MirrorFallback && c.RegistryMirror == "" {
+		add("the mirror fallback requires a registry mirror")
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// validateFilesystemInstall checks the target of the to-filesystem install
+// mode and refuses the options shaping a new disk image
+func (c DiskImageConfig) validateFilesystemInstall(add func(string, ...any)) {
+	if c.InstallTarget == "" {
+		add("the %s install mode requires an install target", InstallModeFilesystem)
+	} else if isDisk, err := checkInstallTarget(c.InstallTarget); err != nil {
+		add("invalid install target: %v", err)
+	} else if isDisk && c.InstallBackend == InstallBackendHost {
+		add("the %s install backend installs to a mounted directory, not to the disk image %s", InstallBackendHost, c.InstallTarget)
+	}
+	switch c.InstallReplace {
+	case "", InstallReplaceWipe, InstallReplaceAlongside:
+	default:
+		add("invalid install replace mode %q, use %q or %q", c.InstallReplace, InstallReplaceWipe, InstallReplaceAlongside)
+	}
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"filesystem", c.Filesystem != ""},
+		{"root size", c.RootSizeMax != ""},
+		{"disk size", c.DiskSize != ""},
+		{"minimum disk size", c.MinDiskSize != ""},
+		{"preallocation", c.preallocation() != PreallocationSparse},
+		{"block setup", c.BlockSetup != ""},
+		{"mkfs options", len(c.FilesystemOptions) > 0},
+		{"disk format", c.Format != "" && c.Format != FormatRaw},
+		{"upgrade rebuild strategy", c.RebuildStrategy == RebuildUpgrade},
+		{"content verification", c.VerifyContent},
+		{"bound images", c.BoundImages},
+		{"provenance", c.Provenance || c.ProvenanceKey != ""},
+		{"post-install hook", c.PostInstallHook != ""},
+		{"disk mode", c.DiskMode != ""},
+		{"disk owner", c.DiskOwner != ""},
+	} {
+		if option.set {
+			add("the %s applies to new disk images, it cannot be used with the %s install mode", option.name, InstallModeFilesystem)
+		}
+	}
+}
+
+// checkFilesystemSupport fails before the temporary disk is created when
+// the install image cannot create the root filesystem. Failing to probe the
+// image is not fatal, bootc reports the problem itself.
+func (p *BootcDisk) checkFilesystemSupport(filesystem string) error {
+	if filesystem == "" {
+		return nil
+	}
+	output, err := p.runHelperContainer(p.installImage(), []string{"sh", "-c", mkfsScript, "mkfs", filesystem})
+	if err != nil {
+		logrus.Warnf("unable to check the filesystems of the image: %v", err)
+		return nil
+	}
+	if strings.TrimSpace(output) == "missing" {
+		return fmt.Errorf("the install image has no mkfs.%s, bootc install cannot create a %s root filesystem with it", filesystem, filesystem)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
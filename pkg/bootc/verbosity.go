@@ -0,0 +1,113 @@
+package bootc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/containers/podman/v5/pkg/specgen"
+	"github.com/sirupsen/logrus"
+)
+
+// Verbosity controls the output of the disk image build
+type Verbosity int
+
+const (
+	// VerbositySilent only reports errors
+	VerbositySilent Verbosity = iota - 2
+	// VerbosityQuiet hides the output of the install container but keeps
+	// one-line phase updates
+	VerbosityQuiet
+	// VerbosityNormal shows the phase updates and the install container output
+	VerbosityNormal
+	// VerbosityVerbose additionally shows informational log messages
+	VerbosityVerbose
+	// VerbosityDebug additionally shows debug log messages and the
+	// specification of the install container
+	VerbosityDebug
+)
+
+// NewVerbosity returns the verbosity of the number of -q and -v flags
+func NewVerbosity(quiet, verbose int) Verbosity {
+	v := VerbosityNormal + Verbosity(verbose-quiet)
+	if v < VerbositySilent {
+		return VerbositySilent
+	}
+	if v > VerbosityDebug {
+		return VerbosityDebug
+	}
+	return v
+}
+
+// LogLevel returns the logrus level matching the verbosity
+func (v Verbosity) LogLevel() logrus.Level {
+	switch {
+	case v <= VerbositySilent:
+		return logrus.ErrorLevel
+	case v >= VerbosityDebug:
+		return logrus.DebugLevel
+	case v == VerbosityVerbose:
+		return logrus.InfoLevel
+	default:
+		return logrus.WarnLevel
+	}
+}
+
+func (v Verbosity) showInstallOutput() bool {
+	return v >= VerbosityNormal
+}
+
+func (v Verbosity) showProgress() bool {
+	return v >= VerbosityQuiet
+}
+
+// SetOutput sets where the phase updates and the install container output
+// are written, os.Stdout by default
+func (p *BootcDisk) SetOutput(w io.Writer) {
+	p.output = w
+}
+
+// out returns the output of the build, wrapped to keep heartbeats between
+// the lines of the install container output
+func (p *BootcDisk) out() io.Writer {
+	w := p.output
+	if w == nil {
+		w = os.Stdout
+	}
+	if p.console == nil || p.console.w != w {
+		p.console = &consoleWriter{w: w}
+	}
+	return p.console
+}
+
+// progressf prints a one-line phase update unless the build is silent
+func (p *BootcDisk) progressf(format string, args ...any) {
+	if !p.verbosity.showProgress() {
+		return
+	}
+	line := fmt.Sprintf(format, args...)
+	fmt.Fprintln(p.out(), line)
+	p.updateProgress(func(e *ProgressEvent) { e.Message = line })
+}
+
+// pullOptions returns the options pulling an image with the policy, hiding
+// the pull progress together with the install container output. The
+// progress is still streamed, it names the layers of failed pulls.
+func (p *BootcDisk) pullOptions(policy *string) *images.PullOptions {
+	return (&images.PullOptions{Policy: policy}).WithProgressWriter(p.pullOutput())
+}
+
+// dumpSpec logs the specification of the install container at debug verbosity
+func (p *BootcDisk) dumpSpec(s *specgen.SpecGenerator) {
+	if p.verbosity < VerbosityDebug {
+		return
+	}
+	buf, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		logrus.Debugf("unable to marshal the install container spec: %v", err)
+		return
+	}
+	logrus.Debugf("install container spec:\n%s", buf)
+}
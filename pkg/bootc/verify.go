@@ -0,0 +1,143 @@
+package bootc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// verifySamples is the number of files of /usr compared by verifyContent
+const verifySamples = 200
+
+// verifyContentScript mounts the root of the disk read-only and compares a
+// random sample of the files of /usr of the container, which runs from the
+// image, with the ones of the deployment. It prints "ok <path>" or
+// "mismatch <path>" for each file of the sample.
+const verifyContentScript = `set -eu
+disk=$1
+samples=$2
+mnt=/run/podman-bootc-target
+dev=$(losetup --show -frP "$disk")
+cleanup() {
+	umount -R "$mnt" || true
+	losetup -d "$dev"
+}
+trap cleanup EXIT
+udevadm settle || true
+root=$(lsblk -lnpo NAME,LABEL "$dev" | awk '$2 == "root" { print $1 }')
+if [ -z "$root" ]; then
+	echo "no root partition found on $disk" 1>&2
+	exit 1
+fi
+mkdir -p "$mnt"
+mount -o ro "$root" "$mnt"
+deploy=
+for d in "$mnt"/ostree/deploy/*/deploy/*/; do
+	deploy=${d%/}
+	break
+done
+if [ -z "$deploy" ]; then
+	echo "no ostree deployment found on $disk" 1>&2
+	exit 1
+fi
+# /usr/local holds the wrappers bind mounted by podman-bootc
+find /usr -xdev -path /usr/local -prune -o -type f -print | shuf -n "$samples" | while read -r f; do
+	if cmp -s "$f" "$deploy$f"; then
+		echo "ok $f"
+	else
+		echo "mismatch $f"
+	fi
+done
+`
+
+// ContentVerification is the result of comparing files of the disk image
+// with the container image after the install
+type ContentVerification struct {
+	Verified bool `json:"verified"`
+	// Checked is the number of files compared
+	Checked int `json:"checked"`
+	// Mismatches are the files differing from the image
+	Mismatches []string `json:"mismatches,omitempty"`
+	// Error is why the verification could not run, if it could not
+	Error string `json:"error,omitempty"`
+}
+
+// Summary returns a human readable description of the verification
+func (v *ContentVerification) Summary() string {
+	switch {
+	case v.Error != "":
+		return "unable to verify the contents of the disk image: " + v.Error
+	case v.Verified:
+		return fmt.Sprintf("%d files of /usr match the image", v.Checked)
+	default:
+		return fmt.Sprintf("%d of %d files of /usr differ from the image: %s",
+			len(v.Mismatches), v.Checked, strings.Join(v.Mismatches, ", "))
+	}
+}
+
+// failed reports if files of the disk image differ from the image, a
+// verification which could not run did not fail
+func (v *ContentVerification) failed() bool {
+	return !v.Verified && v.Error == ""
+}
+
+// VerificationError is returned when the contents of a new disk image do not
+// match the image. The disk image is kept, marked as failed in its metadata.
+type VerificationError struct {
+	DiskPath     string
+	Verification ContentVerification
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("content verification of the disk image %s failed, it is kept: %s", e.DiskPath, e.Verification.Summary())
+}
+
+// isVerificationError reports if err is a failed verification of a kept disk
+func isVerificationError(err error) bool {
+	var verificationErr *VerificationError
+	return errors.As(err, &verificationErr)
+}
+
+// parseContentVerification parses the output of verifyContentScript
+func parseContentVerification(output string) (*ContentVerification, error) {
+	v := &ContentVerification{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		status, path, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if !ok {
+			continue
+		}
+		switch status {
+		case "ok":
+		case "mismatch":
+			v.Mismatches = append(v.Mismatches, path)
+		default:
+			continue
+		}
+		v.Checked++
+	}
+	if v.Checked == 0 {
+		return nil, fmt.Errorf("no files compared: %q", strings.TrimSpace(output))
+	}
+	v.Verified = len(v.Mismatches) == 0
+	return v, nil
+}
+
+// verifyContent compares a sample of the files of the temporary disk with
+// the image, in a helper container running from the image itself
+func (p *BootcDisk) verifyContent() *ContentVerification {
+	command := []string{"sh", "-c", verifyContentScript, "verify-content",
+		"/output/" + filepath.Base(p.file.Name()), strconv.Itoa(verifySamples)}
+	output, err := p.runHelperContainer(p.ImageId, command)
+	if err != nil {
+		return &ContentVerification{Error: err.Error()}
+	}
+	v, err := parseContentVerification(output)
+	if err != nil {
+		return &ContentVerification{Error: err.Error()}
+	}
+	return v
+}
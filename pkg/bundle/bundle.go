@@ -0,0 +1,312 @@
+package bundle
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// bundleFile is the first entry of a bundle, identifying the cache entry
+	bundleFile = "bundle.json"
+	// digestsFile is the last entry of a bundle, with the digests of the files
+	digestsFile = "digests.json"
+
+	bundleVersion = 1
+)
+
+type bundleInfo struct {
+	Version int       `json:"version"`
+	Id      string    `json:"id"`
+	Created time.Time `json:"created"`
+}
+
+// Options configures Unbundle
+type Options struct {
+	// Force replaces an existing cache entry newer than the bundle
+	Force bool
+}
+
+// skipped are the files of a cache entry which are not bundled, the data
+// disks belong to the VM of the entry
+func skipped(name string) bool {
+	return name == config.DiskMetaFile || name == "build-failure.json" ||
+		name == "kept-failure.json" ||
+		strings.HasPrefix(name, config.DataDiskPrefix) ||
+		strings.HasPrefix(name, "podman-bootc-temp") || strings.HasPrefix(name, ".")
+}
+
+// Bundle writes the cache entry in cacheDir as a zstd compressed tar to w:
+// its metadata, the files of the entry, the disk image as a sparse entry and
+// finally the digests of all of them
+func Bundle(w io.Writer, cacheDir string) error {
+	id := filepath.Base(cacheDir)
+	diskPath := filepath.Join(cacheDir, config.DiskImage)
+	meta, err := bootc.ReadDiskMeta(diskPath)
+	if err != nil {
+		return err
+	}
+
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+
+	digests := map[string]string{}
+	info, err := json.Marshal(bundleInfo{Version: bundleVersion, Id: id, Created: time.Now()})
+	if err != nil {
+		return err
+	}
+	metaBuf, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := writeBuffer(tw, bundleFile, info); err != nil {
+		return err
+	}
+	if err := writeBuffer(tw, config.DiskMetaFile, metaBuf); err != nil {
+		return err
+	}
+	digests[config.DiskMetaFile] = bufferDigest(metaBuf)
+
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || skipped(name) || name == config.DiskImage {
+			continue
+		}
+		buf, err := os.ReadFile(filepath.Join(cacheDir, name))
+		if err != nil {
+			return err
+		}
+		if err := writeBuffer(tw, name, buf); err != nil {
+			return err
+		}
+		digests[name] = bufferDigest(buf)
+	}
+
+	disk, err := os.Open(diskPath)
+	if err != nil {
+		return err
+	}
+	defer disk.Close()
+	if digests[config.DiskImage], err = writeSparseFile(zw, tw, config.DiskImage, disk); err != nil {
+		return err
+	}
+
+	digestsBuf, err := json.Marshal(digests)
+	if err != nil {
+		return err
+	}
+	if err := writeBuffer(tw, digestsFile, digestsBuf); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeBuffer(tw *tar.Writer, name string, buf []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0o644,
+		Size:     int64(len(buf)),
+		ModTime:  time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(buf)
+	return err
+}
+
+func bufferDigest(buf []byte) string {
+	d := newBlockDigest()
+	d.writeAt(buf, 0)
+	return d.sum()
+}
+
+// ReadId returns the id of the cache entry in the bundle from its first entry
+func ReadId(r io.Reader) (string, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	info, err := readInfo(tar.NewReader(zr))
+	if err != nil {
+		return "", err
+	}
+	return info.Id, nil
+}
+
+func readInfo(tr *tar.Reader) (*bundleInfo, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("reading the bundle: %w", err)
+	}
+	if hdr.Name != bundleFile {
+		return nil, fmt.Errorf("not a podman-bootc bundle, it starts with %s", hdr.Name)
+	}
+	var info bundleInfo
+	if err := json.NewDecoder(io.LimitReader(tr, 64*1024)).Decode(&info); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", bundleFile, err)
+	}
+	if info.Version != bundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", info.Version)
+	}
+	if !bootc.IsCacheEntryId(info.Id) {
+		return nil, fmt.Errorf("invalid cache entry id %q in the bundle", info.Id)
+	}
+	return &info, nil
+}
+
+// Unbundle recreates the cache entry of the bundle read from r in cacheRoot,
+// verifying the digests of its files. It returns the id of the entry. The
+// caller must hold the lock of the entry.
+func Unbundle(r io.Reader, cacheRoot string, opts Options) (string, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	info, err := readInfo(tr)
+	if err != nil {
+		return "", err
+	}
+
+	tmpDir, err := os.MkdirTemp(cacheRoot, ".unbundle-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	computed := map[string]string{}
+	var expected map[string]string
+	var meta *bootc.DiskMeta
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("reading the bundle: %w", err)
+		}
+		if expected != nil {
+			return "", fmt.Errorf("unexpected %s after %s", hdr.Name, digestsFile)
+		}
+		if hdr.Typeflag != tar.TypeReg || hdr.Name != filepath.Base(hdr.Name) || hdr.Name == ".." || hdr.Name == bundleFile {
+			return "", fmt.Errorf("invalid bundle entry %s", hdr.Name)
+		}
+
+		if hdr.Name == digestsFile {
+			if err := json.NewDecoder(io.LimitReader(tr, 1024*1024)).Decode(&expected); err != nil {
+				return "", fmt.Errorf("parsing %s: %w", digestsFile, err)
+			}
+			continue
+		}
+		if hdr.Name == config.DiskMetaFile {
+			buf, err := io.ReadAll(io.LimitReader(tr, 16*1024*1024))
+			if err != nil {
+				return "", err
+			}
+			meta = &bootc.DiskMeta{}
+			if err := json.Unmarshal(buf, meta); err != nil {
+				return "", fmt.Errorf("parsing the disk metadata: %w", err)
+			}
+			computed[hdr.Name] = bufferDigest(buf)
+			continue
+		}
+		if computed[hdr.Name], err = extractFile(tr, hdr, filepath.Join(tmpDir, hdr.Name)); err != nil {
+			return "", err
+		}
+	}
+
+	if expected == nil {
+		return "", fmt.Errorf("the bundle is truncated, %s is missing", digestsFile)
+	}
+	if meta == nil || computed[config.DiskImage] == "" {
+		return "", errors.New("the bundle has no disk image")
+	}
+	for name, digest := range expected {
+		if computed[name] != digest {
+			return "", fmt.Errorf("digest mismatch for %s: expected %s, got %s", name, digest, computed[name])
+		}
+	}
+	for name := range computed {
+		if _, ok := expected[name]; !ok {
+			return "", fmt.Errorf("%s has no digest in the bundle", name)
+		}
+	}
+	if meta.ImageDigest != info.Id {
+		return "", fmt.Errorf("the disk image was built from %s, not %s", meta.ImageDigest, info.Id)
+	}
+
+	if _, ok := computed[config.ProvenanceFile]; ok {
+		if _, err := bootc.VerifyProvenance(filepath.Join(tmpDir, config.ProvenanceFile), filepath.Join(tmpDir, config.DiskImage)); err != nil {
+			return "", err
+		}
+	}
+
+	target := filepath.Join(cacheRoot, info.Id)
+	if existing, err := bootc.ReadDiskMeta(filepath.Join(target, config.DiskImage)); err == nil {
+		if existing.Created.After(meta.Created) && !opts.Force {
+			return "", fmt.Errorf("the cache entry %s is newer than the bundle (built %s), use --force to replace it", info.Id, existing.Created.Format(time.RFC3339))
+		}
+	}
+
+	// The extracted disk image was verified, its mtime may differ
+	if err := meta.SetFingerprint(filepath.Join(tmpDir, config.DiskImage)); err != nil {
+		return "", err
+	}
+	if err := bootc.WriteDiskMeta(filepath.Join(tmpDir, config.DiskImage), meta); err != nil {
+		return "", err
+	}
+	if err := bootc.ReplaceCacheEntry(tmpDir, target); err != nil {
+		return "", err
+	}
+	return info.Id, nil
+}
+
+func extractFile(tr *tar.Reader, hdr *tar.Header, path string) (string, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// archive/tar seeks over the holes of sparse entries
+	w := &digestWriter{f: f, digest: newBlockDigest()}
+	if _, err := io.Copy(w, tr); err != nil {
+		return "", fmt.Errorf("extracting %s: %w", hdr.Name, err)
+	}
+	if err := f.Truncate(hdr.Size); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chtimes(path, hdr.ModTime, hdr.ModTime); err != nil {
+		logrus.Debugf("unable to set the mtime of %s: %v", path, err)
+	}
+	return w.digest.sum(), nil
+}
@@ -0,0 +1,139 @@
+package bundle
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/bootc"
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestBundle(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Bundle Suite")
+}
+
+const testID = "a025064b145ed339eeef86046aea3ee221a2a5a16f588aff4f43a42e5ca9f844"
+
+// testEntry creates a cache entry with a sparse disk image of 64MiB
+func testEntry(cacheRoot string, created time.Time) string {
+	dir := filepath.Join(cacheRoot, testID)
+	Expect(os.MkdirAll(dir, 0o755)).To(Succeed())
+
+	diskPath := filepath.Join(dir, config.DiskImage)
+	f, err := os.Create(diskPath)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(f.Truncate(64 * 1024 * 1024)).To(Succeed())
+	_, err = f.WriteAt([]byte("bootloader"), 0)
+	Expect(err).ToNot(HaveOccurred())
+	_, err = f.WriteAt([]byte("filesystem"), 32*1024*1024)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(f.Close()).To(Succeed())
+
+	Expect(bootc.WriteDiskMeta(diskPath, &bootc.DiskMeta{ImageDigest: testID, Created: created})).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(dir, config.SshKeyFile), []byte("key"), 0o600)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(dir, "install.log"), []byte("Installation complete!\n"), 0o600)).To(Succeed())
+	return dir
+}
+
+var _ = Describe("Bundle", func() {
+	It("should recreate the cache entry", func() {
+		source := GinkgoT().TempDir()
+		created := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+		var bundle bytes.Buffer
+		Expect(Bundle(&bundle, testEntry(source, created))).To(Succeed())
+		Expect(bundle.Len()).To(BeNumerically("<", 1024*1024))
+
+		target := GinkgoT().TempDir()
+		id, err := Unbundle(bytes.NewReader(bundle.Bytes()), target, Options{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(id).To(Equal(testID))
+
+		diskPath := filepath.Join(target, testID, config.DiskImage)
+		disk, err := os.ReadFile(diskPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(disk).To(HaveLen(64 * 1024 * 1024))
+		Expect(string(disk[:10])).To(Equal("bootloader"))
+		Expect(string(disk[32*1024*1024 : 32*1024*1024+10])).To(Equal("filesystem"))
+
+		meta, err := bootc.ReadDiskMeta(diskPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(meta.ImageDigest).To(Equal(testID))
+		Expect(meta.Created.Equal(created)).To(BeTrue())
+		Expect(filepath.Join(target, testID, config.SshKeyFile)).To(BeARegularFile())
+		installLog, err := os.ReadFile(filepath.Join(target, testID, "install.log"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(installLog)).To(Equal("Installation complete!\n"))
+
+		entries, err := os.ReadDir(target)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+	})
+
+	It("should verify the provenance of the disk image", func() {
+		source := GinkgoT().TempDir()
+		dir := testEntry(source, time.Now())
+		provenance := `{"_type":"https://in-toto.io/Statement/v1","predicateType":"https://slsa.dev/provenance/v1",` +
+			`"subject":[{"name":"disk.raw","digest":{"sha256":"0000"}}]}`
+		Expect(os.WriteFile(filepath.Join(dir, config.ProvenanceFile), []byte(provenance), 0o644)).To(Succeed())
+		var bundle bytes.Buffer
+		Expect(Bundle(&bundle, dir)).To(Succeed())
+
+		_, err := Unbundle(bytes.NewReader(bundle.Bytes()), GinkgoT().TempDir(), Options{})
+		Expect(err).To(MatchError(ContainSubstring("the provenance does not match the disk image")))
+	})
+
+	It("should refuse to replace a newer entry without force", func() {
+		source := GinkgoT().TempDir()
+		var bundle bytes.Buffer
+		Expect(Bundle(&bundle, testEntry(source, time.Now().Add(-time.Hour)))).To(Succeed())
+
+		target := GinkgoT().TempDir()
+		testEntry(target, time.Now())
+		_, err := Unbundle(bytes.NewReader(bundle.Bytes()), target, Options{})
+		Expect(err).To(MatchError(ContainSubstring("--force")))
+
+		_, err = Unbundle(bytes.NewReader(bundle.Bytes()), target, Options{Force: true})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should keep the data disks of the replaced entry", func() {
+		source := GinkgoT().TempDir()
+		var bundle bytes.Buffer
+		Expect(Bundle(&bundle, testEntry(source, time.Now().Add(-time.Hour)))).To(Succeed())
+
+		target := GinkgoT().TempDir()
+		dir := testEntry(target, time.Now())
+		Expect(os.WriteFile(filepath.Join(dir, config.DataDiskPrefix+"1.raw"), []byte("data"), 0o644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, config.DataDisksFile), []byte(`[{"name":"data-1.raw"}]`), 0o644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "build-failure.json"), []byte("{}"), 0o644)).To(Succeed())
+		_, err := Unbundle(bytes.NewReader(bundle.Bytes()), target, Options{Force: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		data, err := os.ReadFile(filepath.Join(dir, config.DataDiskPrefix+"1.raw"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).To(Equal("data"))
+		Expect(filepath.Join(dir, config.DataDisksFile)).To(BeARegularFile())
+		Expect(filepath.Join(dir, "build-failure.json")).ToNot(BeAnExistingFile())
+		entries, err := os.ReadDir(target)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+	})
+
+	It("should reject a truncated bundle", func() {
+		source := GinkgoT().TempDir()
+		var bundle bytes.Buffer
+		Expect(Bundle(&bundle, testEntry(source, time.Now()))).To(Succeed())
+
+		target := GinkgoT().TempDir()
+		_, err := Unbundle(bytes.NewReader(bundle.Bytes()[:bundle.Len()/2]), target, Options{})
+		Expect(err).To(HaveOccurred())
+		Expect(filepath.Join(target, testID)).ToNot(BeADirectory())
+	})
+})
@@ -0,0 +1,268 @@
+package bundle
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	blockSize = 512
+	// digestBlockSize is the granularity at which zeros are treated as holes
+	digestBlockSize = 4096
+)
+
+// segment is a data range of a sparse file
+type segment struct {
+	offset, length int64
+}
+
+// dataSegments returns the data ranges of f, aligned to digestBlockSize. The
+// whole file is data when the filesystem does not report holes.
+func dataSegments(f *os.File, size int64) ([]segment, error) {
+	var segments []segment
+	fd := int(f.Fd())
+	for offset := int64(0); offset < size; {
+		start, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			break
+		}
+		if errors.Is(err, unix.EINVAL) {
+			return []segment{{offset: 0, length: size}}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		end, err := unix.Seek(fd, start, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		start = start &^ (digestBlockSize - 1)
+		if end = (end + digestBlockSize - 1) &^ (digestBlockSize - 1); end > size {
+			end = size
+		}
+		if n := len(segments); n > 0 && segments[n-1].offset+segments[n-1].length >= start {
+			segments[n-1].length = end - segments[n-1].offset
+		} else {
+			segments = append(segments, segment{offset: start, length: end - start})
+		}
+		offset = end
+	}
+	return segments, nil
+}
+
+// blockDigest hashes the offset and content of the non-zero blocks of a
+// file, so it does not depend on how the holes are represented. Writes must
+// be at increasing offsets.
+type blockDigest struct {
+	h     hash.Hash
+	index int64
+	block []byte
+	dirty bool
+}
+
+func newBlockDigest() *blockDigest {
+	return &blockDigest{h: sha256.New(), index: -1, block: make([]byte, digestBlockSize)}
+}
+
+func (d *blockDigest) writeAt(p []byte, off int64) {
+	for len(p) > 0 {
+		if index := off / digestBlockSize; index != d.index {
+			d.flush()
+			d.index = index
+		}
+		n := copy(d.block[off%digestBlockSize:], p)
+		d.dirty = true
+		p = p[n:]
+		off += int64(n)
+	}
+}
+
+func (d *blockDigest) flush() {
+	if !d.dirty {
+		return
+	}
+	for _, b := range d.block {
+		if b != 0 {
+			var index [8]byte
+			binary.BigEndian.PutUint64(index[:], uint64(d.index))
+			d.h.Write(index[:])
+			d.h.Write(d.block)
+			break
+		}
+	}
+	for i := range d.block {
+		d.block[i] = 0
+	}
+	d.dirty = false
+}
+
+func (d *blockDigest) sum() string {
+	d.flush()
+	return "sha256:" + hex.EncodeToString(d.h.Sum(nil))
+}
+
+// digestWriter feeds the blockDigest with the writes to a file, tracking the
+// seeks over holes
+type digestWriter struct {
+	f      *os.File
+	offset int64
+	digest *blockDigest
+}
+
+func (w *digestWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.digest.writeAt(p[:n], w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+func (w *digestWriter) Seek(offset int64, whence int) (int64, error) {
+	pos, err := w.f.Seek(offset, whence)
+	if err == nil {
+		w.offset = pos
+	}
+	return pos, err
+}
+
+// paxRecord formats a PAX record, whose length includes itself
+func paxRecord(key, value string) string {
+	size := len(key) + len(value) + 3
+	size += len(strconv.Itoa(size))
+	record := strconv.Itoa(size) + " " + key + "=" + value + "\n"
+	if len(record) != size {
+		record = strconv.Itoa(len(record)) + " " + key + "=" + value + "\n"
+	}
+	return record
+}
+
+// ustarHeader formats a USTAR header block
+func ustarHeader(typeflag byte, name string, size int64, modTime time.Time) ([]byte, error) {
+	if len(name) > 100 {
+		return nil, fmt.Errorf("tar entry name %s too long", name)
+	}
+	block := make([]byte, blockSize)
+	copy(block[0:100], name)
+	copy(block[100:108], "0000644\x00")
+	copy(block[108:116], "0000000\x00")
+	copy(block[116:124], "0000000\x00")
+	copy(block[124:136], fmt.Sprintf("%011o\x00", size))
+	copy(block[136:148], fmt.Sprintf("%011o\x00", modTime.Unix()))
+	block[156] = typeflag
+	copy(block[257:263], "ustar\x00")
+	copy(block[263:265], "00")
+
+	copy(block[148:156], "        ")
+	sum := 0
+	for _, b := range block {
+		sum += int(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	return block, nil
+}
+
+func padding(size int64) []byte {
+	return make([]byte, (blockSize-size%blockSize)%blockSize)
+}
+
+// writeSparseFile writes f as a PAX 1.0 GNU sparse entry, which
+// archive/tar does not write but reads: a PAX header with the real name and
+// size, then an entry holding the sparse map followed by the data segments.
+// It returns the block digest of the file.
+func writeSparseFile(out io.Writer, tw *tar.Writer, name string, f *os.File) (string, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	segments, err := dataSegments(f, st.Size())
+	if err != nil {
+		return "", fmt.Errorf("finding the holes of %s: %w", name, err)
+	}
+
+	// Like GNU tar, a final empty segment records the trailing hole
+	if n := len(segments); n == 0 || segments[n-1].offset+segments[n-1].length < st.Size() {
+		segments = append(segments, segment{offset: st.Size()})
+	}
+	sparseMap := strconv.Itoa(len(segments)) + "\n"
+	dataSize := int64(0)
+	for _, s := range segments {
+		sparseMap += strconv.FormatInt(s.offset, 10) + "\n" + strconv.FormatInt(s.length, 10) + "\n"
+		dataSize += s.length
+	}
+	sparseMapBlocks := append([]byte(sparseMap), padding(int64(len(sparseMap)))...)
+
+	records := map[string]string{
+		"GNU.sparse.major":    "1",
+		"GNU.sparse.minor":    "0",
+		"GNU.sparse.name":     name,
+		"GNU.sparse.realsize": strconv.FormatInt(st.Size(), 10),
+	}
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pax := ""
+	for _, key := range keys {
+		pax += paxRecord(key, records[key])
+	}
+
+	// The PAX header goes straight to the output, between the entries
+	if err := tw.Flush(); err != nil {
+		return "", err
+	}
+	header, err := ustarHeader(tar.TypeXHeader, "PaxHeaders.0/"+name, int64(len(pax)), st.ModTime())
+	if err != nil {
+		return "", err
+	}
+	for _, b := range [][]byte{header, []byte(pax), padding(int64(len(pax)))} {
+		if _, err := out.Write(b); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     "GNUSparseFile.0/" + name,
+		Mode:     0o644,
+		Size:     int64(len(sparseMapBlocks)) + dataSize,
+		ModTime:  st.ModTime(),
+		Format:   tar.FormatUSTAR,
+	}); err != nil {
+		return "", err
+	}
+	if _, err := tw.Write(sparseMapBlocks); err != nil {
+		return "", err
+	}
+
+	digest := newBlockDigest()
+	buf := make([]byte, 1024*1024)
+	for _, s := range segments {
+		for offset := s.offset; offset < s.offset+s.length; {
+			chunk := buf
+			if rest := s.offset + s.length - offset; rest < int64(len(chunk)) {
+				chunk = chunk[:rest]
+			}
+			if _, err := f.ReadAt(chunk, offset); err != nil {
+				return "", fmt.Errorf("reading %s: %w", name, err)
+			}
+			if _, err := tw.Write(chunk); err != nil {
+				return "", err
+			}
+			digest.writeAt(chunk, offset)
+			offset += int64(len(chunk))
+		}
+	}
+	return digest.sum(), nil
+}
@@ -0,0 +1,319 @@
+package chunked
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ManifestFile is the name of the manifest in an export directory
+	ManifestFile = "manifest.json"
+	// journalFile records the chunks written so far, to resume an export
+	journalFile = "manifest.json.partial"
+
+	manifestVersion = 1
+)
+
+// Chunk is a range of the disk image. Data chunks are stored in their own
+// file, zero chunks are holes merged into a single range and have no file.
+type Chunk struct {
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	File   string `json:"file,omitempty"`
+	Sha256 string `json:"sha256,omitempty"`
+	Zero   bool   `json:"zero,omitempty"`
+}
+
+// Manifest describes a disk image split in chunks
+type Manifest struct {
+	Version   int     `json:"version"`
+	Size      int64   `json:"size"`
+	ChunkSize int64   `json:"chunkSize"`
+	Chunks    []Chunk `json:"chunks"`
+}
+
+// journal is the manifest of an interrupted export and the disk image it
+// exported
+type journal struct {
+	Manifest
+	Disk diskIdentity `json:"disk"`
+}
+
+// diskIdentity identifies the contents of a disk image, a rebuilt disk
+// image of the same size differs by its mtime
+type diskIdentity struct {
+	Id      string    `json:"id,omitempty"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// ExportOptions configures Export
+type ExportOptions struct {
+	ChunkSize int64
+	// DiskId identifies the disk image, e.g. the id of its image
+	DiskId string
+	// Resume skips the chunks already written by an interrupted export of
+	// the same disk image
+	Resume bool
+	// Progress is called after each chunk with the bytes processed so far
+	Progress func(done, total int64)
+}
+
+func chunkFileName(offset, chunkSize int64) string {
+	return fmt.Sprintf("chunk-%06d", offset/chunkSize)
+}
+
+// readJournal loads the chunks recorded by a previous export of the disk
+// image, the data chunks whose file is missing or truncated are written again
+func readJournal(path string, disk diskIdentity, size, chunkSize int64) (map[int64]Chunk, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return map[int64]Chunk{}, nil
+		}
+		return nil, err
+	}
+	var j journal
+	if err := json.Unmarshal(buf, &j); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if j.Disk.Id != disk.Id || !j.Disk.ModTime.Equal(disk.ModTime) {
+		return nil, fmt.Errorf("the interrupted export in %s is of another disk image, remove it to export again", filepath.Dir(path))
+	}
+	if j.Size != size || j.ChunkSize != chunkSize {
+		return nil, fmt.Errorf("the interrupted export in %s has a different size or chunk size", filepath.Dir(path))
+	}
+	done := make(map[int64]Chunk, len(j.Chunks))
+	for _, c := range j.Chunks {
+		if c.Length <= 0 || c.Offset+c.Length > size {
+			return nil, fmt.Errorf("the interrupted export in %s has an invalid chunk at offset %d", filepath.Dir(path), c.Offset)
+		}
+		if !c.Zero {
+			if c.File != chunkFileName(c.Offset, chunkSize) {
+				return nil, fmt.Errorf("the interrupted export in %s has an invalid chunk file %q", filepath.Dir(path), c.File)
+			}
+			st, err := os.Stat(filepath.Join(filepath.Dir(path), c.File))
+			if err != nil || st.Size() != c.Length {
+				logrus.Debugf("writing the chunk %s again: %v", c.File, err)
+				continue
+			}
+		}
+		done[c.Offset] = c
+	}
+	return done, nil
+}
+
+func writeManifest(path string, manifest any) error {
+	buf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Export splits the disk image in chunk files and a manifest in directory
+func Export(diskPath, directory string, opts ExportOptions) (*Manifest, error) {
+	if opts.ChunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size %d", opts.ChunkSize)
+	}
+
+	src, err := os.Open(diskPath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	st, err := src.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(directory, ManifestFile)); err == nil {
+		return nil, fmt.Errorf("%s already contains an export", directory)
+	}
+
+	manifest := &Manifest{
+		Version:   manifestVersion,
+		Size:      st.Size(),
+		ChunkSize: opts.ChunkSize,
+	}
+	disk := diskIdentity{Id: opts.DiskId, ModTime: st.ModTime().UTC()}
+	journalPath := filepath.Join(directory, journalFile)
+	done := map[int64]Chunk{}
+	if opts.Resume {
+		done, err = readJournal(journalPath, disk, manifest.Size, manifest.ChunkSize)
+		if err != nil {
+			return nil, err
+		}
+		logrus.Debugf("resuming export with %d chunks already written", len(done))
+	}
+
+	buf := make([]byte, opts.ChunkSize)
+	// A journaled hole spans several chunks, the export goes on where it
+	// ends
+	var chunk Chunk
+	for offset := int64(0); offset < manifest.Size; offset += chunk.Length {
+		var ok bool
+		chunk, ok = done[offset]
+		if !ok {
+			n, err := src.ReadAt(buf, offset)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			chunk, err = writeChunk(buf[:n], offset, directory, opts.ChunkSize)
+			if err != nil {
+				return nil, err
+			}
+		}
+		manifest.appendChunk(chunk)
+		if err := writeManifest(journalPath, &journal{Manifest: *manifest, Disk: disk}); err != nil {
+			return nil, fmt.Errorf("writing the export journal: %w", err)
+		}
+		if opts.Progress != nil {
+			opts.Progress(offset+chunk.Length, manifest.Size)
+		}
+	}
+
+	if err := writeManifest(filepath.Join(directory, ManifestFile), manifest); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(journalPath); err != nil {
+		logrus.Warnf("unable to remove %s: %v", journalPath, err)
+	}
+	return manifest, nil
+}
+
+// appendChunk adds a chunk to the manifest, merging consecutive zero chunks
+func (m *Manifest) appendChunk(chunk Chunk) {
+	if chunk.Zero && len(m.Chunks) > 0 {
+		last := &m.Chunks[len(m.Chunks)-1]
+		if last.Zero && last.Offset+last.Length == chunk.Offset {
+			last.Length += chunk.Length
+			return
+		}
+	}
+	m.Chunks = append(m.Chunks, chunk)
+}
+
+// writeChunk stores the data of a chunk in its own file, written under a
+// temporary name so an interrupted export never leaves a partial chunk
+func writeChunk(data []byte, offset int64, directory string, chunkSize int64) (Chunk, error) {
+	chunk := Chunk{Offset: offset, Length: int64(len(data))}
+	if isZero(data) {
+		chunk.Zero = true
+		return chunk, nil
+	}
+
+	sum := sha256.Sum256(data)
+	chunk.Sha256 = hex.EncodeToString(sum[:])
+	chunk.File = chunkFileName(offset, chunkSize)
+
+	path := filepath.Join(directory, chunk.File)
+	if err := os.WriteFile(path+".part", data, 0644); err != nil {
+		return chunk, err
+	}
+	if err := os.Rename(path+".part", path); err != nil {
+		return chunk, err
+	}
+	return chunk, nil
+}
+
+// ReadManifest loads the manifest of an export directory
+func ReadManifest(directory string) (*Manifest, error) {
+	buf, err := os.ReadFile(filepath.Join(directory, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(buf, &manifest); err != nil {
+		return nil, fmt.Errorf("parsing the manifest: %w", err)
+	}
+	if manifest.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", manifest.Version)
+	}
+	return &manifest, nil
+}
+
+// Assemble reconstructs the disk image exported in directory, verifying
+// the checksum of every chunk. Zero chunks are left as holes.
+func Assemble(directory, diskPath string) error {
+	manifest, err := ReadManifest(directory)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(diskPath), "."+filepath.Base(diskPath))
+	if err != nil {
+		return err
+	}
+	doCleanup := true
+	defer func() {
+		tmp.Close()
+		if doCleanup {
+			os.Remove(tmp.Name())
+		}
+	}()
+	if err := tmp.Truncate(manifest.Size); err != nil {
+		return err
+	}
+
+	var end int64
+	for _, chunk := range manifest.Chunks {
+		if chunk.Offset != end || chunk.Length <= 0 {
+			return fmt.Errorf("invalid manifest: chunk at offset %d does not follow the previous one", chunk.Offset)
+		}
+		end += chunk.Length
+		if chunk.Zero {
+			continue
+		}
+		if chunk.File != filepath.Base(chunk.File) {
+			return fmt.Errorf("invalid manifest: chunk file %q", chunk.File)
+		}
+		data, err := os.ReadFile(filepath.Join(directory, chunk.File))
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		if int64(len(data)) != chunk.Length || hex.EncodeToString(sum[:]) != chunk.Sha256 {
+			return fmt.Errorf("chunk %s is corrupted", chunk.File)
+		}
+		if _, err := tmp.WriteAt(data, chunk.Offset); err != nil {
+			return err
+		}
+	}
+	if end != manifest.Size {
+		return fmt.Errorf("invalid manifest: chunks cover %d of %d bytes", end, manifest.Size)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), diskPath); err != nil {
+		return err
+	}
+	doCleanup = false
+	return nil
+}
+
+func isZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package chunked
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestChunked(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Chunked Suite")
+}
+
+const testChunkSize = 4096
+
+// writeTestDisk creates a sparse disk with data in the first and last chunks
+func writeTestDisk(path string) []byte {
+	content := make([]byte, 10*testChunkSize+100)
+	copy(content, bytes.Repeat([]byte("a"), testChunkSize+10))
+	copy(content[len(content)-50:], bytes.Repeat([]byte("z"), 50))
+	Expect(os.WriteFile(path, content, 0644)).To(Succeed())
+	return content
+}
+
+// interruptExport turns the export of the disk image in directory into an
+// export interrupted after the chunks of manifest
+func interruptExport(diskPath, directory string, manifest *Manifest) {
+	st, err := os.Stat(diskPath)
+	Expect(err).ToNot(HaveOccurred())
+	disk := diskIdentity{Id: "test", ModTime: st.ModTime().UTC()}
+	Expect(writeManifest(filepath.Join(directory, journalFile), &journal{Manifest: *manifest, Disk: disk})).To(Succeed())
+	Expect(os.Remove(filepath.Join(directory, ManifestFile))).To(Succeed())
+}
+
+var _ = Describe("Chunked export", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	It("should round trip a sparse disk image", func() {
+		content := writeTestDisk(filepath.Join(dir, "disk.raw"))
+
+		manifest, err := Export(filepath.Join(dir, "disk.raw"), filepath.Join(dir, "export"), ExportOptions{ChunkSize: testChunkSize})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(manifest.Size).To(Equal(int64(len(content))))
+		// two data chunks, one merged hole and the last data chunk
+		Expect(manifest.Chunks).To(HaveLen(4))
+		Expect(manifest.Chunks[2].Zero).To(BeTrue())
+		Expect(manifest.Chunks[2].File).To(BeEmpty())
+
+		Expect(Assemble(filepath.Join(dir, "export"), filepath.Join(dir, "assembled.raw"))).To(Succeed())
+		assembled, err := os.ReadFile(filepath.Join(dir, "assembled.raw"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(assembled).To(Equal(content))
+	})
+
+	It("should resume an interrupted export", func() {
+		writeTestDisk(filepath.Join(dir, "disk.raw"))
+		export := filepath.Join(dir, "export")
+
+		_, err := Export(filepath.Join(dir, "disk.raw"), export, ExportOptions{ChunkSize: testChunkSize})
+		Expect(err).ToNot(HaveOccurred())
+		// simulate an interruption after the first chunk
+		manifest, err := ReadManifest(export)
+		Expect(err).ToNot(HaveOccurred())
+		manifest.Chunks = manifest.Chunks[:1]
+		interruptExport(filepath.Join(dir, "disk.raw"), export, manifest)
+		Expect(os.Remove(filepath.Join(export, "chunk-000001"))).To(Succeed())
+
+		var written []int64
+		_, err = Export(filepath.Join(dir, "disk.raw"), export, ExportOptions{
+			ChunkSize: testChunkSize,
+			DiskId:    "test",
+			Resume:    true,
+			Progress:  func(done, _ int64) { written = append(written, done) },
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(written).To(HaveLen(11))
+		Expect(filepath.Join(export, "chunk-000001")).To(BeARegularFile())
+		Expect(Assemble(export, filepath.Join(dir, "assembled.raw"))).To(Succeed())
+	})
+
+	It("should resume an export interrupted in a hole", func() {
+		content := writeTestDisk(filepath.Join(dir, "disk.raw"))
+		export := filepath.Join(dir, "export")
+
+		_, err := Export(filepath.Join(dir, "disk.raw"), export, ExportOptions{ChunkSize: testChunkSize})
+		Expect(err).ToNot(HaveOccurred())
+		// simulate an interruption after three chunks of the hole
+		manifest, err := ReadManifest(export)
+		Expect(err).ToNot(HaveOccurred())
+		manifest.Chunks = manifest.Chunks[:3]
+		manifest.Chunks[2].Length = 3 * testChunkSize
+		interruptExport(filepath.Join(dir, "disk.raw"), export, manifest)
+
+		manifest, err = Export(filepath.Join(dir, "disk.raw"), export, ExportOptions{ChunkSize: testChunkSize, DiskId: "test", Resume: true})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(manifest.Chunks).To(HaveLen(4))
+		Expect(manifest.Chunks[2]).To(Equal(Chunk{Offset: 2 * testChunkSize, Length: 8 * testChunkSize, Zero: true}))
+		Expect(Assemble(export, filepath.Join(dir, "assembled.raw"))).To(Succeed())
+		assembled, err := os.ReadFile(filepath.Join(dir, "assembled.raw"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(assembled).To(Equal(content))
+	})
+
+	It("should not resume the export of another disk image", func() {
+		writeTestDisk(filepath.Join(dir, "disk.raw"))
+		export := filepath.Join(dir, "export")
+		_, err := Export(filepath.Join(dir, "disk.raw"), export, ExportOptions{ChunkSize: testChunkSize})
+		Expect(err).ToNot(HaveOccurred())
+		manifest, err := ReadManifest(export)
+		Expect(err).ToNot(HaveOccurred())
+		interruptExport(filepath.Join(dir, "disk.raw"), export, manifest)
+
+		_, err = Export(filepath.Join(dir, "disk.raw"), export, ExportOptions{ChunkSize: testChunkSize, DiskId: "other", Resume: true})
+		Expect(err).To(MatchError(ContainSubstring("is of another disk image")))
+
+		// a rebuilt disk image of the same size
+		rebuilt := time.Now().Add(time.Hour)
+		Expect(os.Chtimes(filepath.Join(dir, "disk.raw"), rebuilt, rebuilt)).To(Succeed())
+		_, err = Export(filepath.Join(dir, "disk.raw"), export, ExportOptions{ChunkSize: testChunkSize, DiskId: "test", Resume: true})
+		Expect(err).To(MatchError(ContainSubstring("is of another disk image")))
+	})
+
+	It("should write the missing chunks of the journal again", func() {
+		writeTestDisk(filepath.Join(dir, "disk.raw"))
+		export := filepath.Join(dir, "export")
+		_, err := Export(filepath.Join(dir, "disk.raw"), export, ExportOptions{ChunkSize: testChunkSize})
+		Expect(err).ToNot(HaveOccurred())
+		manifest, err := ReadManifest(export)
+		Expect(err).ToNot(HaveOccurred())
+		interruptExport(filepath.Join(dir, "disk.raw"), export, manifest)
+		Expect(os.Remove(filepath.Join(export, "chunk-000000"))).To(Succeed())
+		Expect(os.Truncate(filepath.Join(export, "chunk-000001"), 10)).To(Succeed())
+
+		_, err = Export(filepath.Join(dir, "disk.raw"), export, ExportOptions{ChunkSize: testChunkSize, DiskId: "test", Resume: true})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(Assemble(export, filepath.Join(dir, "assembled.raw"))).To(Succeed())
+	})
+
+	It("should detect corrupted chunks", func() {
+		writeTestDisk(filepath.Join(dir, "disk.raw"))
+		export := filepath.Join(dir, "export")
+		_, err := Export(filepath.Join(dir, "disk.raw"), export, ExportOptions{ChunkSize: testChunkSize})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(os.WriteFile(filepath.Join(export, "chunk-000000"), bytes.Repeat([]byte("b"), testChunkSize), 0644)).To(Succeed())
+		Expect(Assemble(export, filepath.Join(dir, "assembled.raw"))).To(MatchError(ContainSubstring("corrupted")))
+		Expect(filepath.Join(dir, "assembled.raw")).ToNot(BeAnExistingFile())
+	})
+})
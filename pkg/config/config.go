@@ -6,8 +6,13 @@ const (
 	RunPidFile       = "run.pid"
 	OciArchiveOutput = "image-archive.tar"
 	DiskImage        = "disk.raw"
+	DiskMetaFile     = "disk.meta.json"
+	ProvenanceFile   = "disk.provenance.json"
 	CiDataIso        = "cidata.iso"
 	SshKeyFile       = "sshkey"
 	CfgFile          = "bc.cfg"
+	DataDisksFile    = "data-disks.json"
+	DataDiskPrefix   = "data-"
 	LibvirtUri       = "qemu:///session"
+	MachineName      = "podman-bootc"
 )
@@ -0,0 +1,123 @@
+package doctor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+)
+
+func init() {
+	Register(Check{Name: "podman machine", Run: checkPodmanMachine})
+	Register(Check{Name: "cache directory", Run: checkCacheDir})
+	Register(Check{Name: "loop devices", Run: checkMachineLoopDevices})
+	Register(Check{Name: "selinux", Run: checkMachineSELinux})
+	Register(Check{Name: "ssh client", Run: checkBinary("ssh", Fail, "install the openssh client")})
+	Register(Check{Name: "xorrisofs", Run: checkBinary("xorrisofs", Warn, "install xorriso, it is required by --cloudinit")})
+}
+
+func checkPodmanMachine(user user.User) (Status, string, string) {
+	return evaluatePodmanMachine(utils.GetMachineInfo(user))
+}
+
+func evaluatePodmanMachine(machineInfo *utils.MachineInfo, err error) (Status, string, string) {
+	if err != nil {
+		return Fail, err.Error(), "run 'podman machine init --rootful' and 'podman machine start'"
+	}
+	if machineInfo == nil {
+		return Fail, "no podman machine found", "run 'podman machine init --rootful' and 'podman machine start'"
+	}
+	if !machineInfo.Rootful {
+		return Fail, "the podman machine is not rootful", "run 'podman machine set --rootful'"
+	}
+	if _, err := os.Stat(machineInfo.PodmanSocket); err != nil {
+		return Fail, fmt.Sprintf("podman socket %s is missing", machineInfo.PodmanSocket), "run 'podman machine start'"
+	}
+	return Pass, "rootful podman machine listening on " + machineInfo.PodmanSocket, ""
+}
+
+func checkCacheDir(user user.User) (Status, string, string) {
+	dir := user.CacheDir()
+	if err := utils.ProbeCacheDir(dir); err != nil {
+		var probeErr *utils.CacheDirError
+		if errors.As(err, &probeErr) {
+			return Fail, err.Error(), probeErr.Hint
+		}
+		return Fail, err.Error(), "fix the permissions of " + dir
+	}
+
+	if fsType, err := utils.NetworkFilesystem(dir); err == nil && fsType != "" {
+		return Warn, fmt.Sprintf("%s is on a network filesystem (%s)", dir, fsType), "keep the clocks of the hosts sharing the cache synchronized, see the README"
+	}
+	return Pass, dir + " is writable and supports user xattrs", ""
+}
+
+// machineSSH runs a command in the podman machine, where the install
+// container and its loop devices are running
+func machineSSH(user user.User, command string) (string, error) {
+	args := []string{"machine", "ssh"}
+	if machineInfo, err := utils.GetMachineInfo(user); err == nil && machineInfo != nil && machineInfo.Name != "" {
+		args = append(args, machineInfo.Name)
+	}
+	cmd := exec.Command("podman", append(args, command)...)
+	var stdout strings.Builder
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	return strings.TrimSpace(stdout.String()), err
+}
+
+func checkMachineLoopDevices(user user.User) (Status, string, string) {
+	_, err := machineSSH(user, "test -e /dev/loop-control")
+	return evaluateLoopDevices(err)
+}
+
+func evaluateLoopDevices(err error) (Status, string, string) {
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return Fail, "the loop module is not available in the podman machine", "run 'podman machine ssh sudo modprobe loop'"
+		}
+		return Warn, fmt.Sprintf("unable to check the podman machine: %v", err), "make sure the podman machine is running"
+	}
+	return Pass, "loop devices are available in the podman machine", ""
+}
+
+func checkMachineSELinux(user user.User) (Status, string, string) {
+	return evaluateSELinux(machineSSH(user, "getenforce"))
+}
+
+// evaluateSELinux checks the mode of SELinux in the podman machine. bootc
+// install labels the files of the disk image with the policy of the image,
+// which it cannot do from a host with SELinux disabled, and a permissive
+// host hides the denials the image would hit when it boots.
+func evaluateSELinux(mode string, err error) (Status, string, string) {
+	if err != nil {
+		return Warn, fmt.Sprintf("unable to query SELinux in the podman machine: %v", err), "make sure the podman machine is running"
+	}
+	switch mode {
+	case "Enforcing":
+		return Pass, "SELinux is enforcing, the install container runs as unconfined_t", ""
+	case "Permissive":
+		return Warn, "SELinux is permissive in the podman machine, the disk images are installed without its denials",
+			"run 'podman machine ssh sudo setenforce 1'"
+	case "Disabled":
+		return Fail, "SELinux is disabled in the podman machine, bootc install cannot label the disk images",
+			"enable SELinux in the podman machine, or recreate it with 'podman machine init --rootful'"
+	default:
+		return Warn, fmt.Sprintf("unknown SELinux mode %q in the podman machine", mode), "make sure SELinux is enforcing in the podman machine"
+	}
+}
+
+func checkBinary(name string, missing Status, hint string) func(user.User) (Status, string, string) {
+	return func(_ user.User) (Status, string, string) {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			return missing, name + " not found in $PATH", hint
+		}
+		return Pass, path, ""
+	}
+}
@@ -0,0 +1,27 @@
+package doctor
+
+import (
+	"errors"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+)
+
+func init() {
+	Register(Check{Name: "kvm", Run: checkKVM})
+}
+
+// checkKVM warns when the VMs fall back to TCG because KVM is not usable
+func checkKVM(_ user.User) (Status, string, string) {
+	return evaluateKVM(utils.CheckKVM())
+}
+
+func evaluateKVM(err error) (Status, string, string) {
+	var kvmErr *utils.KVMError
+	if errors.As(err, &kvmErr) {
+		return Warn, kvmErr.Reason + ", the VMs run with the much slower TCG", kvmErr.Hint
+	} else if err != nil {
+		return Fail, err.Error(), ""
+	}
+	return Pass, "/dev/kvm is accessible", ""
+}
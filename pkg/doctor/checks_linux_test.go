@@ -0,0 +1,21 @@
+package doctor
+
+import (
+	"errors"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = ginkgo.DescribeTable("kvm",
+	func(err error, status Status, message string) {
+		got, msg, _ := evaluateKVM(err)
+		Expect(got).To(Equal(status))
+		Expect(msg).To(ContainSubstring(message))
+	},
+	ginkgo.Entry("accessible", nil, Pass, "/dev/kvm is accessible"),
+	ginkgo.Entry("not usable", &utils.KVMError{Reason: "/dev/kvm does not exist", Hint: "enable virtualization"}, Warn, "the VMs run with the much slower TCG"),
+	ginkgo.Entry("unexpected error", errors.New("ioctl failed"), Fail, "ioctl failed"),
+)
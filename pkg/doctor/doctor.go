@@ -0,0 +1,75 @@
+package doctor
+
+import (
+	"sync"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+)
+
+type Status string
+
+const (
+	Pass Status = "pass"
+	Warn Status = "warn"
+	Fail Status = "fail"
+)
+
+// Result is the outcome of a single check
+type Result struct {
+	Name    string `json:"name"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// Check validates one requirement of the host environment. Run returns
+// the status, a short message and, unless the check passed, a remediation hint.
+type Check struct {
+	Name string
+	Run  func(user user.User) (status Status, message string, hint string)
+}
+
+var (
+	checksMu sync.Mutex
+	checks   []Check
+)
+
+// Register adds a check to the list executed by RunChecks, features
+// register the checks for what they rely on from their init function
+func Register(check Check) {
+	checksMu.Lock()
+	defer checksMu.Unlock()
+	checks = append(checks, check)
+}
+
+// RunChecks executes all the registered checks in registration order
+func RunChecks(user user.User) []Result {
+	checksMu.Lock()
+	registered := append([]Check(nil), checks...)
+	checksMu.Unlock()
+
+	results := make([]Result, 0, len(registered))
+	for _, check := range registered {
+		status, message, hint := check.Run(user)
+		if status == Pass {
+			hint = ""
+		}
+		results = append(results, Result{
+			Name:    check.Name,
+			Status:  status,
+			Message: message,
+			Hint:    hint,
+		})
+	}
+	return results
+}
+
+// Failed returns true if any hard requirement failed
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == Fail {
+			return true
+		}
+	}
+	return false
+}
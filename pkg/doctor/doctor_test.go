@@ -0,0 +1,139 @@
+package doctor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	osuser "os/user"
+	"path/filepath"
+	"testing"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/user"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
+
+	// Fail of the statuses collides with the one of ginkgo
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestDoctor(t *testing.T) {
+	RegisterFailHandler(ginkgo.Fail)
+	ginkgo.RunSpecs(t, "Doctor Suite")
+}
+
+// exitError returns the error of a command exiting with 1
+func exitError() error {
+	err := exec.Command("false").Run()
+	Expect(err).To(BeAssignableToTypeOf(&exec.ExitError{}))
+	return err
+}
+
+// testUser returns a user whose home is a new temporary directory
+func testUser() user.User {
+	return user.User{OSUser: &osuser.User{HomeDir: ginkgo.GinkgoT().TempDir()}}
+}
+
+var _ = ginkgo.Describe("Doctor", func() {
+	ginkgo.DescribeTable("podman machine",
+		func(info func() (*utils.MachineInfo, error), status Status, message string) {
+			got, msg, hint := evaluatePodmanMachine(info())
+			Expect(got).To(Equal(status))
+			Expect(msg).To(ContainSubstring(message))
+			if status != Pass {
+				Expect(hint).ToNot(BeEmpty())
+			}
+		},
+		ginkgo.Entry("rootful and running", func() (*utils.MachineInfo, error) {
+			socket := filepath.Join(ginkgo.GinkgoT().TempDir(), "podman.sock")
+			Expect(os.WriteFile(socket, nil, 0o600)).To(Succeed())
+			return &utils.MachineInfo{Name: "podman-machine-default", PodmanSocket: socket, Rootful: true}, nil
+		}, Pass, "rootful podman machine listening on"),
+		ginkgo.Entry("unknown", func() (*utils.MachineInfo, error) {
+			return nil, errors.New("podman machine inspect failed")
+		}, Fail, "podman machine inspect failed"),
+		ginkgo.Entry("missing", func() (*utils.MachineInfo, error) { return nil, nil }, Fail, "no podman machine found"),
+		ginkgo.Entry("rootless", func() (*utils.MachineInfo, error) {
+			return &utils.MachineInfo{Name: "podman-machine-default", Rootful: false}, nil
+		}, Fail, "not rootful"),
+		ginkgo.Entry("stopped", func() (*utils.MachineInfo, error) {
+			return &utils.MachineInfo{Name: "podman-machine-default", PodmanSocket: "/nonexistent/podman.sock", Rootful: true}, nil
+		}, Fail, "podman socket /nonexistent/podman.sock is missing"),
+	)
+
+	ginkgo.DescribeTable("cache directory",
+		func(setup func(user.User), status Status, message string) {
+			u := testUser()
+			setup(u)
+			got, msg, _ := checkCacheDir(u)
+			Expect(got).To(Equal(status), msg)
+			Expect(msg).To(ContainSubstring(message))
+		},
+		ginkgo.Entry("writable", func(user.User) {}, Pass, "is writable and supports user xattrs"),
+		ginkgo.Entry("not a directory", func(u user.User) {
+			Expect(os.WriteFile(filepath.Join(u.HomeDir(), ".cache"), nil, 0o644)).To(Succeed())
+		}, Fail, "cannot be created"),
+	)
+
+	ginkgo.DescribeTable("loop devices",
+		func(err func() error, status Status, message string) {
+			got, msg, _ := evaluateLoopDevices(err())
+			Expect(got).To(Equal(status))
+			Expect(msg).To(ContainSubstring(message))
+		},
+		ginkgo.Entry("available", func() error { return nil }, Pass, "loop devices are available"),
+		ginkgo.Entry("missing module", exitError, Fail, "the loop module is not available"),
+		ginkgo.Entry("machine unreachable", func() error { return exec.ErrNotFound }, Warn, "unable to check the podman machine"),
+	)
+
+	ginkgo.DescribeTable("selinux",
+		func(mode string, err error, status Status, message string) {
+			got, msg, hint := evaluateSELinux(mode, err)
+			Expect(got).To(Equal(status))
+			Expect(msg).To(ContainSubstring(message))
+			if status != Pass {
+				Expect(hint).ToNot(BeEmpty())
+			}
+		},
+		ginkgo.Entry("enforcing", "Enforcing", nil, Pass, "SELinux is enforcing"),
+		ginkgo.Entry("permissive", "Permissive", nil, Warn, "SELinux is permissive"),
+		ginkgo.Entry("disabled", "Disabled", nil, Fail, "SELinux is disabled"),
+		ginkgo.Entry("unknown mode", "Confused", nil, Warn, `unknown SELinux mode "Confused"`),
+		ginkgo.Entry("machine unreachable", "", errors.New("ssh failed"), Warn, "unable to query SELinux"),
+	)
+
+	ginkgo.DescribeTable("binaries",
+		func(name string, missing Status, status Status, message string) {
+			got, msg, hint := checkBinary(name, missing, "install it")(testUser())
+			Expect(got).To(Equal(status))
+			Expect(msg).To(ContainSubstring(message))
+			if status != Pass {
+				Expect(hint).To(Equal("install it"))
+			}
+		},
+		ginkgo.Entry("found", "sh", Fail, Pass, "/sh"),
+		ginkgo.Entry("required and missing", "podman-bootc-missing", Fail, Fail, "podman-bootc-missing not found in $PATH"),
+		ginkgo.Entry("optional and missing", "podman-bootc-missing", Warn, Warn, "podman-bootc-missing not found in $PATH"),
+	)
+
+	ginkgo.It("should drop the hint of passed checks and fail on hard requirements", func() {
+		checksMu.Lock()
+		saved := checks
+		checks = nil
+		checksMu.Unlock()
+		ginkgo.DeferCleanup(func() {
+			checksMu.Lock()
+			checks = saved
+			checksMu.Unlock()
+		})
+		Register(Check{Name: "pass", Run: func(user.User) (Status, string, string) { return Pass, "ok", "ignored" }})
+		Register(Check{Name: "warn", Run: func(user.User) (Status, string, string) { return Warn, "meh", "fix it" }})
+
+		results := RunChecks(testUser())
+		Expect(results).To(Equal([]Result{
+			{Name: "pass", Status: Pass, Message: "ok"},
+			{Name: "warn", Status: Warn, Message: "meh", Hint: "fix it"},
+		}))
+		Expect(Failed(results)).To(BeFalse())
+		Expect(Failed(append(results, Result{Status: Fail}))).To(BeTrue())
+	})
+})
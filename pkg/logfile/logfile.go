@@ -0,0 +1,272 @@
+package logfile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/term"
+)
+
+const (
+	// MaxSize is the size at which a log file is rotated
+	MaxSize = 32 * 1024 * 1024
+	// Rotated is the number of rotated files kept of a log file
+	Rotated = 3
+	// Kept is the number of default log files kept in the log directory
+	Kept = 10
+
+	// maxPartialLine is flushed without a newline, e.g. progress bars
+	maxPartialLine = 64 * 1024
+	defaultPrefix  = "podman-bootc-"
+)
+
+var redactions = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`(?i)((?:password|passwd|token|secret|api[_-]?key)"?\s*[=:]\s*)"[^"]*"`), `${1}"***"`},
+	{regexp.MustCompile(`(?i)((?:password|passwd|token|secret|api[_-]?key)"?\s*[=:]\s*)[^\s,}"]+`), "${1}***"},
+	{regexp.MustCompile(`(?i)(authorization:\s*\w+\s+)\S+`), "${1}***"},
+	{regexp.MustCompile(`(://[^/\s:@]+:)[^/\s@]+@`), "${1}***@"},
+}
+
+// Redact masks the passwords, tokens and URL credentials in a log line
+func Redact(line string) string {
+	for _, r := range redactions {
+		line = r.re.ReplaceAllString(line, r.repl)
+	}
+	return line
+}
+
+// File is a log file redacting the lines written to it, which is rotated when
+// it grows over its maximum size
+type File struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	rotated int
+	f       *os.File
+	size    int64
+	partial []byte
+}
+
+// Open opens the log file for appending
+func Open(path string, maxSize int64, rotated int) (*File, error) {
+	l := &File{path: path, maxSize: maxSize, rotated: rotated}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *File) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, st.Size()
+	return nil
+}
+
+// Path returns the path of the log file
+func (l *File) Path() string {
+	return l.path
+}
+
+func (l *File) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if err := l.writeLine(l.partial[:i+1]); err != nil {
+			return 0, err
+		}
+		l.partial = l.partial[i+1:]
+	}
+	if len(l.partial) > maxPartialLine {
+		if err := l.writeLine(l.partial); err != nil {
+			return 0, err
+		}
+		l.partial = nil
+	}
+	return len(p), nil
+}
+
+func (l *File) writeLine(line []byte) error {
+	redacted := Redact(string(line))
+	if l.size > 0 && l.size+int64(len(redacted)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.WriteString(redacted)
+	l.size += int64(n)
+	return err
+}
+
+// rotate renames the log file to path.1, shifting the previous rotated
+// files and removing the oldest one
+func (l *File) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	for i := l.rotated - 1; i >= 1; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if l.rotated > 0 {
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// Close flushes the last partial line and closes the log file
+func (l *File) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.partial) > 0 {
+		if err := l.writeLine(append(l.partial, '\n')); err != nil {
+			l.f.Close()
+			return err
+		}
+		l.partial = nil
+	}
+	return l.f.Close()
+}
+
+// DefaultPath returns a new timestamped log file path in dir, removing the
+// oldest log files to keep at most keep files with the new one
+func DefaultPath(dir string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	logs, err := filepath.Glob(filepath.Join(dir, defaultPrefix+"*.log"))
+	if err != nil {
+		return "", err
+	}
+	// The timestamps sort chronologically
+	sort.Strings(logs)
+	for len(logs) >= keep && len(logs) > 0 {
+		rotated, _ := filepath.Glob(logs[0] + ".*")
+		for _, path := range append(rotated, logs[0]) {
+			if err := os.Remove(path); err != nil {
+				logrus.Debugf("unable to remove old log file %s: %v", path, err)
+			}
+		}
+		logs = logs[1:]
+	}
+	name := fmt.Sprintf("%s%s-%d.log", defaultPrefix, time.Now().Format("20060102-150405"), os.Getpid())
+	return filepath.Join(dir, name), nil
+}
+
+var (
+	activeMu      sync.Mutex
+	active        *File
+	terminalLevel logrus.Level
+)
+
+// hook writes the logrus entries at or above a level with a formatter
+type hook struct {
+	levels    []logrus.Level
+	formatter logrus.Formatter
+	out       io.Writer
+}
+
+func (h *hook) Levels() []logrus.Level {
+	return h.levels
+}
+
+func (h *hook) Fire(entry *logrus.Entry) error {
+	buf, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = h.out.Write(buf)
+	return err
+}
+
+// Start captures all the logrus output and the Stream of the invocation in
+// the log file at path, while the terminal keeps showing the entries up to
+// level
+func Start(path string, level logrus.Level) error {
+	l, err := Open(path, MaxSize, Rotated)
+	if err != nil {
+		return fmt.Errorf("opening log file: %w", err)
+	}
+
+	activeMu.Lock()
+	active, terminalLevel = l, level
+	activeMu.Unlock()
+
+	logger := logrus.StandardLogger()
+	logger.AddHook(&hook{
+		levels:    logrus.AllLevels[:level+1],
+		formatter: &logrus.TextFormatter{ForceColors: term.IsTerminal(int(os.Stderr.Fd()))},
+		out:       os.Stderr,
+	})
+	logger.AddHook(&hook{
+		levels:    logrus.AllLevels,
+		formatter: &logrus.TextFormatter{DisableColors: true, FullTimestamp: true},
+		out:       l,
+	})
+	logger.SetOutput(io.Discard)
+	logger.SetLevel(logrus.TraceLevel)
+
+	logrus.Debugf("podman-bootc %s", strings.Join(os.Args[1:], " "))
+	return nil
+}
+
+// Stream returns a writer to the log file of the invocation, which discards
+// the output when there is none
+func Stream() io.Writer {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	if active == nil {
+		return io.Discard
+	}
+	return active
+}
+
+// Stop closes the log file of the invocation and returns its path, or an
+// empty string if there is none
+func Stop() string {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	if active == nil {
+		return ""
+	}
+	logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	logrus.SetOutput(os.Stderr)
+	logrus.SetLevel(terminalLevel)
+	if err := active.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to close the log file %s: %v\n", active.Path(), err)
+	}
+	path := active.Path()
+	active = nil
+	return path
+}
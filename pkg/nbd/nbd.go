@@ -0,0 +1,114 @@
+package nbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// stopTimeout is how long qemu-nbd has to exit before being killed
+const stopTimeout = 5 * time.Second
+
+// ServeOptions configures an NBD export of a disk image
+type ServeOptions struct {
+	// Address is either unix:<socket path> or tcp:<host>:<port>
+	Address  string
+	ReadOnly bool
+	// Format is the format of the disk image, raw when empty
+	Format string
+}
+
+// listenArgs converts the address to qemu-nbd arguments
+func listenArgs(address string) (args []string, socket string, err error) {
+	kind, rest, ok := strings.Cut(address, ":")
+	if !ok || rest == "" {
+		return nil, "", fmt.Errorf("invalid NBD address %q, expected unix:<path> or tcp:<host>:<port>", address)
+	}
+	switch kind {
+	case "unix":
+		return []string{"--socket", rest}, rest, nil
+	case "tcp":
+		i := strings.LastIndex(rest, ":")
+		if i < 0 {
+			return nil, "", fmt.Errorf("invalid NBD address %q, missing port", address)
+		}
+		host, port := rest[:i], rest[i+1:]
+		if host == "" {
+			host = "localhost"
+		}
+		return []string{"--bind", host, "--port", port}, "", nil
+	default:
+		return nil, "", fmt.Errorf("invalid NBD address %q, unknown transport %s", address, kind)
+	}
+}
+
+// Serve exports the disk image with qemu-nbd until the context is done
+func Serve(ctx context.Context, diskPath string, opts ServeOptions) error {
+	args, socket, err := listenArgs(opts.Address)
+	if err != nil {
+		return err
+	}
+	qemuNbd, err := exec.LookPath("qemu-nbd")
+	if err != nil {
+		return fmt.Errorf("qemu-nbd is required to serve disk images: %w", err)
+	}
+
+	format := opts.Format
+	if format == "" {
+		format = "raw"
+	}
+	args = append(args, "--format", format, "--persistent")
+	if opts.ReadOnly {
+		args = append(args, "--read-only", "--shared", "16")
+	} else {
+		args = append(args, "--shared", "1")
+	}
+	args = append(args, diskPath)
+
+	cmd := exec.Command(qemuNbd, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// Keep the terminal signals away from qemu-nbd, we stop it ourselves
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	logrus.Debugf("Executing: %v", cmd.Args)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting qemu-nbd: %w", err)
+	}
+	if socket != "" {
+		defer os.Remove(socket)
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-exited:
+		if err != nil {
+			return fmt.Errorf("qemu-nbd: %w", err)
+		}
+		return errors.New("qemu-nbd exited unexpectedly")
+	case <-ctx.Done():
+	}
+
+	logrus.Debugf("Stopping qemu-nbd")
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		logrus.Warnf("unable to stop qemu-nbd: %v", err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(stopTimeout):
+		logrus.Warnf("qemu-nbd did not exit, killing it")
+		_ = cmd.Process.Kill()
+		<-exited
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+// Package owner gives the files podman-bootc creates for the user, e.g.
+// exports, bundles and logs, to the user invoking sudo instead of root
+package owner
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Owner is the user and group of the files created for the user
+type Owner struct {
+	Uid int
+	Gid int
+}
+
+func (o *Owner) String() string {
+	return fmt.Sprintf("%d:%d", o.Uid, o.Gid)
+}
+
+// Parse parses user[:group], names or numeric ids. Without a group, the
+// primary group of the user is used.
+func Parse(spec string) (*Owner, error) {
+	userSpec, groupSpec, hasGroup := strings.Cut(spec, ":")
+	if userSpec == "" || (hasGroup && groupSpec == "") {
+		return nil, fmt.Errorf("invalid owner %q, use user[:group]", spec)
+	}
+
+	o := &Owner{}
+	u, err := lookupUser(userSpec)
+	if err != nil {
+		return nil, err
+	}
+	if o.Uid, err = strconv.Atoi(u.Uid); err != nil {
+		return nil, fmt.Errorf("invalid uid %q of user %s", u.Uid, userSpec)
+	}
+	gid := u.Gid
+	if hasGroup {
+		if gid, err = lookupGroup(groupSpec); err != nil {
+			return nil, err
+		}
+	}
+	if o.Gid, err = strconv.Atoi(gid); err != nil {
+		return nil, fmt.Errorf("invalid gid %q of owner %s", gid, spec)
+	}
+	return o, nil
+}
+
+// lookupUser finds a user by name or id, an id without a passwd entry is
+// used as is
+func lookupUser(spec string) (*user.User, error) {
+	if _, err := strconv.Atoi(spec); err == nil {
+		u, err := user.LookupId(spec)
+		if err != nil {
+			return &user.User{Uid: spec, Gid: spec}, nil
+		}
+		return u, nil
+	}
+	u, err := user.Lookup(spec)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user %s: %w", spec, err)
+	}
+	return u, nil
+}
+
+// lookupGroup returns the id of a group name or id
+func lookupGroup(spec string) (string, error) {
+	if _, err := strconv.Atoi(spec); err == nil {
+		return spec, nil
+	}
+	g, err := user.LookupGroup(spec)
+	if err != nil {
+		return "", fmt.Errorf("unknown group %s: %w", spec, err)
+	}
+	return g.Gid, nil
+}
+
+// FromSudo returns the user invoking sudo according to SUDO_UID and
+// SUDO_GID, or nil when not running as root under sudo
+func FromSudo() (*Owner, error) {
+	uid, gid := os.Getenv("SUDO_UID"), os.Getenv("SUDO_GID")
+	if os.Geteuid() != 0 || uid == "" {
+		return nil, nil
+	}
+	if gid == "" {
+		gid = uid
+	}
+	o := &Owner{}
+	var err error
+	if o.Uid, err = strconv.Atoi(uid); err != nil {
+		return nil, fmt.Errorf("invalid SUDO_UID %q", uid)
+	}
+	if o.Gid, err = strconv.Atoi(gid); err != nil {
+		return nil, fmt.Errorf("invalid SUDO_GID %q", gid)
+	}
+	if o.Uid == 0 {
+		return nil, nil
+	}
+	return o, nil
+}
+
+// Resolve returns the owner of --chown, the user invoking sudo when it is
+// empty, or nil to keep the files of the process
+func Resolve(spec string) (*Owner, error) {
+	if spec != "" {
+		return Parse(spec)
+	}
+	return FromSudo()
+}
+
+// InHome returns the owner when path is in its home directory, or nil. The
+// cache and the logs of root are kept by root under sudo: a directory given
+// to the invoking user would let them tamper with the files root writes
+// there.
+func (o *Owner) InHome(path string) *Owner {
+	if o == nil {
+		return nil
+	}
+	u, err := user.LookupId(strconv.Itoa(o.Uid))
+	if err != nil {
+		return nil
+	}
+	// Users without a home, e.g. nobody, have / as home
+	home := filepath.Clean(u.HomeDir)
+	if !filepath.IsAbs(home) || home == "/" {
+		return nil
+	}
+	rel, err := filepath.Rel(home, filepath.Clean(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return nil
+	}
+	return o
+}
+
+// Chown gives the paths to the owner, with everything in them for
+// directories. A nil owner and missing paths are skipped.
+func (o *Owner) Chown(paths ...string) error {
+	if o == nil {
+		return nil
+	}
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(p string, _ fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Lchown(p, o.Uid, o.Gid)
+		})
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("changing the owner of %s to %s: %w", path, o, err)
+		}
+	}
+	return nil
+}
+
+// MkdirAll creates the directory path and its missing parents like
+// os.MkdirAll, and gives the ones it created to the owner
+func (o *Owner) MkdirAll(path string, perm os.FileMode) error {
+	var created []string
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil || dir == filepath.Dir(dir) {
+			break
+		}
+		created = append(created, dir)
+	}
+	if err := os.MkdirAll(path, perm); err != nil {
+		return err
+	}
+	if o == nil {
+		return nil
+	}
+	for _, dir := range created {
+		if err := os.Lchown(dir, o.Uid, o.Gid); err != nil {
+			return fmt.Errorf("changing the owner of %s to %s: %w", dir, o, err)
+		}
+	}
+	return nil
+}
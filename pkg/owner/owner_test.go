@@ -0,0 +1,153 @@
+package owner
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestOwner(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Owner Suite")
+}
+
+// ownerOf returns the uid and gid of path
+func ownerOf(path string) Owner {
+	st, err := os.Lstat(path)
+	Expect(err).ToNot(HaveOccurred())
+	sys := st.Sys().(*syscall.Stat_t)
+	return Owner{Uid: int(sys.Uid), Gid: int(sys.Gid)}
+}
+
+// simulateSudo sets the environment of sudo invoked by uid and gid, empty
+// for no sudo
+func simulateSudo(uid, gid string) {
+	GinkgoT().Setenv("SUDO_UID", uid)
+	GinkgoT().Setenv("SUDO_GID", gid)
+}
+
+var _ = Describe("Owner", func() {
+	BeforeEach(func() {
+		simulateSudo("", "")
+	})
+
+	It("should parse users and groups", func() {
+		o, err := Parse("1234:1235")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*o).To(Equal(Owner{Uid: 1234, Gid: 1235}))
+
+		o, err = Parse("root")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*o).To(Equal(Owner{Uid: 0, Gid: 0}))
+
+		o, err = Parse("0:1235")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*o).To(Equal(Owner{Uid: 0, Gid: 1235}))
+	})
+
+	It("should reject invalid owners", func() {
+		for _, spec := range []string{"", ":1000", "1000:", "no-such-user-podman-bootc"} {
+			_, err := Parse(spec)
+			Expect(err).To(HaveOccurred(), spec)
+		}
+	})
+
+	It("should default to the user invoking sudo", func() {
+		if os.Geteuid() != 0 {
+			Skip("sudo runs as root")
+		}
+		o, err := Resolve("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(o).To(BeNil())
+
+		simulateSudo("1234", "1235")
+		o, err = Resolve("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*o).To(Equal(Owner{Uid: 1234, Gid: 1235}))
+
+		o, err = Resolve("4321:4322")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*o).To(Equal(Owner{Uid: 4321, Gid: 4322}))
+
+		simulateSudo("0", "0")
+		o, err = Resolve("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(o).To(BeNil())
+
+		simulateSudo("me", "")
+		_, err = Resolve("")
+		Expect(err).To(MatchError(ContainSubstring("invalid SUDO_UID")))
+	})
+
+	It("should give a tree to the owner", func() {
+		if os.Geteuid() != 0 {
+			Skip("changing the owner requires root")
+		}
+		simulateSudo("1234", "1235")
+		o, err := Resolve("")
+		Expect(err).ToNot(HaveOccurred())
+
+		dir := GinkgoT().TempDir()
+		out := filepath.Join(dir, "export")
+		Expect(os.MkdirAll(filepath.Join(out, "chunks"), 0o755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(out, "chunks", "0000"), []byte("chunk"), 0o644)).To(Succeed())
+		Expect(os.Symlink("chunks/0000", filepath.Join(out, "first"))).To(Succeed())
+		bundle := filepath.Join(dir, "bundle.tar.zst")
+		Expect(os.WriteFile(bundle, nil, 0o644)).To(Succeed())
+
+		Expect(o.Chown(out, bundle, filepath.Join(dir, "missing"))).To(Succeed())
+		for _, path := range []string{out, filepath.Join(out, "chunks"), filepath.Join(out, "chunks", "0000"), filepath.Join(out, "first"), bundle} {
+			Expect(ownerOf(path)).To(Equal(*o), path)
+		}
+		Expect(ownerOf(dir)).To(Equal(Owner{}))
+	})
+
+	It("should only give the directories it created to the owner", func() {
+		if os.Geteuid() != 0 {
+			Skip("changing the owner requires root")
+		}
+		simulateSudo("1234", "1235")
+		o, err := Resolve("")
+		Expect(err).ToNot(HaveOccurred())
+
+		home := GinkgoT().TempDir()
+		cache := filepath.Join(home, ".cache", "podman-bootc")
+		Expect(o.MkdirAll(cache, os.ModePerm)).To(Succeed())
+		Expect(ownerOf(home)).To(Equal(Owner{}))
+		Expect(ownerOf(filepath.Join(home, ".cache"))).To(Equal(*o))
+		Expect(ownerOf(cache)).To(Equal(*o))
+
+		// An existing cache keeps its owner
+		Expect(os.Lchown(cache, 0, 0)).To(Succeed())
+		Expect(o.MkdirAll(cache, os.ModePerm)).To(Succeed())
+		Expect(ownerOf(cache)).To(Equal(Owner{}))
+	})
+
+	It("should only keep the owner in its home directory", func() {
+		u, err := user.Current()
+		Expect(err).ToNot(HaveOccurred())
+		o := &Owner{Uid: os.Getuid(), Gid: os.Getgid()}
+		Expect(o.InHome(filepath.Join(u.HomeDir, ".cache", "podman-bootc"))).To(Equal(o))
+		Expect(o.InHome(u.HomeDir)).To(Equal(o))
+		Expect(o.InHome(u.HomeDir + "-other")).To(BeNil())
+		Expect(o.InHome(filepath.Join(u.HomeDir, "..", "other"))).To(BeNil())
+
+		// The user invoking sudo has no business in the cache of root
+		Expect((&Owner{Uid: 1234, Gid: 1235}).InHome("/root/.cache/podman-bootc")).To(BeNil())
+		var none *Owner
+		Expect(none.InHome(u.HomeDir)).To(BeNil())
+	})
+
+	It("should do nothing without an owner", func() {
+		var o *Owner
+		dir := filepath.Join(GinkgoT().TempDir(), "new")
+		Expect(o.MkdirAll(dir, os.ModePerm)).To(Succeed())
+		Expect(dir).To(BeADirectory())
+		Expect(o.Chown(dir)).To(Succeed())
+	})
+})
@@ -0,0 +1,211 @@
+package partitions
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// Partition describes a GPT partition and the filesystem it holds
+type Partition struct {
+	Number   int    `json:"number"`
+	Name     string `json:"name,omitempty"`
+	Type     string `json:"type"`
+	PartUUID string `json:"partUUID"`
+	Start    int64  `json:"start"`
+	Size     int64  `json:"size"`
+	// Filesystem is empty when the partition holds no known filesystem
+	Filesystem string `json:"filesystem,omitempty"`
+	UUID       string `json:"uuid,omitempty"`
+	Label      string `json:"label,omitempty"`
+}
+
+const (
+	gptSignature    = "EFI PART"
+	gptHeaderSize   = 92
+	maxPartitions   = 1024
+	maxGPTEntrySize = 4096
+)
+
+// ErrNoGPT is returned when the disk image has no GPT
+var ErrNoGPT = errors.New("no GUID partition table found")
+
+// formatGUID formats a mixed-endian GUID as found on disk
+func formatGUID(b []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(b[0:4]), binary.LittleEndian.Uint16(b[4:6]),
+		binary.LittleEndian.Uint16(b[6:8]), b[8:10], b[10:16])
+}
+
+// Inspect reads the GPT of a raw disk image and the superblocks of its
+// partitions. Only reads are issued to r.
+func Inspect(r io.ReaderAt) ([]Partition, error) {
+	var lastErr error = ErrNoGPT
+	for _, sectorSize := range []int64{512, 4096} {
+		parts, err := inspect(r, sectorSize)
+		if err == nil {
+			return parts, nil
+		}
+		if !errors.Is(err, ErrNoGPT) {
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
+
+func inspect(r io.ReaderAt, sectorSize int64) ([]Partition, error) {
+	header := make([]byte, gptHeaderSize)
+	if _, err := r.ReadAt(header, sectorSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, ErrNoGPT
+		}
+		return nil, err
+	}
+	if string(header[0:8]) != gptSignature {
+		return nil, ErrNoGPT
+	}
+
+	headerSize := binary.LittleEndian.Uint32(header[12:16])
+	if headerSize != gptHeaderSize {
+		return nil, fmt.Errorf("unsupported GPT header size %d", headerSize)
+	}
+	headerCRC := binary.LittleEndian.Uint32(header[16:20])
+	binary.LittleEndian.PutUint32(header[16:20], 0)
+	if crc32.ChecksumIEEE(header) != headerCRC {
+		return nil, errors.New("GPT header checksum mismatch")
+	}
+
+	entriesLBA := int64(binary.LittleEndian.Uint64(header[72:80]))
+	numEntries := binary.LittleEndian.Uint32(header[80:84])
+	entrySize := binary.LittleEndian.Uint32(header[84:88])
+	entriesCRC := binary.LittleEndian.Uint32(header[88:92])
+	if numEntries > maxPartitions || entrySize < 128 || entrySize > maxGPTEntrySize || entrySize%8 != 0 {
+		return nil, fmt.Errorf("invalid GPT with %d entries of %d bytes", numEntries, entrySize)
+	}
+	if entriesLBA < 2 || entriesLBA > (1<<62)/sectorSize {
+		return nil, fmt.Errorf("invalid GPT entries LBA %d", entriesLBA)
+	}
+
+	entries := make([]byte, int64(numEntries)*int64(entrySize))
+	if _, err := r.ReadAt(entries, entriesLBA*sectorSize); err != nil {
+		return nil, fmt.Errorf("reading the GPT entries: %w", err)
+	}
+	if crc32.ChecksumIEEE(entries) != entriesCRC {
+		return nil, errors.New("GPT entries checksum mismatch")
+	}
+
+	var parts []Partition
+	for i := uint32(0); i < numEntries; i++ {
+		entry := entries[int64(i)*int64(entrySize) : int64(i+1)*int64(entrySize)]
+		if bytes.Equal(entry[0:16], make([]byte, 16)) {
+			continue
+		}
+		first := binary.LittleEndian.Uint64(entry[32:40])
+		last := binary.LittleEndian.Uint64(entry[40:48])
+		if last < first || last > uint64((1<<62)/sectorSize) {
+			return nil, fmt.Errorf("invalid range of partition %d", i+1)
+		}
+
+		part := Partition{
+			Number:   int(i + 1),
+			Name:     decodeName(entry[56:128]),
+			Type:     formatGUID(entry[0:16]),
+			PartUUID: formatGUID(entry[16:32]),
+			Start:    int64(first) * sectorSize,
+			Size:     int64(last-first+1) * sectorSize,
+		}
+		probeFilesystem(io.NewSectionReader(r, part.Start, part.Size), &part)
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+func decodeName(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func formatUUID(b []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// probeFilesystem fills in the filesystem type, UUID and label of a partition
+func probeFilesystem(r io.ReaderAt, part *Partition) {
+	buf := make([]byte, 4096)
+	if n, _ := r.ReadAt(buf, 0); n < 4096 {
+		return
+	}
+
+	switch {
+	case string(buf[0:4]) == "XFSB":
+		part.Filesystem = "xfs"
+		part.UUID = formatUUID(buf[32:48])
+		part.Label = cString(buf[108:120])
+		return
+	case binary.LittleEndian.Uint16(buf[1024+0x38:]) == 0xef53:
+		part.Filesystem = "ext4"
+		part.UUID = formatUUID(buf[1024+0x68 : 1024+0x78])
+		part.Label = cString(buf[1024+0x78 : 1024+0x88])
+		return
+	case string(buf[0x52:0x57]) == "FAT32":
+		part.Filesystem = "vfat"
+		part.UUID = fmt.Sprintf("%04X-%04X", binary.LittleEndian.Uint16(buf[0x45:]), binary.LittleEndian.Uint16(buf[0x43:]))
+		part.Label = fatLabel(buf[0x47:0x52])
+		return
+	case string(buf[0x36:0x39]) == "FAT":
+		part.Filesystem = "vfat"
+		part.UUID = fmt.Sprintf("%04X-%04X", binary.LittleEndian.Uint16(buf[0x29:]), binary.LittleEndian.Uint16(buf[0x27:]))
+		part.Label = fatLabel(buf[0x2b:0x36])
+		return
+	}
+
+	// The btrfs superblock is at 64KiB
+	btrfs := make([]byte, 0x12b+256)
+	if n, _ := r.ReadAt(btrfs, 64*1024); n == len(btrfs) && string(btrfs[0x40:0x48]) == "_BHRfS_M" {
+		part.Filesystem = "btrfs"
+		part.UUID = formatUUID(btrfs[0x20:0x30])
+		part.Label = cString(btrfs[0x12b:])
+	}
+}
+
+func fatLabel(b []byte) string {
+	label := cString(b)
+	if label == "NO NAME" {
+		return ""
+	}
+	return label
+}
+
+// String formats the partition like blkid
+func (p Partition) String() string {
+	s := fmt.Sprintf("%d: PARTUUID=%q", p.Number, p.PartUUID)
+	if p.Name != "" {
+		s += fmt.Sprintf(" PARTLABEL=%q", p.Name)
+	}
+	if p.Filesystem != "" {
+		s += fmt.Sprintf(" TYPE=%q UUID=%q", p.Filesystem, p.UUID)
+	}
+	if p.Label != "" {
+		s += fmt.Sprintf(" LABEL=%q", p.Label)
+	}
+	return s
+}
@@ -0,0 +1,82 @@
+package partitions
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"unicode/utf16"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestPartitions(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Partitions Suite")
+}
+
+var (
+	linuxFsType = []byte{0xaf, 0x3d, 0xc6, 0x0f, 0x83, 0x84, 0x72, 0x47, 0x8e, 0x79, 0x3d, 0x69, 0xd8, 0x47, 0x7d, 0xe4}
+	testGUID    = []byte{0x04, 0x03, 0x02, 0x01, 0x06, 0x05, 0x08, 0x07, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10}
+)
+
+// testDisk builds a 1MiB disk with a GPT and an ext4 partition from LBA 34 to 1023
+func testDisk() []byte {
+	disk := make([]byte, 2048*512)
+
+	entries := make([]byte, 128*128)
+	copy(entries[0:16], linuxFsType)
+	copy(entries[16:32], testGUID)
+	binary.LittleEndian.PutUint64(entries[32:], 34)
+	binary.LittleEndian.PutUint64(entries[40:], 1023)
+	for i, c := range utf16.Encode([]rune("root")) {
+		binary.LittleEndian.PutUint16(entries[56+2*i:], c)
+	}
+	copy(disk[2*512:], entries)
+
+	header := disk[512 : 512+gptHeaderSize]
+	copy(header, gptSignature)
+	binary.LittleEndian.PutUint32(header[12:], gptHeaderSize)
+	binary.LittleEndian.PutUint64(header[72:], 2)
+	binary.LittleEndian.PutUint32(header[80:], 128)
+	binary.LittleEndian.PutUint32(header[84:], 128)
+	binary.LittleEndian.PutUint32(header[88:], crc32.ChecksumIEEE(entries))
+	binary.LittleEndian.PutUint32(header[16:], crc32.ChecksumIEEE(header))
+
+	sb := disk[34*512+1024:]
+	binary.LittleEndian.PutUint16(sb[0x38:], 0xef53)
+	copy(sb[0x68:], bytes.Repeat([]byte{0xab}, 16))
+	copy(sb[0x78:], "myroot")
+	return disk
+}
+
+var _ = Describe("Inspect", func() {
+	It("should read the GPT and the filesystem superblock", func() {
+		parts, err := Inspect(bytes.NewReader(testDisk()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parts).To(Equal([]Partition{{
+			Number:     1,
+			Name:       "root",
+			Type:       "0fc63daf-8483-4772-8e79-3d69d8477de4",
+			PartUUID:   "01020304-0506-0708-090a-0b0c0d0e0f10",
+			Start:      34 * 512,
+			Size:       990 * 512,
+			Filesystem: "ext4",
+			UUID:       "abababab-abab-abab-abab-abababababab",
+			Label:      "myroot",
+		}}))
+	})
+
+	It("should report a missing GPT", func() {
+		_, err := Inspect(bytes.NewReader(make([]byte, 64*1024)))
+		Expect(err).To(MatchError(ErrNoGPT))
+	})
+
+	It("should detect a corrupted GPT", func() {
+		disk := testDisk()
+		disk[2*512+40] ^= 0xff
+		_, err := Inspect(bytes.NewReader(disk))
+		Expect(err).To(MatchError(ContainSubstring("checksum mismatch")))
+	})
+})
@@ -0,0 +1,263 @@
+// Package progressui shows the progress of a disk image build on an
+// interactive terminal: the steps, a spinner with the phase in progress,
+// the pull counters and the elapsed time, pinned below the plain output
+package progressui
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/api"
+
+	"github.com/docker/go-units"
+	"golang.org/x/term"
+)
+
+// tick is the interval of the spinner and of the elapsed time
+const tick = 100 * time.Millisecond
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// escapeRegexp matches the terminal escape sequences of the install output
+var escapeRegexp = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]|\x1b\][^\x07]*\x07`)
+
+// UI draws the status of the build below the output written to it. The
+// output keeps scrolling above the status, so the plain output stays
+// complete.
+type UI struct {
+	mu    sync.Mutex
+	out   io.Writer
+	start time.Time
+	event api.ProgressEvent
+	// partial is the output line waiting for its newline, shown in the
+	// status until then
+	partial []byte
+	// drawn is the number of status lines on the terminal
+	drawn   int
+	frame   int
+	running bool
+	paused  bool
+	// held is the output written while paused
+	held    bytes.Buffer
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// New returns a UI drawing on out, usually os.Stdout
+func New(out io.Writer) *UI {
+	return &UI{out: out}
+}
+
+// Start draws the status and animates it until Stop
+func (u *UI) Start() {
+	u.mu.Lock()
+	u.start = time.Now()
+	u.running = true
+	u.done = make(chan struct{})
+	u.stopped = make(chan struct{})
+	u.drawLocked()
+	u.mu.Unlock()
+
+	go func() {
+		defer close(u.stopped)
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-u.done:
+				return
+			case <-ticker.C:
+				u.mu.Lock()
+				u.frame++
+				u.redrawLocked()
+				u.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// Stop erases the status and writes the pending output
+func (u *UI) Stop() {
+	u.mu.Lock()
+	running := u.running
+	u.mu.Unlock()
+	if !running {
+		return
+	}
+	close(u.done)
+	<-u.stopped
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.clearLocked()
+	u.running, u.paused = false, false
+	u.out.Write(u.held.Bytes())
+	u.held.Reset()
+	if len(u.partial) > 0 {
+		fmt.Fprintf(u.out, "%s\n", lastSegment(u.partial))
+		u.partial = nil
+	}
+}
+
+// Event updates the status with a progress event of the build
+func (u *UI) Event(e api.ProgressEvent) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.event = e
+	u.redrawLocked()
+}
+
+// Write writes the complete lines of b above the status
+func (u *UI) Write(b []byte) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.paused {
+		return u.held.Write(b)
+	}
+	if !u.running {
+		return u.out.Write(b)
+	}
+
+	u.partial = append(u.partial, b...)
+	i := bytes.LastIndexByte(u.partial, '\n')
+	if i < 0 {
+		u.redrawLocked()
+		return len(b), nil
+	}
+	u.clearLocked()
+	for _, line := range bytes.Split(u.partial[:i], []byte("\n")) {
+		if _, err := fmt.Fprintf(u.out, "%s\n", lastSegment(line)); err != nil {
+			return 0, err
+		}
+	}
+	u.partial = append([]byte(nil), u.partial[i+1:]...)
+	u.drawLocked()
+	return len(b), nil
+}
+
+// Pause erases the status and holds the output, e.g. while prompting
+func (u *UI) Pause() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.clearLocked()
+	u.paused = true
+}
+
+// Resume writes the output held while paused and draws the status again
+func (u *UI) Resume() {
+	u.mu.Lock()
+	held := append([]byte(nil), u.held.Bytes()...)
+	u.held.Reset()
+	u.paused = false
+	u.mu.Unlock()
+	u.Write(held)
+	u.mu.Lock()
+	u.redrawLocked()
+	u.mu.Unlock()
+}
+
+func (u *UI) redrawLocked() {
+	u.clearLocked()
+	u.drawLocked()
+}
+
+// clearLocked moves to the first status line and erases the status
+func (u *UI) clearLocked() {
+	if u.drawn == 0 {
+		return
+	}
+	fmt.Fprintf(u.out, "\x1b[%dA\r\x1b[J", u.drawn)
+	u.drawn = 0
+}
+
+func (u *UI) drawLocked() {
+	if u.paused || !u.running {
+		return
+	}
+	lines := u.status(width(u.out))
+	for _, line := range lines {
+		fmt.Fprintf(u.out, "%s\n", line)
+	}
+	u.drawn = len(lines)
+}
+
+// status renders the step header, the spinner line and the footer
+func (u *UI) status(width int) []string {
+	e := u.event
+	var steps []string
+	for _, step := range api.ProgressSteps {
+		mark := "·"
+		switch {
+		case step < e.Step:
+			mark = "✓"
+		case step == e.Step:
+			mark = spinnerFrames[u.frame%len(spinnerFrames)]
+		}
+		name := step.String()
+		if step == api.StepPull && e.PulledBytes > 0 {
+			name += " (" + units.HumanSize(float64(e.PulledBytes)) + ")"
+		}
+		steps = append(steps, mark+" "+name)
+	}
+
+	detail := e.Phase
+	if detail == "" {
+		detail = "starting"
+	}
+	if e.Step == api.StepPull && e.PulledLayers > 0 {
+		detail += fmt.Sprintf(", %d layers", e.PulledLayers)
+	}
+	if e.Step == api.StepInstall && e.InstallPhase != "" {
+		detail += ", " + e.InstallPhase
+	}
+	if last := strings.TrimSpace(escapeRegexp.ReplaceAllString(string(lastSegment(u.partial)), "")); last != "" {
+		detail += ": " + last
+	} else if e.Message != "" {
+		detail += ": " + e.Message
+	}
+
+	elapsed := time.Duration(0)
+	if !u.start.IsZero() {
+		elapsed = time.Since(u.start).Round(time.Second)
+	}
+	return []string{
+		truncate(strings.Join(steps, "  "), width),
+		truncate(spinnerFrames[u.frame%len(spinnerFrames)]+" "+detail, width),
+		truncate(fmt.Sprintf("elapsed %s · Ctrl-C to cancel", elapsed), width),
+	}
+}
+
+// lastSegment returns what a terminal shows of a line rewritten with
+// carriage returns
+func lastSegment(line []byte) []byte {
+	line = bytes.TrimRight(line, "\r")
+	if i := bytes.LastIndexByte(line, '\r'); i >= 0 {
+		return line[i+1:]
+	}
+	return line
+}
+
+// truncate cuts s to width runes, so a status line never wraps
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if len(r) < width {
+		return s
+	}
+	return string(r[:width-1]) + "…"
+}
+
+// width returns the width of the terminal of w, 80 if it is not one
+func width(w io.Writer) int {
+	if f, ok := w.(*os.File); ok {
+		if cols, _, err := term.GetSize(int(f.Fd())); err == nil && cols > 1 {
+			return cols
+		}
+	}
+	return 80
+}
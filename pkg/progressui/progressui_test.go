@@ -0,0 +1,92 @@
+package progressui
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/api"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestProgressUI(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Progress UI Suite")
+}
+
+// plain removes the status redraws from the output of a UI
+func plain(out string) string {
+	return strings.ReplaceAll(escapeRegexp.ReplaceAllString(out, ""), "\r", "")
+}
+
+var _ = Describe("Progress UI", func() {
+	It("should render the steps, the phase and the pull counters", func() {
+		ui := New(&bytes.Buffer{})
+		ui.event = api.ProgressEvent{Step: api.StepPull, Phase: "pulling the image", PulledLayers: 3}
+		status := ui.status(80)
+		Expect(status).To(HaveLen(3))
+		Expect(status[0]).To(Equal("⠋ pull  · allocate  · install  · finalize"))
+		Expect(status[1]).To(Equal("⠋ pulling the image, 3 layers"))
+		Expect(status[2]).To(HavePrefix("elapsed 0s"))
+
+		ui.event = api.ProgressEvent{Step: api.StepInstall, Phase: "building the disk image", PulledBytes: 2000000000}
+		ui.partial = []byte("Installing image: \x1b[1m50%\x1b[0m\rInstalling image: 75%")
+		status = ui.status(80)
+		Expect(status[0]).To(Equal("✓ pull (2GB)  ✓ allocate  ⠋ install  · finalize"))
+		Expect(status[1]).To(Equal("⠋ building the disk image: Installing image: 75%"))
+
+		ui.event.InstallPhase = "copying the ostree commit"
+		ui.partial = nil
+		Expect(ui.status(80)[1]).To(Equal("⠋ building the disk image, copying the ostree commit"))
+	})
+
+	It("should not wrap narrow terminals", func() {
+		ui := New(&bytes.Buffer{})
+		ui.event = api.ProgressEvent{Phase: "building the disk image"}
+		for _, line := range ui.status(12) {
+			Expect([]rune(line)).To(HaveLen(12))
+		}
+	})
+
+	It("should keep the complete lines above the status", func() {
+		var out bytes.Buffer
+		ui := New(&out)
+		ui.Start()
+		_, err := ui.Write([]byte("first line\nsecond"))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = ui.Write([]byte(" line\r\nlast"))
+		Expect(err).ToNot(HaveOccurred())
+		ui.Stop()
+
+		Expect(plain(out.String())).To(ContainSubstring("\nfirst line\n"))
+		Expect(plain(out.String())).To(ContainSubstring("\nsecond line\n"))
+		Expect(plain(out.String())).To(HaveSuffix("\nlast\n"))
+		Expect(plain(out.String())).To(ContainSubstring("elapsed 0s"))
+	})
+
+	It("should hold the output while paused", func() {
+		var out bytes.Buffer
+		ui := New(&out)
+		ui.Start()
+		ui.Pause()
+		_, err := ui.Write([]byte("held\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(out.String()).ToNot(ContainSubstring("held"))
+		ui.Resume()
+		ui.Stop()
+		Expect(out.String()).To(ContainSubstring("held\n"))
+	})
+
+	It("should pass the output through once stopped", func() {
+		var out bytes.Buffer
+		ui := New(&out)
+		ui.Start()
+		ui.Stop()
+		out.Reset()
+		_, err := ui.Write([]byte("Booting the VM"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(out.String()).To(Equal("Booting the VM"))
+	})
+})
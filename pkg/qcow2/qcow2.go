@@ -0,0 +1,303 @@
+package qcow2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+const (
+	magic = "QFI\xfb"
+
+	minClusterBits = 9
+	maxClusterBits = 21
+	// maxL1Size bounds the L1 table read in memory, like qemu does
+	maxL1Size = 32 * 1024 * 1024 / 8
+	// maxSize keeps the offset computations from overflowing
+	maxSize = 1 << 56
+
+	v2HeaderLength = 72
+	v3HeaderLength = 104
+
+	incompatDirty        = 1 << 0
+	incompatCorrupt      = 1 << 1
+	incompatExternalData = 1 << 2
+	incompatCompression  = 1 << 3
+	incompatExtendedL2   = 1 << 4
+
+	// offsetMask extracts the host offsets from the L1 and L2 entries
+	offsetMask      = 0x00fffffffffffe00
+	l2Compressed    = 1 << 62
+	l2ZeroesCluster = 1 << 0
+)
+
+// ErrNotQcow2 is returned when the image does not start with the qcow2 magic
+var ErrNotQcow2 = errors.New("not a qcow2 image")
+
+// Header is the qcow2 header, only the fields needed for reading are kept
+type Header struct {
+	Version       uint32
+	ClusterBits   uint32
+	Size          uint64
+	L1Size        uint32
+	L1TableOffset uint64
+	BackingFile   bool
+	IncompatFlags uint64
+}
+
+// ParseHeader parses and validates the qcow2 header at the start of buf,
+// rejecting the images which cannot be read by this package
+func ParseHeader(buf []byte) (*Header, error) {
+	if len(buf) < v2HeaderLength || string(buf[:4]) != magic {
+		return nil, ErrNotQcow2
+	}
+	be := binary.BigEndian
+	h := &Header{
+		Version:       be.Uint32(buf[4:8]),
+		BackingFile:   be.Uint64(buf[8:16]) != 0,
+		ClusterBits:   be.Uint32(buf[20:24]),
+		Size:          be.Uint64(buf[24:32]),
+		L1Size:        be.Uint32(buf[36:40]),
+		L1TableOffset: be.Uint64(buf[40:48]),
+	}
+	cryptMethod := be.Uint32(buf[32:36])
+
+	switch h.Version {
+	case 2:
+	case 3:
+		if len(buf) < v3HeaderLength {
+			return nil, fmt.Errorf("truncated qcow2 v3 header")
+		}
+		h.IncompatFlags = be.Uint64(buf[72:80])
+		if headerLength := be.Uint32(buf[100:104]); headerLength < v3HeaderLength {
+			return nil, fmt.Errorf("invalid qcow2 header length %d", headerLength)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported qcow2 version %d", h.Version)
+	}
+
+	if cryptMethod != 0 {
+		return nil, errors.New("encrypted qcow2 images are not supported")
+	}
+	if h.IncompatFlags&incompatCorrupt != 0 {
+		return nil, errors.New("the qcow2 image is marked corrupt, check it with qemu-img check")
+	}
+	if h.IncompatFlags&incompatExternalData != 0 {
+		return nil, errors.New("qcow2 images with an external data file are not supported")
+	}
+	if h.IncompatFlags&incompatExtendedL2 != 0 {
+		return nil, errors.New("qcow2 images with extended L2 entries are not supported")
+	}
+	if unknown := h.IncompatFlags &^ (incompatDirty | incompatCompression); unknown != 0 {
+		return nil, fmt.Errorf("unsupported qcow2 incompatible features %#x", unknown)
+	}
+	if h.BackingFile {
+		return nil, errors.New("qcow2 images with a backing file are not supported")
+	}
+
+	if h.ClusterBits < minClusterBits || h.ClusterBits > maxClusterBits {
+		return nil, fmt.Errorf("invalid qcow2 cluster bits %d", h.ClusterBits)
+	}
+	if h.Size > maxSize {
+		return nil, fmt.Errorf("invalid qcow2 virtual size %d", h.Size)
+	}
+	if h.L1Size > maxL1Size {
+		return nil, fmt.Errorf("qcow2 L1 table too large: %d entries", h.L1Size)
+	}
+	// Each L1 entry maps a cluster of L2 entries of 8 bytes each
+	coveredBits := 2*h.ClusterBits - 3
+	if needed := (h.Size + 1<<coveredBits - 1) >> coveredBits; uint64(h.L1Size) < needed {
+		return nil, fmt.Errorf("qcow2 L1 table too small for the virtual size: %d < %d entries", h.L1Size, needed)
+	}
+	if h.L1TableOffset&(1<<h.ClusterBits-1) != 0 || h.L1TableOffset > maxSize {
+		return nil, fmt.Errorf("invalid qcow2 L1 table offset %#x", h.L1TableOffset)
+	}
+	return h, nil
+}
+
+// Image gives read-only access to the virtual disk of a qcow2 image
+type Image struct {
+	r      io.ReaderAt
+	header *Header
+	l1     []uint64
+
+	mu       sync.Mutex
+	l2Offset uint64
+	l2       []uint64
+}
+
+// Open reads the header and the L1 table of a qcow2 image
+func Open(r io.ReaderAt) (*Image, error) {
+	buf := make([]byte, v3HeaderLength)
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	header, err := ParseHeader(buf[:n])
+	if err != nil {
+		return nil, err
+	}
+
+	table := make([]byte, 8*int(header.L1Size))
+	if _, err := r.ReadAt(table, int64(header.L1TableOffset)); err != nil {
+		return nil, fmt.Errorf("reading the qcow2 L1 table: %w", err)
+	}
+	l1 := make([]uint64, header.L1Size)
+	for i := range l1 {
+		l1[i] = binary.BigEndian.Uint64(table[8*i:]) & offsetMask
+	}
+	return &Image{r: r, header: header, l1: l1}, nil
+}
+
+// Size returns the virtual size of the disk
+func (img *Image) Size() int64 {
+	return int64(img.header.Size)
+}
+
+// ReadAt reads the virtual disk, unallocated clusters read as zeros
+func (img *Image) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	read := 0
+	clusterSize := int64(1) << img.header.ClusterBits
+	for read < len(p) {
+		if off >= img.Size() {
+			return read, io.EOF
+		}
+		inCluster := off & (clusterSize - 1)
+		chunk := p[read:]
+		if left := clusterSize - inCluster; int64(len(chunk)) > left {
+			chunk = chunk[:left]
+		}
+		if rest := img.Size() - off; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+
+		hostOffset, err := img.clusterOffset(uint64(off))
+		if err != nil {
+			return read, err
+		}
+		if hostOffset == 0 {
+			for i := range chunk {
+				chunk[i] = 0
+			}
+		} else if _, err := img.r.ReadAt(chunk, int64(hostOffset)+inCluster); err != nil {
+			return read, fmt.Errorf("reading qcow2 cluster at %#x: %w", hostOffset, err)
+		}
+		read += len(chunk)
+		off += int64(len(chunk))
+	}
+	return read, nil
+}
+
+// clusterOffset walks the L1 and L2 tables to the host offset of the cluster
+// holding the virtual offset, 0 for clusters reading as zeros
+func (img *Image) clusterOffset(off uint64) (uint64, error) {
+	clusterBits := img.header.ClusterBits
+	l2Bits := clusterBits - 3
+	l1Index := off >> (clusterBits + l2Bits)
+	if l1Index >= uint64(len(img.l1)) {
+		return 0, fmt.Errorf("offset %#x outside of the qcow2 L1 table", off)
+	}
+	l2Offset := img.l1[l1Index]
+	if l2Offset == 0 {
+		return 0, nil
+	}
+
+	l2, err := img.l2Table(l2Offset)
+	if err != nil {
+		return 0, err
+	}
+	entry := l2[(off>>clusterBits)&(1<<l2Bits-1)]
+	if entry&l2Compressed != 0 {
+		return 0, errors.New("compressed qcow2 clusters are not supported, decompress the image with qemu-img convert -O qcow2")
+	}
+	if entry&l2ZeroesCluster != 0 && img.header.Version >= 3 {
+		return 0, nil
+	}
+	hostOffset := entry & offsetMask
+	if hostOffset&(1<<clusterBits-1) != 0 {
+		return 0, fmt.Errorf("unaligned qcow2 cluster offset %#x", hostOffset)
+	}
+	return hostOffset, nil
+}
+
+// l2Table returns the L2 table at offset, the last one read is cached
+func (img *Image) l2Table(offset uint64) ([]uint64, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	if img.l2 != nil && img.l2Offset == offset {
+		return img.l2, nil
+	}
+	if offset&(1<<img.header.ClusterBits-1) != 0 {
+		return nil, fmt.Errorf("unaligned qcow2 L2 table offset %#x", offset)
+	}
+
+	buf := make([]byte, 1<<img.header.ClusterBits)
+	if _, err := img.r.ReadAt(buf, int64(offset)); err != nil {
+		return nil, fmt.Errorf("reading the qcow2 L2 table at %#x: %w", offset, err)
+	}
+	l2 := make([]uint64, len(buf)/8)
+	for i := range l2 {
+		l2[i] = binary.BigEndian.Uint64(buf[8*i:])
+	}
+	img.l2, img.l2Offset = l2, offset
+	return l2, nil
+}
+
+// IsQcow2 reports if the file starts with the qcow2 magic
+func IsQcow2(f io.ReaderAt) bool {
+	buf := make([]byte, len(magic))
+	_, err := f.ReadAt(buf, 0)
+	return err == nil && string(buf) == magic
+}
+
+// Disk is an open raw or qcow2 disk image file
+type Disk struct {
+	io.ReaderAt
+	Format string
+	Size   int64
+	file   *os.File
+}
+
+// OpenDisk opens a raw or qcow2 disk image file for reading its virtual disk
+func OpenDisk(path string) (*Disk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !IsQcow2(f) {
+		st, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &Disk{ReaderAt: f, Format: "raw", Size: st.Size(), file: f}, nil
+	}
+	img, err := Open(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &Disk{ReaderAt: img, Format: "qcow2", Size: img.Size(), file: f}, nil
+}
+
+// Close closes the disk image file
+func (d *Disk) Close() error {
+	return d.file.Close()
+}
+
+// RequireQemuImg returns an error explaining how to install qemu-img when it
+// is missing for an operation this package does not implement, e.g. convert
+func RequireQemuImg(operation string) (string, error) {
+	path, err := exec.LookPath("qemu-img")
+	if err != nil {
+		return "", fmt.Errorf("%s requires qemu-img, which is not installed: install it with e.g. 'dnf install qemu-img' or 'brew install qemu'", operation)
+	}
+	return path, nil
+}
@@ -0,0 +1,106 @@
+package qcow2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestQcow2(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Qcow2 Suite")
+}
+
+const testClusterBits = 16
+
+// testImage builds a qcow2 v3 image of 1MiB with 64KiB clusters: the header
+// in cluster 0, the L1 table in cluster 1, the L2 table in cluster 2 and the
+// data of virtual cluster 1 in cluster 3
+func testImage() []byte {
+	cluster := 1 << testClusterBits
+	img := make([]byte, 4*cluster)
+	be := binary.BigEndian
+
+	copy(img, magic)
+	be.PutUint32(img[4:], 3)
+	be.PutUint32(img[20:], testClusterBits)
+	be.PutUint64(img[24:], 1024*1024)
+	be.PutUint32(img[36:], 1)
+	be.PutUint64(img[40:], uint64(cluster))
+	be.PutUint32(img[96:], 4)
+	be.PutUint32(img[100:], v3HeaderLength)
+
+	be.PutUint64(img[cluster:], uint64(2*cluster)|1<<63)
+	be.PutUint64(img[2*cluster+8:], uint64(3*cluster)|1<<63)
+	copy(img[3*cluster:], "hello from cluster 1")
+	return img
+}
+
+var _ = Describe("Qcow2", func() {
+	It("should read allocated and unallocated clusters", func() {
+		img, err := Open(bytes.NewReader(testImage()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(img.Size()).To(Equal(int64(1024 * 1024)))
+
+		buf := make([]byte, 32)
+		_, err = img.ReadAt(buf, 1<<testClusterBits)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(buf[:20])).To(Equal("hello from cluster 1"))
+
+		// Across the end of the unallocated cluster 0
+		_, err = img.ReadAt(buf, 1<<testClusterBits-6)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(buf[:6]).To(Equal(make([]byte, 6)))
+		Expect(string(buf[6:11])).To(Equal("hello"))
+
+		n, err := img.ReadAt(buf, img.Size()-8)
+		Expect(err).To(Equal(io.EOF))
+		Expect(n).To(Equal(8))
+	})
+
+	It("should reject unsupported images", func() {
+		raw := testImage()
+		binary.BigEndian.PutUint32(raw[32:], 1)
+		_, err := Open(bytes.NewReader(raw))
+		Expect(err).To(MatchError(ContainSubstring("encrypted")))
+
+		raw = testImage()
+		binary.BigEndian.PutUint64(raw[8:], 0x1000)
+		_, err = Open(bytes.NewReader(raw))
+		Expect(err).To(MatchError(ContainSubstring("backing file")))
+
+		_, err = Open(bytes.NewReader(make([]byte, 512)))
+		Expect(err).To(MatchError(ErrNotQcow2))
+	})
+
+	It("should fail on compressed clusters", func() {
+		raw := testImage()
+		binary.BigEndian.PutUint64(raw[2<<testClusterBits+8:], 1<<62)
+		img, err := Open(bytes.NewReader(raw))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = img.ReadAt(make([]byte, 8), 1<<testClusterBits)
+		Expect(err).To(MatchError(ContainSubstring("qemu-img convert")))
+	})
+})
+
+func FuzzParseHeader(f *testing.F) {
+	f.Add(testImage()[:v3HeaderLength])
+	f.Add([]byte(magic))
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		h, err := ParseHeader(buf)
+		if err != nil {
+			return
+		}
+		if h.ClusterBits < minClusterBits || h.ClusterBits > maxClusterBits || h.Size > maxSize {
+			t.Fatalf("invalid header accepted: %+v", h)
+		}
+		// Reading the header as a whole image must fail cleanly
+		if img, err := Open(bytes.NewReader(buf)); err == nil {
+			_, _ = img.ReadAt(make([]byte, 4096), 0)
+		}
+	})
+}
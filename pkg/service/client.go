@@ -0,0 +1,140 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// clientURL is the URL of the requests, the transport always connects to
+// the socket
+const clientURL = "http://podman-bootc"
+
+// Client is a client of the API of the build service
+type Client struct {
+	http *http.Client
+}
+
+// NewClient returns a client of the service listening on address,
+// unix:///path/to/socket
+func NewClient(address string) (*Client, error) {
+	path, err := SocketPath(address)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return &Client{http: &http.Client{Transport: transport}}, nil
+}
+
+// do sends the request and decodes the response into v, or returns the
+// error of the service
+func (c *Client) do(ctx context.Context, method, path string, body any, v any) error {
+	resp, err := c.request(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *Client) request(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, clientURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the build service: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var apiErr apiError
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil || apiErr.Error == "" {
+			return nil, fmt.Errorf("the build service returned %s", resp.Status)
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s", ErrJobNotFound, apiErr.Error)
+		}
+		return nil, errors.New(apiErr.Error)
+	}
+	return resp, nil
+}
+
+// Submit queues the build of req and returns its job
+func (c *Client) Submit(ctx context.Context, req BuildRequest) (Job, error) {
+	var job Job
+	err := c.do(ctx, http.MethodPost, "/v1/jobs", req, &job)
+	return job, err
+}
+
+// Job returns the status of the job id
+func (c *Client) Job(ctx context.Context, id string) (Job, error) {
+	var job Job
+	err := c.do(ctx, http.MethodGet, "/v1/jobs/"+url.PathEscape(id), nil, &job)
+	return job, err
+}
+
+// Jobs returns the status of the jobs of the service
+func (c *Client) Jobs(ctx context.Context) ([]Job, error) {
+	var jobs []Job
+	err := c.do(ctx, http.MethodGet, "/v1/jobs", nil, &jobs)
+	return jobs, err
+}
+
+// Cancel cancels the job id
+func (c *Client) Cancel(ctx context.Context, id string) (Job, error) {
+	var job Job
+	err := c.do(ctx, http.MethodPost, "/v1/jobs/"+url.PathEscape(id)+"/cancel", nil, &job)
+	return job, err
+}
+
+// Events calls fn with the events of the job id numbered from seq on until
+// the job finished. It returns an error when the stream ends before.
+func (c *Client) Events(ctx context.Context, id string, seq int, fn func(Event) error) error {
+	resp, err := c.request(ctx, http.MethodGet, fmt.Sprintf("/v1/jobs/%s/events?from=%d", url.PathEscape(id), seq), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("invalid event of job %s: %w", id, err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+		if e.State.Done() {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("streaming the events of job %s: %w", id, err)
+	}
+	return fmt.Errorf("the event stream of job %s ended before it finished", id)
+}
@@ -0,0 +1,173 @@
+// Package service runs podman-bootc as a build service: a queue of disk
+// image builds accepted over an HTTP API on a unix socket, with the progress
+// of every build streamed to any number of clients.
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/api"
+)
+
+// JobState is the state of a build job
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCanceled  JobState = "canceled"
+)
+
+// Done reports if the job finished, successfully or not
+func (s JobState) Done() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCanceled
+}
+
+// BuildRequest is a disk image build submitted to the service
+type BuildRequest struct {
+	// Image is the name or id of the bootc container image
+	Image   string             `json:"image"`
+	Options api.InstallOptions `json:"options"`
+}
+
+// JobResult describes the disk image of a succeeded job
+type JobResult struct {
+	// Id is the id of the cache entry, empty for an install to the filesystem
+	Id string `json:"id,omitempty"`
+	// Path is the cached disk image, or the target of an install to the
+	// filesystem
+	Path     string `json:"path"`
+	CacheHit bool   `json:"cacheHit,omitempty"`
+}
+
+// Job is the status of a build job
+type Job struct {
+	Id        string     `json:"id"`
+	Image     string     `json:"image"`
+	State     JobState   `json:"state"`
+	Submitted time.Time  `json:"submitted"`
+	Started   *time.Time `json:"started,omitempty"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	// Error is why a failed job failed
+	Error  string     `json:"error,omitempty"`
+	Result *JobResult `json:"result,omitempty"`
+}
+
+// Event is an event of a job: a progress event of its build or a change of
+// its state. Seq numbers the events of a job from 0, a client resumes a
+// stream after the last event it received.
+type Event struct {
+	Seq  int       `json:"seq"`
+	Time time.Time `json:"time"`
+	// State is set for the changes of the state of the job
+	State JobState `json:"state,omitempty"`
+	// Error is set with the failed state
+	Error    string             `json:"error,omitempty"`
+	Progress *api.ProgressEvent `json:"progress,omitempty"`
+}
+
+// maxJobEvents bounds the events kept for the clients of a job, the oldest
+// ones are dropped
+const maxJobEvents = 10000
+
+// job is a build job and the events of its build. The progress hook of the
+// build appends the events, it must not block, so the clients of the events
+// wait on changed instead of being sent the events.
+type job struct {
+	mu     sync.Mutex
+	status Job
+	req    BuildRequest
+	events []Event
+	// first is the sequence number of events[0]
+	first int
+	// changed is closed and replaced on every new event
+	changed chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newJob(ctx context.Context, id string, req BuildRequest) *job {
+	now := time.Now()
+	j := &job{
+		status:  Job{Id: id, Image: req.Image, State: JobQueued, Submitted: now},
+		req:     req,
+		changed: make(chan struct{}),
+	}
+	j.ctx, j.cancel = context.WithCancel(ctx)
+	j.appendLocked(Event{Time: now, State: JobQueued})
+	return j
+}
+
+// Status returns the status of the job
+func (j *job) Status() Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// appendLocked appends the event, numbering it, and wakes up the clients
+func (j *job) appendLocked(e Event) {
+	e.Seq = j.first + len(j.events)
+	j.events = append(j.events, e)
+	if len(j.events) > maxJobEvents {
+		dropped := len(j.events) - maxJobEvents
+		j.events = append([]Event(nil), j.events[dropped:]...)
+		j.first += dropped
+	}
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// progress is the progress hook of the build of the job
+func (j *job) progress(e api.ProgressEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.appendLocked(Event{Time: time.Now(), Progress: &e})
+}
+
+// setState moves the job to state, which is a no-op for finished jobs. It
+// reports if the state changed.
+func (j *job) setState(state JobState, err error, result *JobResult) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status.State.Done() {
+		return false
+	}
+	now := time.Now()
+	j.status.State = state
+	event := Event{Time: now, State: state}
+	switch {
+	case state == JobRunning:
+		j.status.Started = &now
+	case state.Done():
+		j.status.Finished = &now
+		j.status.Result = result
+		if err != nil {
+			j.status.Error = err.Error()
+			event.Error = j.status.Error
+		}
+	}
+	j.appendLocked(event)
+	return true
+}
+
+// eventsSince returns the events numbered from seq on, whether the job
+// finished, and a channel closed when there are more events
+func (j *job) eventsSince(seq int) ([]Event, bool, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	i := seq - j.first
+	if i < 0 {
+		i = 0
+	}
+	var events []Event
+	if i < len(j.events) {
+		events = append(events, j.events[i:]...)
+	}
+	return events, j.status.State.Done(), j.changed
+}
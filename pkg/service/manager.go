@@ -0,0 +1,307 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/api"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultMaxJobs is the number of builds running at once by default,
+	// the others are queued
+	DefaultMaxJobs = 1
+	// maxFinishedJobs is the number of finished jobs whose status is kept,
+	// the oldest ones are forgotten
+	maxFinishedJobs = 100
+)
+
+var (
+	// ErrJobNotFound is returned for unknown or forgotten jobs
+	ErrJobNotFound = errors.New("no such job")
+	// ErrShuttingDown is returned by Submit once the manager is closed
+	ErrShuttingDown = errors.New("the build service is shutting down")
+)
+
+// Builder builds the disk image of a job, it is an api.DiskBuilder in the
+// service
+type Builder interface {
+	SetProgressHook(hook func(api.ProgressEvent))
+	Build(opts api.InstallOptions) (api.InstallResult, error)
+	Disk() (api.CachedDisk, error)
+}
+
+// NewBuilderFunc returns the builder of image, canceling ctx cancels its
+// build
+type NewBuilderFunc func(ctx context.Context, image string) Builder
+
+// Manager queues the build jobs and runs at most maxJobs of them at once.
+// Builds of the same image, or sharing a cache entry, are serialized by
+// the locks of the cache like the builds of concurrent commands.
+type Manager struct {
+	newBuilder NewBuilderFunc
+	maxJobs    int
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs map[string]*job
+	// order is the ids of the jobs in submission order
+	order []string
+	// queue is the jobs waiting for one of the running builds to finish
+	queue   []*job
+	running int
+	closed  bool
+}
+
+// NewManager returns a manager running the builds of newBuilder, ctx
+// carries the connection to the podman service
+func NewManager(ctx context.Context, newBuilder NewBuilderFunc, maxJobs int) *Manager {
+	if maxJobs <= 0 {
+		maxJobs = DefaultMaxJobs
+	}
+	m := &Manager{
+		newBuilder: newBuilder,
+		maxJobs:    maxJobs,
+		jobs:       map[string]*job{},
+	}
+	m.ctx, m.cancel = context.WithCancel(ctx)
+	return m
+}
+
+// validateRequest rejects the requests the service does not build. The
+// builds run with the privileges of the service on its filesystem: options
+// naming files or directories would read or write them for any client of the
+// socket, relative paths would resolve against the directory of the service,
+// the host backend and the post-install hook run executables on the host,
+// and disk modes change the permissions of the disk images of every client.
+func validateRequest(req BuildRequest) error {
+	if req.Image == "" {
+		return errors.New("the image of the build is missing")
+	}
+	o := req.Options
+	unsupported := []struct {
+		name string
+		set  bool
+	}{
+		{"post-install hooks", o.PostInstallHook != ""},
+		{"disk owners", o.DiskOwner != ""},
+		{"disk modes", o.DiskMode != ""},
+		{"installs to a filesystem", o.InstallMode == api.InstallModeFilesystem || o.InstallTarget != ""},
+		{"the host install backend", o.InstallBackend == api.InstallBackendHost},
+		{"digest files", o.DigestFile != ""},
+		{"install configurations", o.InstallConfig != ""},
+		{"root SSH keys", len(o.RootSSHKeys) > 0},
+		{"provenance keys", o.ProvenanceKey != ""},
+		{"extra install arguments", len(o.ExtraInstallArgs) > 0},
+	}
+	for _, u := range unsupported {
+		if u.set {
+			return fmt.Errorf("%s are not supported by the build service", u.name)
+		}
+	}
+	return nil
+}
+
+// Submit queues the build of req and returns its job
+func (m *Manager) Submit(req BuildRequest) (Job, error) {
+	if err := validateRequest(req); err != nil {
+		return Job{}, err
+	}
+	id, err := newJobId()
+	if err != nil {
+		return Job{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return Job{}, ErrShuttingDown
+	}
+	j := newJob(m.ctx, id, req)
+	m.jobs[id] = j
+	m.order = append(m.order, id)
+	m.forgetFinishedLocked()
+
+	logrus.Infof("Queued job %s building %s", id, req.Image)
+	m.queue = append(m.queue, j)
+	m.dispatchLocked()
+	return j.Status(), nil
+}
+
+// dispatchLocked starts the queued jobs in order while fewer than maxJobs
+// builds run, skipping the canceled ones
+func (m *Manager) dispatchLocked() {
+	for !m.closed && m.running < m.maxJobs && len(m.queue) > 0 {
+		j := m.queue[0]
+		m.queue = m.queue[1:]
+		if !j.setState(JobRunning, nil, nil) {
+			continue
+		}
+		m.running++
+		m.wg.Add(1)
+		go m.run(j)
+	}
+}
+
+// run builds the job, then starts the next queued one
+func (m *Manager) run(j *job) {
+	defer func() {
+		m.mu.Lock()
+		m.running--
+		m.dispatchLocked()
+		m.mu.Unlock()
+		m.wg.Done()
+	}()
+
+	id := j.Status().Id
+	logrus.Infof("Started job %s building %s", id, j.req.Image)
+	builder := m.newBuilder(j.ctx, j.req.Image)
+	builder.SetProgressHook(j.progress)
+	opts := j.req.Options
+	// Nobody can confirm the creation of a large disk image
+	opts.AssumeYes = true
+	result, err := builder.Build(opts)
+	if err != nil {
+		state := JobFailed
+		if j.ctx.Err() != nil {
+			state = JobCanceled
+		}
+		j.setState(state, err, nil)
+		logrus.Infof("Job %s %s: %v", id, state, err)
+		return
+	}
+
+	jobResult := &JobResult{Path: result.Target, CacheHit: result.CacheHit}
+	// An install to the filesystem does not cache a disk image
+	if result.Target == "" {
+		disk, err := builder.Disk()
+		if err != nil {
+			j.setState(JobFailed, err, nil)
+			logrus.Infof("Job %s failed: %v", id, err)
+			return
+		}
+		jobResult.Id, jobResult.Path = disk.Id, disk.Path
+	}
+	j.setState(JobSucceeded, nil, jobResult)
+	logrus.Infof("Job %s succeeded: %s", id, jobResult.Path)
+}
+
+// forgetFinishedLocked forgets the oldest finished jobs over maxFinishedJobs
+func (m *Manager) forgetFinishedLocked() {
+	finished := 0
+	for _, id := range m.order {
+		if m.jobs[id].Status().State.Done() {
+			finished++
+		}
+	}
+	order := m.order[:0]
+	for _, id := range m.order {
+		if finished > maxFinishedJobs && m.jobs[id].Status().State.Done() {
+			delete(m.jobs, id)
+			finished--
+			continue
+		}
+		order = append(order, id)
+	}
+	m.order = order
+}
+
+func (m *Manager) job(id string) (*job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return j, nil
+}
+
+// Job returns the status of the job id
+func (m *Manager) Job(id string) (Job, error) {
+	j, err := m.job(id)
+	if err != nil {
+		return Job{}, err
+	}
+	return j.Status(), nil
+}
+
+// Jobs returns the status of the jobs in submission order
+func (m *Manager) Jobs() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]Job, 0, len(m.order))
+	for _, id := range m.order {
+		jobs = append(jobs, m.jobs[id].Status())
+	}
+	return jobs
+}
+
+// Cancel cancels the job id: a queued job is canceled at once, the build of
+// a running one is interrupted and its install container removed. Canceling
+// a finished job does nothing.
+func (m *Manager) Cancel(id string) (Job, error) {
+	j, err := m.job(id)
+	if err != nil {
+		return Job{}, err
+	}
+	j.cancel()
+	if j.Status().State == JobQueued {
+		j.setState(JobCanceled, nil, nil)
+	}
+	return j.Status(), nil
+}
+
+// Events calls fn with the events of the job id numbered from seq on, as
+// they happen, until the job finished or ctx is done
+func (m *Manager) Events(ctx context.Context, id string, seq int, fn func(Event) error) error {
+	j, err := m.job(id)
+	if err != nil {
+		return err
+	}
+	for {
+		events, done, changed := j.eventsSince(seq)
+		for _, e := range events {
+			if err := fn(e); err != nil {
+				return err
+			}
+			seq = e.Seq + 1
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close cancels the jobs and waits for their builds to stop
+func (m *Manager) Close() {
+	m.mu.Lock()
+	m.closed = true
+	for _, j := range m.queue {
+		j.setState(JobCanceled, nil, nil)
+	}
+	m.queue = nil
+	m.mu.Unlock()
+	m.cancel()
+	m.wg.Wait()
+}
+
+func newJobId() (string, error) {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// shutdownTimeout is how long the requests in flight have to complete once
+// the service stops
+const shutdownTimeout = 5 * time.Second
+
+// SocketPath returns the path of the socket of an address of the service,
+// unix:///path/to/socket. Only unix sockets are supported, their permissions
+// restrict who may submit builds.
+func SocketPath(address string) (string, error) {
+	path, ok := strings.CutPrefix(address, "unix://")
+	if !ok || !filepath.IsAbs(path) {
+		return "", fmt.Errorf("invalid address %q, expected unix:///path/to/socket", address)
+	}
+	return path, nil
+}
+
+// Listen listens on the unix socket of address, replacing the socket of a
+// service which did not stop cleanly. The socket is only accessible to the
+// user of the service and its group.
+func Listen(address string) (net.Listener, error) {
+	path, err := SocketPath(address)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another service", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o660); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Serve serves the API of the manager on l until ctx is done, then cancels
+// the jobs of the manager and waits for their builds to stop
+func Serve(ctx context.Context, l net.Listener, m *Manager) error {
+	srv := &http.Server{Handler: NewHandler(m), ReadHeaderTimeout: 10 * time.Second}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		// The event streams end with the jobs
+		m.Close()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logrus.Warnf("unable to shut down the build service: %v", err)
+		}
+	}()
+	err := srv.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		<-stopped
+		return nil
+	}
+	return err
+}
+
+// apiError is the body of the error responses
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// NewHandler returns the HTTP API of the manager:
+//
+//	POST /v1/jobs                 queues a BuildRequest, returns its Job
+//	GET  /v1/jobs                 returns the jobs
+//	GET  /v1/jobs/<id>            returns the job
+//	POST /v1/jobs/<id>/cancel     cancels the job, returns it
+//	GET  /v1/jobs/<id>/events     streams the events of the job as JSON
+//	                              lines until it finished, ?from=<seq>
+//	                              resumes after the events already received
+func NewHandler(m *Manager) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/jobs", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, m.Jobs())
+		case http.MethodPost:
+			var req BuildRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid build request: %w", err))
+				return
+			}
+			job, err := m.Submit(req)
+			if err != nil {
+				writeError(w, statusOf(err), err)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, job)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		}
+	})
+	mux.HandleFunc("/v1/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/jobs/"), "/")
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			job, err := m.Job(id)
+			if err != nil {
+				writeError(w, statusOf(err), err)
+				return
+			}
+			writeJSON(w, http.StatusOK, job)
+		case action == "cancel" && r.Method == http.MethodPost:
+			job, err := m.Cancel(id)
+			if err != nil {
+				writeError(w, statusOf(err), err)
+				return
+			}
+			writeJSON(w, http.StatusOK, job)
+		case action == "events" && r.Method == http.MethodGet:
+			streamEvents(w, r, m, id)
+		case action == "" || action == "cancel" || action == "events":
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		default:
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown endpoint %s", r.URL.Path))
+		}
+	})
+	return mux
+}
+
+// streamEvents writes the events of the job id as JSON lines, flushing
+// every one of them
+func streamEvents(w http.ResponseWriter, r *http.Request, m *Manager, id string) {
+	seq := 0
+	if from := r.URL.Query().Get("from"); from != "" {
+		var err error
+		if seq, err = strconv.Atoi(from); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid event number %q", from))
+			return
+		}
+	}
+	if _, err := m.Job(id); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	err := m.Events(r.Context(), id, seq, func(e Event) error {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil && r.Context().Err() == nil {
+		logrus.Debugf("streaming the events of job %s: %v", id, err)
+	}
+}
+
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrShuttingDown):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Debugf("unable to write the response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
@@ -0,0 +1,279 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/api"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestService(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Service Suite")
+}
+
+// fakeBuilder sends itself to started and builds until it is released or
+// canceled
+type fakeBuilder struct {
+	ctx     context.Context
+	image   string
+	opts    api.InstallOptions
+	hook    func(api.ProgressEvent)
+	started chan *fakeBuilder
+	release chan error
+}
+
+func (b *fakeBuilder) SetProgressHook(hook func(api.ProgressEvent)) {
+	b.hook = hook
+}
+
+func (b *fakeBuilder) Build(opts api.InstallOptions) (api.InstallResult, error) {
+	b.opts = opts
+	b.started <- b
+	b.hook(api.ProgressEvent{Step: api.StepPull, Message: "Pulling " + b.image})
+	select {
+	case err := <-b.release:
+		if err != nil {
+			return api.InstallResult{}, err
+		}
+	case <-b.ctx.Done():
+		return api.InstallResult{}, b.ctx.Err()
+	}
+	b.hook(api.ProgressEvent{Step: api.StepFinalize, Message: "Done"})
+	return api.InstallResult{}, nil
+}
+
+func (b *fakeBuilder) Disk() (api.CachedDisk, error) {
+	return api.CachedDisk{Id: "0123456789ab", Path: "/cache/0123456789ab/disk.raw"}, nil
+}
+
+// newFakeManager returns a manager whose builders are sent to started
+func newFakeManager(maxJobs int) (*Manager, chan *fakeBuilder) {
+	started := make(chan *fakeBuilder, 10)
+	m := NewManager(context.Background(), func(ctx context.Context, image string) Builder {
+		return &fakeBuilder{ctx: ctx, image: image, started: started, release: make(chan error, 1)}
+	}, maxJobs)
+	DeferCleanup(m.Close)
+	return m, started
+}
+
+func waitForState(m *Manager, id string, state JobState) Job {
+	var job Job
+	Eventually(func() JobState {
+		job, _ = m.Job(id)
+		return job.State
+	}).Should(Equal(state))
+	return job
+}
+
+var _ = Describe("Service", func() {
+	It("should queue the jobs over the maximum", func() {
+		m, started := newFakeManager(1)
+		first, err := m.Submit(BuildRequest{Image: "quay.io/test/first"})
+		Expect(err).ToNot(HaveOccurred())
+		second, err := m.Submit(BuildRequest{Image: "quay.io/test/second"})
+		Expect(err).ToNot(HaveOccurred())
+
+		var b *fakeBuilder
+		Eventually(started).Should(Receive(&b))
+		Expect(b.image).To(Equal("quay.io/test/first"))
+		Expect(b.opts.AssumeYes).To(BeTrue())
+		waitForState(m, first.Id, JobRunning)
+		Consistently(started, 100*time.Millisecond).ShouldNot(Receive())
+		Expect(m.Job(second.Id)).To(HaveField("State", JobQueued))
+
+		b.release <- nil
+		job := waitForState(m, first.Id, JobSucceeded)
+		Expect(job.Result).To(Equal(&JobResult{Id: "0123456789ab", Path: "/cache/0123456789ab/disk.raw"}))
+		Expect(job.Started).ToNot(BeNil())
+		Expect(job.Finished).ToNot(BeNil())
+
+		Eventually(started).Should(Receive(&b))
+		Expect(b.image).To(Equal("quay.io/test/second"))
+		b.release <- errors.New("bootc install failed")
+		job = waitForState(m, second.Id, JobFailed)
+		Expect(job.Error).To(Equal("bootc install failed"))
+		Expect(m.Jobs()).To(HaveLen(2))
+		Expect(m.Jobs()[0].Id).To(Equal(first.Id))
+	})
+
+	It("should cancel queued and running jobs", func() {
+		m, started := newFakeManager(1)
+		running, err := m.Submit(BuildRequest{Image: "quay.io/test/running"})
+		Expect(err).ToNot(HaveOccurred())
+		queued, err := m.Submit(BuildRequest{Image: "quay.io/test/queued"})
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(started).Should(Receive())
+
+		job, err := m.Cancel(queued.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(job.State).To(Equal(JobCanceled))
+		_, err = m.Cancel(running.Id)
+		Expect(err).ToNot(HaveOccurred())
+		job = waitForState(m, running.Id, JobCanceled)
+		Expect(job.Error).To(Equal(context.Canceled.Error()))
+		Consistently(started, 100*time.Millisecond).ShouldNot(Receive())
+
+		_, err = m.Cancel("unknown")
+		Expect(err).To(MatchError(ErrJobNotFound))
+	})
+
+	It("should reject invalid requests", func() {
+		m, _ := newFakeManager(1)
+		_, err := m.Submit(BuildRequest{})
+		Expect(err).To(MatchError("the image of the build is missing"))
+		for opts, msg := range map[*api.InstallOptions]string{
+			{PostInstallHook: "/bin/true"}:               "post-install hooks",
+			{DiskOwner: "qemu:qemu"}:                     "disk owners",
+			{DiskMode: "0666"}:                           "disk modes",
+			{InstallMode: api.InstallModeFilesystem}:     "installs to a filesystem",
+			{InstallTarget: "/var/lib/target"}:           "installs to a filesystem",
+			{InstallBackend: api.InstallBackendHost}:     "host install backend",
+			{DigestFile: "/etc/cron.d/digest"}:           "digest files",
+			{InstallConfig: "/etc/shadow"}:               "install configurations",
+			{RootSSHKeys: []string{"../.ssh/id_rsa"}}:    "root SSH keys",
+			{ProvenanceKey: "cosign.key"}:                "provenance keys",
+			{ExtraInstallArgs: []string{"--karg=quiet"}}: "extra install arguments",
+		} {
+			_, err = m.Submit(BuildRequest{Image: "quay.io/test/test", Options: *opts})
+			Expect(err).To(MatchError(ContainSubstring(msg)))
+		}
+		Expect(m.Jobs()).To(BeEmpty())
+		m.Close()
+		_, err = m.Submit(BuildRequest{Image: "quay.io/test/test"})
+		Expect(err).To(MatchError(ErrShuttingDown))
+	})
+
+	It("should forget the oldest finished jobs", func() {
+		m, started := newFakeManager(1)
+		var ids []string
+		for i := 0; i < maxFinishedJobs+2; i++ {
+			job, err := m.Submit(BuildRequest{Image: "quay.io/test/test"})
+			Expect(err).ToNot(HaveOccurred())
+			var b *fakeBuilder
+			Eventually(started).Should(Receive(&b))
+			b.release <- nil
+			waitForState(m, job.Id, JobSucceeded)
+			ids = append(ids, job.Id)
+		}
+		_, err := m.Submit(BuildRequest{Image: "quay.io/test/test"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m.Jobs()).To(HaveLen(maxFinishedJobs + 1))
+		_, err = m.Job(ids[0])
+		Expect(err).To(MatchError(ErrJobNotFound))
+		Expect(m.Job(ids[2])).To(HaveField("State", JobSucceeded))
+	})
+
+	Context("API", func() {
+		var (
+			client  *Client
+			started chan *fakeBuilder
+			ctx     context.Context
+		)
+
+		BeforeEach(func() {
+			// The path of a unix socket is limited to about 100 bytes
+			dir, err := os.MkdirTemp("", "service")
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(os.RemoveAll, dir)
+			address := "unix://" + filepath.Join(dir, "run", "build.sock")
+			l, err := Listen(address)
+			Expect(err).ToNot(HaveOccurred())
+			st, err := os.Stat(filepath.Join(dir, "run", "build.sock"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(st.Mode().Perm()).To(Equal(os.FileMode(0o660)))
+			_, err = Listen(address)
+			Expect(err).To(MatchError(ContainSubstring("in use by another service")))
+
+			var m *Manager
+			m, started = newFakeManager(2)
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithCancel(context.Background())
+			served := make(chan error)
+			go func() { served <- Serve(ctx, l, m) }()
+			DeferCleanup(func() {
+				cancel()
+				Eventually(served).Should(Receive(BeNil()))
+			})
+			client, err = NewClient(address)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should submit jobs and stream their events", func() {
+			job, err := client.Submit(ctx, BuildRequest{Image: "quay.io/test/test", Options: api.InstallOptions{Filesystem: "xfs"}})
+			Expect(err).ToNot(HaveOccurred())
+			// A free slot starts the job at once
+			Expect(job.State).To(Equal(JobRunning))
+			var b *fakeBuilder
+			Eventually(started).Should(Receive(&b))
+			Expect(b.opts.Filesystem).To(Equal("xfs"))
+
+			var events []Event
+			streamed := make(chan error)
+			go func() {
+				streamed <- client.Events(ctx, job.Id, 0, func(e Event) error {
+					events = append(events, e)
+					return nil
+				})
+			}()
+			b.release <- nil
+			Eventually(streamed).Should(Receive(BeNil()))
+			Expect(events).To(HaveLen(5))
+			Expect(events[0].State).To(Equal(JobQueued))
+			Expect(events[1].State).To(Equal(JobRunning))
+			Expect(events[2].Progress.Message).To(Equal("Pulling quay.io/test/test"))
+			Expect(events[3].Progress.Step).To(Equal(api.StepFinalize))
+			Expect(events[4].State).To(Equal(JobSucceeded))
+			for i, e := range events {
+				Expect(e.Seq).To(Equal(i))
+			}
+
+			var resumed []Event
+			Expect(client.Events(ctx, job.Id, 3, func(e Event) error {
+				resumed = append(resumed, e)
+				return nil
+			})).To(Succeed())
+			Expect(resumed).To(Equal(events[3:]))
+
+			job, err = client.Job(ctx, job.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(job.State).To(Equal(JobSucceeded))
+			Expect(job.Result.Path).To(Equal("/cache/0123456789ab/disk.raw"))
+			jobs, err := client.Jobs(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(jobs).To(HaveLen(1))
+		})
+
+		It("should cancel jobs and report errors", func() {
+			job, err := client.Submit(ctx, BuildRequest{Image: "quay.io/test/test"})
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(started).Should(Receive())
+			_, err = client.Cancel(ctx, job.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(client.Events(ctx, job.Id, 0, func(Event) error { return nil })).To(Succeed())
+			job, err = client.Job(ctx, job.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(job.State).To(Equal(JobCanceled))
+
+			_, err = client.Job(ctx, "unknown")
+			Expect(err).To(MatchError(ErrJobNotFound))
+			_, err = client.Submit(ctx, BuildRequest{})
+			Expect(err).To(MatchError("the image of the build is missing"))
+		})
+	})
+
+	It("should only accept unix socket addresses", func() {
+		Expect(SocketPath("unix:///run/podman-bootc.sock")).To(Equal("/run/podman-bootc.sock"))
+		_, err := SocketPath("tcp://localhost:8080")
+		Expect(err).To(HaveOccurred())
+		_, err = NewClient("unix://relative.sock")
+		Expect(err).To(HaveOccurred())
+	})
+})
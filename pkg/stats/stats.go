@@ -0,0 +1,226 @@
+// Package stats keeps opt-in usage statistics of the disk builds in a local
+// file. They are never sent anywhere, they are only read by podman-bootc
+// stats.
+package stats
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// File holds one JSON record per line in the state directory
+	File = "stats.jsonl"
+	// enabledFile is created in the state directory to opt in
+	enabledFile = "stats.enabled"
+)
+
+// Record is what an invocation building a disk image appends to the stats
+// file. It holds no image names, paths or hosts.
+type Record struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	// CacheHit is set when the cached disk image was used, Built when a
+	// new one was built
+	CacheHit bool `json:"cacheHit,omitempty"`
+	Built    bool `json:"built,omitempty"`
+	Failed   bool `json:"failed,omitempty"`
+	// BuildTime is the duration of a successful build
+	BuildTime   time.Duration `json:"buildTime,omitempty"`
+	BytesPulled int64         `json:"bytesPulled,omitempty"`
+}
+
+// Enabled reports if the statistics are kept in stateDir, they are off
+// until Enable is called
+func Enabled(stateDir string) bool {
+	_, err := os.Stat(filepath.Join(stateDir, enabledFile))
+	return err == nil
+}
+
+// Enable opts in to keeping the statistics in stateDir
+func Enable(stateDir string) error {
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(stateDir, enabledFile), nil, 0o644)
+}
+
+// Disable opts out of keeping the statistics in stateDir, the records
+// already written are kept
+func Disable(stateDir string) error {
+	err := os.Remove(filepath.Join(stateDir, enabledFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Recorder collects the record of an invocation, it implements the Metrics
+// of the disk build
+type Recorder struct {
+	mu     sync.Mutex
+	record Record
+}
+
+// NewRecorder returns a Recorder of an invocation of command
+func NewRecorder(command string) *Recorder {
+	return &Recorder{record: Record{Time: time.Now(), Command: command}}
+}
+
+func (r *Recorder) BuildStarted() {}
+
+func (r *Recorder) BuildSucceeded(duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.record.Built = true
+	r.record.BuildTime += duration
+}
+
+func (r *Recorder) BuildFailed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.record.Failed = true
+}
+
+func (r *Recorder) CacheHit() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.record.CacheHit = true
+}
+
+func (r *Recorder) CacheMiss() {}
+
+func (r *Recorder) BytesPulled(bytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.record.BytesPulled += bytes
+}
+
+func (r *Recorder) CacheSize(_ int64) {}
+
+// Record returns the record collected so far
+func (r *Recorder) Record() Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.record
+}
+
+// Append appends the record to the stats file at path. A single write of a
+// line is atomic, concurrent invocations do not interleave their records.
+func Append(path string, record Record) error {
+	buf, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(buf, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Read returns the records of the stats file at path written since since,
+// all of them when it is zero. Lines which are not records are skipped.
+func Read(path string, since time.Time) ([]Record, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			logrus.Debugf("skipping line %d of %s: %v", line, path, err)
+			continue
+		}
+		if record.Time.Before(since) {
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return records, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return records, nil
+}
+
+// Summary aggregates records
+type Summary struct {
+	// From and To are the times of the first and the last record
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	Invocations int       `json:"invocations"`
+	CacheHits   int       `json:"cacheHits"`
+	Builds      int       `json:"builds"`
+	Failures    int       `json:"failures"`
+	// CacheHitRatio is the share of the disk images reused from the cache
+	// among the ones reused or built
+	CacheHitRatio   float64       `json:"cacheHitRatio"`
+	MedianBuildTime time.Duration `json:"medianBuildTime"`
+	BytesPulled     int64         `json:"bytesPulled"`
+}
+
+// Summarize aggregates the records
+func Summarize(records []Record) Summary {
+	var s Summary
+	var buildTimes []time.Duration
+	for _, record := range records {
+		if s.From.IsZero() || record.Time.Before(s.From) {
+			s.From = record.Time
+		}
+		if record.Time.After(s.To) {
+			s.To = record.Time
+		}
+		s.Invocations++
+		if record.CacheHit {
+			s.CacheHits++
+		}
+		if record.Built {
+			s.Builds++
+			buildTimes = append(buildTimes, record.BuildTime)
+		}
+		if record.Failed {
+			s.Failures++
+		}
+		s.BytesPulled += record.BytesPulled
+	}
+	if lookups := s.CacheHits + s.Builds; lookups > 0 {
+		s.CacheHitRatio = float64(s.CacheHits) / float64(lookups)
+	}
+	s.MedianBuildTime = median(buildTimes)
+	return s
+}
+
+// median returns the median of durations, 0 for none
+func median(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	mid := len(durations) / 2
+	if len(durations)%2 == 0 {
+		return (durations[mid-1] + durations[mid]) / 2
+	}
+	return durations[mid]
+}
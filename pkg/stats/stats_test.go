@@ -0,0 +1,89 @@
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestStats(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Stats Suite")
+}
+
+var _ = Describe("Stats", func() {
+	It("should be off until enabled", func() {
+		dir := GinkgoT().TempDir()
+		Expect(Enabled(dir)).To(BeFalse())
+		Expect(Enable(filepath.Join(dir, "state"))).To(Succeed())
+		Expect(Enabled(filepath.Join(dir, "state"))).To(BeTrue())
+		Expect(Disable(filepath.Join(dir, "state"))).To(Succeed())
+		Expect(Enabled(filepath.Join(dir, "state"))).To(BeFalse())
+		Expect(Disable(filepath.Join(dir, "state"))).To(Succeed())
+	})
+
+	It("should collect the record of an invocation", func() {
+		r := NewRecorder("run")
+		r.BuildStarted()
+		r.CacheMiss()
+		r.BytesPulled(1024)
+		r.BuildSucceeded(3 * time.Minute)
+		record := r.Record()
+		Expect(record.Command).To(Equal("run"))
+		Expect(record.Built).To(BeTrue())
+		Expect(record.CacheHit).To(BeFalse())
+		Expect(record.BuildTime).To(Equal(3 * time.Minute))
+		Expect(record.BytesPulled).To(Equal(int64(1024)))
+	})
+
+	It("should append and read the records of a window", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "state", File)
+		now := time.Now().Truncate(time.Second)
+		Expect(Append(path, Record{Time: now.Add(-48 * time.Hour), Command: "run", Built: true, BuildTime: time.Hour})).To(Succeed())
+		Expect(Append(path, Record{Time: now.Add(-time.Hour), Command: "disk build", CacheHit: true})).To(Succeed())
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = f.WriteString("{truncated\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+		Expect(Append(path, Record{Time: now, Command: "run", Built: true, BuildTime: 2 * time.Minute, BytesPulled: 500})).To(Succeed())
+
+		records, err := Read(path, time.Time{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(records).To(HaveLen(3))
+		records, err = Read(path, now.Add(-24*time.Hour))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(records).To(HaveLen(2))
+		Expect(records[0].Command).To(Equal("disk build"))
+
+		records, err = Read(filepath.Join(GinkgoT().TempDir(), File), time.Time{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(records).To(BeEmpty())
+	})
+
+	It("should summarize the records", func() {
+		now := time.Now()
+		s := Summarize([]Record{
+			{Time: now.Add(-time.Hour), Built: true, BuildTime: 4 * time.Minute, BytesPulled: 100},
+			{Time: now, CacheHit: true},
+			{Time: now.Add(-2 * time.Hour), Built: true, BuildTime: 2 * time.Minute, BytesPulled: 50},
+			{Time: now.Add(-3 * time.Hour), CacheHit: true},
+			{Time: now.Add(-4 * time.Hour), Failed: true},
+		})
+		Expect(s.Invocations).To(Equal(5))
+		Expect(s.CacheHits).To(Equal(2))
+		Expect(s.Builds).To(Equal(2))
+		Expect(s.Failures).To(Equal(1))
+		Expect(s.CacheHitRatio).To(Equal(0.5))
+		Expect(s.MedianBuildTime).To(Equal(3 * time.Minute))
+		Expect(s.BytesPulled).To(Equal(int64(150)))
+		Expect(s.From).To(Equal(now.Add(-4 * time.Hour)))
+		Expect(s.To).To(Equal(now))
+
+		Expect(Summarize(nil)).To(Equal(Summary{}))
+	})
+})
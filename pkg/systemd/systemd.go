@@ -0,0 +1,161 @@
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// RestartNo does not start the VM with the host
+	RestartNo = "no"
+	// RestartAlways starts the VM with the host and again whenever it stops
+	RestartAlways = "always"
+	// RestartOnFailure starts the VM with the host and again when it fails
+	RestartOnFailure = "on-failure"
+)
+
+// ErrUnsupported is returned on hosts without systemd
+var ErrUnsupported = errors.New("autostart and restart policies require a host running systemd")
+
+// ValidateRestart checks the restart policy
+func ValidateRestart(policy string) error {
+	switch policy {
+	case RestartNo, RestartAlways, RestartOnFailure:
+		return nil
+	}
+	return fmt.Errorf("invalid restart policy %q, use %q, %q or %q", policy, RestartNo, RestartAlways, RestartOnFailure)
+}
+
+// Available reports if the host runs systemd
+func Available() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	_, err := os.Stat("/run/systemd/system")
+	return err == nil
+}
+
+// UnitName returns the name of the unit starting the VM of the image id
+func UnitName(id string) string {
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return "podman-bootc-" + id + ".service"
+}
+
+// Unit starts a VM with the host and restarts it according to its policy
+type Unit struct {
+	Id         string // the image id of the VM
+	Restart    string // RestartAlways or RestartOnFailure
+	System     bool   // a system unit running as User instead of a user unit
+	User       string // the user running a system unit
+	Executable string // the path of podman-bootc
+}
+
+// escapeArg escapes an argument of ExecStart, % and $ would expand
+// specifiers and variables, and the arguments with spaces, quotes or
+// backslashes are quoted
+func escapeArg(arg string) string {
+	arg = strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\"'\\;") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(arg) + `"`
+}
+
+// String returns the unit file
+func (u Unit) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by podman-bootc, removed by 'podman-bootc stop' and 'podman-bootc rm'\n")
+	fmt.Fprintf(&b, "[Unit]\nDescription=podman-bootc VM %s\n", u.Id[:12])
+	fmt.Fprintf(&b, "Wants=network-online.target\nAfter=network-online.target\n\n")
+	fmt.Fprintf(&b, "[Service]\nType=simple\n")
+	if u.System {
+		fmt.Fprintf(&b, "User=%s\n", strings.ReplaceAll(u.User, "%", "%%"))
+	}
+	// start --wait stops the VM on SIGTERM and fails when the VM stops
+	fmt.Fprintf(&b, "ExecStart=%s start --wait %s\n", escapeArg(u.Executable), escapeArg(u.Id))
+	fmt.Fprintf(&b, "Restart=%s\nRestartSec=10\nTimeoutStopSec=120\n\n", u.Restart)
+	target := "default.target"
+	if u.System {
+		target = "multi-user.target"
+	}
+	fmt.Fprintf(&b, "[Install]\nWantedBy=%s\n", target)
+	return b.String()
+}
+
+// unitDir returns the directory of the user or system units
+func unitDir(system bool) (string, error) {
+	if system {
+		return "/etc/systemd/system", nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user"), nil
+}
+
+func systemctl(system bool, args ...string) error {
+	if !system {
+		args = append([]string{"--user"}, args...)
+	}
+	logrus.Debugf("running systemctl %s", strings.Join(args, " "))
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Install writes the unit, enables and starts it
+func Install(u Unit) error {
+	if !Available() {
+		return ErrUnsupported
+	}
+	dir, err := unitDir(u.System)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, UnitName(u.Id)), []byte(u.String()), 0o644); err != nil {
+		return err
+	}
+	if err := systemctl(u.System, "daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl(u.System, "enable", "--now", UnitName(u.Id))
+}
+
+// Remove disables, stops and removes the user or system unit of the VM, if any
+func Remove(id string) error {
+	for _, system := range []bool{false, true} {
+		dir, err := unitDir(system)
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(dir, UnitName(id))
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if err := systemctl(system, "disable", "--now", UnitName(id)); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		if err := systemctl(system, "daemon-reload"); err != nil {
+			logrus.Warnf("%v", err)
+		}
+	}
+	return nil
+}
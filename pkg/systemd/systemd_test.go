@@ -0,0 +1,46 @@
+package systemd
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestSystemd(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Systemd Suite")
+}
+
+const testID = "a025064b145ed339eeef86046aea3ee221a2a5a16f588aff4f43a42e5ca9f844"
+
+var _ = Describe("Unit", func() {
+	It("should start the VM as a user unit", func() {
+		unit := Unit{Id: testID, Restart: RestartAlways, Executable: "/usr/bin/podman-bootc"}.String()
+		Expect(unit).To(ContainSubstring("ExecStart=/usr/bin/podman-bootc start --wait " + testID + "\n"))
+		Expect(unit).To(ContainSubstring("Restart=always\n"))
+		Expect(unit).To(ContainSubstring("WantedBy=default.target\n"))
+		Expect(unit).ToNot(ContainSubstring("User="))
+		Expect(UnitName(testID)).To(Equal("podman-bootc-a025064b145e.service"))
+	})
+
+	It("should run a system unit as the user", func() {
+		unit := Unit{Id: testID, Restart: RestartOnFailure, System: true, User: "dev", Executable: "/usr/bin/podman-bootc"}.String()
+		Expect(unit).To(ContainSubstring("User=dev\n"))
+		Expect(unit).To(ContainSubstring("Restart=on-failure\n"))
+		Expect(unit).To(ContainSubstring("WantedBy=multi-user.target\n"))
+	})
+
+	It("should quote the arguments of ExecStart", func() {
+		unit := Unit{Id: testID, Restart: RestartAlways, Executable: "/home/dev/My Tools/100%/podman-bootc"}.String()
+		Expect(unit).To(ContainSubstring(`ExecStart="/home/dev/My Tools/100%%/podman-bootc" start --wait ` + testID + "\n"))
+		Expect(escapeArg(`C:\bin\"x"`)).To(Equal(`"C:\\bin\\\"x\""`))
+		Expect(escapeArg("$HOME")).To(Equal("$$HOME"))
+		Expect(escapeArg("")).To(Equal(`""`))
+	})
+
+	It("should validate the restart policy", func() {
+		Expect(ValidateRestart(RestartNo)).To(Succeed())
+		Expect(ValidateRestart("unless-stopped")).To(MatchError(ContainSubstring(`invalid restart policy "unless-stopped"`)))
+	})
+})
@@ -52,6 +52,16 @@ func (u *User) CacheDir() string {
 	return filepath.Join(u.HomeDir(), config.CacheDir, config.ProjectName)
 }
 
+// ImageCacheDir holds the disk image and the VM state of an image
+func (u *User) ImageCacheDir(imageId string) string {
+	return filepath.Join(u.CacheDir(), imageId)
+}
+
+// DiskImagePath is the disk image of an image
+func (u *User) DiskImagePath(imageId string) string {
+	return filepath.Join(u.ImageCacheDir(imageId), config.DiskImage)
+}
+
 func (u *User) DefaultIdentity() string {
 	return filepath.Join(u.SSHDir(), "id_rsa")
 }
@@ -60,6 +70,15 @@ func (u *User) RunDir() string {
 	return filepath.Join(xdg.RuntimeDir, config.ProjectName, "run")
 }
 
+// StateDir holds the state kept across invocations, e.g. their log files
+func (u *User) StateDir() string {
+	return filepath.Join(xdg.StateHome, config.ProjectName)
+}
+
+func (u *User) LogDir() string {
+	return filepath.Join(u.StateDir(), "logs")
+}
+
 func (u *User) InitOSCDirs() error {
 	if err := os.MkdirAll(u.CacheDir(), os.ModePerm); err != nil {
 		return err
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// CacheDirError explains why the cache directory cannot be used
+type CacheDirError struct {
+	Dir string
+	// Owner is the uid owning the directory, -1 if it is unknown
+	Owner      int
+	Limitation string
+	Hint       string
+	Err        error
+}
+
+func (e *CacheDirError) Error() string {
+	if e.Owner < 0 {
+		return fmt.Sprintf("the cache directory %s %s: %v", e.Dir, e.Limitation, e.Err)
+	}
+	return fmt.Sprintf("the cache directory %s (owned by uid %d) %s: %v", e.Dir, e.Owner, e.Limitation, e.Err)
+}
+
+func (e *CacheDirError) Unwrap() error {
+	return e.Err
+}
+
+// ProbeCacheDir checks that files with user extended attributes can be
+// created in the cache directory, it returns a *CacheDirError otherwise.
+// The xattrs are not required on network filesystems, the metadata is
+// written to sidecar files there.
+func ProbeCacheDir(dir string) error {
+	fail := func(limitation, hint string, err error) error {
+		return &CacheDirError{Dir: dir, Owner: fileOwner(dir), Limitation: limitation, Hint: hint, Err: err}
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fail("cannot be created", "make sure the home directory is writable", err)
+	}
+
+	probe, err := os.CreateTemp(dir, ".podman-bootc-probe")
+	switch {
+	case errors.Is(err, syscall.EROFS):
+		return fail("is on a read-only filesystem", "move the cache to a writable filesystem", err)
+	case errors.Is(err, os.ErrPermission):
+		return fail(fmt.Sprintf("is not writable by uid %d", os.Getuid()), "fix the permissions of "+dir, err)
+	case err != nil:
+		return fail("is not writable", "fix the permissions of "+dir, err)
+	}
+	defer os.Remove(probe.Name())
+	defer probe.Close()
+
+	if fsType, err := NetworkFilesystem(dir); err == nil && fsType != "" {
+		return nil
+	}
+	if err := unix.Fsetxattr(int(probe.Fd()), "user.bootc.probe", []byte("1"), 0); err != nil {
+		return fail("does not support user extended attributes", "use a filesystem supporting user xattrs for the cache", err)
+	}
+	return nil
+}
+
+// fileOwner returns the uid owning path, or -1
+func fileOwner(path string) int {
+	st, err := os.Stat(path)
+	if err != nil {
+		return -1
+	}
+	if sys, ok := st.Sys().(*syscall.Stat_t); ok {
+		return int(sys.Uid)
+	}
+	return -1
+}
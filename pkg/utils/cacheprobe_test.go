@@ -0,0 +1,17 @@
+package utils
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cache directory errors", func() {
+	It("should only name the owner when it is known", func() {
+		err := &CacheDirError{Dir: "/cache", Owner: 1000, Limitation: "is not writable", Err: errors.New("denied")}
+		Expect(err).To(MatchError("the cache directory /cache (owned by uid 1000) is not writable: denied"))
+		err.Owner = -1
+		Expect(err).To(MatchError("the cache directory /cache is not writable: denied"))
+	})
+})
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	commonconfig "github.com/containers/common/pkg/config"
+)
+
+// Connection is a podman service selected by name or URL instead of the
+// podman machine
+type Connection struct {
+	// Name is the name of the podman connection, or the URL
+	Name     string
+	URI      string
+	Identity string
+	Machine  bool
+}
+
+// ResolveConnection returns the podman connection called name, as listed by
+// podman system connection list, or the service at uri when name is empty
+func ResolveConnection(name, uri string) (*Connection, error) {
+	if name != "" && uri != "" {
+		return nil, errors.New("--connection and --url cannot be used together")
+	}
+	if uri != "" {
+		if _, err := url.Parse(uri); err != nil {
+			return nil, fmt.Errorf("invalid podman service URL %q: %w", uri, err)
+		}
+		return &Connection{Name: uri, URI: uri}, nil
+	}
+
+	cfg, err := commonconfig.Default()
+	if err != nil {
+		return nil, fmt.Errorf("reading the podman connections: %w", err)
+	}
+	conn, err := cfg.GetConnection(name, false)
+	if err != nil {
+		return nil, fmt.Errorf("podman connection %s: %w", name, err)
+	}
+	return &Connection{
+		Name:     conn.Name,
+		URI:      conn.URI,
+		Identity: conn.Identity,
+		Machine:  conn.IsMachine,
+	}, nil
+}
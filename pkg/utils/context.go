@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"context"
+	"time"
+)
+
+// detachedContext keeps the values of its parent, like the podman
+// connection, without its deadline and cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+func (c detachedContext) Value(key any) any         { return c.parent.Value(key) }
+
+// WithoutCancel returns a context with the values of ctx which is never
+// cancelled, to clean up after ctx expired
+func WithoutCancel(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
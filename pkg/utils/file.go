@@ -3,8 +3,11 @@ package utils
 import (
 	"bytes"
 	"errors"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"strconv"
+	"syscall"
 )
 
 func ReadPidFile(pidFile string) (int, error) {
@@ -35,3 +38,29 @@ func FileExists(path string) (bool, error) {
 	}
 	return exists, err
 }
+
+// DiskUsage returns the space allocated by the files under path, which
+// is less than their apparent size for sparse files
+func DiskUsage(path string) (int64, error) {
+	var usage int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			usage += st.Blocks * 512
+		} else {
+			usage += info.Size()
+		}
+		return nil
+	})
+	return usage, err
+}
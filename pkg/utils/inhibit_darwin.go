@@ -0,0 +1,6 @@
+package utils
+
+// InhibitSleep is a no-op on macOS, there is no logind
+func InhibitSleep(_ string) (release func()) {
+	return func() {}
+}
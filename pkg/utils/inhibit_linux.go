@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"syscall"
+
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// InhibitSleep takes a logind inhibitor lock blocking sleep and shutdown,
+// calling the returned function releases it. This is best effort: when
+// logind is not reachable the lock is not taken and release is a no-op.
+func InhibitSleep(why string) (release func()) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		logrus.Debugf("unable to connect to the system bus, not inhibiting sleep: %v", err)
+		return func() {}
+	}
+
+	var fd dbus.UnixFD
+	logind := conn.Object("org.freedesktop.login1", "/org/freedesktop/login1")
+	err = logind.Call("org.freedesktop.login1.Manager.Inhibit", 0, "sleep:shutdown", config.ProjectName, why, "block").Store(&fd)
+	if err != nil {
+		logrus.Debugf("unable to take the logind inhibitor lock: %v", err)
+		conn.Close()
+		return func() {}
+	}
+	logrus.Debugf("Took logind inhibitor lock: %s", why)
+
+	return func() {
+		if err := syscall.Close(int(fd)); err != nil {
+			logrus.Debugf("unable to release the logind inhibitor lock: %v", err)
+		}
+		conn.Close()
+	}
+}
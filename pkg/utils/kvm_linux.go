@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const kvmDevice = "/dev/kvm"
+
+// KVMError explains why KVM is not usable, with the fix in Hint
+type KVMError struct {
+	Reason string
+	Hint   string
+}
+
+func (e *KVMError) Error() string {
+	return fmt.Sprintf("%s, %s", e.Reason, e.Hint)
+}
+
+// CheckKVM returns a *KVMError when /dev/kvm is missing or the user may not
+// open it
+func CheckKVM() error {
+	return checkKVM(kvmDevice)
+}
+
+func checkKVM(device string) error {
+	st, err := os.Stat(device)
+	if errors.Is(err, os.ErrNotExist) {
+		return &KVMError{
+			Reason: device + " does not exist",
+			Hint:   "enable virtualization in the firmware and load the kvm module",
+		}
+	} else if err != nil {
+		return &KVMError{Reason: err.Error(), Hint: "check the permissions of " + device}
+	}
+	if err := unix.Access(device, unix.R_OK|unix.W_OK); err != nil {
+		return &KVMError{
+			Reason: fmt.Sprintf("%s is not accessible: %v", device, err),
+			Hint:   kvmGroupHint(st),
+		}
+	}
+	return nil
+}
+
+// kvmGroupHint names the group owning the device the user has to join
+func kvmGroupHint(st os.FileInfo) string {
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "add your user to the kvm group"
+	}
+	gid := strconv.FormatUint(uint64(sys.Gid), 10)
+	group := gid
+	if g, err := user.LookupGroupId(gid); err == nil {
+		group = g.Name
+	}
+	if sys.Gid == 0 {
+		return "the device is only accessible to root, give it to the kvm group with a udev rule"
+	}
+	return fmt.Sprintf("add your user to the %s group, e.g. 'sudo usermod -aG %s $USER', then log in again", group, group)
+}
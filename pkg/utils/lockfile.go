@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// lockFileRefresh is how often the holder of a lock file refreshes its mtime
+	lockFileRefresh = 30 * time.Second
+	// lockFileStale is how old the mtime of a lock file must be to break it.
+	// It is way larger than lockFileRefresh to tolerate clock skew.
+	lockFileStale = 5 * time.Minute
+)
+
+// lockHolder is written to the lock files to identify their holder
+type lockHolder struct {
+	Host     string    `json:"host"`
+	Pid      int       `json:"pid"`
+	Acquired time.Time `json:"acquired"`
+}
+
+// lockFiles implements the cache lock with files created with O_EXCL, which
+// is atomic on NFS, instead of flock, which may not be enforced across hosts.
+// An exclusive lock is the lock file itself, a shared lock is a reader file
+// next to it. Since the PID of a holder on another host means nothing, the
+// holder keeps refreshing the mtime and a lock file is stale when its mtime
+// is older than lockFileStale.
+type lockFiles struct {
+	path string
+
+	mu   sync.Mutex
+	held string
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newLockFiles(path string) *lockFiles {
+	return &lockFiles{path: path}
+}
+
+func (l *lockFiles) tryLock(mode AccessMode) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held != "" {
+		return false, fmt.Errorf("%s is already locked", l.path)
+	}
+
+	if err := breakStaleLock(l.path); err != nil {
+		return false, err
+	}
+
+	if mode == Exclusive {
+		created, err := createLockFile(l.path)
+		if err != nil || !created {
+			return false, err
+		}
+		readers, err := l.liveReaders()
+		if err != nil || readers {
+			os.Remove(l.path)
+			return false, err
+		}
+		l.hold(l.path)
+		return true, nil
+	}
+
+	if _, err := os.Stat(l.path); err == nil {
+		return false, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return false, err
+	}
+	reader := l.path + ".reader-" + hex.EncodeToString(suffix)
+	if _, err := createLockFile(reader); err != nil {
+		return false, err
+	}
+	// A writer may have taken the lock before it could see the reader
+	if _, err := os.Stat(l.path); err == nil {
+		os.Remove(reader)
+		return false, nil
+	}
+	l.hold(reader)
+	return true, nil
+}
+
+func (l *lockFiles) lockContext(ctx context.Context, mode AccessMode) (bool, error) {
+	for {
+		locked, err := l.tryLock(mode)
+		if err != nil || locked {
+			return locked, err
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(lockRetryDelay):
+		}
+	}
+}
+
+func (l *lockFiles) unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held == "" {
+		return nil
+	}
+	close(l.stop)
+	<-l.done
+	err := os.Remove(l.held)
+	l.held = ""
+	return err
+}
+
+// liveReaders breaks the stale reader files and reports if any is left
+func (l *lockFiles) liveReaders() (bool, error) {
+	readers, err := filepath.Glob(l.path + ".reader-*")
+	if err != nil {
+		return false, err
+	}
+	for _, reader := range readers {
+		if err := breakStaleLock(reader); err != nil {
+			return false, err
+		}
+		if _, err := os.Stat(reader); err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// hold keeps refreshing the mtime of the lock file until it is unlocked
+func (l *lockFiles) hold(path string) {
+	l.held = path
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(lockFileRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				now := time.Now()
+				if err := os.Chtimes(path, now, now); err != nil {
+					logrus.Warnf("unable to refresh lock file %s: %v", path, err)
+				}
+			}
+		}
+	}(l.stop, l.done)
+}
+
+// createLockFile creates the lock file with the holder info, it returns
+// false if it already exists
+func createLockFile(path string) (bool, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	host, _ := os.Hostname()
+	holder := lockHolder{Host: host, Pid: os.Getpid(), Acquired: time.Now()}
+	if err := json.NewEncoder(f).Encode(holder); err != nil {
+		os.Remove(path)
+		return false, err
+	}
+	return true, nil
+}
+
+// breakStaleLock removes the lock file if its holder stopped refreshing it
+func breakStaleLock(path string) error {
+	st, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if time.Since(st.ModTime()) < lockFileStale {
+		return nil
+	}
+
+	return breakLock(path, st)
+}
+
+// breakLock removes the stale lock file path whose stat is st. Another
+// process may have broken it and created a fresh one since st, renaming
+// does not tell them apart, so the renamed file is checked to be the stale
+// one and the fresh one is put back otherwise.
+func breakLock(path string, st os.FileInfo) error {
+	var holder lockHolder
+	if buf, err := os.ReadFile(path); err == nil {
+		_ = json.Unmarshal(buf, &holder)
+	}
+	// Renaming is atomic, only one of the invocations breaking the same
+	// stale lock removes it
+	stale := fmt.Sprintf("%s.stale-%d", path, time.Now().UnixNano())
+	if err := os.Rename(path, stale); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	renamed, err := os.Stat(stale)
+	if err != nil {
+		return err
+	}
+	// The inode of a removed lock may be reused by the fresh one, which has
+	// another mtime
+	if !os.SameFile(st, renamed) || !renamed.ModTime().Equal(st.ModTime()) {
+		// Linking fails instead of replacing a lock created meanwhile
+		if err := os.Link(stale, path); err != nil && !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("restoring the lock file %s: %w", path, err)
+		}
+		return os.Remove(stale)
+	}
+	logrus.Warnf("breaking the stale lock %s of pid %d on host %s, last refreshed %s", path, holder.Pid, holder.Host, st.ModTime().Format(time.RFC3339))
+	return os.Remove(stale)
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestUtils(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Utils Suite")
+}
+
+var _ = Describe("Lock files", func() {
+	var path string
+
+	// staleLockFile creates a lock file whose holder stopped refreshing it
+	staleLockFile := func() os.FileInfo {
+		created, err := createLockFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(created).To(BeTrue())
+		old := time.Now().Add(-2 * lockFileStale)
+		Expect(os.Chtimes(path, old, old)).To(Succeed())
+		st, err := os.Stat(path)
+		Expect(err).ToNot(HaveOccurred())
+		return st
+	}
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "lock")
+	})
+
+	It("should break a stale lock", func() {
+		staleLockFile()
+		Expect(breakStaleLock(path)).To(Succeed())
+		Expect(path).ToNot(BeAnExistingFile())
+		Expect(filepath.Glob(path + ".stale-*")).To(BeEmpty())
+	})
+
+	It("should keep a fresh lock", func() {
+		created, err := createLockFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(created).To(BeTrue())
+		Expect(breakStaleLock(path)).To(Succeed())
+		Expect(path).To(BeAnExistingFile())
+	})
+
+	It("should keep a lock created again after its stat", func() {
+		st := staleLockFile()
+		// Another process broke the stale lock and took it meanwhile
+		Expect(os.Remove(path)).To(Succeed())
+		created, err := createLockFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(created).To(BeTrue())
+		fresh, err := os.Stat(path)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(breakLock(path, st)).To(Succeed())
+		restored, err := os.Stat(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.SameFile(fresh, restored)).To(BeTrue())
+		Expect(filepath.Glob(path + ".stale-*")).To(BeEmpty())
+
+		held, err := createLockFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(held).To(BeFalse())
+	})
+})
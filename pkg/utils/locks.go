@@ -1,13 +1,19 @@
 package utils
 
 import (
+	"context"
 	"path/filepath"
+	"time"
 
 	"github.com/gofrs/flock"
+	"github.com/sirupsen/logrus"
 )
 
 type AccessMode uint
 
+// lockRetryDelay is how often a waiting LockContext retries to take the lock
+const lockRetryDelay = 500 * time.Millisecond
+
 const (
 	Exclusive AccessMode = iota
 	Shared
@@ -15,11 +21,18 @@ const (
 
 type CacheLock struct {
 	inner *flock.Flock
+	files *lockFiles
 }
 
 // NewCacheLock  returns a new instance of *CacheLock. It takes the path to the VM cache dir.
+// When the cache dir is on a network filesystem, the lock is a lock file in the
+// cache, shared by all the hosts, instead of a flock in the local lockDir.
 func NewCacheLock(lockDir, cacheDir string) CacheLock {
 	imageLongID := filepath.Base(cacheDir)
+	if fsType, err := NetworkFilesystem(cacheDir); err == nil && fsType != "" {
+		logrus.Debugf("%s is on a network filesystem (%s), using lock files", cacheDir, fsType)
+		return CacheLock{files: newLockFiles(filepath.Join(filepath.Dir(cacheDir), "."+imageLongID+".lock"))}
+	}
 	cacheDirLockFile := filepath.Join(lockDir, imageLongID+".lock")
 	return CacheLock{inner: flock.New(cacheDirLockFile)}
 }
@@ -28,6 +41,9 @@ func NewCacheLock(lockDir, cacheDir string) CacheLock {
 // The lock is non-blocking, if we are unable to lock the cache directory,
 // the function will return false instead of waiting for the lock.
 func (l CacheLock) TryLock(mode AccessMode) (bool, error) {
+	if l.files != nil {
+		return l.files.tryLock(mode)
+	}
 	if mode == Exclusive {
 		return l.inner.TryLock()
 	} else {
@@ -35,7 +51,23 @@ func (l CacheLock) TryLock(mode AccessMode) (bool, error) {
 	}
 }
 
+// LockContext takes an exclusive or shared lock like TryLock, but it waits
+// for the lock until it is available or the context is done.
+func (l CacheLock) LockContext(ctx context.Context, mode AccessMode) (bool, error) {
+	if l.files != nil {
+		return l.files.lockContext(ctx, mode)
+	}
+	if mode == Exclusive {
+		return l.inner.TryLockContext(ctx, lockRetryDelay)
+	} else {
+		return l.inner.TryRLockContext(ctx, lockRetryDelay)
+	}
+}
+
 // Unlock unlocks the cache lock.
 func (l CacheLock) Unlock() error {
+	if l.files != nil {
+		return l.files.unlock()
+	}
 	return l.inner.Unlock()
 }
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// AssumeNetworkFS makes NetworkFilesystem report every path as being on a
+// network filesystem, to use the NFS-safe protocols on local filesystems
+var AssumeNetworkFS = false
+
+// NetworkFilesystem returns the type of the network filesystem path is on,
+// e.g. nfs, or an empty string for local filesystems. Paths which do not
+// exist yet are checked at their nearest existing parent.
+func NetworkFilesystem(path string) (string, error) {
+	if AssumeNetworkFS {
+		return "assumed", nil
+	}
+	for {
+		fsType, err := statNetworkFilesystem(path)
+		if !errors.Is(err, os.ErrNotExist) {
+			return fsType, err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		path = parent
+	}
+}
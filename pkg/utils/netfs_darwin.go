@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+var networkFilesystems = map[string]bool{
+	"nfs":    true,
+	"smbfs":  true,
+	"afpfs":  true,
+	"webdav": true,
+}
+
+// statNetworkFilesystem returns the type of the network filesystem of an
+// existing path, or an empty string for local filesystems
+func statNetworkFilesystem(path string) (string, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return "", err
+	}
+	name := unix.ByteSliceToString(st.Fstypename[:])
+	if networkFilesystems[name] {
+		return name, nil
+	}
+	return "", nil
+}
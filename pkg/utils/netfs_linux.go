@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// smb2SuperMagic is the statfs magic of the cifs module for SMB2 and later
+const smb2SuperMagic = 0xfe534d42
+
+var networkFilesystems = map[uint32]string{
+	unix.NFS_SUPER_MAGIC:  "nfs",
+	unix.CIFS_SUPER_MAGIC: "cifs",
+	smb2SuperMagic:        "smb2",
+	unix.SMB_SUPER_MAGIC:  "smb",
+	unix.AFS_SUPER_MAGIC:  "afs",
+	unix.CEPH_SUPER_MAGIC: "ceph",
+	unix.V9FS_MAGIC:       "9p",
+}
+
+// statNetworkFilesystem returns the type of the network filesystem of an
+// existing path, or an empty string for local filesystems
+func statNetworkFilesystem(path string) (string, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return "", err
+	}
+	return networkFilesystems[uint32(st.Type)], nil
+}
@@ -4,9 +4,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"sort"
 	"strings"
+	"sync"
 
+	"gitlab.com/bootc-org/podman-bootc/pkg/config"
 	"gitlab.com/bootc-org/podman-bootc/pkg/user"
 
 	"github.com/containers/podman/v5/pkg/machine"
@@ -17,13 +21,50 @@ import (
 )
 
 type MachineInfo struct {
+	Name            string
 	PodmanSocket    string
 	SSHIdentityPath string
 	Rootful         bool
 }
 
+// Machine describes a podman machine and its resources
+type Machine struct {
+	Name      string
+	Default   bool
+	Rootful   bool
+	Running   bool
+	CPUs      uint64
+	MemoryMiB uint64
+	DiskGiB   uint64
+}
+
+var fallbackNotice sync.Once
+
+// GetMachineInfo returns the connection info of the default podman machine.
+// When it is missing or rootless, the machine created by machine-setup is
+// used instead if it exists.
 func GetMachineInfo(user user.User) (*MachineInfo, error) {
-	minfo, err := getMachineInfo()
+	minfo, err := getDefaultMachineInfo(user)
+	if err == nil && minfo != nil && minfo.Rootful {
+		return minfo, nil
+	}
+
+	setup, setupErr := getMachineInfo(config.MachineName)
+	if setupErr != nil || !setup.Rootful {
+		return minfo, err
+	}
+	reason := "rootless"
+	if err != nil || minfo == nil {
+		reason = "not available"
+	}
+	fallbackNotice.Do(func() {
+		fmt.Fprintf(os.Stderr, "Using the podman machine %s created by machine-setup, the default machine is %s\n", config.MachineName, reason)
+	})
+	return setup, nil
+}
+
+func getDefaultMachineInfo(user user.User) (*MachineInfo, error) {
+	minfo, err := getMachineInfo(machine.DefaultMachineName)
 	if err != nil {
 		var errIncompatibleMachineConfig *define.ErrIncompatibleMachineConfig
 		var errVMDoesNotExist *define.ErrVMDoesNotExist
@@ -41,7 +82,7 @@ func GetMachineInfo(user user.User) (*MachineInfo, error) {
 }
 
 // Get podman v5 machine info
-func getMachineInfo() (*MachineInfo, error) {
+func getMachineInfo(name string) (*MachineInfo, error) {
 	prov, err := provider.Get()
 	if err != nil {
 		return nil, fmt.Errorf("getting podman machine provider: %w", err)
@@ -52,7 +93,7 @@ func getMachineInfo() (*MachineInfo, error) {
 		return nil, fmt.Errorf("getting podman machine dirs: %w", err)
 	}
 
-	pm, err := vmconfigs.LoadMachineByName(machine.DefaultMachineName, dirs)
+	pm, err := vmconfigs.LoadMachineByName(name, dirs)
 	if err != nil {
 		return nil, fmt.Errorf("load podman machine info: %w", err)
 	}
@@ -63,6 +104,7 @@ func getMachineInfo() (*MachineInfo, error) {
 	}
 
 	pmi := MachineInfo{
+		Name:            name,
 		PodmanSocket:    podmanSocket.GetPath(),
 		SSHIdentityPath: pm.SSH.IdentityPath,
 		Rootful:         pm.HostUser.Rootful,
@@ -70,6 +112,43 @@ func getMachineInfo() (*MachineInfo, error) {
 	return &pmi, nil
 }
 
+// ListMachines returns the podman v5 machines with their resources
+func ListMachines() ([]Machine, error) {
+	prov, err := provider.Get()
+	if err != nil {
+		return nil, fmt.Errorf("getting podman machine provider: %w", err)
+	}
+
+	dirs, err := env.GetMachineDirs(prov.VMType())
+	if err != nil {
+		return nil, fmt.Errorf("getting podman machine dirs: %w", err)
+	}
+
+	configs, err := vmconfigs.LoadMachinesInDir(dirs)
+	if err != nil {
+		return nil, fmt.Errorf("listing podman machines: %w", err)
+	}
+
+	machines := make([]Machine, 0, len(configs))
+	for name, mc := range configs {
+		state, err := prov.State(mc, false)
+		if err != nil {
+			return nil, fmt.Errorf("getting the state of podman machine %s: %w", name, err)
+		}
+		machines = append(machines, Machine{
+			Name:      name,
+			Default:   name == machine.DefaultMachineName,
+			Rootful:   mc.HostUser.Rootful,
+			Running:   state == define.Running,
+			CPUs:      mc.Resources.CPUs,
+			MemoryMiB: uint64(mc.Resources.Memory),
+			DiskGiB:   uint64(mc.Resources.DiskSize),
+		})
+	}
+	sort.Slice(machines, func(i, j int) bool { return machines[i].Name < machines[j].Name })
+	return machines, nil
+}
+
 // Just to support podman v4.9, it will be removed in the future
 func getPv4MachineInfo(user user.User) (*MachineInfo, error) {
 	//check if a default podman machine exists
@@ -138,6 +217,7 @@ func getPv4MachineInfo(user user.User) (*MachineInfo, error) {
 	}
 
 	return &MachineInfo{
+		Name:            defaultMachineName,
 		PodmanSocket:    machineInspect[0].ConnectionInfo.PodmanSocket.Path,
 		SSHIdentityPath: machineInspect[0].SSHConfig.IdentityPath,
 		Rootful:         machineInspect[0].Rootful,
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"sync"
+)
+
+// TailBuffer is an io.Writer that only keeps the last bytes written to it,
+// e.g. to include the end of a command output in an error message. It is a
+// ring buffer, its memory use does not depend on the amount written.
+type TailBuffer struct {
+	mu   sync.Mutex
+	size int
+	buf  []byte
+	pos  int
+	full bool
+}
+
+// NewTailBuffer returns a TailBuffer keeping at most size bytes
+func NewTailBuffer(size int) *TailBuffer {
+	return &TailBuffer{size: size}
+}
+
+func (t *TailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.size <= 0 {
+		return len(p), nil
+	}
+	if t.buf == nil {
+		t.buf = make([]byte, t.size)
+	}
+	if len(p) >= t.size {
+		copy(t.buf, p[len(p)-t.size:])
+		t.pos, t.full = 0, true
+		return len(p), nil
+	}
+	n := copy(t.buf[t.pos:], p)
+	if n < len(p) {
+		t.pos = copy(t.buf, p[n:])
+		t.full = true
+	} else if t.pos += n; t.pos == t.size {
+		t.pos, t.full = 0, true
+	}
+	return len(p), nil
+}
+
+// String returns the retained output
+func (t *TailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.full {
+		return string(t.buf[:t.pos])
+	}
+	return string(t.buf[t.pos:]) + string(t.buf[:t.pos])
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// IsInteractive returns true when both stdin and stdout are attached to a terminal
+func IsInteractive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// AskYesNo prints the question and waits for the user to answer; anything
+// other than y or yes is treated as a no
+func AskYesNo(question string) (bool, error) {
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false, fmt.Errorf("reading answer: %w", err)
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+// Choose prints the question with the numbered options and returns the index
+// of the option picked by the user
+func Choose(question string, options []string) (int, error) {
+	fmt.Println(question)
+	for i, option := range options {
+		fmt.Printf("  %d) %s\n", i+1, option)
+	}
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("Choose 1-%d: ", len(options))
+		answer, err := reader.ReadString('\n')
+		if err != nil {
+			return -1, fmt.Errorf("reading answer: %w", err)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(answer))
+		if err == nil && n >= 1 && n <= len(options) {
+			return n - 1, nil
+		}
+	}
+}
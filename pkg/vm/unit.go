@@ -0,0 +1,159 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// defaultUnitTimeout is how long WaitForUnit waits without a timeout
+	// in --wait-unit
+	defaultUnitTimeout = 5 * time.Minute
+	// unitJournalLines is the number of journal lines of a unit included
+	// in UnitError
+	unitJournalLines = 20
+	unitPollInterval = 2 * time.Second
+	// unitJournalGrace is how long the journal of the unit may take to read
+	// after the timeout
+	unitJournalGrace = 10 * time.Second
+)
+
+// WaitUnit is a systemd unit of the guest the VM must reach active in Timeout
+type WaitUnit struct {
+	Name    string
+	Timeout time.Duration
+}
+
+// ParseWaitUnit parses NAME[:timeout], e.g. my-app.service:2m. Unit names
+// may contain colons, a suffix which is not a duration belongs to the name.
+func ParseWaitUnit(value string) (WaitUnit, error) {
+	unit := WaitUnit{Name: value, Timeout: defaultUnitTimeout}
+	if i := strings.LastIndex(value, ":"); i >= 0 {
+		if timeout, err := time.ParseDuration(value[i+1:]); err == nil {
+			if timeout <= 0 {
+				return unit, fmt.Errorf("invalid timeout %q of the unit %s", value[i+1:], value[:i])
+			}
+			unit.Name, unit.Timeout = value[:i], timeout
+		}
+	}
+	if unit.Name == "" || strings.ContainsAny(unit.Name, " \t\n'\"\\/") {
+		return unit, fmt.Errorf("invalid unit name %q", unit.Name)
+	}
+	return unit, nil
+}
+
+// UnitError is returned by WaitForUnit when the VM booted but the unit did
+// not become active
+type UnitError struct {
+	Unit string
+	// State is the last state of the unit, e.g. failed or activating
+	State string
+	// TimedOut is set when the unit was still not active after the timeout
+	TimedOut bool
+	Timeout  time.Duration
+	// Journal holds the last lines of the journal of the unit
+	Journal string
+}
+
+func (e *UnitError) Error() string {
+	msg := fmt.Sprintf("the unit %s is %s", e.Unit, e.State)
+	if e.TimedOut {
+		msg = fmt.Sprintf("the unit %s did not become active in %s, it is %s", e.Unit, e.Timeout, e.State)
+	}
+	if e.Journal != "" {
+		msg += ", the last lines of its journal:\n" + strings.TrimRight(e.Journal, "\n")
+	}
+	return msg
+}
+
+// WaitForUnit waits for the unit of the guest to become active, polling
+// systemctl over SSH. The VM must accept SSH connections. A failed unit
+// returns a *UnitError at once, with the last lines of its journal.
+func (v *BootcVMCommon) WaitForUnit(unit WaitUnit) error {
+	config, err := v.sshClientConfig()
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(unit.Timeout)
+	address := net.JoinHostPort("localhost", strconv.Itoa(v.sshPort))
+	conn, err := net.DialTimeout("tcp", address, config.Timeout)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSSHNotReady, err)
+	}
+	// A guest hanging in the middle of the session must not block past the
+	// timeout
+	if err := conn.SetDeadline(deadline.Add(unitJournalGrace)); err != nil {
+		conn.Close()
+		return err
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("%w: %v", ErrSSHNotReady, err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+
+	state := "unknown"
+	for {
+		current, err := unitState(client, unit.Name)
+		if err != nil {
+			if time.Now().After(deadline) {
+				return &UnitError{Unit: unit.Name, State: state, TimedOut: true, Timeout: unit.Timeout}
+			}
+			return err
+		}
+		state = current
+		logrus.Debugf("unit %s is %s", unit.Name, state)
+		switch {
+		case state == "active":
+			return nil
+		case state == "failed":
+			return &UnitError{Unit: unit.Name, State: state, Journal: unitJournal(client, unit.Name)}
+		case time.Now().After(deadline):
+			return &UnitError{Unit: unit.Name, State: state, TimedOut: true, Timeout: unit.Timeout, Journal: unitJournal(client, unit.Name)}
+		}
+		time.Sleep(unitPollInterval)
+	}
+}
+
+// unitState returns the state of the unit printed by systemctl is-active,
+// which exits non-zero for the states other than active
+func unitState(client *ssh.Client, unit string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("querying the state of %s: %w", unit, err)
+	}
+	defer session.Close()
+	out, err := session.Output("systemctl is-active '" + unit + "'")
+	var exitErr *ssh.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return "", fmt.Errorf("querying the state of %s: %w", unit, err)
+	}
+	if state := strings.TrimSpace(string(out)); state != "" {
+		return state, nil
+	}
+	return "unknown", nil
+}
+
+// unitJournal returns the last lines of the journal of the unit, empty when
+// it cannot be read
+func unitJournal(client *ssh.Client, unit string) string {
+	session, err := client.NewSession()
+	if err != nil {
+		return ""
+	}
+	defer session.Close()
+	out, err := session.CombinedOutput(fmt.Sprintf("journalctl --no-pager -n %d -u '%s'", unitJournalLines, unit))
+	if err != nil {
+		logrus.Debugf("unable to read the journal of %s: %v", unit, err)
+	}
+	return string(out)
+}
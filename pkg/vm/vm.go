@@ -42,7 +42,7 @@ func GetVMCachePath(imageId string, user user.User) (longID string, path string,
 		return "", "", fmt.Errorf("local installation '%s' does not exists", imageId)
 	}
 
-	return fullImageId, filepath.Join(user.CacheDir(), fullImageId), nil
+	return fullImageId, user.ImageCacheDir(fullImageId), nil
 }
 
 type NewVMParameters struct {
@@ -53,15 +53,53 @@ type NewVMParameters struct {
 }
 
 type RunVMParameters struct {
-	VMUser        string //user to use when connecting to the VM
-	CloudInitDir  string
-	NoCredentials bool
-	CloudInitData bool
-	SSHIdentity   string
-	SSHPort       int
-	Cmd           []string
-	RemoveVm      bool
-	Background    bool
+	VMUser          string //user to use when connecting to the VM
+	CloudInitDir    string
+	NoCredentials   bool
+	CloudInitData   bool
+	SSHIdentity     string
+	SSHPort         int
+	Cmd             []string
+	RemoveVm        bool
+	Background      bool
+	Memory          string   // defaults to defaultMemory
+	CPUs            int      // defaults to defaultCPUs
+	TPM             bool     // attach an emulated TPM 2.0
+	Publish         []string // hostPort:guestPort TCP forwarding rules
+	Accel           string   // AccelAuto, AccelKVM or AccelTCG, defaults to AccelAuto
+	DataDisks       []string // sizes of the empty data disks attached after the disk image
+	RemoveDataDisks bool     // remove the existing data disks and their content first
+}
+
+const (
+	defaultMemory = "2G"
+	defaultCPUs   = 2
+)
+
+// The accelerators of --accel. AccelAuto uses the hardware accelerator of
+// the host when it is usable and falls back to TCG, the much slower
+// software emulation of qemu.
+const (
+	AccelAuto = "auto"
+	AccelKVM  = "kvm"
+	AccelTCG  = "tcg"
+	// AccelHVF is the Hypervisor framework of macOS used by AccelAuto
+	AccelHVF = "hvf"
+)
+
+// ValidateAccel checks an accelerator of --accel
+func ValidateAccel(accel string) error {
+	switch accel {
+	case "", AccelAuto, AccelKVM, AccelTCG:
+		return nil
+	}
+	return fmt.Errorf("invalid accelerator %q, use kvm, tcg or auto", accel)
+}
+
+// warnTCG warns prominently that the VM falls back to software emulation
+func warnTCG(reason string) {
+	logrus.Warnf("hardware acceleration is not available (%s)", reason)
+	logrus.Warnf("the VM runs with TCG software emulation and is MUCH slower, use --accel tcg to acknowledge it")
 }
 
 type BootcVM interface {
@@ -70,6 +108,7 @@ type BootcVM interface {
 	IsRunning() (bool, error)
 	WriteConfig(bootc.BootcDisk) error
 	WaitForSSHToBeReady() error
+	WaitForUnit(WaitUnit) error
 	RunSSH([]string) error
 	DeleteFromCache() error
 	Exists() (bool, error)
@@ -96,6 +135,28 @@ type BootcVMCommon struct {
 	cloudInitDir  string
 	cloudInitArgs string
 	cacheDirLock  utils.CacheLock
+	memory        string
+	cpus          int
+	tpm           bool
+	publish       []string
+	// accelOption is the accelerator requested with --accel, accel the
+	// one the VM runs with
+	accelOption string
+	accel       string
+	// dataDiskSizes are the sizes of --data-disk, dataDisks the disks
+	// prepared for them in the cache entry
+	dataDiskSizes []string
+	dataDisks     []bootc.DataDisk
+}
+
+// diskFormat returns the format of the disk image recorded in its metadata
+func (v *BootcVMCommon) diskFormat() string {
+	meta, err := bootc.ReadDiskMeta(v.diskImagePath)
+	if err != nil {
+		logrus.Debugf("unable to read the disk metadata, assuming a raw disk image: %v", err)
+		return bootc.FormatRaw
+	}
+	return meta.DiskFormat()
 }
 
 type BootcVMConfig struct {
@@ -106,6 +167,18 @@ type BootcVMConfig struct {
 	Created     string `json:"Created,omitempty"`
 	DiskSize    string `json:"DiskSize,omitempty"`
 	Running     bool   `json:"Running,omitempty"`
+	// The options the VM was started with, used to start it again
+	VMUser  string   `json:"User,omitempty"`
+	Memory  string   `json:"Memory,omitempty"`
+	CPUs    int      `json:"CPUs,omitempty"`
+	TPM     bool     `json:"TPM,omitempty"`
+	Publish []string `json:"Publish,omitempty"`
+	// Accel is the accelerator the VM runs with, AccelOption the one
+	// requested with --accel
+	Accel       string `json:"Accel,omitempty"`
+	AccelOption string `json:"AccelOption,omitempty"`
+	// DataDisks are the sizes of the data disks of --data-disk
+	DataDisks []string `json:"DataDisks,omitempty"`
 }
 
 // writeConfig writes the configuration for the VM to the disk
@@ -121,6 +194,14 @@ func (v *BootcVMCommon) WriteConfig(bootcDisk bootc.BootcDisk) error {
 		RepoTag:     bootcDisk.GetRepoTag(),
 		Created:     bootcDisk.GetCreatedAt().Format(time.RFC3339),
 		DiskSize:    strconv.FormatInt(size, 10),
+		VMUser:      v.vmUsername,
+		Memory:      v.memory,
+		CPUs:        v.cpus,
+		TPM:         v.tpm,
+		Publish:     v.publish,
+		Accel:       v.accel,
+		AccelOption: v.accelOption,
+		DataDisks:   v.dataDiskSizes,
 	}
 
 	bcConfigMsh, err := json.Marshal(bcConfig)
@@ -173,28 +254,40 @@ func (v *BootcVMCommon) SetUser(user string) error {
 	return nil
 }
 
-func (v *BootcVMCommon) WaitForSSHToBeReady() error {
-	timeout := 1 * time.Minute
-	elapsed := 0 * time.Millisecond
-	interval := 500 * time.Millisecond
+// ErrSSHNotReady is returned by WaitForSSHToBeReady when the VM never
+// booted far enough to accept SSH connections
+var ErrSSHNotReady = errors.New("SSH did not become ready")
 
+// sshClientConfig returns the configuration of the SSH connections to the VM
+func (v *BootcVMCommon) sshClientConfig() (*ssh.ClientConfig, error) {
 	key, err := os.ReadFile(v.sshIdentity)
 	if err != nil {
-		return fmt.Errorf("failed to read private key file: %s\n", err)
+		return nil, fmt.Errorf("failed to read private key file: %s\n", err)
 	}
 
 	signer, err := ssh.ParsePrivateKey(key)
 	if err != nil {
-		return fmt.Errorf("failed to parse private key: %s\n", err)
+		return nil, fmt.Errorf("failed to parse private key: %s\n", err)
 	}
 
-	config := &ssh.ClientConfig{
+	return &ssh.ClientConfig{
 		User: v.vmUsername,
 		Auth: []ssh.AuthMethod{
 			ssh.PublicKeys(signer),
 		},
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 		Timeout:         1 * time.Second,
+	}, nil
+}
+
+func (v *BootcVMCommon) WaitForSSHToBeReady() error {
+	timeout := 1 * time.Minute
+	elapsed := 0 * time.Millisecond
+	interval := 500 * time.Millisecond
+
+	config, err := v.sshClientConfig()
+	if err != nil {
+		return err
 	}
 
 	for elapsed < timeout {
@@ -209,7 +302,7 @@ func (v *BootcVMCommon) WaitForSSHToBeReady() error {
 		}
 	}
 
-	return fmt.Errorf("SSH did not become ready in %s seconds", timeout)
+	return fmt.Errorf("%w in %s", ErrSSHNotReady, timeout)
 }
 
 // RunSSH runs a command over ssh or starts an interactive ssh connection if no command is provided
@@ -254,6 +347,63 @@ func (v *BootcVMCommon) DeleteFromCache() error {
 	return os.RemoveAll(v.cacheDir)
 }
 
+// setResources sets the VM resources from the run parameters
+func (v *BootcVMCommon) setResources(params RunVMParameters) error {
+	v.memory = params.Memory
+	if v.memory == "" {
+		v.memory = defaultMemory
+	}
+	if _, err := units.RAMInBytes(v.memory); err != nil {
+		return fmt.Errorf("invalid memory %q: %w", v.memory, err)
+	}
+	v.cpus = params.CPUs
+	if v.cpus <= 0 {
+		v.cpus = defaultCPUs
+	}
+	v.tpm = params.TPM
+	v.publish = params.Publish
+	if err := ValidateAccel(params.Accel); err != nil {
+		return err
+	}
+	v.accelOption = params.Accel
+	if v.accelOption == "" {
+		v.accelOption = AccelAuto
+	}
+	accel, err := resolveAccel(v.accelOption)
+	if err != nil {
+		return err
+	}
+	v.accel = accel
+	v.dataDiskSizes = params.DataDisks
+	v.dataDisks, err = bootc.PrepareDataDisks(v.cacheDir, v.dataDiskSizes, v.diskFormat(), params.RemoveDataDisks)
+	if err != nil {
+		return fmt.Errorf("preparing the data disks: %w", err)
+	}
+	return nil
+}
+
+// dataDiskTarget returns the virtio device of the data disk i, the disk
+// image is vda
+func dataDiskTarget(i int) string {
+	return "vd" + string(rune('b'+i))
+}
+
+// memoryMiB returns the VM memory in MiB
+func (v *BootcVMCommon) memoryMiB() int64 {
+	bytes, _ := units.RAMInBytes(v.memory)
+	return bytes / units.MiB
+}
+
+// hostForwards returns the qemu user network forwarding rules for the published ports
+func (v *BootcVMCommon) hostForwards() string {
+	var fwd strings.Builder
+	for _, rule := range v.publish {
+		host, guest, _ := strings.Cut(rule, ":")
+		fmt.Fprintf(&fwd, ",hostfwd=tcp::%s-:%s", host, guest)
+	}
+	return fwd.String()
+}
+
 func (b *BootcVMCommon) oemString() (string, error) {
 	tmpFilesCmd, err := b.tmpFileInjectSshKeyEnc()
 	if err != nil {
@@ -268,6 +418,12 @@ func (b *BootcVMCommon) tmpFileInjectSshKeyEnc() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if b.vmUsername == "root" {
+		// The file is replaced, keep the keys baked into the disk
+		if meta, err := bootc.ReadDiskMeta(b.diskImagePath); err == nil && meta.RootAuthorizedKeys != "" {
+			pubKey = append([]byte(meta.RootAuthorizedKeys), pubKey...)
+		}
+	}
 	pubKeyEnc := base64.StdEncoding.EncodeToString(pubKey)
 
 	userHomeDir := "/root"
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"gitlab.com/bootc-org/podman-bootc/pkg/config"
@@ -20,6 +21,27 @@ type BootcVMMac struct {
 	BootcVMCommon
 }
 
+// resolveAccel returns the accelerator of the VM for the one requested with
+// --accel, auto is the Hypervisor framework
+func resolveAccel(accel string) (string, error) {
+	switch accel {
+	case AccelTCG:
+		return AccelTCG, nil
+	case AccelKVM:
+		return "", errors.New("--accel kvm is only available on Linux, use auto for the Hypervisor framework of macOS")
+	}
+	return AccelHVF, nil
+}
+
+// qemuCPU returns the CPU model of qemu for the accelerator, host requires
+// hardware acceleration
+func (b *BootcVMMac) qemuCPU() string {
+	if b.accel == AccelTCG {
+		return "max"
+	}
+	return "host"
+}
+
 func NewVM(params NewVMParameters) (vm *BootcVMMac, err error) {
 	if params.ImageID == "" {
 		return nil, fmt.Errorf("image ID is required")
@@ -109,6 +131,12 @@ func (b *BootcVMMac) Run(params RunVMParameters) (err error) {
 	b.cloudInitDir = params.CloudInitDir
 	b.vmUsername = params.VMUser
 	b.sshIdentity = params.SSHIdentity
+	if err := b.setResources(params); err != nil {
+		return err
+	}
+	if b.tpm {
+		logrus.Warnf("TPM emulation is not supported on macOS, starting the VM without TPM")
+	}
 
 	if params.NoCredentials {
 		b.sshIdentity = ""
@@ -122,19 +150,23 @@ func (b *BootcVMMac) Run(params RunVMParameters) (err error) {
 	args = append(args, "-display", "none")
 	args = append(args, "-chardev", fmt.Sprintf("socket,id=char0,server=on,wait=off,path=%s", b.socketFile), "-serial", "chardev:char0")
 
-	args = append(args, "-cpu", "host")
-	args = append(args, "-m", "2G")
-	args = append(args, "-smp", "2")
+	args = append(args, "-cpu", b.qemuCPU())
+	args = append(args, "-m", fmt.Sprintf("%dM", b.memoryMiB()))
+	args = append(args, "-smp", strconv.Itoa(b.cpus))
 	args = append(args, "-snapshot")
-	nicCmd := fmt.Sprintf("user,model=virtio-net-pci,hostfwd=tcp::%d-:22", b.sshPort)
+	nicCmd := fmt.Sprintf("user,model=virtio-net-pci,hostfwd=tcp::%d-:22%s", b.sshPort, b.hostForwards())
 	args = append(args, "-nic", nicCmd)
 
 	vmPidFile := filepath.Join(b.cacheDir, "run.pid")
 	args = append(args, "-pidfile", vmPidFile)
 
 	vmDiskImage := filepath.Join(b.cacheDir, config.DiskImage)
-	driveCmd := fmt.Sprintf("if=virtio,format=raw,file=%s", vmDiskImage)
+	driveCmd := fmt.Sprintf("if=virtio,format=%s,file=%s", b.diskFormat(), vmDiskImage)
 	args = append(args, "-drive", driveCmd)
+	for _, disk := range b.dataDisks {
+		// -snapshot applies to every drive, the data disks opt out of it
+		args = append(args, "-drive", fmt.Sprintf("if=virtio,format=%s,file=%s,snapshot=off", disk.Format, filepath.Join(b.cacheDir, disk.Name)))
+	}
 
 	err = b.ParseCloudInit()
 	if err != nil {
@@ -222,8 +254,8 @@ func (b *BootcVMMac) createQemuCommand() (*exec.Cmd, error) {
 
 	path := qemuInstallPath + "/bin/qemu-system-aarch64"
 	args := []string{
-		"-accel", "hvf",
-		"-cpu", "host",
+		"-accel", b.accel,
+		"-cpu", b.qemuCPU(),
 		"-M", "virt,highmem=on",
 		"-drive", "file=" + qemuInstallPath + "/share/qemu/edk2-aarch64-code.fd" + ",if=pflash,format=raw,readonly=on",
 	}
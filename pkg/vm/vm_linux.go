@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"gitlab.com/bootc-org/podman-bootc/pkg/config"
+	"gitlab.com/bootc-org/podman-bootc/pkg/utils"
 
 	"github.com/sirupsen/logrus"
 	"libvirt.org/go/libvirt"
@@ -30,6 +31,22 @@ func vmName(id string) string {
 	return "podman-bootc-" + id[:12]
 }
 
+// resolveAccel returns the accelerator of the VM for the one requested with
+// --accel, warning when auto falls back to TCG
+func resolveAccel(accel string) (string, error) {
+	if accel == AccelTCG {
+		return AccelTCG, nil
+	}
+	if err := utils.CheckKVM(); err != nil {
+		if accel == AccelKVM {
+			return "", fmt.Errorf("--accel kvm: %w", err)
+		}
+		warnTCG(err.Error())
+		return AccelTCG, nil
+	}
+	return AccelKVM, nil
+}
+
 func NewVM(params NewVMParameters) (vm *BootcVMLinux, err error) {
 	if params.ImageID == "" {
 		return nil, fmt.Errorf("image ID is required")
@@ -122,6 +139,9 @@ func (v *BootcVMLinux) Run(params RunVMParameters) (err error) {
 	v.cloudInitDir = params.CloudInitDir
 	v.vmUsername = params.VMUser
 	v.sshIdentity = params.SSHIdentity
+	if err := v.setResources(params); err != nil {
+		return err
+	}
 
 	if params.NoCredentials {
 		v.sshIdentity = ""
@@ -184,21 +204,53 @@ func (v *BootcVMLinux) parseDomainTemplate() (domainXML string, err error) {
 
 	var domainXMLBuf bytes.Buffer
 
+	type dataDiskParams struct {
+		Path   string
+		Format string
+		Target string
+	}
+
 	type TemplateParams struct {
 		DiskImagePath   string
+		DiskFormat      string
 		Port            string
 		PIDFile         string
 		SMBios          string
 		Name            string
 		CloudInitCDRom  string
 		CloudInitSMBios string
+		MemoryMiB       int64
+		CPUs            int
+		TPM             bool
+		HostForwards    string
+		DomainType      string
+		CPUMode         string
+		DataDisks       []dataDiskParams
 	}
 
 	templateParams := TemplateParams{
 		DiskImagePath: v.diskImagePath,
+		DiskFormat:    v.diskFormat(),
 		Port:          strconv.Itoa(v.sshPort),
 		PIDFile:       v.pidFile,
 		Name:          v.vmName,
+		MemoryMiB:     v.memoryMiB(),
+		CPUs:          v.cpus,
+		TPM:           v.tpm,
+		HostForwards:  v.hostForwards(),
+		DomainType:    "kvm",
+		CPUMode:       "host-model",
+	}
+	for i, disk := range v.dataDisks {
+		templateParams.DataDisks = append(templateParams.DataDisks, dataDiskParams{
+			Path:   filepath.Join(v.cacheDir, disk.Name),
+			Format: disk.Format,
+			Target: dataDiskTarget(i),
+		})
+	}
+	if v.accel == AccelTCG {
+		// The qemu domains of libvirt run with TCG, host-model needs KVM
+		templateParams.DomainType, templateParams.CPUMode = "qemu", "maximum"
 	}
 
 	if v.sshIdentity != "" {
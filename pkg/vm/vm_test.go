@@ -297,3 +297,37 @@ var _ = Describe("VM", func() {
 		})
 	})
 })
+
+var _ = Describe("Wait unit", func() {
+	It("should parse the unit and its timeout", func() {
+		unit, err := vm.ParseWaitUnit("my-app.service:2m")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unit).To(Equal(vm.WaitUnit{Name: "my-app.service", Timeout: 2 * time.Minute}))
+
+		unit, err = vm.ParseWaitUnit("my-app.service")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unit.Name).To(Equal("my-app.service"))
+		Expect(unit.Timeout).To(Equal(5 * time.Minute))
+
+		// a suffix which is not a duration belongs to the name
+		unit, err = vm.ParseWaitUnit("getty@tty1:x.service")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unit.Name).To(Equal("getty@tty1:x.service"))
+	})
+
+	It("should refuse invalid units", func() {
+		_, err := vm.ParseWaitUnit(":2m")
+		Expect(err).To(HaveOccurred())
+		_, err = vm.ParseWaitUnit("my-app.service:0s")
+		Expect(err).To(HaveOccurred())
+		_, err = vm.ParseWaitUnit("a'; reboot; '.service")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should describe the failed unit with its journal", func() {
+		err := &vm.UnitError{Unit: "my-app.service", State: "failed", Journal: "my-app[1]: boom\n"}
+		Expect(err.Error()).To(Equal("the unit my-app.service is failed, the last lines of its journal:\nmy-app[1]: boom"))
+		err = &vm.UnitError{Unit: "my-app.service", State: "activating", TimedOut: true, Timeout: time.Minute}
+		Expect(err.Error()).To(Equal("the unit my-app.service did not become active in 1m0s, it is activating"))
+	})
+})
@@ -17,6 +17,10 @@ import (
 )
 
 func cleanup() {
+	if err := cmd.CleanupDiskBuild(); err != nil {
+		logrus.Errorf("%v", err)
+	}
+
 	user, err := user.NewUser()
 	if err != nil {
 		logrus.Errorf("unable to get user info: %s", err)
@@ -68,9 +72,14 @@ func main() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
-		<-c
-		cleanup()
-		os.Exit(1)
+		for sig := range c {
+			// The progress UI asks first, a second interrupt forces the exit
+			if sig == os.Interrupt && cmd.InterruptBuild() {
+				continue
+			}
+			cleanup()
+			os.Exit(1)
+		}
 	}()
 
 	cmd.Execute()
@@ -17,6 +17,7 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sync"
 	"testing"
 
@@ -315,4 +316,122 @@ var _ = Describe("E2E", func() {
 			}
 		})
 	})
+
+	Context("Image without bash", Ordered, func() {
+		It("should build the disk image", func() {
+			_, _, err := e2e.RunPodmanBootc("disk", "build", "-q", e2e.TestImageNoBash)
+			Expect(err).To(Not(HaveOccurred()))
+
+			vmDirs, err := e2e.ListCacheDirs()
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(vmDirs).To(HaveLen(1))
+			_, err = os.Stat(filepath.Join(vmDirs[0], config.DiskImage))
+			Expect(err).To(Not(HaveOccurred()))
+		})
+
+		It("should reinstall to an existing disk image", func() {
+			vmDirs, err := e2e.ListCacheDirs()
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(vmDirs).To(HaveLen(1))
+			target := filepath.Join(GinkgoT().TempDir(), "target.raw")
+			_, _, err = e2e.RunCmd("cp", "--sparse=always", filepath.Join(vmDirs[0], config.DiskImage), target)
+			Expect(err).To(Not(HaveOccurred()))
+
+			_, _, err = e2e.RunPodmanBootc("disk", "build", "-q", "--install-mode", "to-filesystem", "--install-target", target, "--install-replace", "wipe", e2e.TestImageNoBash)
+			Expect(err).To(Not(HaveOccurred()))
+		})
+
+		It("should verify the contents of the disk image", func() {
+			_, _, err := e2e.RunPodmanBootc("disk", "build", "-q", "--force-rebuild", "--verify-content", e2e.TestImageNoBash)
+			Expect(err).To(Not(HaveOccurred()))
+		})
+
+		It("should upgrade the disk image of another image of the repository", func() {
+			_, _, err := e2e.RunPodmanBootc("rm", "-f", "--all")
+			Expect(err).To(Not(HaveOccurred()))
+			_, _, err = e2e.RunPodmanBootc("disk", "build", "-q", e2e.TestImageOne)
+			Expect(err).To(Not(HaveOccurred()))
+
+			_, _, err = e2e.RunPodmanBootc("disk", "build", "-q", "--rebuild-strategy", "upgrade", e2e.TestImageNoBash)
+			Expect(err).To(Not(HaveOccurred()))
+
+			vmDirs, err := e2e.ListCacheDirs()
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(vmDirs).To(HaveLen(2))
+		})
+
+		AfterAll(func() {
+			err := e2e.Cleanup()
+			if err != nil {
+				Fail(err.Error())
+			}
+		})
+	})
+
+	Context("Relocated graph root", Ordered, func() {
+		It("should build the disk image with the storage of the podman service outside of /var/lib/containers", func() {
+			if runtime.GOOS != "linux" || os.Geteuid() != 0 {
+				Skip("requires a rootful podman service on the host")
+			}
+			dir, err := os.MkdirTemp("/var/tmp", "podman-bootc-graphroot")
+			Expect(err).To(Not(HaveOccurred()))
+			defer os.RemoveAll(dir)
+			url, stop, err := e2e.StartRelocatedPodmanService(dir)
+			Expect(err).To(Not(HaveOccurred()))
+			defer stop()
+
+			_, _, err = e2e.RunPodmanBootc("--url", url, "disk", "build", "-q", e2e.TestImageNoBash)
+			Expect(err).To(Not(HaveOccurred()))
+
+			vmDirs, err := e2e.ListCacheDirs()
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(vmDirs).To(HaveLen(1))
+			_, err = os.Stat(filepath.Join(vmDirs[0], config.DiskImage))
+			Expect(err).To(Not(HaveOccurred()))
+		})
+
+		AfterAll(func() {
+			err := e2e.Cleanup()
+			if err != nil {
+				Fail(err.Error())
+			}
+		})
+	})
+
+	Describe("Throttled IO", Ordered, func() {
+		It("should complete a build with throttled IO", func() {
+			_, _, err := e2e.RunPodmanBootc("disk", "build", "-q", "--nice", "--io-max", "200MB/s", e2e.TestImageNoBash)
+			Expect(err).To(Not(HaveOccurred()))
+
+			vmDirs, err := e2e.ListCacheDirs()
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(vmDirs).To(HaveLen(1))
+		})
+
+		AfterAll(func() {
+			err := e2e.Cleanup()
+			if err != nil {
+				Fail(err.Error())
+			}
+		})
+	})
+
+	Context("Image with an entrypoint", Ordered, func() {
+		It("should build the disk image without running the entrypoint", func() {
+			_, stderr, err := e2e.RunPodmanBootc("disk", "build", "-q", e2e.TestImageEntrypoint)
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(stderr).To(Not(ContainSubstring("hostile entrypoint")))
+
+			vmDirs, err := e2e.ListCacheDirs()
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(vmDirs).To(HaveLen(1))
+		})
+
+		AfterAll(func() {
+			err := e2e.Cleanup()
+			if err != nil {
+				Fail(err.Error())
+			}
+		})
+	})
 })
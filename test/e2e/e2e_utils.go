@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"gitlab.com/bootc-org/podman-bootc/pkg/user"
 )
@@ -14,6 +15,8 @@ import (
 const DefaultBaseImage = "quay.io/centos-bootc/centos-bootc-dev:stream9"
 const TestImageOne = "quay.io/ckyrouac/podman-bootc-test:one"
 const TestImageTwo = "quay.io/ckyrouac/podman-bootc-test:two"
+const TestImageNoBash = "quay.io/ckyrouac/podman-bootc-test:three"
+const TestImageEntrypoint = "quay.io/ckyrouac/podman-bootc-test:four"
 
 var BaseImage = GetBaseImage()
 
@@ -61,6 +64,33 @@ func RunPodmanBootc(args ...string) (stdout string, stderr string, err error) {
 	return RunCmd(PodmanBootcBinary(), args...)
 }
 
+// StartRelocatedPodmanService starts a rootful podman service of the host
+// with its graph root and run root in dir, as a storage.conf relocating
+// them would. It returns the URL of the service and the function stopping it.
+func StartRelocatedPodmanService(dir string) (url string, stop func(), err error) {
+	socket := filepath.Join(dir, "podman.sock")
+	url = "unix://" + socket
+	cmd := exec.Command("podman",
+		"--root", filepath.Join(dir, "storage"),
+		"--runroot", filepath.Join(dir, "run"),
+		"system", "service", "--time=0", url)
+	if err := cmd.Start(); err != nil {
+		return "", nil, err
+	}
+	stop = func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			return url, stop, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	stop()
+	return "", nil, fmt.Errorf("the podman service did not create %s", socket)
+}
+
 func RunPodman(args ...string) (stdout string, stderr string, err error) {
 	podmanArgs := append([]string{"-c", "podman-machine-default-root"}, args...)
 	return RunCmd("podman", podmanArgs...)
@@ -156,6 +186,16 @@ func Cleanup() (err error) {
 		return
 	}
 
+	_, _, err = RunPodman("rmi", TestImageNoBash, "-f")
+	if err != nil {
+		return
+	}
+
+	_, _, err = RunPodman("rmi", TestImageEntrypoint, "-f")
+	if err != nil {
+		return
+	}
+
 	_, _, err = RunPodman("rmi", TestImageOne, "-f")
 	if err != nil {
 		return
@@ -17,3 +17,19 @@ podman push quay.io/ckyrouac/podman-bootc-test:two-arm64
 podman manifest create quay.io/ckyrouac/podman-bootc-test:two quay.io/ckyrouac/podman-bootc-test:two-arm64 quay.io/ckyrouac/podman-bootc-test:two-amd64
 podman manifest push quay.io/ckyrouac/podman-bootc-test:two
 podman manifest rm quay.io/ckyrouac/podman-bootc-test:two
+
+podman build --platform linux/amd64 -f Containerfile.3 -t quay.io/ckyrouac/podman-bootc-test:three-amd64 .
+podman build --platform linux/arm64 -f Containerfile.3 -t quay.io/ckyrouac/podman-bootc-test:three-arm64 .
+podman push quay.io/ckyrouac/podman-bootc-test:three-amd64
+podman push quay.io/ckyrouac/podman-bootc-test:three-arm64
+podman manifest create quay.io/ckyrouac/podman-bootc-test:three quay.io/ckyrouac/podman-bootc-test:three-arm64 quay.io/ckyrouac/podman-bootc-test:three-amd64
+podman manifest push quay.io/ckyrouac/podman-bootc-test:three
+podman manifest rm quay.io/ckyrouac/podman-bootc-test:three
+
+podman build --platform linux/amd64 -f Containerfile.4 -t quay.io/ckyrouac/podman-bootc-test:four-amd64 .
+podman build --platform linux/arm64 -f Containerfile.4 -t quay.io/ckyrouac/podman-bootc-test:four-arm64 .
+podman push quay.io/ckyrouac/podman-bootc-test:four-amd64
+podman push quay.io/ckyrouac/podman-bootc-test:four-arm64
+podman manifest create quay.io/ckyrouac/podman-bootc-test:four quay.io/ckyrouac/podman-bootc-test:four-arm64 quay.io/ckyrouac/podman-bootc-test:four-amd64
+podman manifest push quay.io/ckyrouac/podman-bootc-test:four
+podman manifest rm quay.io/ckyrouac/podman-bootc-test:four